@@ -6,6 +6,7 @@
 package addrmgr
 
 import (
+	"bytes"
 	"container/list"
 	crand "crypto/rand" // for seeding
 	"encoding/base32"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/bitum-project/bitumd/chaincfg/chainhash"
 	"github.com/bitum-project/bitumd/wire"
+	"golang.org/x/crypto/sha3"
 )
 
 // PeersFilename is the default filename to store serialized peers.
@@ -50,6 +52,7 @@ type AddrManager struct {
 	nNew           int                                      // number of new addresses (i.e., not tried)
 	lamtx          sync.Mutex                               // local address mutex
 	localAddresses map[string]*localAddress                 // address key to la for all local addresses
+	asmap          *ASMap                                   // optional map of IP prefixes to ASNs for grouping
 }
 
 type serializedKnownAddress struct {
@@ -68,6 +71,7 @@ type serializedAddrManager struct {
 	Addresses    []*serializedKnownAddress
 	NewBuckets   [newBucketCount][]string // string is NetAddressKey
 	TriedBuckets [triedBucketCount][]string
+	ASMapVersion string // empty when no asmap was used for the buckets
 }
 
 type localAddress struct {
@@ -159,19 +163,21 @@ const (
 	getAddrPercent = 23
 
 	// serialisationVersion is the current version of the on-disk format.
-	serialisationVersion = 1
+	// Version 2 added Tor v3 onion addresses, all other addresses are
+	// serialized like in version 1.
+	serialisationVersion = 2
 )
 
 // updateAddress is a helper function to either update an address already known
 // to the address manager, or to add the address if not already known.
-func (a *AddrManager) updateAddress(netAddr, srcAddr *wire.NetAddress) {
+func (a *AddrManager) updateAddress(netAddr, srcAddr *wire.NetAddressV2) {
 	// Filter out non-routable addresses. Note that non-routable
 	// also includes invalid and local addresses.
-	if !IsRoutable(netAddr) {
+	if !IsRoutableV2(netAddr) {
 		return
 	}
 
-	addr := NetAddressKey(netAddr)
+	addr := NetAddressKeyV2(netAddr)
 	ka := a.find(netAddr)
 	if ka != nil {
 		// TODO(oga) only update addresses periodically.
@@ -271,7 +277,7 @@ func (a *AddrManager) expireNew(bucket int) {
 	}
 
 	if oldest != nil {
-		key := NetAddressKey(oldest.na)
+		key := NetAddressKeyV2(oldest.na)
 		log.Tracef("expiring oldest address %v", key)
 
 		delete(a.addrNew[bucket], key)
@@ -301,15 +307,15 @@ func (a *AddrManager) pickTried(bucket int) *list.Element {
 	return oldestElem
 }
 
-func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
+func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddressV2) int {
 	// bitcoind:
 	// doublesha256(key + sourcegroup + int64(doublesha256(key + group
 	// + sourcegroup))%bucket_per_source_group) % num_new_buckets
 
 	data1 := []byte{}
 	data1 = append(data1, a.key[:]...)
-	data1 = append(data1, []byte(GroupKey(netAddr))...)
-	data1 = append(data1, []byte(GroupKey(srcAddr))...)
+	data1 = append(data1, []byte(a.GroupKey(netAddr))...)
+	data1 = append(data1, []byte(a.GroupKey(srcAddr))...)
 	hash1 := chainhash.HashB(data1)
 	hash64 := binary.LittleEndian.Uint64(hash1)
 	hash64 %= newBucketsPerGroup
@@ -317,20 +323,20 @@ func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
 	binary.LittleEndian.PutUint64(hashbuf[:], hash64)
 	data2 := []byte{}
 	data2 = append(data2, a.key[:]...)
-	data2 = append(data2, GroupKey(srcAddr)...)
+	data2 = append(data2, a.GroupKey(srcAddr)...)
 	data2 = append(data2, hashbuf[:]...)
 
 	hash2 := chainhash.HashB(data2)
 	return int(binary.LittleEndian.Uint64(hash2) % newBucketCount)
 }
 
-func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddress) int {
+func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddressV2) int {
 	// bitcoind hashes this as:
 	// doublesha256(key + group + truncate_to_64bits(doublesha256(key))
 	// % buckets_per_group) % num_buckets
 	data1 := []byte{}
 	data1 = append(data1, a.key[:]...)
-	data1 = append(data1, []byte(NetAddressKey(netAddr))...)
+	data1 = append(data1, []byte(NetAddressKeyV2(netAddr))...)
 	hash1 := chainhash.HashB(data1)
 	hash64 := binary.LittleEndian.Uint64(hash1)
 	hash64 %= triedBucketsPerGroup
@@ -338,7 +344,7 @@ func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddress) int {
 	binary.LittleEndian.PutUint64(hashbuf[:], hash64)
 	data2 := []byte{}
 	data2 = append(data2, a.key[:]...)
-	data2 = append(data2, GroupKey(netAddr)...)
+	data2 = append(data2, a.GroupKey(netAddr)...)
 	data2 = append(data2, hashbuf[:]...)
 
 	hash2 := chainhash.HashB(data2)
@@ -380,6 +386,7 @@ func (a *AddrManager) savePeers() {
 	sam := new(serializedAddrManager)
 	sam.Version = serialisationVersion
 	copy(sam.Key[:], a.key[:])
+	sam.ASMapVersion = a.asmap.Version()
 
 	sam.Addresses = make([]*serializedKnownAddress, len(a.addrIndex))
 	i := 0
@@ -387,7 +394,7 @@ func (a *AddrManager) savePeers() {
 		ska := new(serializedKnownAddress)
 		ska.Addr = k
 		ska.TimeStamp = v.na.Timestamp.Unix()
-		ska.Src = NetAddressKey(v.srcAddr)
+		ska.Src = NetAddressKeyV2(v.srcAddr)
 		ska.Attempts = v.attempts
 		ska.LastAttempt = v.lastattempt.Unix()
 		ska.LastSuccess = v.lastsuccess.Unix()
@@ -409,7 +416,7 @@ func (a *AddrManager) savePeers() {
 		j := 0
 		for e := a.addrTried[i].Front(); e != nil; e = e.Next() {
 			ka := e.Value.(*KnownAddress)
-			sam.TriedBuckets[i][j] = NetAddressKey(ka.na)
+			sam.TriedBuckets[i][j] = NetAddressKeyV2(ka.na)
 			j++
 		}
 	}
@@ -476,7 +483,9 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		return fmt.Errorf("error reading %s: %v", filePath, err)
 	}
 
-	if sam.Version != serialisationVersion {
+	// Version 1 is a subset of the current version, so it is read the
+	// same way.
+	if sam.Version != 1 && sam.Version != serialisationVersion {
 		return fmt.Errorf("unknown version %v in serialized "+
 			"addrmanager", sam.Version)
 	}
@@ -484,12 +493,12 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 
 	for _, v := range sam.Addresses {
 		ka := new(KnownAddress)
-		ka.na, err = a.DeserializeNetAddress(v.Addr)
+		ka.na, err = a.deserializeNetAddressV2(v.Addr)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Addr, err)
 		}
-		ka.srcAddr, err = a.DeserializeNetAddress(v.Src)
+		ka.srcAddr, err = a.deserializeNetAddressV2(v.Src)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Src, err)
@@ -497,7 +506,7 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		ka.attempts = v.Attempts
 		ka.lastattempt = time.Unix(v.LastAttempt, 0)
 		ka.lastsuccess = time.Unix(v.LastSuccess, 0)
-		a.addrIndex[NetAddressKey(ka.na)] = ka
+		a.addrIndex[NetAddressKeyV2(ka.na)] = ka
 	}
 
 	for i := range sam.NewBuckets {
@@ -542,9 +551,56 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		}
 	}
 
+	// The buckets depend on the groups of the addresses, so redistribute
+	// the addresses when the asmap changed since they were saved.
+	if sam.ASMapVersion != a.asmap.Version() {
+		log.Infof("Redistributing addresses over the buckets since the " +
+			"asmap changed")
+		a.rebucket()
+	}
+
 	return nil
 }
 
+// rebucket redistributes all addresses over the new and tried buckets.  Tried
+// addresses are moved to the new buckets when their tried bucket is full and
+// new addresses are dropped when their new bucket is full.
+//
+// This function MUST be called with the address manager lock held (for writes).
+func (a *AddrManager) rebucket() {
+	for i := range a.addrNew {
+		a.addrNew[i] = make(map[string]*KnownAddress)
+	}
+	for i := range a.addrTried {
+		a.addrTried[i] = list.New()
+	}
+	a.nNew = 0
+	a.nTried = 0
+
+	for k, ka := range a.addrIndex {
+		if ka.tried {
+			bucket := a.getTriedBucket(ka.na)
+			if a.addrTried[bucket].Len() < triedBucketSize {
+				a.addrTried[bucket].PushBack(ka)
+				a.nTried++
+				continue
+			}
+			ka.tried = false
+		}
+
+		ka.refs = 0
+		bucket := a.getNewBucket(ka.na, ka.srcAddr)
+		if len(a.addrNew[bucket]) >= newBucketSize {
+			delete(a.addrIndex, k)
+			continue
+		}
+		a.addrNew[bucket][k] = ka
+		ka.refs++
+		a.nNew++
+	}
+	a.addrChanged = true
+}
+
 // DeserializeNetAddress converts a given address string to a *wire.NetAddress
 func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, error) {
 	host, portStr, err := net.SplitHostPort(addr)
@@ -559,6 +615,22 @@ func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, erro
 	return a.HostToNetAddress(host, uint16(port), wire.SFNodeNetwork)
 }
 
+// deserializeNetAddressV2 converts a given address string to a
+// *wire.NetAddressV2.  Unlike DeserializeNetAddress, Tor v3 onion addresses
+// are supported.
+func (a *AddrManager) deserializeNetAddressV2(addr string) (*wire.NetAddressV2, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.HostToNetAddressV2(host, uint16(port), wire.SFNodeNetwork)
+}
+
 // Start begins the core address handler which manages a pool of known
 // addresses, timeouts, and interval based writes.
 func (a *AddrManager) Start() {
@@ -598,6 +670,20 @@ func (a *AddrManager) AddAddresses(addrs []*wire.NetAddress, srcAddr *wire.NetAd
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
+	srcAddrV2 := wire.NewNetAddressV2FromLegacy(srcAddr)
+	for _, na := range addrs {
+		a.updateAddress(wire.NewNetAddressV2FromLegacy(na), srcAddrV2)
+	}
+}
+
+// AddAddressesV2 adds new addresses of any network to the address manager.  It
+// enforces a max number of addresses and silently ignores duplicate addresses
+// as well as addresses of networks which are not supported.  It is safe for
+// concurrent access.
+func (a *AddrManager) AddAddressesV2(addrs []*wire.NetAddressV2, srcAddr *wire.NetAddressV2) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
 	for _, na := range addrs {
 		a.updateAddress(na, srcAddr)
 	}
@@ -610,7 +696,8 @@ func (a *AddrManager) AddAddress(addr, srcAddr *wire.NetAddress) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	a.updateAddress(addr, srcAddr)
+	a.updateAddress(wire.NewNetAddressV2FromLegacy(addr),
+		wire.NewNetAddressV2FromLegacy(srcAddr))
 }
 
 // addAddressByIP adds an address where we are given an ip:port and not a
@@ -650,8 +737,27 @@ func (a *AddrManager) NeedMoreAddresses() bool {
 }
 
 // AddressCache returns the current address cache.  It must be treated as
-// read-only (but since it is a copy now, this is not as dangerous).
+// read-only (but since it is a copy now, this is not as dangerous).  Addresses
+// which can not be represented by a wire.NetAddress, such as Tor v3 onion
+// addresses, are not included.  See AddressCacheV2.
 func (a *AddrManager) AddressCache() []*wire.NetAddress {
+	addrCache := a.AddressCacheV2()
+	if addrCache == nil {
+		return nil
+	}
+
+	legacyAddrs := make([]*wire.NetAddress, 0, len(addrCache))
+	for _, na := range addrCache {
+		if legacy, ok := na.ToLegacy(); ok {
+			legacyAddrs = append(legacyAddrs, legacy)
+		}
+	}
+	return legacyAddrs
+}
+
+// AddressCacheV2 returns the current address cache including the addresses of
+// all supported networks.  It must be treated as read-only.
+func (a *AddrManager) AddressCacheV2() []*wire.NetAddressV2 {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -661,7 +767,7 @@ func (a *AddrManager) AddressCache() []*wire.NetAddress {
 		return nil
 	}
 
-	allAddr := make([]*wire.NetAddress, 0, addrLen)
+	allAddr := make([]*wire.NetAddressV2, 0, addrLen)
 	// Iteration order is undefined here, but we randomise it anyway.
 	for _, v := range a.addrIndex {
 		// Skip low quality addresses.
@@ -743,6 +849,63 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.S
 	return wire.NewNetAddressIPPort(ip, port, services), nil
 }
 
+// torV3Version is the version byte of Tor v3 onion addresses.
+const torV3Version = 0x03
+
+// torV3Checksum returns the checksum of a Tor v3 onion address for the passed
+// public key of the onion service.
+func torV3Checksum(pubKey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubKey)
+	h.Write([]byte{torV3Version})
+	return h.Sum(nil)[:2]
+}
+
+// decodeTorV3 returns the public key of the onion service of the passed Tor v3
+// onion address without the ".onion" suffix.  An error is returned if the
+// address is not a valid Tor v3 onion address.
+func decodeTorV3(host string) ([]byte, error) {
+	// go base32 encoding uses capitals (as does the rfc but Tor tends to
+	// use lowercase, so we switch case here.
+	data, err := base32.StdEncoding.DecodeString(strings.ToUpper(host))
+	if err != nil {
+		return nil, err
+	}
+
+	// The address consists of the public key, checksum, and version.
+	if len(data) != 35 || data[34] != torV3Version {
+		return nil, fmt.Errorf("invalid Tor v3 onion address %s", host)
+	}
+	pubKey := data[:32]
+	if !bytes.Equal(data[32:34], torV3Checksum(pubKey)) {
+		return nil, fmt.Errorf("invalid checksum in Tor v3 onion "+
+			"address %s", host)
+	}
+
+	return pubKey, nil
+}
+
+// HostToNetAddressV2 returns a netaddress given a host address like
+// HostToNetAddress, but additionally supports Tor v3 .onion addresses.
+func (a *AddrManager) HostToNetAddressV2(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddressV2, error) {
+	// Tor v3 address is 56 char base32 + ".onion"
+	if len(host) == 62 && host[56:] == ".onion" {
+		pubKey, err := decodeTorV3(host[:56])
+		if err != nil {
+			return nil, err
+		}
+		return wire.NewNetAddressV2(time.Now(), services,
+			wire.NetIDTorV3, pubKey, port), nil
+	}
+
+	na, err := a.HostToNetAddress(host, port, services)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewNetAddressV2FromLegacy(na), nil
+}
+
 // ipString returns a string for the ip from the provided NetAddress. If the
 // ip is in the range used for Tor addresses then it will be transformed into
 // the relevant .onion address.
@@ -756,6 +919,27 @@ func ipString(na *wire.NetAddress) string {
 	return na.IP.String()
 }
 
+// hostStringV2 returns a string for the address of the provided NetAddressV2.
+// Tor v3 addresses are transformed into the relevant .onion address, all other
+// addresses are represented the same way as by ipString.  Addresses of
+// unsupported networks result in an empty string.
+func hostStringV2(na *wire.NetAddressV2) string {
+	if na.NetID == wire.NetIDTorV3 && len(na.Addr) == 32 {
+		data := make([]byte, 0, 35)
+		data = append(data, na.Addr...)
+		data = append(data, torV3Checksum(na.Addr)...)
+		data = append(data, torV3Version)
+		base32 := base32.StdEncoding.EncodeToString(data)
+		return strings.ToLower(base32) + ".onion"
+	}
+
+	legacy, ok := na.ToLegacy()
+	if !ok {
+		return ""
+	}
+	return ipString(legacy)
+}
+
 // NetAddressKey returns a string key in the form of ip:port for IPv4 addresses
 // or [ip]:port for IPv6 addresses.
 func NetAddressKey(na *wire.NetAddress) string {
@@ -764,11 +948,39 @@ func NetAddressKey(na *wire.NetAddress) string {
 	return net.JoinHostPort(ipString(na), port)
 }
 
-// GetAddress returns a single address that should be routable.  It picks a
+// NetAddressKeyV2 returns a string key for the provided NetAddressV2 in the
+// same form as NetAddressKey.  Tor v3 addresses result in a key of the form
+// onion:port.  The key of an address which can be represented by a
+// wire.NetAddress is the same as the one returned by NetAddressKey.
+func NetAddressKeyV2(na *wire.NetAddressV2) string {
+	port := strconv.FormatUint(uint64(na.Port), 10)
+
+	return net.JoinHostPort(hostStringV2(na), port)
+}
+
+// GetAddress returns a single address that should be routable and is
+// representable by a wire.NetAddress, so NetAddress of the returned known
+// address is never nil.  It returns nil when no such address was found.  See
+// GetAddressV2.
+func (a *AddrManager) GetAddress() *KnownAddress {
+	for tries := 0; tries < 100; tries++ {
+		ka := a.GetAddressV2()
+		if ka == nil {
+			return nil
+		}
+		if _, ok := ka.NetAddressV2().ToLegacy(); ok {
+			return ka
+		}
+	}
+	return nil
+}
+
+// GetAddressV2 returns a single address that should be routable.  It picks a
 // random one from the possible addresses with preference given to ones that
 // have not been used recently and should not pick 'close' addresses
-// consecutively.
-func (a *AddrManager) GetAddress() *KnownAddress {
+// consecutively.  Unlike GetAddress, the address may be of any supported
+// network, such as a Tor v3 onion address.
+func (a *AddrManager) GetAddressV2() *KnownAddress {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -797,7 +1009,7 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 			randval := a.rand.Intn(large)
 			if float64(randval) < (factor * ka.chance() * float64(large)) {
 				log.Tracef("Selected %v from tried bucket",
-					NetAddressKey(ka.na))
+					NetAddressKeyV2(ka.na))
 				return ka
 			}
 			factor *= 1.2
@@ -823,7 +1035,7 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 			randval := a.rand.Intn(large)
 			if float64(randval) < (factor * ka.chance() * float64(large)) {
 				log.Tracef("Selected %v from new bucket",
-					NetAddressKey(ka.na))
+					NetAddressKeyV2(ka.na))
 				return ka
 			}
 			factor *= 1.2
@@ -831,13 +1043,19 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 	}
 }
 
-func (a *AddrManager) find(addr *wire.NetAddress) *KnownAddress {
-	return a.addrIndex[NetAddressKey(addr)]
+func (a *AddrManager) find(addr *wire.NetAddressV2) *KnownAddress {
+	return a.addrIndex[NetAddressKeyV2(addr)]
 }
 
 // Attempt increases the given address' attempt counter and updates
 // the last attempt time.
 func (a *AddrManager) Attempt(addr *wire.NetAddress) {
+	a.AttemptV2(wire.NewNetAddressV2FromLegacy(addr))
+}
+
+// AttemptV2 increases the given address' attempt counter and updates the last
+// attempt time like Attempt.
+func (a *AddrManager) AttemptV2(addr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -859,6 +1077,12 @@ func (a *AddrManager) Attempt(addr *wire.NetAddress) {
 // current time.  The address must already be known to AddrManager else it will
 // be ignored.
 func (a *AddrManager) Connected(addr *wire.NetAddress) {
+	a.ConnectedV2(wire.NewNetAddressV2FromLegacy(addr))
+}
+
+// ConnectedV2 marks the given address as currently connected and working at
+// the current time like Connected.
+func (a *AddrManager) ConnectedV2(addr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -884,6 +1108,11 @@ func (a *AddrManager) Connected(addr *wire.NetAddress) {
 // connection and version exchange.  If the address is unknown to the address
 // manager it will be ignored.
 func (a *AddrManager) Good(addr *wire.NetAddress) {
+	a.GoodV2(wire.NewNetAddressV2FromLegacy(addr))
+}
+
+// GoodV2 marks the given address as good like Good.
+func (a *AddrManager) GoodV2(addr *wire.NetAddressV2) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -908,7 +1137,7 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 
 	// remove from all new buckets.
 	// record one of the buckets in question and call it the `first'
-	addrKey := NetAddressKey(addr)
+	addrKey := NetAddressKeyV2(addr)
 	oldBucket := -1
 	for i := range a.addrNew {
 		// we check for existence so we can record the first one
@@ -964,7 +1193,7 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	// something back.
 	a.nNew++
 
-	rmkey := NetAddressKey(rmka.na)
+	rmkey := NetAddressKeyV2(rmka.na)
 	log.Tracef("Replacing %s with %s in tried", rmkey, addrKey)
 
 	// We made sure there is space here just above.
@@ -973,6 +1202,12 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 
 // SetServices sets the services for the giiven address to the provided value.
 func (a *AddrManager) SetServices(addr *wire.NetAddress, services wire.ServiceFlag) {
+	a.SetServicesV2(wire.NewNetAddressV2FromLegacy(addr), services)
+}
+
+// SetServicesV2 sets the services for the given address to the provided value
+// like SetServices.
+func (a *AddrManager) SetServicesV2(addr *wire.NetAddressV2, services wire.ServiceFlag) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -992,6 +1227,19 @@ func (a *AddrManager) SetServices(addr *wire.NetAddress, services wire.ServiceFl
 	}
 }
 
+// KnownServices returns the services the given address is known to support
+// along with whether or not the address is known at all.
+func (a *AddrManager) KnownServices(addr *wire.NetAddressV2) (wire.ServiceFlag, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil {
+		return 0, false
+	}
+	return ka.NetAddressV2().Services, true
+}
+
 // AddLocalAddress adds na to the list of known local addresses to advertise
 // with the given priority.
 func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPriority) error {
@@ -1134,6 +1382,30 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 	return bestAddress
 }
 
+// SetASMap sets the asmap used to group addresses by the autonomous system
+// numbers (ASNs) of their networks instead of by their network prefixes.  It
+// must be called before Start.
+func (a *AddrManager) SetASMap(asmap *ASMap) {
+	a.asmap = asmap
+}
+
+// ASN returns the autonomous system number (ASN) of the passed address
+// according to the asmap, or 0 when no asmap is set or the address is not
+// mapped.
+func (a *AddrManager) ASN(na *wire.NetAddressV2) uint32 {
+	return a.asmap.ASN(na)
+}
+
+// GroupKey returns a string representing the network group the passed address
+// is part of.  This is the ASN of the address when it is mapped by the asmap
+// and the group returned by GroupKeyV2 otherwise.
+func (a *AddrManager) GroupKey(na *wire.NetAddressV2) string {
+	if asn := a.asmap.ASN(na); asn != 0 {
+		return fmt.Sprintf("as%d", asn)
+	}
+	return GroupKeyV2(na)
+}
+
 // New returns a new Bitum address manager.
 // Use Start to begin processing asynchronous address updates.
 // The address manager uses lookupFunc for necessary DNS lookups.
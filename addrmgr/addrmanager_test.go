@@ -316,6 +316,35 @@ func TestConnected(t *testing.T) {
 	}
 }
 
+func TestKnownServices(t *testing.T) {
+	n := New("testknownservices", lookupFunc)
+
+	// Ensure unknown addresses are reported as such.
+	na, err := n.HostToNetAddressV2(someIP, 8333, 0)
+	if err != nil {
+		t.Fatalf("HostToNetAddressV2 failed: %v", err)
+	}
+	if _, known := n.KnownServices(na); known {
+		t.Errorf("Address should not be known, but is")
+	}
+
+	// Add the address and ensure its services are reported after they
+	// changed.
+	err = n.addAddressByIP(someIP + ":8333")
+	if err != nil {
+		t.Fatalf("Adding address failed: %v", err)
+	}
+	want := wire.SFNodeNetwork | wire.SFNodeP2PV2
+	n.SetServicesV2(na, want)
+	services, known := n.KnownServices(na)
+	if !known {
+		t.Fatalf("Address should be known, but is not")
+	}
+	if services != want {
+		t.Errorf("Unexpected services - got %v, want %v", services, want)
+	}
+}
+
 func TestNeedMoreAddresses(t *testing.T) {
 	n := New("testneedmoreaddresses", lookupFunc)
 	addrsToAdd := 1500
@@ -559,3 +588,148 @@ func TestCorruptPeersFile(t *testing.T) {
 		t.Fatalf("Corrupt peers file has not been removed: %s", peersFile)
 	}
 }
+
+// torV3Host is a valid Tor v3 onion address used throughout the tests.
+const torV3Host = "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion"
+
+// TestHostToNetAddressV2 ensures Tor v3 onion addresses are converted to
+// addresses of the Tor v3 network and back to the same key.
+func TestHostToNetAddressV2(t *testing.T) {
+	n := New("testhosttonetaddressv2", lookupFunc)
+
+	na, err := n.HostToNetAddressV2(torV3Host, 9108, wire.SFNodeNetwork)
+	if err != nil {
+		t.Fatalf("HostToNetAddressV2: unexpected error: %v", err)
+	}
+	if na.NetID != wire.NetIDTorV3 || len(na.Addr) != 32 {
+		t.Fatalf("HostToNetAddressV2: wrong address - got %v %x",
+			na.NetID, na.Addr)
+	}
+	wantKey := torV3Host + ":9108"
+	if key := NetAddressKeyV2(na); key != wantKey {
+		t.Errorf("NetAddressKeyV2: got %s, want %s", key, wantKey)
+	}
+	if !IsRoutableV2(na) {
+		t.Errorf("IsRoutableV2: Tor v3 address is not routable")
+	}
+	if key := GroupKeyV2(na); key != "tor:13" {
+		t.Errorf("GroupKeyV2: got %s, want tor:13", key)
+	}
+
+	// Ensure legacy addresses have the same key as with NetAddressKey.
+	for _, test := range naTests {
+		na, err := n.HostToNetAddressV2(ipString(&test.in), test.in.Port,
+			wire.SFNodeNetwork)
+		if err != nil {
+			t.Errorf("HostToNetAddressV2: unexpected error: %v", err)
+			continue
+		}
+		if key := NetAddressKeyV2(na); key != test.want {
+			t.Errorf("NetAddressKeyV2: got %s, want %s", key, test.want)
+		}
+	}
+
+	// Ensure invalid Tor v3 onion addresses are rejected.
+	badChecksum := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaad.onion"
+	badVersion := "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczae.onion"
+	for _, host := range []string{badChecksum, badVersion} {
+		if _, err := n.HostToNetAddressV2(host, 9108, 0); err == nil {
+			t.Errorf("HostToNetAddressV2: accepted invalid address %s",
+				host)
+		}
+	}
+}
+
+// TestAddAddressesV2 ensures Tor v3 onion addresses are tracked by the address
+// manager, only returned by AddressCacheV2, and survive a restart.
+func TestAddAddressesV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testaddaddressesv2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := New(dir, lookupFunc)
+	n.Start()
+	torV3, err := n.HostToNetAddressV2(torV3Host, 9108, wire.SFNodeNetwork)
+	if err != nil {
+		t.Fatalf("HostToNetAddressV2: unexpected error: %v", err)
+	}
+	srcAddr := wire.NewNetAddressV2FromLegacy(wire.NewNetAddressIPPort(
+		net.IPv4(173, 144, 173, 111), 8333, 0))
+
+	// Add a mix of IPv4 and Tor v3 addresses along with an address of an
+	// unsupported network, which must be ignored.
+	addrs := []*wire.NetAddressV2{torV3}
+	for i := 0; i < 99; i++ {
+		pubKey := make([]byte, 32)
+		pubKey[0], pubKey[1] = byte(i), 0xff
+		addrs = append(addrs, wire.NewNetAddressV2(time.Now(),
+			wire.SFNodeNetwork, wire.NetIDTorV3, pubKey, 9108))
+	}
+	for i := 0; i < 100; i++ {
+		ip := net.IPv4(byte(i/64+60), 173, 147, byte(i%64+60))
+		addrs = append(addrs, wire.NewNetAddressV2FromLegacy(
+			wire.NewNetAddressIPPort(ip, 8333, wire.SFNodeNetwork)))
+	}
+	addrs = append(addrs, wire.NewNetAddressV2(time.Now(),
+		wire.SFNodeNetwork, wire.NetIDI2P, make([]byte, 32), 9108))
+	n.AddAddressesV2(addrs, srcAddr)
+	if numAddrs := n.numAddresses(); numAddrs > 200 {
+		t.Fatalf("Number of addresses is too many: %d vs 200", numAddrs)
+	}
+
+	// Mark the addresses as good so they are part of the address cache and
+	// ensure the legacy address cache only contains IPv4 addresses.
+	for _, addr := range addrs {
+		n.GoodV2(addr)
+	}
+	for _, na := range n.AddressCache() {
+		if na.IP.To4() == nil {
+			t.Errorf("AddressCache: unexpected address %v", na.IP)
+		}
+	}
+	var numTorV3 int
+	for _, na := range n.AddressCacheV2() {
+		if na.NetID == wire.NetIDTorV3 {
+			numTorV3++
+		}
+	}
+	if numTorV3 == 0 {
+		t.Errorf("AddressCacheV2: no Tor v3 addresses")
+	}
+
+	// Ensure GetAddress only returns addresses representable by a
+	// wire.NetAddress while GetAddressV2 returns Tor v3 addresses too.
+	numTorV3 = 0
+	for i := 0; i < 100; i++ {
+		if ka := n.GetAddress(); ka == nil || ka.NetAddress() == nil {
+			t.Fatalf("GetAddress: got address without legacy form")
+		}
+		ka := n.GetAddressV2()
+		if ka.NetAddressV2().NetID == wire.NetIDTorV3 {
+			numTorV3++
+		}
+	}
+	if numTorV3 == 0 {
+		t.Errorf("GetAddressV2: no Tor v3 addresses")
+	}
+
+	// Ensure the Tor v3 address is known after loading the peers file.
+	if n.find(torV3) == nil {
+		t.Fatalf("Tor v3 address %s not added", torV3Host)
+	}
+	numAddrs := n.numAddresses()
+	n.Stop()
+	n = New(dir, lookupFunc)
+	n.Start()
+	defer n.Stop()
+	if got := n.numAddresses(); got != numAddrs {
+		t.Fatalf("Wrong number of addresses after restart: got %d, "+
+			"want %d", got, numAddrs)
+	}
+	ka := n.find(torV3)
+	if ka == nil || !ka.tried || ka.NetAddress() != nil {
+		t.Fatalf("Tor v3 address %s not restored", torV3Host)
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/bits"
+	"net"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+// asmap instruction opcodes.
+const (
+	// asmapReturn returns the ASN which follows it.
+	asmapReturn = 0
+
+	// asmapJump consumes the next bit of the IP address and skips the
+	// number of bits of the map which follows it when the bit is set.
+	asmapJump = 1
+
+	// asmapMatch compares the next bits of the IP address with the bits
+	// which follow it and returns the default ASN when they differ.
+	asmapMatch = 2
+
+	// asmapDefault sets the default ASN to the ASN which follows it.
+	asmapDefault = 3
+)
+
+// asmapInvalid is returned when decoding a value of the map fails since it
+// straddles the end of the map.
+const asmapInvalid = 0xffffffff
+
+// asmapIPBits is the number of bits of the IPv6 addresses the map is
+// interpreted over.  IPv4 addresses are mapped into IPv6 addresses.
+const asmapIPBits = 128
+
+var (
+	// The following variables describe the variable length encodings of
+	// the opcodes, ASNs, match bits and jump offsets of the map.  Each
+	// value is encoded by a bit selecting whether it exceeds the range of
+	// the next mantissa size, followed by the mantissa bits of the first
+	// range it does not exceed.  The final range is not preceded by a bit.
+	asmapTypeBitSizes  = []uint8{0, 0, 1}
+	asmapASNBitSizes   = []uint8{15, 16, 17, 18, 19, 20, 21, 22, 23, 24}
+	asmapMatchBitSizes = []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+	asmapJumpBitSizes  = []uint8{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30}
+)
+
+// ASMap maps IP address prefixes to the autonomous system numbers (ASNs) of the
+// networks which announce them.  It uses the compact asmap format of Bitcoin
+// Core, which is a program over the bits of the IP address that is interpreted
+// to find the ASN of the address.
+type ASMap struct {
+	data    []byte
+	version string
+}
+
+// asmapReader reads the bits of an asmap starting at the least significant bit
+// of each byte.
+type asmapReader struct {
+	data []byte
+	pos  uint64
+	end  uint64
+}
+
+// readBit returns the next bit of the map.  The caller must ensure the end of
+// the map has not been reached.
+func (r *asmapReader) readBit() bool {
+	bit := r.data[r.pos/8]>>(r.pos%8)&1 == 1
+	r.pos++
+	return bit
+}
+
+// decode decodes the next value of the map with the passed minimum value and
+// mantissa sizes.  It returns asmapInvalid when the value straddles the end of
+// the map.
+func (r *asmapReader) decode(minVal uint32, bitSizes []uint8) uint32 {
+	val := minVal
+	for i, size := range bitSizes {
+		var bit bool
+		if i != len(bitSizes)-1 {
+			if r.pos == r.end {
+				break
+			}
+			bit = r.readBit()
+		}
+		if bit {
+			val += 1 << size
+			continue
+		}
+		for b := uint8(0); b < size; b++ {
+			if r.pos == r.end {
+				return asmapInvalid
+			}
+			if r.readBit() {
+				val += 1 << (size - 1 - b)
+			}
+		}
+		return val
+	}
+	return asmapInvalid
+}
+
+// ipBit returns the passed bit of the IP address starting at the most
+// significant bit of the first byte.
+func ipBit(ip net.IP, bit int) bool {
+	return ip[bit/8]>>(7-uint(bit%8))&1 == 1
+}
+
+// NewASMap returns the asmap encoded by the passed data.  An error is returned
+// when the data is not a valid asmap.
+func NewASMap(data []byte) (*ASMap, error) {
+	m := &ASMap{data: data}
+	if !m.sanityCheck() {
+		return nil, errors.New("malformed asmap")
+	}
+	m.version = chainhash.HashH(data).String()
+	return m, nil
+}
+
+// LoadASMap reads the asmap in the passed file.
+func LoadASMap(file string) (*ASMap, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewASMap(data)
+}
+
+// Version returns the hash of the asmap, which identifies it.  It returns an
+// empty string for a nil asmap.
+func (m *ASMap) Version() string {
+	if m == nil {
+		return ""
+	}
+	return m.version
+}
+
+// sanityCheck returns whether the map is valid.  This is the case when every
+// IP address ends on a return instruction without reading past the end of the
+// map or the address, all instructions are reachable, and the map is encoded
+// canonically.
+func (m *ASMap) sanityCheck() bool {
+	// jump describes a position of the map which may be jumped to along
+	// with the number of bits of the IP address left at that position.
+	type jump struct {
+		pos  uint64
+		bits int
+	}
+	var jumps []jump
+
+	r := asmapReader{data: m.data, end: uint64(len(m.data)) * 8}
+	ipBits := asmapIPBits
+	prevOpcode := uint32(asmapJump)
+	hadIncompleteMatch := false
+	for r.pos != r.end {
+		// Ensure there was no jump into the middle of the previous
+		// instruction.
+		if len(jumps) > 0 && r.pos >= jumps[len(jumps)-1].pos {
+			return false
+		}
+
+		switch opcode := r.decode(0, asmapTypeBitSizes); opcode {
+		case asmapReturn:
+			// A return directly after a default could be combined into
+			// just the return.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decode(1, asmapASNBitSizes) == asmapInvalid {
+				return false
+			}
+			if len(jumps) == 0 {
+				// Nothing is left to execute, so only zero padding
+				// up to the next byte may follow.
+				if r.end-r.pos > 7 {
+					return false
+				}
+				for r.pos != r.end {
+					if r.readBit() {
+						return false
+					}
+				}
+				return true
+			}
+
+			// Continue as if the last jump was taken, which must
+			// target the next instruction to not leave unreachable
+			// code.
+			last := jumps[len(jumps)-1]
+			if r.pos != last.pos {
+				return false
+			}
+			ipBits = last.bits
+			jumps = jumps[:len(jumps)-1]
+			prevOpcode = asmapJump
+
+		case asmapJump:
+			offset := r.decode(17, asmapJumpBitSizes)
+			if offset == asmapInvalid || uint64(offset) > r.end-r.pos {
+				return false
+			}
+			if ipBits == 0 {
+				return false
+			}
+			ipBits--
+			target := r.pos + uint64(offset)
+			if len(jumps) > 0 && target >= jumps[len(jumps)-1].pos {
+				return false
+			}
+			jumps = append(jumps, jump{pos: target, bits: ipBits})
+			prevOpcode = asmapJump
+
+		case asmapMatch:
+			match := r.decode(2, asmapMatchBitSizes)
+			if match == asmapInvalid {
+				return false
+			}
+			matchLen := bits.Len32(match) - 1
+			if prevOpcode != asmapMatch {
+				hadIncompleteMatch = false
+			}
+
+			// Only the last of a sequence of matches may match fewer
+			// than 8 bits.
+			if matchLen < 8 && hadIncompleteMatch {
+				return false
+			}
+			hadIncompleteMatch = matchLen < 8
+			if ipBits < matchLen {
+				return false
+			}
+			ipBits -= matchLen
+			prevOpcode = asmapMatch
+
+		case asmapDefault:
+			// Successive defaults could be combined into one.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decode(1, asmapASNBitSizes) == asmapInvalid {
+				return false
+			}
+			prevOpcode = asmapDefault
+
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// lookup returns the ASN of the passed 16-byte IP address, or 0 when it is not
+// mapped.
+func (m *ASMap) lookup(ip net.IP) uint32 {
+	r := asmapReader{data: m.data, end: uint64(len(m.data)) * 8}
+	ipBits := asmapIPBits
+	var defaultASN uint32
+	for r.pos != r.end {
+		switch r.decode(0, asmapTypeBitSizes) {
+		case asmapReturn:
+			asn := r.decode(1, asmapASNBitSizes)
+			if asn == asmapInvalid {
+				return 0
+			}
+			return asn
+
+		case asmapJump:
+			offset := r.decode(17, asmapJumpBitSizes)
+			if offset == asmapInvalid || ipBits == 0 ||
+				uint64(offset) >= r.end-r.pos {
+
+				return 0
+			}
+			if ipBit(ip, asmapIPBits-ipBits) {
+				r.pos += uint64(offset)
+			}
+			ipBits--
+
+		case asmapMatch:
+			match := r.decode(2, asmapMatchBitSizes)
+			if match == asmapInvalid {
+				return 0
+			}
+			matchLen := bits.Len32(match) - 1
+			if ipBits < matchLen {
+				return 0
+			}
+			for i := 0; i < matchLen; i++ {
+				want := match>>uint(matchLen-1-i)&1 == 1
+				if ipBit(ip, asmapIPBits-ipBits) != want {
+					return defaultASN
+				}
+				ipBits--
+			}
+
+		case asmapDefault:
+			defaultASN = r.decode(1, asmapASNBitSizes)
+			if defaultASN == asmapInvalid {
+				return 0
+			}
+
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// mappedIP returns the IP address the ASN of the passed address is looked up
+// for.  This is the embedded IPv4 address for addresses of IPv6 transition
+// mechanisms which embed one, like GroupKey.
+func mappedIP(na *wire.NetAddress) net.IP {
+	switch {
+	case isIPv4(na):
+		return na.IP.To16()
+	case isRFC6145(na) || isRFC6052(na):
+		return net.IP(na.IP[12:16]).To16()
+	case isRFC3964(na):
+		return net.IP(na.IP[2:6]).To16()
+	case isRFC4380(na):
+		ip := net.IP(make([]byte, 4))
+		for i, b := range na.IP[12:16] {
+			ip[i] = b ^ 0xff
+		}
+		return ip.To16()
+	}
+	return na.IP.To16()
+}
+
+// ASN returns the ASN of the passed address, or 0 when it is not mapped.  Only
+// routable IPv4 and IPv6 addresses are mapped.  It returns 0 for a nil asmap.
+func (m *ASMap) ASN(na *wire.NetAddressV2) uint32 {
+	if m == nil || !IsRoutableV2(na) {
+		return 0
+	}
+	legacy, ok := na.ToLegacy()
+	if !ok || isOnionCatTor(legacy) {
+		return 0
+	}
+	return m.lookup(mappedIP(legacy))
+}
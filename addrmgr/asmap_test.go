@@ -0,0 +1,286 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/bitum-project/bitumd/wire"
+)
+
+// asmapNode is a node of a binary trie over the bits of IP addresses which is
+// compiled to an asmap by compileASMap.  Leaves have a non-zero ASN.
+type asmapNode struct {
+	children [2]*asmapNode
+	asn      uint32
+}
+
+// insert maps the passed IP address prefix to the passed ASN.  Prefixes may not
+// contain each other.
+func (n *asmapNode) insert(ip net.IP, prefixLen int, asn uint32) {
+	ip = ip.To16()
+	for i := 0; i < prefixLen; i++ {
+		bit := 0
+		if ipBit(ip, i) {
+			bit = 1
+		}
+		if n.children[bit] == nil {
+			n.children[bit] = new(asmapNode)
+		}
+		n = n.children[bit]
+	}
+	n.asn = asn
+}
+
+// encodeASMapValue returns the bits of the passed value encoded with the passed
+// minimum value and mantissa sizes.  It is the inverse of asmapReader.decode.
+func encodeASMapValue(val, minVal uint32, bitSizes []uint8) []bool {
+	var out []bool
+	val -= minVal
+	for i, size := range bitSizes {
+		if i != len(bitSizes)-1 {
+			if val >= 1<<size {
+				out = append(out, true)
+				val -= 1 << size
+				continue
+			}
+			out = append(out, false)
+		}
+		for b := int(size) - 1; b >= 0; b-- {
+			out = append(out, val>>uint(b)&1 == 1)
+		}
+		break
+	}
+	return out
+}
+
+// compileASMapNode returns the bits of the asmap program for the trie rooted at
+// the passed node.  Chains of nodes with a single child are compiled to match
+// instructions and nodes with two children to jump instructions.
+func compileASMapNode(n *asmapNode) []bool {
+	var code []bool
+	var match []bool
+	for n.asn == 0 && (n.children[0] == nil) != (n.children[1] == nil) {
+		if n.children[0] != nil {
+			match = append(match, false)
+			n = n.children[0]
+		} else {
+			match = append(match, true)
+			n = n.children[1]
+		}
+	}
+	for len(match) > 0 {
+		chunk := match
+		if len(chunk) > 8 {
+			chunk = chunk[:8]
+		}
+		match = match[len(chunk):]
+		val := uint32(1)
+		for _, bit := range chunk {
+			val <<= 1
+			if bit {
+				val |= 1
+			}
+		}
+		code = append(code, encodeASMapValue(asmapMatch, 0,
+			asmapTypeBitSizes)...)
+		code = append(code, encodeASMapValue(val, 2,
+			asmapMatchBitSizes)...)
+	}
+
+	if n.asn != 0 {
+		code = append(code, encodeASMapValue(asmapReturn, 0,
+			asmapTypeBitSizes)...)
+		return append(code, encodeASMapValue(n.asn, 1,
+			asmapASNBitSizes)...)
+	}
+	left := compileASMapNode(n.children[0])
+	right := compileASMapNode(n.children[1])
+	code = append(code, encodeASMapValue(asmapJump, 0,
+		asmapTypeBitSizes)...)
+	code = append(code, encodeASMapValue(uint32(len(left)), 17,
+		asmapJumpBitSizes)...)
+	code = append(code, left...)
+	return append(code, right...)
+}
+
+// compileASMap returns the asmap for the trie rooted at the passed node.
+func compileASMap(root *asmapNode) []byte {
+	code := compileASMapNode(root)
+	data := make([]byte, (len(code)+7)/8)
+	for i, bit := range code {
+		if bit {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// testASMap returns an asmap which maps 1.2.0.0/16 to AS100, 1.3.0.0/16 to
+// AS200, and 2a00:1450::/32 to AS300.
+func testASMap() []byte {
+	root := new(asmapNode)
+	root.insert(net.ParseIP("1.2.0.0"), 96+16, 100)
+	root.insert(net.ParseIP("1.3.0.0"), 96+16, 200)
+	root.insert(net.ParseIP("2a00:1450::"), 32, 300)
+	return compileASMap(root)
+}
+
+// newTestNetAddressV2 returns an address with the passed IP address.
+func newTestNetAddressV2(ip string) *wire.NetAddressV2 {
+	return wire.NewNetAddressV2FromLegacy(wire.NewNetAddressIPPort(
+		net.ParseIP(ip), 8333, wire.SFNodeNetwork))
+}
+
+// TestASMap ensures the ASNs of addresses are looked up according to the asmap
+// and malformed asmaps are rejected.
+func TestASMap(t *testing.T) {
+	data := testASMap()
+	asmap, err := NewASMap(data)
+	if err != nil {
+		t.Fatalf("NewASMap: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want uint32
+	}{
+		{name: "ipv4 first prefix", ip: "1.2.3.4", want: 100},
+		{name: "ipv4 second prefix", ip: "1.3.255.1", want: 200},
+		{name: "ipv4 unmapped", ip: "1.4.0.1", want: 0},
+		{name: "ipv4 unroutable", ip: "10.1.2.3", want: 0},
+		{name: "ipv6 prefix", ip: "2a00:1450:4001::1", want: 300},
+		{name: "ipv6 unmapped", ip: "2a00:1451::1", want: 0},
+		{name: "ipv6 rfc3964 with ipv4 encap", ip: "2002:0102:0304::", want: 100},
+		{name: "ipv6 rfc4380 toredo ipv4", ip: "2001:0:1234::fefd:fcfb", want: 100},
+		{name: "ipv6 rfc6052 well-known prefix with ipv4", ip: "64:ff9b::0103:0203", want: 200},
+		{name: "ipv6 tor onioncat", ip: "fd87:d87e:eb43:1234::5678", want: 0},
+	}
+	for _, test := range tests {
+		if asn := asmap.ASN(newTestNetAddressV2(test.ip)); asn != test.want {
+			t.Errorf("%s: unexpected ASN - got %d, want %d", test.name,
+				asn, test.want)
+		}
+	}
+
+	var nilASMap *ASMap
+	if asn := nilASMap.ASN(newTestNetAddressV2("1.2.3.4")); asn != 0 {
+		t.Errorf("nil asmap: unexpected ASN %d", asn)
+	}
+
+	malformed := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "truncated", data: data[:len(data)-2]},
+		{name: "excessive padding", data: append(append([]byte{},
+			data...), 0)},
+		{name: "missing return", data: []byte{0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, test := range malformed {
+		if _, err := NewASMap(test.data); err == nil {
+			t.Errorf("%s: malformed asmap was accepted", test.name)
+		}
+	}
+}
+
+// TestASMapGroupKey ensures the address manager groups addresses by their ASNs
+// when an asmap is set and falls back to their network prefixes otherwise.
+func TestASMapGroupKey(t *testing.T) {
+	asmap, err := NewASMap(testASMap())
+	if err != nil {
+		t.Fatalf("NewASMap: unexpected error: %v", err)
+	}
+
+	n := New("testasmapgroupkey", lookupFunc)
+	if key := n.GroupKey(newTestNetAddressV2("1.2.3.4")); key != "1.2.0.0" {
+		t.Errorf("GroupKey without asmap: got %q, want %q", key,
+			"1.2.0.0")
+	}
+
+	n.SetASMap(asmap)
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "1.2.3.4", want: "as100"},
+		{ip: "1.3.3.4", want: "as200"},
+		{ip: "2a00:1450::1", want: "as300"},
+		{ip: "5.6.7.8", want: "5.6.0.0"},
+		{ip: "127.0.0.1", want: "local"},
+	}
+	for _, test := range tests {
+		if key := n.GroupKey(newTestNetAddressV2(test.ip)); key != test.want {
+			t.Errorf("GroupKey(%s): got %q, want %q", test.ip, key,
+				test.want)
+		}
+	}
+}
+
+// TestASMapRebucket ensures the addresses are redistributed over the buckets
+// when they are loaded with a different asmap than they were saved with.
+func TestASMapRebucket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testasmaprebucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	asmap, err := NewASMap(testASMap())
+	if err != nil {
+		t.Fatalf("NewASMap: unexpected error: %v", err)
+	}
+
+	// Add addresses from both mapped networks and mark some of them good so
+	// there are both new and tried addresses.
+	n := New(dir, lookupFunc)
+	n.Start()
+	srcAddr := newTestNetAddressV2("173.144.173.111")
+	var addrs []*wire.NetAddressV2
+	for i := 0; i < 100; i++ {
+		ip := net.IPv4(1, byte(2+i%2), byte(i), 1)
+		addrs = append(addrs, wire.NewNetAddressV2FromLegacy(
+			wire.NewNetAddressIPPort(ip, 8333, wire.SFNodeNetwork)))
+	}
+	n.AddAddressesV2(addrs, srcAddr)
+	for _, addr := range addrs[:20] {
+		n.GoodV2(addr)
+	}
+	numAddrs := n.numAddresses()
+	if err := n.Stop(); err != nil {
+		t.Fatalf("Stop: unexpected error: %v", err)
+	}
+
+	n = New(dir, lookupFunc)
+	n.SetASMap(asmap)
+	n.Start()
+	defer n.Stop()
+	if got := n.numAddresses(); got != numAddrs {
+		t.Fatalf("unexpected number of addresses - got %d, want %d",
+			got, numAddrs)
+	}
+	for i := range n.addrNew {
+		for _, ka := range n.addrNew[i] {
+			if bucket := n.getNewBucket(ka.na, ka.srcAddr); bucket != i {
+				t.Errorf("new address %v in bucket %d, want %d",
+					ka.na.Addr, i, bucket)
+			}
+		}
+	}
+	for i := range n.addrTried {
+		for e := n.addrTried[i].Front(); e != nil; e = e.Next() {
+			ka := e.Value.(*KnownAddress)
+			if bucket := n.getTriedBucket(ka.na); bucket != i {
+				t.Errorf("tried address %v in bucket %d, want %d",
+					ka.na.Addr, i, bucket)
+			}
+		}
+	}
+}
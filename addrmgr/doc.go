@@ -29,8 +29,15 @@ generally helps provide greater peer diversity, and perhaps more importantly,
 drastically reduces the chances an attacker is able to coerce your peer into
 only connecting to nodes they control.
 
-The address manager also understands routability and Tor addresses and tries
-hard to only return routable addresses.  In addition, it uses the information
+Optionally, an asmap which maps IP address prefixes to the autonomous system
+numbers (ASNs) of the networks announcing them may be set with SetASMap.  The
+addresses are then grouped by their ASNs instead of by their network prefixes,
+so a single network operator which announces many prefixes is not able to
+occupy many groups.
+
+The address manager also understands routability and Tor addresses, including
+Tor v3 onion addresses which are tracked as wire.NetAddressV2, and tries hard to
+only return routable addresses.  In addition, it uses the information
 provided by the caller about connected, known good, and attempted addresses to
 periodically purge peers which no longer appear to be good peers as well as
 bias the selection toward known good peers.  The general idea is to make a best
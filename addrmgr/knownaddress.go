@@ -16,8 +16,8 @@ import (
 // to determine how viable an address is.
 type KnownAddress struct {
 	mtx         sync.Mutex
-	na          *wire.NetAddress
-	srcAddr     *wire.NetAddress
+	na          *wire.NetAddressV2
+	srcAddr     *wire.NetAddressV2
 	attempts    int
 	lastattempt time.Time
 	lastsuccess time.Time
@@ -25,9 +25,23 @@ type KnownAddress struct {
 	refs        int // reference count of new buckets
 }
 
-// NetAddress returns the underlying wire.NetAddress associated with the
-// known address.
+// NetAddress returns the wire.NetAddress associated with the known address.
+// It is never nil for the addresses returned by GetAddress.  It returns nil when
+// the address can not be represented by a wire.NetAddress, such as for Tor v3
+// onion addresses returned by GetAddressV2.  See NetAddressV2.
 func (ka *KnownAddress) NetAddress() *wire.NetAddress {
+	ka.mtx.Lock()
+	defer ka.mtx.Unlock()
+	na, ok := ka.na.ToLegacy()
+	if !ok {
+		return nil
+	}
+	return na
+}
+
+// NetAddressV2 returns the underlying wire.NetAddressV2 associated with the
+// known address.
+func (ka *KnownAddress) NetAddressV2() *wire.NetAddressV2 {
 	ka.mtx.Lock()
 	defer ka.mtx.Unlock()
 	return ka.na
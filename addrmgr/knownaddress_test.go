@@ -14,7 +14,7 @@ import (
 )
 
 func newKnownAddress(na *wire.NetAddress, attempts int, lastattempt, lastsuccess time.Time, tried bool, refs int) *KnownAddress {
-	return &KnownAddress{na: na, attempts: attempts, lastattempt: lastattempt,
+	return &KnownAddress{na: wire.NewNetAddressV2FromLegacy(na), attempts: attempts, lastattempt: lastattempt,
 		lastsuccess: lastsuccess, tried: tried, refs: refs}
 }
 
@@ -280,3 +280,36 @@ func GroupKey(na *wire.NetAddress) string {
 
 	return na.IP.Mask(net.CIDRMask(bits, 128)).String()
 }
+
+// IsRoutableV2 returns whether or not the passed address is routable over the
+// public internet or the Tor network.  Addresses which can be represented by a
+// wire.NetAddress are routable under the same circumstances as with
+// IsRoutable.  Tor v3 addresses are always routable, while the addresses of all
+// other networks are not supported and thus are considered unroutable.
+func IsRoutableV2(na *wire.NetAddressV2) bool {
+	if na.NetID == wire.NetIDTorV3 {
+		return len(na.Addr) == 32
+	}
+
+	legacy, ok := na.ToLegacy()
+	return ok && IsRoutable(legacy)
+}
+
+// GroupKeyV2 returns a string representing the network group an address is
+// part of like GroupKey.  Tor v3 addresses are grouped with Tor addresses by
+// the /4 of the onion address.
+func GroupKeyV2(na *wire.NetAddressV2) string {
+	if !IsRoutableV2(na) {
+		if legacy, ok := na.ToLegacy(); ok {
+			return GroupKey(legacy)
+		}
+		return "unroutable"
+	}
+	if na.NetID == wire.NetIDTorV3 {
+		// group is keyed off the first 4 bits of the onion address.
+		return fmt.Sprintf("tor:%d", na.Addr[0]&((1<<4)-1))
+	}
+
+	legacy, _ := na.ToLegacy()
+	return GroupKey(legacy)
+}
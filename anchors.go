@@ -0,0 +1,75 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	// anchorsFilename is the name of the file the addresses of the
+	// block-relay-only peers are saved to in the data directory on
+	// shutdown.
+	anchorsFilename = "anchors.json"
+
+	// anchorsVersion is the current version of the serialized anchors.
+	anchorsVersion = 1
+)
+
+// serializedAnchors is the serialized form of the anchors file.
+type serializedAnchors struct {
+	Version int      `json:"version"`
+	Anchors []string `json:"anchors"`
+}
+
+// saveAnchors saves the passed addresses of the block-relay-only peers to the
+// anchors file so they are reconnected first at the next startup.
+func saveAnchors(file string, addrs []string) error {
+	sa := serializedAnchors{
+		Version: anchorsVersion,
+		Anchors: addrs,
+	}
+
+	tmpfile := file + ".new"
+	w, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(&sa); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpfile, file)
+}
+
+// loadAnchors returns the addresses saved to the anchors file and removes the
+// file, so the anchors are not reconnected again after an unclean shutdown.  A
+// missing file results in no anchors.
+func loadAnchors(file string) ([]string, error) {
+	r, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file)
+	defer r.Close()
+
+	var sa serializedAnchors
+	if err := json.NewDecoder(r).Decode(&sa); err != nil {
+		return nil, err
+	}
+	if sa.Version != anchorsVersion {
+		return nil, fmt.Errorf("unknown version %d in serialized anchors",
+			sa.Version)
+	}
+	return sa.Anchors, nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestAnchors ensures the anchors survive a save and load round trip and are
+// only loaded once.
+func TestAnchors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anchors")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, anchorsFilename)
+	want := []string{"1.2.3.4:9208", "[2001:db8::1]:9208"}
+	if err := saveAnchors(file, want); err != nil {
+		t.Fatalf("saveAnchors: unexpected error: %v", err)
+	}
+	got, err := loadAnchors(file)
+	if err != nil {
+		t.Fatalf("loadAnchors: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("loadAnchors: got %v, want %v", got, want)
+	}
+
+	// Ensure the file was removed so the anchors are not loaded again.
+	got, err = loadAnchors(file)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("loadAnchors: got %v (%v) after loading, want none",
+			got, err)
+	}
+}
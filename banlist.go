@@ -0,0 +1,289 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// banListFilename is the name of the file the ban list is saved to in
+	// the data directory.
+	banListFilename = "banlist.json"
+
+	// banListVersion is the current version of the serialized ban list.
+	banListVersion = 1
+
+	// banReasonMisbehaving is the reason recorded for peers which are
+	// banned for exceeding the ban threshold.
+	banReasonMisbehaving = "node misbehaving"
+
+	// banReasonManual is the reason recorded for subnets which are banned
+	// via the setban RPC.
+	banReasonManual = "manually added"
+
+	// maxBanDuration is the maximum duration a subnet can be banned for via
+	// the setban RPC.  It keeps the requested ban time from overflowing
+	// once it is converted to a duration.
+	maxBanDuration = 100 * 365 * 24 * time.Hour
+)
+
+// banEntry describes a banned subnet along with when and why it was banned and
+// when the ban expires.
+type banEntry struct {
+	subnet  *net.IPNet
+	created time.Time
+	until   time.Time
+	reason  string
+}
+
+// serializedBanEntry is the serialized form of a banEntry.
+type serializedBanEntry struct {
+	Subnet  string `json:"subnet"`
+	Created int64  `json:"created"`
+	Until   int64  `json:"until"`
+	Reason  string `json:"reason"`
+}
+
+// serializedBanList is the serialized form of a banList.
+type serializedBanList struct {
+	Version int                  `json:"version"`
+	Bans    []serializedBanEntry `json:"bans"`
+}
+
+// banList houses the banned subnets and keeps them saved to a file so they
+// survive restarts.  Single IP addresses are banned as subnets which contain
+// only that address.
+//
+// The ban list is not safe for concurrent access.  It is owned by the peer
+// handler of the server.
+type banList struct {
+	file string
+	bans map[string]*banEntry // keyed by subnet
+}
+
+// newBanList returns a new empty ban list which is saved to the passed file.
+func newBanList(file string) *banList {
+	return &banList{
+		file: file,
+		bans: make(map[string]*banEntry),
+	}
+}
+
+// parseSubnet parses the passed string as either an IP address or a subnet in
+// CIDR notation and returns the subnet.  IP addresses result in a subnet which
+// contains only that address.
+func parseSubnet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q", s)
+		}
+		return subnet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// banUntil returns the time a ban requested via the setban RPC at the passed
+// time expires.  The ban time is either the number of seconds to ban for or,
+// when absolute is set, the unix time the ban expires.  Zero selects the passed
+// default duration.  Ban times which are negative, in the past, or beyond
+// maxBanDuration are rejected.
+func banUntil(banTime int64, absolute bool, defaultDuration time.Duration, now time.Time) (time.Time, error) {
+	if banTime < 0 {
+		return time.Time{}, errors.New("ban time must not be negative")
+	}
+	latest := now.Add(maxBanDuration)
+	if absolute {
+		until := time.Unix(banTime, 0)
+		if !until.After(now) {
+			return time.Time{}, errors.New("absolute ban time must " +
+				"be in the future")
+		}
+		if until.After(latest) {
+			return time.Time{}, fmt.Errorf("absolute ban time must "+
+				"not be later than %d", latest.Unix())
+		}
+		return until, nil
+	}
+	if banTime == 0 {
+		return now.Add(defaultDuration), nil
+	}
+	if banTime > int64(maxBanDuration/time.Second) {
+		return time.Time{}, fmt.Errorf("ban time must not exceed %d "+
+			"seconds", int64(maxBanDuration/time.Second))
+	}
+	return now.Add(time.Duration(banTime) * time.Second), nil
+}
+
+// ban bans the passed subnet until the provided time.  An existing ban of the
+// same subnet is replaced.  Bans which expired are removed first.
+func (bl *banList) ban(subnet *net.IPNet, until time.Time, reason string) {
+	bl.removeExpired()
+	bl.bans[subnet.String()] = &banEntry{
+		subnet:  subnet,
+		created: time.Now(),
+		until:   until,
+		reason:  reason,
+	}
+}
+
+// isSubnetBanned returns whether or not the passed subnet itself is currently
+// banned.  Bans of subnets which merely contain it are not considered.  The ban
+// of the subnet is removed when it expired.
+func (bl *banList) isSubnetBanned(subnet *net.IPNet) bool {
+	key := subnet.String()
+	entry, ok := bl.bans[key]
+	if ok && !time.Now().Before(entry.until) {
+		delete(bl.bans, key)
+		return false
+	}
+	return ok
+}
+
+// unban removes the ban of the passed subnet and returns whether or not it was
+// banned.
+func (bl *banList) unban(subnet *net.IPNet) bool {
+	key := subnet.String()
+	if _, ok := bl.bans[key]; !ok {
+		return false
+	}
+	delete(bl.bans, key)
+	return true
+}
+
+// clear removes all bans.
+func (bl *banList) clear() {
+	bl.bans = make(map[string]*banEntry)
+}
+
+// bannedUntil returns the time the latest expiring ban of a subnet which
+// contains the passed IP address expires and whether or not the address is
+// banned at all.  Bans which expired are removed along the way.
+func (bl *banList) bannedUntil(ip net.IP) (time.Time, bool) {
+	var until time.Time
+	now := time.Now()
+	for key, entry := range bl.bans {
+		if !now.Before(entry.until) {
+			delete(bl.bans, key)
+			continue
+		}
+		if entry.subnet.Contains(ip) && entry.until.After(until) {
+			until = entry.until
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// removeExpired removes all bans which expired and returns the number of
+// removed bans.
+func (bl *banList) removeExpired() int {
+	var removed int
+	now := time.Now()
+	for key, entry := range bl.bans {
+		if !now.Before(entry.until) {
+			delete(bl.bans, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// entries returns a copy of all bans sorted by subnet.
+func (bl *banList) entries() []banEntry {
+	entries := make([]banEntry, 0, len(bl.bans))
+	for _, entry := range bl.bans {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].subnet.String() < entries[j].subnet.String()
+	})
+	return entries
+}
+
+// save writes the ban list to its file.  The file is written atomically by
+// writing to a temporary file first which then replaces it.
+func (bl *banList) save() error {
+	sbl := serializedBanList{
+		Version: banListVersion,
+		Bans:    make([]serializedBanEntry, 0, len(bl.bans)),
+	}
+	for _, entry := range bl.entries() {
+		sbl.Bans = append(sbl.Bans, serializedBanEntry{
+			Subnet:  entry.subnet.String(),
+			Created: entry.created.Unix(),
+			Until:   entry.until.Unix(),
+			Reason:  entry.reason,
+		})
+	}
+
+	tmpfile := bl.file + ".new"
+	w, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(&sbl); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpfile, bl.file)
+}
+
+// load reads the ban list from its file, skipping bans which expired in the
+// meantime.  A missing file results in an empty ban list.
+func (bl *banList) load() error {
+	r, err := os.Open(bl.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var sbl serializedBanList
+	if err := json.NewDecoder(r).Decode(&sbl); err != nil {
+		return err
+	}
+	if sbl.Version != banListVersion {
+		return fmt.Errorf("unknown version %d in serialized ban list",
+			sbl.Version)
+	}
+
+	bans := make(map[string]*banEntry, len(sbl.Bans))
+	for _, sbe := range sbl.Bans {
+		subnet, err := parseSubnet(sbe.Subnet)
+		if err != nil {
+			return err
+		}
+		bans[subnet.String()] = &banEntry{
+			subnet:  subnet,
+			created: time.Unix(sbe.Created, 0),
+			until:   time.Unix(sbe.Until, 0),
+			reason:  sbe.Reason,
+		}
+	}
+	bl.bans = bans
+	bl.removeExpired()
+	return nil
+}
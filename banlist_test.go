@@ -0,0 +1,227 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestParseSubnet ensures IP addresses and subnets are parsed as expected.
+func TestParseSubnet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "1.2.3.4", want: "1.2.3.4/32"},
+		{in: "::ffff:1.2.3.4", want: "1.2.3.4/32"},
+		{in: "2001:db8::1", want: "2001:db8::1/128"},
+		{in: "192.168.1.1/16", want: "192.168.0.0/16"},
+		{in: "2001:db8::/32", want: "2001:db8::/32"},
+		{in: "1.2.3", err: true},
+		{in: "1.2.3.4/33", err: true},
+		{in: "example.com", err: true},
+	}
+
+	for _, test := range tests {
+		subnet, err := parseSubnet(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseSubnet(%q): unexpected success", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSubnet(%q): unexpected error: %v", test.in, err)
+			continue
+		}
+		if subnet.String() != test.want {
+			t.Errorf("parseSubnet(%q): got %v, want %v", test.in, subnet,
+				test.want)
+		}
+	}
+}
+
+// TestBanList ensures bans cover the addresses of their subnets, expire, and
+// survive a save and load round trip.
+func TestBanList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mustParseSubnet := func(s string) *net.IPNet {
+		subnet, err := parseSubnet(s)
+		if err != nil {
+			t.Fatalf("parseSubnet(%q): %v", s, err)
+		}
+		return subnet
+	}
+
+	file := filepath.Join(dir, banListFilename)
+	bl := newBanList(file)
+	now := time.Now()
+	bl.ban(mustParseSubnet("10.0.0.0/8"), now.Add(time.Hour), banReasonManual)
+	bl.ban(mustParseSubnet("10.1.2.3"), now.Add(2*time.Hour),
+		banReasonMisbehaving)
+	bl.ban(mustParseSubnet("2001:db8::1"), now.Add(-time.Second),
+		banReasonManual)
+
+	// Ensure addresses are banned until the latest expiring ban which
+	// covers them and expired bans are ignored.
+	tests := []struct {
+		ip     string
+		banned bool
+		until  time.Time
+	}{
+		{ip: "10.5.5.5", banned: true, until: now.Add(time.Hour)},
+		{ip: "10.1.2.3", banned: true, until: now.Add(2 * time.Hour)},
+		{ip: "11.0.0.1", banned: false},
+		{ip: "2001:db8::1", banned: false},
+	}
+	for _, test := range tests {
+		until, banned := bl.bannedUntil(net.ParseIP(test.ip))
+		if banned != test.banned {
+			t.Errorf("bannedUntil(%s): got banned %v, want %v", test.ip,
+				banned, test.banned)
+			continue
+		}
+		if banned && !until.Equal(test.until) {
+			t.Errorf("bannedUntil(%s): got %v, want %v", test.ip, until,
+				test.until)
+		}
+	}
+	if !bl.isSubnetBanned(mustParseSubnet("10.0.0.0/8")) {
+		t.Errorf("isSubnetBanned: 10.0.0.0/8 is not banned")
+	}
+	if bl.isSubnetBanned(mustParseSubnet("10.0.0.0/16")) {
+		t.Errorf("isSubnetBanned: 10.0.0.0/16 is banned")
+	}
+
+	// Ensure the expired ban was removed by the lookups and that adding a
+	// ban removes the bans which expired since.
+	if len(bl.bans) != 2 {
+		t.Errorf("lookup: got %d bans, want 2", len(bl.bans))
+	}
+	bl.ban(mustParseSubnet("192.168.0.0/16"), now.Add(-time.Second),
+		banReasonManual)
+	if bl.isSubnetBanned(mustParseSubnet("192.168.0.0/16")) {
+		t.Errorf("isSubnetBanned: expired 192.168.0.0/16 is banned")
+	}
+	if _, ok := bl.bans["192.168.0.0/16"]; ok {
+		t.Errorf("isSubnetBanned: expired 192.168.0.0/16 was not removed")
+	}
+	bl.ban(mustParseSubnet("172.16.0.0/12"), now.Add(-time.Second),
+		banReasonManual)
+	bl.ban(mustParseSubnet("10.9.9.9"), now.Add(time.Hour), banReasonManual)
+	if _, ok := bl.bans["172.16.0.0/12"]; ok {
+		t.Errorf("ban: expired 172.16.0.0/12 was not removed")
+	}
+	bl.unban(mustParseSubnet("10.9.9.9"))
+
+	// Ensure the expired ban is not loaded again.
+	if err := bl.save(); err != nil {
+		t.Fatalf("save: unexpected error: %v", err)
+	}
+	loaded := newBanList(file)
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load: unexpected error: %v", err)
+	}
+	entries := loaded.entries()
+	if len(entries) != 2 {
+		t.Fatalf("load: got %d bans, want 2", len(entries))
+	}
+	want := []struct {
+		subnet string
+		until  time.Time
+		reason string
+	}{
+		{"10.0.0.0/8", now.Add(time.Hour), banReasonManual},
+		{"10.1.2.3/32", now.Add(2 * time.Hour), banReasonMisbehaving},
+	}
+	for i, entry := range entries {
+		if entry.subnet.String() != want[i].subnet ||
+			entry.until.Unix() != want[i].until.Unix() ||
+			entry.reason != want[i].reason {
+
+			t.Errorf("load: ban #%d is %v until %v (%s), want %v until "+
+				"%v (%s)", i, entry.subnet, entry.until, entry.reason,
+				want[i].subnet, want[i].until, want[i].reason)
+		}
+	}
+
+	// Ensure unbanning and clearing remove the bans.
+	if !loaded.unban(mustParseSubnet("10.0.0.0/8")) {
+		t.Errorf("unban: 10.0.0.0/8 was not banned")
+	}
+	if loaded.unban(mustParseSubnet("10.0.0.0/8")) {
+		t.Errorf("unban: 10.0.0.0/8 was banned twice")
+	}
+	if _, banned := loaded.bannedUntil(net.ParseIP("10.5.5.5")); banned {
+		t.Errorf("bannedUntil: 10.5.5.5 is banned after unban")
+	}
+	loaded.clear()
+	if _, banned := loaded.bannedUntil(net.ParseIP("10.1.2.3")); banned {
+		t.Errorf("bannedUntil: 10.1.2.3 is banned after clear")
+	}
+
+	// Ensure a missing file results in an empty ban list.
+	missing := newBanList(filepath.Join(dir, "missing.json"))
+	if err := missing.load(); err != nil || len(missing.entries()) != 0 {
+		t.Errorf("load: unexpected result for missing file: %v", err)
+	}
+}
+
+// TestBanUntil ensures the ban times requested via the setban RPC are converted
+// to the time the ban expires and that out of range ban times are rejected.
+func TestBanUntil(t *testing.T) {
+	now := time.Unix(1560000000, 0)
+	maxSecs := int64(maxBanDuration / time.Second)
+	tests := []struct {
+		name     string
+		banTime  int64
+		absolute bool
+		want     time.Time
+		valid    bool
+	}{
+		{"default", 0, false, now.Add(time.Hour), true},
+		{"relative", 60, false, now.Add(time.Minute), true},
+		{"maximum relative", maxSecs, false, now.Add(maxBanDuration), true},
+		{"relative beyond maximum", maxSecs + 1, false, time.Time{}, false},
+		{"overflowing relative", math.MaxInt64, false, time.Time{}, false},
+		{"negative", -1, false, time.Time{}, false},
+		{"absolute", now.Unix() + 60, true, now.Add(time.Minute), true},
+		{"absolute in the past", now.Unix(), true, time.Time{}, false},
+		{"absolute beyond maximum", now.Unix() + maxSecs + 1, true,
+			time.Time{}, false},
+		{"overflowing absolute", math.MaxInt64, true, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		until, err := banUntil(test.banTime, test.absolute, time.Hour, now)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", test.name,
+					until)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !until.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, until,
+				test.want)
+		}
+	}
+}
@@ -22,6 +22,11 @@ import (
 
 var cfg *config
 
+// treehash is the Codechain source tree hash of the running binary.  It is
+// set during the build process with '-ldflags "-X main.treehash=foo"' (see the
+// Makefile) and remains "undefined" otherwise.
+var treehash = "undefined"
+
 // winServiceMain is only invoked on Windows.  It detects when bitumd is running
 // as a service and reacts accordingly.
 var winServiceMain func() (bool, error)
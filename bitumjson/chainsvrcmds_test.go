@@ -39,6 +39,17 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"addnode","params":["127.0.0.1","remove"],"id":1}`,
 			unmarshalled: &AddNodeCmd{Addr: "127.0.0.1", SubCmd: ANRemove},
 		},
+		{
+			name: "clearbanned",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("clearbanned")
+			},
+			staticCmd: func() interface{} {
+				return NewClearBannedCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"clearbanned","params":[],"id":1}`,
+			unmarshalled: &ClearBannedCmd{},
+		},
 		{
 			name: "createrawtransaction",
 			newCmd: func() (interface{}, error) {
@@ -721,6 +732,32 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"gettxoutsetinfo","params":[],"id":1}`,
 			unmarshalled: &GetTxOutSetInfoCmd{},
 		},
+		{
+			name: "getupdateinfo",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("getupdateinfo")
+			},
+			staticCmd: func() interface{} {
+				return NewGetUpdateInfoCmd(nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getupdateinfo","params":[],"id":1}`,
+			unmarshalled: &GetUpdateInfoCmd{
+				Blocks: Int(1000),
+			},
+		},
+		{
+			name: "getupdateinfo optional",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("getupdateinfo", 200)
+			},
+			staticCmd: func() interface{} {
+				return NewGetUpdateInfoCmd(Int(200))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getupdateinfo","params":[200],"id":1}`,
+			unmarshalled: &GetUpdateInfoCmd{
+				Blocks: Int(200),
+			},
+		},
 		{
 			name: "getvoteinfo",
 			newCmd: func() (interface{}, error) {
@@ -786,6 +823,17 @@ func TestChainSvrCmds(t *testing.T) {
 				Command: String("getblock"),
 			},
 		},
+		{
+			name: "listbanned",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("listbanned")
+			},
+			staticCmd: func() interface{} {
+				return NewListBannedCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"listbanned","params":[],"id":1}`,
+			unmarshalled: &ListBannedCmd{},
+		},
 		{
 			name: "node option remove",
 			newCmd: func() (interface{}, error) {
@@ -1009,6 +1057,54 @@ func TestChainSvrCmds(t *testing.T) {
 				AllowHighFees: Bool(false),
 			},
 		},
+		{
+			name: "setban",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("setban", "192.168.0.0/16", SBAdd)
+			},
+			staticCmd: func() interface{} {
+				return NewSetBanCmd("192.168.0.0/16", SBAdd, nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"setban","params":["192.168.0.0/16","add"],"id":1}`,
+			unmarshalled: &SetBanCmd{
+				Subnet:   "192.168.0.0/16",
+				SubCmd:   SBAdd,
+				BanTime:  Int64(0),
+				Absolute: Bool(false),
+			},
+		},
+		{
+			name: "setban optional",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("setban", "1.2.3.4", SBAdd, int64(1600000000), true)
+			},
+			staticCmd: func() interface{} {
+				return NewSetBanCmd("1.2.3.4", SBAdd, Int64(1600000000), Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"setban","params":["1.2.3.4","add",1600000000,true],"id":1}`,
+			unmarshalled: &SetBanCmd{
+				Subnet:   "1.2.3.4",
+				SubCmd:   SBAdd,
+				BanTime:  Int64(1600000000),
+				Absolute: Bool(true),
+			},
+		},
+		{
+			name: "setban remove",
+			newCmd: func() (interface{}, error) {
+				return NewCmd("setban", "1.2.3.4", SBRemove)
+			},
+			staticCmd: func() interface{} {
+				return NewSetBanCmd("1.2.3.4", SBRemove, nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"setban","params":["1.2.3.4","remove"],"id":1}`,
+			unmarshalled: &SetBanCmd{
+				Subnet:   "1.2.3.4",
+				SubCmd:   SBRemove,
+				BanTime:  Int64(0),
+				Absolute: Bool(false),
+			},
+		},
 		{
 			name: "setgenerate",
 			newCmd: func() (interface{}, error) {
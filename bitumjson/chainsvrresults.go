@@ -84,6 +84,7 @@ type GetBlockVerboseResult struct {
 	SBits         float64       `json:"sbits"`
 	ExtraData     string        `json:"extradata"`
 	StakeVersion  uint32        `json:"stakeversion"`
+	CodechainHead string        `json:"codechainhead"`
 	Difficulty    float64       `json:"difficulty"`
 	ChainWork     string        `json:"chainwork"`
 	PreviousHash  string        `json:"previousblockhash"`
@@ -137,6 +138,7 @@ type GetBlockHeaderVerboseResult struct {
 	Nonce         uint32  `json:"nonce"`
 	ExtraData     string  `json:"extradata"`
 	StakeVersion  uint32  `json:"stakeversion"`
+	CodechainHead string  `json:"codechainhead"`
 	Difficulty    float64 `json:"difficulty"`
 	ChainWork     string  `json:"chainwork"`
 	PreviousHash  string  `json:"previousblockhash,omitempty"`
@@ -287,11 +289,22 @@ type GetNetworkInfoResult struct {
 	LocalAddresses  []LocalAddressesResult `json:"localaddresses"`
 }
 
+// UploadTargetResult models the upload target data returned as part of the
+// getnettotals command.
+type UploadTargetResult struct {
+	TimeFrame             int64  `json:"timeframe"`
+	Target                uint64 `json:"target"`
+	TargetReached         bool   `json:"targetreached"`
+	ServeHistoricalBlocks bool   `json:"servehistoricalblocks"`
+	BytesLeft             uint64 `json:"bytesleft"`
+}
+
 // GetNetTotalsResult models the data returned from the getnettotals command.
 type GetNetTotalsResult struct {
-	TotalBytesRecv uint64 `json:"totalbytesrecv"`
-	TotalBytesSent uint64 `json:"totalbytessent"`
-	TimeMillis     int64  `json:"timemillis"`
+	TotalBytesRecv uint64             `json:"totalbytesrecv"`
+	TotalBytesSent uint64             `json:"totalbytessent"`
+	TimeMillis     int64              `json:"timemillis"`
+	UploadTarget   UploadTargetResult `json:"uploadtarget"`
 }
 
 // GetPeerInfoResult models the data returned from the getpeerinfo command.
@@ -311,11 +324,17 @@ type GetPeerInfoResult struct {
 	PingWait       float64 `json:"pingwait,omitempty"`
 	Version        uint32  `json:"version"`
 	SubVer         string  `json:"subver"`
+	TreeHash       string  `json:"treehash,omitempty"`
+	Transport      string  `json:"transport"`
 	Inbound        bool    `json:"inbound"`
+	BlockRelayOnly bool    `json:"blockrelayonly"`
+	MappedAS       uint32  `json:"mappedas,omitempty"`
 	StartingHeight int64   `json:"startingheight"`
 	CurrentHeight  int64   `json:"currentheight,omitempty"`
 	BanScore       int32   `json:"banscore"`
 	SyncNode       bool    `json:"syncnode"`
+	SyncStalls     uint32  `json:"syncstalls"`
+	LastSyncStall  int64   `json:"lastsyncstall,omitempty"`
 }
 
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
@@ -408,6 +427,38 @@ type GetTxOutResult struct {
 	Coinbase      bool               `json:"coinbase"`
 }
 
+// CodechainHeadCount models the number of blocks which published a given
+// CodechainHead.
+type CodechainHeadCount struct {
+	CodechainHead string `json:"codechainhead"`
+	Count         uint32 `json:"count"`
+}
+
+// AutoUpdateResult models the automatic update data returned from the
+// getupdateinfo command.
+type AutoUpdateResult struct {
+	Enabled        bool    `json:"enabled"`
+	Probability    float64 `json:"probability"`
+	Window         uint32  `json:"window"`
+	LastCheck      int64   `json:"lastcheck"`
+	SourceTreeHash string  `json:"sourcetreehash,omitempty"`
+	TargetTreeHash string  `json:"targettreehash,omitempty"`
+	Decision       string  `json:"decision"`
+}
+
+// GetUpdateInfoResult models the data returned from the getupdateinfo
+// command.
+type GetUpdateInfoResult struct {
+	TreeHash           string               `json:"treehash"`
+	TreeHashSigned     bool                 `json:"treehashsigned"`
+	HashchainHead      string               `json:"hashchainhead,omitempty"`
+	LastSignedTreeHash string               `json:"lastsignedtreehash,omitempty"`
+	AutoUpdate         *AutoUpdateResult    `json:"autoupdate,omitempty"`
+	StartHeight        int64                `json:"startheight"`
+	EndHeight          int64                `json:"endheight"`
+	CodechainHeads     []CodechainHeadCount `json:"codechainheads"`
+}
+
 // Choice models an individual choice inside an Agenda.
 type Choice struct {
 	ID          string  `json:"id"`
@@ -455,6 +506,15 @@ type Ticket struct {
 	Owner string `json:"owner"`
 }
 
+// ListBannedResult models the data of a banned subnet returned from the
+// listbanned command.
+type ListBannedResult struct {
+	Address     string `json:"address"`
+	BanCreated  int64  `json:"bancreated"`
+	BannedUntil int64  `json:"banneduntil"`
+	BanReason   string `json:"banreason"`
+}
+
 // LiveTicketsResult models the data returned from the livetickets
 // command.
 type LiveTicketsResult struct {
@@ -89,23 +89,24 @@ type blockNode struct {
 	// reconstructing headers from memory.  These must be treated as
 	// immutable and are intentionally ordered to avoid padding on 64-bit
 	// platforms.
-	height       int64
-	voteBits     uint16
-	finalState   [6]byte
-	blockVersion int32
-	voters       uint16
-	freshStake   uint8
-	revocations  uint8
-	poolSize     uint32
-	bits         uint32
-	sbits        int64
-	timestamp    int64
-	merkleRoot   chainhash.Hash
-	stakeRoot    chainhash.Hash
-	blockSize    uint32
-	nonce        uint32
-	extraData    [32]byte
-	stakeVersion uint32
+	height        int64
+	voteBits      uint16
+	finalState    [6]byte
+	blockVersion  int32
+	voters        uint16
+	freshStake    uint8
+	revocations   uint8
+	poolSize      uint32
+	bits          uint32
+	sbits         int64
+	timestamp     int64
+	merkleRoot    chainhash.Hash
+	stakeRoot     chainhash.Hash
+	blockSize     uint32
+	nonce         uint32
+	extraData     [32]byte
+	stakeVersion  uint32
+	codechainHead [32]byte
 
 	// status is a bitfield representing the validation state of the block.
 	// This field, unlike the other fields, may be changed after the block
@@ -137,25 +138,26 @@ type blockNode struct {
 // initially creating a node.
 func initBlockNode(node *blockNode, blockHeader *wire.BlockHeader, parent *blockNode) {
 	*node = blockNode{
-		hash:         blockHeader.BlockHash(),
-		workSum:      CalcWork(blockHeader.Bits),
-		height:       int64(blockHeader.Height),
-		blockVersion: blockHeader.Version,
-		voteBits:     blockHeader.VoteBits,
-		finalState:   blockHeader.FinalState,
-		voters:       blockHeader.Voters,
-		freshStake:   blockHeader.FreshStake,
-		poolSize:     blockHeader.PoolSize,
-		bits:         blockHeader.Bits,
-		sbits:        blockHeader.SBits,
-		timestamp:    blockHeader.Timestamp.Unix(),
-		merkleRoot:   blockHeader.MerkleRoot,
-		stakeRoot:    blockHeader.StakeRoot,
-		revocations:  blockHeader.Revocations,
-		blockSize:    blockHeader.Size,
-		nonce:        blockHeader.Nonce,
-		extraData:    blockHeader.ExtraData,
-		stakeVersion: blockHeader.StakeVersion,
+		hash:          blockHeader.BlockHash(),
+		workSum:       CalcWork(blockHeader.Bits),
+		height:        int64(blockHeader.Height),
+		blockVersion:  blockHeader.Version,
+		voteBits:      blockHeader.VoteBits,
+		finalState:    blockHeader.FinalState,
+		voters:        blockHeader.Voters,
+		freshStake:    blockHeader.FreshStake,
+		poolSize:      blockHeader.PoolSize,
+		bits:          blockHeader.Bits,
+		sbits:         blockHeader.SBits,
+		timestamp:     blockHeader.Timestamp.Unix(),
+		merkleRoot:    blockHeader.MerkleRoot,
+		stakeRoot:     blockHeader.StakeRoot,
+		revocations:   blockHeader.Revocations,
+		blockSize:     blockHeader.Size,
+		nonce:         blockHeader.Nonce,
+		extraData:     blockHeader.ExtraData,
+		stakeVersion:  blockHeader.StakeVersion,
+		codechainHead: blockHeader.CodechainHead,
 	}
 	if parent != nil {
 		node.parent = parent
@@ -182,24 +184,25 @@ func (node *blockNode) Header() wire.BlockHeader {
 		prevHash = &node.parent.hash
 	}
 	return wire.BlockHeader{
-		Version:      node.blockVersion,
-		PrevBlock:    *prevHash,
-		MerkleRoot:   node.merkleRoot,
-		StakeRoot:    node.stakeRoot,
-		VoteBits:     node.voteBits,
-		FinalState:   node.finalState,
-		Voters:       node.voters,
-		FreshStake:   node.freshStake,
-		Revocations:  node.revocations,
-		PoolSize:     node.poolSize,
-		Bits:         node.bits,
-		SBits:        node.sbits,
-		Height:       uint32(node.height),
-		Size:         node.blockSize,
-		Timestamp:    time.Unix(node.timestamp, 0),
-		Nonce:        node.nonce,
-		ExtraData:    node.extraData,
-		StakeVersion: node.stakeVersion,
+		Version:       node.blockVersion,
+		PrevBlock:     *prevHash,
+		MerkleRoot:    node.merkleRoot,
+		StakeRoot:     node.stakeRoot,
+		VoteBits:      node.voteBits,
+		FinalState:    node.finalState,
+		Voters:        node.voters,
+		FreshStake:    node.freshStake,
+		Revocations:   node.revocations,
+		PoolSize:      node.poolSize,
+		Bits:          node.bits,
+		SBits:         node.sbits,
+		Height:        uint32(node.height),
+		Size:          node.blockSize,
+		Timestamp:     time.Unix(node.timestamp, 0),
+		Nonce:         node.nonce,
+		ExtraData:     node.extraData,
+		StakeVersion:  node.stakeVersion,
+		CodechainHead: node.codechainHead,
 	}
 }
 
@@ -0,0 +1,55 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/bitum-project/bitumd/chaincfg"
+)
+
+// AllowedCodechainHeads returns the source tree hashes a block header
+// CodechainHead may commit to once the codechainhead agenda is active, which
+// are the last CodechainVersionWindow tree hashes pinned by the passed network
+// parameters ordered from the oldest to the newest one.
+func AllowedCodechainHeads(params *chaincfg.Params) [][32]byte {
+	treeHashes := params.CodechainTreeHashes
+	window := int(params.CodechainVersionWindow)
+	if len(treeHashes) > window {
+		treeHashes = treeHashes[len(treeHashes)-window:]
+	}
+	return treeHashes
+}
+
+// checkCodechainHead ensures the provided CodechainHead commits to one of the
+// source tree hashes pinned by the passed network parameters and that it is
+// within the allowed window of the most recent ones.
+func checkCodechainHead(head [32]byte, params *chaincfg.Params) error {
+	if head == [32]byte{} {
+		str := "block header does not commit to a Codechain head"
+		return ruleError(ErrCodechainHeadMissing, str)
+	}
+
+	treeHashes := params.CodechainTreeHashes
+	for i := len(treeHashes) - 1; i >= 0; i-- {
+		if treeHashes[i] != head {
+			continue
+		}
+
+		age := len(treeHashes) - 1 - i
+		if age >= int(params.CodechainVersionWindow) {
+			str := fmt.Sprintf("block header commits to Codechain "+
+				"head %x which is %d versions behind the latest "+
+				"signed tree hash (allowed window %d)", head[:], age,
+				params.CodechainVersionWindow)
+			return ruleError(ErrCodechainHeadTooOld, str)
+		}
+		return nil
+	}
+
+	str := fmt.Sprintf("block header commits to Codechain head %x which "+
+		"is not a signed tree hash of the network", head[:])
+	return ruleError(ErrCodechainHeadNotSigned, str)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg"
+)
+
+// TestAllowedCodechainHeads ensures the Codechain heads a block may commit to
+// are the last window of the tree hashes pinned by the network parameters.
+func TestAllowedCodechainHeads(t *testing.T) {
+	treeHashes := [][32]byte{{0x01}, {0x02}, {0x03}, {0x04}}
+
+	tests := []struct {
+		name       string
+		treeHashes [][32]byte
+		window     uint32
+		want       [][32]byte
+	}{{
+		name:       "window smaller than tree hashes",
+		treeHashes: treeHashes,
+		window:     2,
+		want:       [][32]byte{{0x03}, {0x04}},
+	}, {
+		name:       "window equal to tree hashes",
+		treeHashes: treeHashes,
+		window:     4,
+		want:       treeHashes,
+	}, {
+		name:       "window larger than tree hashes",
+		treeHashes: treeHashes,
+		window:     5,
+		want:       treeHashes,
+	}, {
+		name:       "no tree hashes",
+		treeHashes: nil,
+		window:     5,
+		want:       nil,
+	}}
+
+	for _, test := range tests {
+		params := cloneParams(&chaincfg.RegNetParams)
+		params.CodechainTreeHashes = test.treeHashes
+		params.CodechainVersionWindow = test.window
+		got := AllowedCodechainHeads(params)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: unexpected heads -- got %x, want %x",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestCheckCodechainHead ensures the CodechainHead committed to by a block
+// header is validated against the window of the most recent tree hashes pinned
+// by the network parameters.
+func TestCheckCodechainHead(t *testing.T) {
+	treeHashes := [][32]byte{{0x01}, {0x02}, {0x03}, {0x04}}
+
+	tests := []struct {
+		name       string
+		head       [32]byte
+		treeHashes [][32]byte
+		window     uint32
+		wantErr    error
+	}{{
+		name:       "latest tree hash",
+		head:       [32]byte{0x04},
+		treeHashes: treeHashes,
+		window:     2,
+		wantErr:    nil,
+	}, {
+		name:       "oldest tree hash within window",
+		head:       [32]byte{0x03},
+		treeHashes: treeHashes,
+		window:     2,
+		wantErr:    nil,
+	}, {
+		name:       "tree hash outside of window",
+		head:       [32]byte{0x02},
+		treeHashes: treeHashes,
+		window:     2,
+		wantErr:    ruleError(ErrCodechainHeadTooOld, ""),
+	}, {
+		name:       "first tree hash with whole window",
+		head:       [32]byte{0x01},
+		treeHashes: treeHashes,
+		window:     4,
+		wantErr:    nil,
+	}, {
+		name:       "unsigned head",
+		head:       [32]byte{0x05},
+		treeHashes: treeHashes,
+		window:     4,
+		wantErr:    ruleError(ErrCodechainHeadNotSigned, ""),
+	}, {
+		name:       "no tree hashes",
+		head:       [32]byte{0x01},
+		treeHashes: nil,
+		window:     4,
+		wantErr:    ruleError(ErrCodechainHeadNotSigned, ""),
+	}, {
+		name:       "zero head",
+		head:       [32]byte{},
+		treeHashes: treeHashes,
+		window:     4,
+		wantErr:    ruleError(ErrCodechainHeadMissing, ""),
+	}, {
+		name:       "zero window",
+		head:       [32]byte{0x04},
+		treeHashes: treeHashes,
+		window:     0,
+		wantErr:    ruleError(ErrCodechainHeadTooOld, ""),
+	}}
+
+	for _, test := range tests {
+		params := cloneParams(&chaincfg.RegNetParams)
+		params.CodechainTreeHashes = test.treeHashes
+		params.CodechainVersionWindow = test.window
+		err := checkCodechainHead(test.head, params)
+		if test.wantErr == nil {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(RuleError)
+		if !ok {
+			t.Errorf("%q: unexpected error type %T (%v)", test.name,
+				err, err)
+			continue
+		}
+		wantCode := test.wantErr.(RuleError).ErrorCode
+		if rerr.ErrorCode != wantCode {
+			t.Errorf("%q: unexpected error code -- got %v, want %v",
+				test.name, rerr.ErrorCode, wantCode)
+		}
+	}
+}
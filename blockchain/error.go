@@ -473,6 +473,19 @@ const (
 	// block that is either not the current best chain tip or its parent.
 	ErrInvalidTemplateParent
 
+	// ErrCodechainHeadMissing indicates that a block header does not commit
+	// to a CodechainHead.
+	ErrCodechainHeadMissing
+
+	// ErrCodechainHeadNotSigned indicates that the CodechainHead committed to
+	// by a block header is not one of the signed tree hashes of the network.
+	ErrCodechainHeadNotSigned
+
+	// ErrCodechainHeadTooOld indicates that the CodechainHead committed to by
+	// a block header is a signed tree hash of the network, but it is not
+	// within the allowed window of the most recent ones.
+	ErrCodechainHeadTooOld
+
 	// numErrorCodes is the maximum error code number used in tests.
 	numErrorCodes
 )
@@ -579,6 +592,9 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrKnownInvalidBlock:      "ErrKnownInvalidBlock",
 	ErrInvalidAncestorBlock:   "ErrInvalidAncestorBlock",
 	ErrInvalidTemplateParent:  "ErrInvalidTemplateParent",
+	ErrCodechainHeadMissing:   "ErrCodechainHeadMissing",
+	ErrCodechainHeadNotSigned: "ErrCodechainHeadNotSigned",
+	ErrCodechainHeadTooOld:    "ErrCodechainHeadTooOld",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -115,6 +115,9 @@ func TestErrorCodeStringer(t *testing.T) {
 		{ErrKnownInvalidBlock, "ErrKnownInvalidBlock"},
 		{ErrInvalidAncestorBlock, "ErrInvalidAncestorBlock"},
 		{ErrInvalidTemplateParent, "ErrInvalidTemplateParent"},
+		{ErrCodechainHeadMissing, "ErrCodechainHeadMissing"},
+		{ErrCodechainHeadNotSigned, "ErrCodechainHeadNotSigned"},
+		{ErrCodechainHeadTooOld, "ErrCodechainHeadTooOld"},
 		{0xffff, "Unknown ErrorCode (65535)"},
 	}
 
@@ -120,10 +120,29 @@ func TestFullBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to generate tests: %v", err)
 	}
+	testFullBlocks(t, "fullblocktest", &chaincfg.RegNetParams, tests)
+}
+
+// TestFullBlocksCodechainHead ensures all tests generated by the
+// fullblocktests package for the codechainhead agenda have the expected result
+// when processed via ProcessBlock.
+func TestFullBlocksCodechainHead(t *testing.T) {
+	tests, err := fullblocktests.GenerateCodechainHead()
+	if err != nil {
+		t.Fatalf("failed to generate tests: %v", err)
+	}
+	testFullBlocks(t, "fullblocktestcodechainhead",
+		fullblocktests.CodechainHeadParams(), tests)
+}
+
+// testFullBlocks ensures the passed tests generated by the fullblocktests
+// package have the expected result when processed via ProcessBlock by a new
+// chain instance for the passed network parameters.
+func testFullBlocks(t *testing.T, dbName string, params *chaincfg.Params,
+	tests [][]fullblocktests.TestInstance) {
 
 	// Create a new database and chain instance to run tests against.
-	chain, teardownFunc, err := chainSetup("fullblocktest",
-		&chaincfg.RegNetParams)
+	chain, teardownFunc, err := chainSetup(dbName, params)
 	if err != nil {
 		t.Fatalf("Failed to setup chain instance: %v", err)
 	}
@@ -0,0 +1,405 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package fullblocktests
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bitum-project/bitumd/blockchain"
+	"github.com/bitum-project/bitumd/blockchain/chaingen"
+	"github.com/bitum-project/bitumd/chaincfg"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+// codechainHeadVersion is the deployment version of the codechainhead agenda
+// in the test network parameters.
+const codechainHeadVersion = 8
+
+// codechainHeadParams defines the network parameters the tests returned by
+// GenerateCodechainHead are generated for.  They are the regression test
+// network parameters with the signed tree hashes 0x01 through 0x07 pinned, so
+// the last CodechainVersionWindow (5) of them are 0x03 through 0x07.
+//
+// The premine is paid to a main network address since the addresses of the
+// block one ledger are decoded with bitumutil.DecodeAddress, which does not
+// decode the addresses of the other networks.  Also, the consensus rules do not
+// process the ticket lottery up to height 21339 on any network, so the stake
+// validation height is raised to leave room for purchasing the tickets which
+// vote after it.
+var codechainHeadParams = func() *chaincfg.Params {
+	params := *regNetParams
+	params.StakeValidationHeight = 21340 + int64(params.TicketPoolSize)*2
+	params.CodechainTreeHashes = [][32]byte{{0x01}, {0x02}, {0x03}, {0x04},
+		{0x05}, {0x06}, {0x07}}
+	params.BlockOneLedger = []*chaincfg.TokenPayout{
+		{Address: "B1vdJagYGHjPCGfCAe3L5yVNhPQpvVDeTkQ", Amount: 300000 * 1e8},
+	}
+	return &params
+}()
+
+// CodechainHeadParams returns the network parameters the tests returned by
+// GenerateCodechainHead must be run against.
+func CodechainHeadParams() *chaincfg.Params {
+	params := *codechainHeadParams
+	return &params
+}
+
+// GenerateCodechainHead returns a slice of tests that can be used to exercise
+// the consensus validation rules of the codechainhead agenda once it is active.
+// The tests build their own chain from the genesis block on which the agenda
+// is voted in, so they must be run against a separate chain instance than the
+// tests returned by Generate which uses the parameters returned by
+// CodechainHeadParams.
+func GenerateCodechainHead() (tests [][]TestInstance, err error) {
+	// In order to simplify the generation code which really should never
+	// fail unless the test code itself is broken, panics are used
+	// internally.  This deferred func ensures any panics don't escape the
+	// generator by replacing the named error return with the underlying
+	// panic error.
+	defer func() {
+		if r := recover(); r != nil {
+			tests = nil
+
+			switch rt := r.(type) {
+			case string:
+				err = errors.New(rt)
+			case error:
+				err = rt
+			default:
+				err = errors.New("unknown panic")
+			}
+		}
+	}()
+
+	// Find the yes choice of the codechainhead agenda.
+	var yesChoice *chaincfg.Choice
+	params := codechainHeadParams
+	for _, deployment := range params.Deployments[codechainHeadVersion] {
+		if deployment.Vote.Id != chaincfg.VoteIDCodechainHead {
+			continue
+		}
+		for i, choice := range deployment.Vote.Choices {
+			if choice.Id == "yes" {
+				yesChoice = &deployment.Vote.Choices[i]
+			}
+		}
+	}
+	if yesChoice == nil {
+		return nil, fmt.Errorf("unable to find the yes choice of the %s "+
+			"agenda", chaincfg.VoteIDCodechainHead)
+	}
+
+	// Create a generator instance initialized with the genesis block as the
+	// tip.
+	g, err := chaingen.MakeGenerator(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Define some convenience helper functions to populate the tests slice
+	// with test instances that have the described characteristics.
+	//
+	// accepted creates and appends a single acceptBlock test instance for
+	// the current tip which expects the block to be accepted to the main
+	// chain.
+	//
+	// acceptedToSideChainWithExpectedTip creates an appends a two-instance
+	// test.  The first instance is an acceptBlock test instance for the
+	// current tip which expects the block to be accepted to a side chain.
+	// The second instance is an expectBlockTip test instance for provided
+	// values.
+	//
+	// rejected creates and appends a single rejectBlock test instance for
+	// the current tip.
+	accepted := func() {
+		tests = append(tests, []TestInstance{
+			AcceptedBlock{g.TipName(), g.Tip(), true, false},
+		})
+	}
+	acceptedToSideChainWithExpectedTip := func(tipName string) {
+		tests = append(tests, []TestInstance{
+			AcceptedBlock{g.TipName(), g.Tip(), false, false},
+			ExpectedTip{tipName, g.BlockByName(tipName)},
+		})
+	}
+	rejected := func(code blockchain.ErrorCode) {
+		tests = append(tests, []TestInstance{
+			RejectedBlock{g.TipName(), g.Tip(), code},
+		})
+	}
+
+	// replaceCodechainHeadVersions is a munge function which modifies the
+	// provided block by replacing the block, stake, and vote versions with
+	// the codechainhead deployment version.
+	replaceCodechainHeadVersions := func(b *wire.MsgBlock) {
+		chaingen.ReplaceBlockVersion(codechainHeadVersion)(b)
+		chaingen.ReplaceStakeVersion(codechainHeadVersion)(b)
+		chaingen.ReplaceVoteVersions(codechainHeadVersion)(b)
+	}
+
+	// replaceCodechainHead is a munge function which modifies the provided
+	// block by replacing its CodechainHead with the passed head.
+	replaceCodechainHead := func(head [32]byte) func(*wire.MsgBlock) {
+		return func(b *wire.MsgBlock) {
+			b.Header.CodechainHead = head
+		}
+	}
+
+	// Shorter versions of useful params for convenience.
+	coinbaseMaturity := g.Params().CoinbaseMaturity
+	stakeValidationHeight := g.Params().StakeValidationHeight
+	stakeVerInterval := g.Params().StakeVersionInterval
+	ruleChangeInterval := int64(g.Params().RuleChangeActivationInterval)
+	ticketPoolSize := int64(g.Params().TicketPoolSize)
+	ticketsPerBlock := int64(g.Params().TicketsPerBlock)
+	activeHeight := stakeValidationHeight + ruleChangeInterval*3
+
+	// ---------------------------------------------------------------------
+	// Premine block and enough blocks to have mature coinbase outputs to
+	// work with.
+	//
+	// The generator spaces the blocks 7/8 of the target timespan apart
+	// starting from the current time, so the premine block is dated back
+	// for the block at the height the agenda becomes active to be dated
+	// around the current time rather than too far in the future.
+	//
+	//   genesis -> bp -> bm0 -> bm1 -> ... -> bm#
+	// ---------------------------------------------------------------------
+
+	blockSpacing := g.Params().TargetTimespan * 7 / 8
+	premineTime := time.Now().Add(-blockSpacing * time.Duration(activeHeight))
+	g.CreatePremineBlock("bp", 0, func(b *wire.MsgBlock) {
+		b.Header.Timestamp = time.Unix(premineTime.Unix(), 0)
+	})
+	g.AssertTipHeight(1)
+	accepted()
+
+	var testInstances []TestInstance
+	for i := uint16(0); i < coinbaseMaturity; i++ {
+		blockName := fmt.Sprintf("bm%d", i)
+		g.NextBlock(blockName, nil, nil)
+		g.SaveTipCoinbaseOuts()
+		testInstances = append(testInstances, AcceptedBlock{g.TipName(),
+			g.Tip(), true, false})
+	}
+	tests = append(tests, testInstances)
+	g.AssertTipHeight(uint32(coinbaseMaturity) + 1)
+
+	// ---------------------------------------------------------------------
+	// Generate enough blocks to reach twice the ticket pool size before the
+	// stake validation height without purchasing tickets, so the tickets
+	// purchased afterwards have not expired yet once votes are required.
+	//
+	//   ... -> bm# ... -> bf0 -> bf1 -> ... -> bf#
+	//
+
+	// The consensus rules compare the approval of the parent committed to
+	// by the blocks from height 4096 up to height 21211 with their votes on
+	// every network, so those blocks disapprove their parent since they do
+	// not have any votes.
+	//
+	// ---------------------------------------------------------------------
+
+	testInstances = nil
+	ticketPurchaseHeight := stakeValidationHeight - ticketPoolSize*2
+	for i := int64(0); int64(g.Tip().Header.Height) < ticketPurchaseHeight; i++ {
+		var mungers []func(*wire.MsgBlock)
+		nextHeight := g.Tip().Header.Height + 1
+		if nextHeight >= 4096 && nextHeight < 21212 {
+			mungers = append(mungers, func(b *wire.MsgBlock) {
+				b.Header.VoteBits &^= voteBitYes
+			})
+		}
+		blockName := fmt.Sprintf("bf%d", i)
+		g.NextBlock(blockName, nil, nil, mungers...)
+		testInstances = append(testInstances, AcceptedBlock{g.TipName(),
+			g.Tip(), true, false})
+	}
+	tests = append(tests, testInstances)
+	g.AssertTipHeight(uint32(ticketPurchaseHeight))
+
+	// ---------------------------------------------------------------------
+	// Generate enough blocks to reach the stake validation height while
+	// purchasing tickets until the target ticket pool size is reached.  The
+	// blocks are generated with the deployment version to upgrade the block
+	// version.
+	//
+	//   ... -> bf# -> bsv0 -> bsv1 -> ... -> bsv#
+	// ---------------------------------------------------------------------
+
+	testInstances = nil
+	var ticketsPurchased int
+	targetPoolSize := ticketPoolSize * ticketsPerBlock
+	for i := int64(0); int64(g.Tip().Header.Height) < stakeValidationHeight; i++ {
+		outs := g.OldestCoinbaseOuts()
+		ticketOuts := outs[1:]
+		if ticketsPurchased+len(ticketOuts) > int(targetPoolSize) {
+			ticketsNeeded := int(targetPoolSize) - ticketsPurchased
+			if ticketsNeeded > 0 {
+				ticketOuts = ticketOuts[1 : ticketsNeeded+1]
+			} else {
+				ticketOuts = nil
+			}
+		}
+		ticketsPurchased += len(ticketOuts)
+
+		blockName := fmt.Sprintf("bsv%d", i)
+		g.NextBlock(blockName, nil, ticketOuts,
+			chaingen.ReplaceBlockVersion(codechainHeadVersion))
+		g.SaveTipCoinbaseOuts()
+		testInstances = append(testInstances, AcceptedBlock{g.TipName(),
+			g.Tip(), true, false})
+	}
+	tests = append(tests, testInstances)
+	g.AssertTipHeight(uint32(stakeValidationHeight))
+
+	// ---------------------------------------------------------------------
+	// Generate enough blocks to reach one block before the next two stake
+	// version intervals with block and vote versions for the codechainhead
+	// agenda.  This upgrades the stake version to the deployment version
+	// after the first interval, so the blocks from then on commit to it.
+	//
+	//   ... -> bsv# -> bvu0 -> bvu1 -> ... -> bvu#
+	// ---------------------------------------------------------------------
+
+	testInstances = nil
+	blocksNeeded := stakeValidationHeight + stakeVerInterval*2 - 1 -
+		int64(g.Tip().Header.Height)
+	for i := int64(0); i < blocksNeeded; i++ {
+		mungers := []func(*wire.MsgBlock){
+			chaingen.ReplaceBlockVersion(codechainHeadVersion),
+			chaingen.ReplaceVoteVersions(codechainHeadVersion),
+		}
+		nextHeight := int64(g.Tip().Header.Height) + 1
+		if nextHeight >= stakeValidationHeight+stakeVerInterval {
+			mungers = append(mungers,
+				chaingen.ReplaceStakeVersion(codechainHeadVersion))
+		}
+		outs := g.OldestCoinbaseOuts()
+		blockName := fmt.Sprintf("bvu%d", i)
+		g.NextBlock(blockName, nil, outs[1:], mungers...)
+		g.SaveTipCoinbaseOuts()
+		testInstances = append(testInstances, AcceptedBlock{g.TipName(),
+			g.Tip(), true, false})
+	}
+	tests = append(tests, testInstances)
+
+	// ---------------------------------------------------------------------
+	// Generate blocks with the block, stake, and vote versions of the
+	// codechainhead agenda and yes votes for it up to the last block before
+	// it is active.  Since the stake version is upgraded before the end of
+	// the first rule change interval, the agenda is started by that
+	// interval, locked in by the next one, and active after the one after
+	// that.
+	//
+	//   ... -> bvu# -> bcv0 -> bcv1 -> ... -> bcv#
+	// ---------------------------------------------------------------------
+
+	testInstances = nil
+	blocksNeeded = activeHeight - 2 - int64(g.Tip().Header.Height)
+	for i := int64(0); i < blocksNeeded; i++ {
+		outs := g.OldestCoinbaseOuts()
+		blockName := fmt.Sprintf("bcv%d", i)
+		g.NextBlock(blockName, nil, outs[1:],
+			replaceCodechainHeadVersions,
+			chaingen.ReplaceVotes(voteBitYes|yesChoice.Bits,
+				codechainHeadVersion))
+		g.SaveTipCoinbaseOuts()
+		testInstances = append(testInstances, AcceptedBlock{g.TipName(),
+			g.Tip(), true, false})
+	}
+	tests = append(tests, testInstances)
+	g.AssertTipHeight(uint32(activeHeight - 2))
+	g.AssertBlockVersion(codechainHeadVersion)
+	g.AssertStakeVersion(codechainHeadVersion)
+
+	// Create a fork with a block that does not commit to a Codechain head
+	// and ensure it is accepted since the agenda is only locked in for it.
+	//
+	//   ... -> bcv#
+	//              \-> bcci0
+	lockedInTipName := g.TipName()
+	g.NextBlock("bcci0", nil, nil, replaceCodechainHeadVersions,
+		chaingen.ReplaceVotes(voteBitYes|yesChoice.Bits,
+			codechainHeadVersion))
+	g.SetTip(lockedInTipName)
+	outs := g.OldestCoinbaseOuts()
+	g.NextBlock(fmt.Sprintf("bcv%d", blocksNeeded), nil, outs[1:],
+		replaceCodechainHeadVersions,
+		chaingen.ReplaceVotes(voteBitYes|yesChoice.Bits,
+			codechainHeadVersion))
+	g.SaveTipCoinbaseOuts()
+	accepted()
+	g.AssertTipHeight(uint32(activeHeight - 1))
+	activeTipName := g.TipName()
+	tests = append(tests, []TestInstance{
+		AcceptedBlock{"bcci0", g.BlockByName("bcci0"), false, false},
+		ExpectedTip{activeTipName, g.BlockByName(activeTipName)},
+	})
+
+	// ---------------------------------------------------------------------
+	// CodechainHead tests with the agenda active.
+	// ---------------------------------------------------------------------
+
+	// nextBlock creates a block on the current tip which commits to the
+	// passed Codechain head.
+	nextBlock := func(blockName string, head [32]byte) {
+		g.NextBlock(blockName, nil, nil, replaceCodechainHeadVersions,
+			chaingen.ReplaceVotes(voteBitYes|yesChoice.Bits,
+				codechainHeadVersion),
+			replaceCodechainHead(head))
+	}
+
+	// Create a block that does not commit to a Codechain head.
+	//
+	//   ... -> bcv#
+	//              \-> bcca0
+	nextBlock("bcca0", [32]byte{})
+	rejected(blockchain.ErrCodechainHeadMissing)
+
+	// Create blocks that commit to the signed tree hashes which are older
+	// than the last CodechainVersionWindow (5) of them.
+	//
+	//   ... -> bcv#
+	//              \-> bcca1
+	//              \-> bcca2
+	g.SetTip(activeTipName)
+	nextBlock("bcca1", [32]byte{0x01})
+	rejected(blockchain.ErrCodechainHeadTooOld)
+
+	g.SetTip(activeTipName)
+	nextBlock("bcca2", [32]byte{0x02})
+	rejected(blockchain.ErrCodechainHeadTooOld)
+
+	// Create a block that commits to a head which is not a signed tree
+	// hash.
+	//
+	//   ... -> bcv#
+	//              \-> bcca3
+	g.SetTip(activeTipName)
+	nextBlock("bcca3", [32]byte{0xff})
+	rejected(blockchain.ErrCodechainHeadNotSigned)
+
+	// Create a block that commits to the oldest signed tree hash within the
+	// window.
+	//
+	//   ... -> bcv# -> bcca4
+	g.SetTip(activeTipName)
+	nextBlock("bcca4", [32]byte{0x03})
+	accepted()
+
+	// Create a fork with a block that commits to the latest signed tree
+	// hash and ensure it is accepted.
+	//
+	//   ... -> bcv# -> bcca4
+	//              \-> bcca5
+	g.SetTip(activeTipName)
+	nextBlock("bcca5", [32]byte{0x07})
+	acceptedToSideChainWithExpectedTip("bcca4")
+
+	return tests, nil
+}
@@ -2983,6 +2983,23 @@ func Generate(includeLargeReorg bool) (tests [][]TestInstance, err error) {
 	g.NextBlock("bdt8", outs[29], ticketOuts[29])
 	accepted()
 
+	// ---------------------------------------------------------------------
+	// CodechainHead tests.
+	// ---------------------------------------------------------------------
+
+	// Create a fork from bdt7 with a block that commits to a Codechain head
+	// after blocks which did not commit to one and ensure it is accepted
+	// since the codechainhead agenda is not active.  The tests with the
+	// agenda active are generated by GenerateCodechainHead.
+	//
+	//   ... -> bdt7(28) -> bdt8(29)
+	//                  \-> bcc1(29)
+	g.SetTip("bdt7")
+	g.NextBlock("bcc1", outs[29], ticketOuts[29], func(b *wire.MsgBlock) {
+		b.Header.CodechainHead = [32]byte{0xff}
+	})
+	acceptedToSideChainWithExpectedTip("bdt8")
+
 	// ---------------------------------------------------------------------
 	// Large block re-org test.
 	// ---------------------------------------------------------------------
@@ -255,6 +255,34 @@ var regNetParams = &chaincfg.Params{
 			StartTime:  0,             // Always available for vote
 			ExpireTime: math.MaxInt64, // Never expires
 		}},
+		8: {{
+			Vote: chaincfg.Vote{
+				Id:          chaincfg.VoteIDCodechainHead,
+				Description: "Require block headers to commit to a recent signed Codechain tree hash",
+				Mask:        0x0006, // Bits 1 and 2
+				Choices: []chaincfg.Choice{{
+					Id:          "abstain",
+					Description: "abstain voting for change",
+					Bits:        0x0000,
+					IsAbstain:   true,
+					IsNo:        false,
+				}, {
+					Id:          "no",
+					Description: "keep the existing consensus rules",
+					Bits:        0x0002, // Bit 1
+					IsAbstain:   false,
+					IsNo:        true,
+				}, {
+					Id:          "yes",
+					Description: "change to the new consensus rules",
+					Bits:        0x0004, // Bit 2
+					IsAbstain:   false,
+					IsNo:        false,
+				}},
+			},
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		}},
 	},
 
 	// Enforce current block version once majority of the network has
@@ -267,6 +295,10 @@ var regNetParams = &chaincfg.Params{
 	BlockRejectNumRequired:  75,
 	BlockUpgradeNumToCheck:  100,
 
+	// Allow block headers to commit to any of the last 5 signed tree
+	// hashes once the codechainhead agenda is active.
+	CodechainVersionWindow: 5,
+
 	// AcceptNonStdTxs is a Mempool param to accept and relay non standard
 	// txs to the network or reject them
 	AcceptNonStdTxs: true,
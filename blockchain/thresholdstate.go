@@ -465,6 +465,19 @@ func (b *BlockChain) deploymentState(prevNode *blockNode, version uint32, deploy
 	return invalidState, DeploymentError(deploymentID)
 }
 
+// deploymentVersion returns the version of the deployment with the provided
+// ID in the chain parameters and whether the network defines it at all.
+func (b *BlockChain) deploymentVersion(deploymentID string) (uint32, bool) {
+	for version, deployments := range b.chainParams.Deployments {
+		for k := range deployments {
+			if deployments[k].Vote.Id == deploymentID {
+				return version, true
+			}
+		}
+	}
+	return 0, false
+}
+
 // stateLastChanged returns the node at which the provided consensus deployment
 // agenda last changed state.  The function will return nil if the state has
 // never changed.
@@ -690,6 +703,50 @@ func (b *BlockChain) IsFixSeqLocksAgendaActive() (bool, error) {
 	return isActive, err
 }
 
+// isCodechainHeadAgendaActive returns whether or not the codechainhead agenda
+// vote, which requires block headers to commit to a recent signed Codechain
+// tree hash, has passed and is now active from the point of view of the passed
+// block node.
+//
+// It is important to note that, as the variable name indicates, this function
+// expects the block node prior to the block for which the deployment state is
+// desired.  In other words, the returned deployment state is for the block
+// AFTER the passed node.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) isCodechainHeadAgendaActive(prevNode *blockNode) (bool, error) {
+	// Determine the version for the codechainhead agenda from the
+	// deployments of the network.  The agenda is never active on networks
+	// which do not define it, such as simnet.
+	deploymentVer, ok := b.deploymentVersion(chaincfg.VoteIDCodechainHead)
+	if !ok {
+		return false, nil
+	}
+
+	state, err := b.deploymentState(prevNode, deploymentVer,
+		chaincfg.VoteIDCodechainHead)
+	if err != nil {
+		return false, err
+	}
+
+	// NOTE: The choice field of the return threshold state is not examined
+	// here because there is only one possible choice that can be active for
+	// the agenda, which is yes, so there is no need to check it.
+	return state.State == ThresholdActive, nil
+}
+
+// IsCodechainHeadAgendaActive returns whether or not the codechainhead agenda
+// vote has passed and is now active for the block AFTER the current best chain
+// block.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsCodechainHeadAgendaActive() (bool, error) {
+	b.chainLock.Lock()
+	isActive, err := b.isCodechainHeadAgendaActive(b.bestChain.Tip())
+	b.chainLock.Unlock()
+	return isActive, err
+}
+
 // VoteCounts is a compacted struct that is used to message vote counts.
 type VoteCounts struct {
 	Total        uint32
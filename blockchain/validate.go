@@ -1197,6 +1197,21 @@ func (b *BlockChain) checkBlockHeaderContext(header *wire.BlockHeader, prevNode
 				calcFinalState)
 			return ruleError(ErrInvalidFinalState, errStr)
 		}
+
+		// Ensure the header commits to one of the most recent signed
+		// tree hashes of the network once the codechainhead agenda is
+		// active.
+		isActive, err := b.isCodechainHeadAgendaActive(prevNode)
+		if err != nil {
+			return err
+		}
+		if isActive {
+			err := checkCodechainHead(header.CodechainHead,
+				b.chainParams)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -9,6 +9,7 @@ import (
 	"container/list"
 	"encoding/binary"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -27,10 +28,42 @@ import (
 )
 
 const (
-	// minInFlightBlocks is the minimum number of blocks that should be
-	// in the request queue for headers-first mode before requesting
-	// more.
-	minInFlightBlocks = 10
+	// blockDownloadWindow is the maximum number of blocks ahead of the next
+	// block to process which are requested in headers-first mode.  Blocks
+	// which arrive out of order are held until all of their ancestors were
+	// processed, so the window also limits the number of blocks held.
+	blockDownloadWindow = 1024
+
+	// maxHeldBlocksSize is the maximum total serialized size of the blocks
+	// held in headers-first mode at which only the blocks needed to process
+	// the held blocks are requested.  This prevents a single stalled block
+	// from pinning a whole download window of blocks in memory.
+	maxHeldBlocksSize = 32 * 1024 * 1024
+
+	// maxInFlightBlocksPerPeer is the maximum number of blocks requested
+	// from a single peer at once in headers-first mode.
+	maxInFlightBlocksPerPeer = 16
+
+	// maxHeadersAhead is the number of downloaded headers with blocks which
+	// were not processed yet at which no more headers are requested from the
+	// sync peer until the blocks for half of them were processed.
+	maxHeadersAhead = 10 * wire.MaxBlockHeadersPerMsg
+
+	// blockStallTimeout is the duration the next block to process in
+	// headers-first mode may take to arrive from the peer it was requested
+	// from before the peer is considered to be stalling the download.
+	blockStallTimeout = 10 * time.Second
+
+	// blockStallTickInterval is the interval of time between checks for
+	// peers which are stalling the download in headers-first mode and for a
+	// stalling sync peer.
+	blockStallTickInterval = time.Second
+
+	// syncPeerStallTimeout is the duration the sync peer may make no
+	// progress while other peers prove more work before it is demoted and a
+	// new sync peer is selected.  It is also the interval at which peers
+	// which claim to have more blocks are asked to prove it.
+	syncPeerStallTimeout = 30 * time.Second
 
 	// blockDbNamePrefix is the prefix for the block database name.  The
 	// database type is appended to this value to form the full block
@@ -84,6 +117,20 @@ type blockMsg struct {
 	peer  *serverPeer
 }
 
+// cmpctBlockMsg packages a Bitum cmpctblock message and the peer it came from
+// together so the block handler has access to that information.
+type cmpctBlockMsg struct {
+	block *wire.MsgCmpctBlock
+	peer  *serverPeer
+}
+
+// blockTxnMsg packages a Bitum blocktxn message and the peer it came from
+// together so the block handler has access to that information.
+type blockTxnMsg struct {
+	blockTxn *wire.MsgBlockTxn
+	peer     *serverPeer
+}
+
 // invMsg packages a Bitum inv message and the peer it came from together
 // so the block handler has access to that information.
 type invMsg struct {
@@ -289,11 +336,31 @@ type setParentTemplateMsg struct {
 type setParentTemplateResponse struct {
 }
 
-// headerNode is used as a node in a list of headers that are linked together
-// between checkpoints.
+// headerNode is used as a node in the list of downloaded headers whose blocks
+// are fetched in headers-first mode.
 type headerNode struct {
-	height int64
-	hash   *chainhash.Hash
+	height   int64
+	hash     *chainhash.Hash
+	prevHash chainhash.Hash
+
+	// fastAdd indicates the header was verified to link to a checkpoint, so
+	// the block is eligible for less validation.
+	fastAdd bool
+
+	// have indicates the block was already known when the header was added,
+	// so it is not fetched.
+	have bool
+
+	// peer is the peer the block was requested from at the requested time.
+	// It is nil when the block is not requested.
+	peer      *serverPeer
+	requested time.Time
+
+	// block is the downloaded block.  It is held until the blocks for all of
+	// the previous headers were processed.  blockSize is its serialized
+	// size.
+	block     *blockMsg
+	blockSize int64
 }
 
 // blockManager provides a concurrency safe block manager for handling all
@@ -310,15 +377,43 @@ type blockManager struct {
 	requestedEverBlocks map[chainhash.Hash]uint8
 	progressLogger      *blockProgressLogger
 	syncPeer            *serverPeer
-	msgChan             chan interface{}
-	wg                  sync.WaitGroup
-	quit                chan struct{}
 
-	// The following fields are used for headers-first mode.
-	headersFirstMode bool
-	headerList       *list.List
-	startHeader      *list.Element
-	nextCheckpoint   *chaincfg.Checkpoint
+	// syncRequested is the last time blocks or headers were requested from
+	// the sync peer and behindSince is the time other candidate peers were
+	// first seen to prove more work, or zero when none have.
+	// syncBlocksRequested indicates the inventory of the blocks requested
+	// from the sync peer with a getblocks message did not arrive yet.  They
+	// are used to detect a stalling sync peer.
+	syncRequested       time.Time
+	behindSince         time.Time
+	syncBlocksRequested bool
+
+	msgChan chan interface{}
+	wg      sync.WaitGroup
+	quit    chan struct{}
+
+	// candidatePeers are the peers which are candidates to sync from.
+	// Blocks are fetched from all of them in headers-first mode.
+	candidatePeers *list.List
+
+	// The following fields are used for headers-first mode.  headerList
+	// holds the headers whose blocks were not processed yet in order and
+	// headerIndex maps their hashes to their list elements.  headerTip is
+	// the latest downloaded header, which the next header must link to.
+	// unverifiedHeaders holds the downloaded headers which do not link to
+	// the next checkpoint yet.  frontSince is the time the first header in
+	// the list became the next block to process.  heldBlocksSize is the
+	// total serialized size of the downloaded blocks held in the list.
+	headersFirstMode  bool
+	headerList        *list.List
+	headerIndex       map[chainhash.Hash]*list.Element
+	headerTip         *headerNode
+	unverifiedHeaders []*headerNode
+	headersSynced     bool
+	headersDeferred   bool
+	frontSince        time.Time
+	nextCheckpoint    *chaincfg.Checkpoint
+	heldBlocksSize    int64
 
 	// lotteryDataBroadcastMutex is a mutex protecting the map
 	// that checks if block lottery data has been broadcasted
@@ -331,6 +426,10 @@ type blockManager struct {
 	cachedParentTemplate  *BlockTemplate
 	AggressiveMining      bool
 
+	// highBandwidthPeers are the peers which were asked to announce new
+	// blocks by sending compact blocks directly, oldest first.
+	highBandwidthPeers []*serverPeer
+
 	// The following fields are used to filter duplicate block announcements.
 	announcedBlockMtx sync.Mutex
 	announcedBlock    *chainhash.Hash
@@ -341,20 +440,60 @@ type blockManager struct {
 	syncHeight    int64
 }
 
+// discardHeaders removes the headers from the passed list element to the end of
+// the list.  Blocks which were requested for them and did not arrive yet are no
+// longer considered requested.
+func (b *blockManager) discardHeaders(e *list.Element) {
+	for e != nil {
+		next := e.Next()
+		node := e.Value.(*headerNode)
+		if node.peer != nil && node.block == nil {
+			delete(node.peer.requestedBlocks, *node.hash)
+			delete(b.requestedBlocks, *node.hash)
+		}
+		b.heldBlocksSize -= node.blockSize
+		b.headerList.Remove(e)
+		delete(b.headerIndex, *node.hash)
+		e = next
+	}
+}
+
 // resetHeaderState sets the headers-first mode state to values appropriate for
-// syncing from a new peer.
+// syncing from a new peer.  The headers up to the last one whose block was
+// downloaded are kept when they still connect to the newest block, so the
+// downloaded blocks are processed once the blocks for the previous headers
+// arrived.  The remaining headers are discarded.
 func (b *blockManager) resetHeaderState(newestHash *chainhash.Hash, newestHeight int64) {
-	b.headersFirstMode = false
-	b.headerList.Init()
-	b.startHeader = nil
+	var lastKept *list.Element
+	front := b.headerList.Front()
+	if front != nil && front.Value.(*headerNode).prevHash == *newestHash {
+		for e := front; e != nil; e = e.Next() {
+			if e.Value.(*headerNode).block != nil {
+				lastKept = e
+			}
+		}
+	}
+	if lastKept != nil {
+		b.discardHeaders(lastKept.Next())
+	} else {
+		b.discardHeaders(front)
+	}
 
-	// When there is a next checkpoint, add an entry for the latest known
-	// block into the header pool.  This allows the next downloaded header
-	// to prove it links to the chain properly.
-	if b.nextCheckpoint != nil {
-		node := headerNode{height: newestHeight, hash: newestHash}
-		b.headerList.PushBack(&node)
+	b.headersFirstMode = false
+	b.unverifiedHeaders = nil
+	b.headersSynced = false
+	b.headersDeferred = false
+	b.frontSince = time.Now()
+
+	// Use the last kept header, or the latest known block when there is
+	// none, as the header tip.  This allows the next downloaded header to
+	// prove it links to the chain properly.
+	if lastKept != nil {
+		b.headerTip = lastKept.Value.(*headerNode)
+	} else {
+		b.headerTip = &headerNode{height: newestHeight, hash: newestHash}
 	}
+	b.nextCheckpoint = b.findNextHeaderCheckpoint(b.headerTip.height)
 }
 
 // SyncHeight returns latest known block being synced to.
@@ -425,9 +564,24 @@ func (b *blockManager) startSync(peers *list.List) {
 			continue
 		}
 
-		// the best sync candidate is the most updated peer
+		// Skip peers which are in the process of disconnecting.
+		if !sp.Connected() {
+			continue
+		}
+
+		// the best sync candidate is the most updated peer, preferring
+		// peers which were never demoted for stalling the sync
 		if bestPeer == nil {
 			bestPeer = sp
+			continue
+		}
+		stalls, _ := sp.syncStallStats()
+		bestStalls, _ := bestPeer.syncStallStats()
+		if (stalls == 0) != (bestStalls == 0) {
+			if stalls == 0 {
+				bestPeer = sp
+			}
+			continue
 		}
 		if bestPeer.LastBlock() < sp.LastBlock() {
 			bestPeer = sp
@@ -441,59 +595,53 @@ func (b *blockManager) startSync(peers *list.List) {
 		// to send.
 		b.requestedBlocks = make(map[chainhash.Hash]struct{})
 
-		locator, err := b.chain.LatestBlockLocator()
-		if err != nil {
-			bmgrLog.Errorf("Failed to get block locator for the "+
-				"latest block: %v", err)
-			return
-		}
-
 		bmgrLog.Infof("Syncing to block height %d from peer %v",
 			bestPeer.LastBlock(), bestPeer.Addr())
 
-		// When the current height is less than a known checkpoint we
-		// can use block headers to learn about which blocks comprise
-		// the chain up to the checkpoint and perform less validation
-		// for them.  This is possible since each header contains the
-		// hash of the previous header and a merkle root.  Therefore if
-		// we validate all of the received headers link together
-		// properly and the checkpoint hashes match, we can be sure the
-		// hashes for the blocks in between are accurate.  Further, once
-		// the full blocks are downloaded, the merkle root is computed
-		// and compared against the value in the header which proves the
-		// full block hasn't been tampered with.
+		b.syncPeer = bestPeer
+		b.syncRequested = time.Now()
+		b.syncHeightMtx.Lock()
+		b.syncHeight = bestPeer.LastBlock()
+		b.syncHeightMtx.Unlock()
+
+		// When the peer has blocks which are not known yet, use block
+		// headers to learn about which blocks comprise the chain and
+		// download the blocks from all candidate peers in parallel.  This
+		// is possible since each header contains the hash of the previous
+		// header and a merkle root.  Therefore if we validate all of the
+		// received headers link together properly, we can be sure the
+		// hashes for the blocks are accurate regardless of which peer
+		// delivers them.  Further, once the full blocks are downloaded,
+		// the merkle root is computed and compared against the value in
+		// the header which proves the full block hasn't been tampered
+		// with.
 		//
+		// The blocks for the headers up to a known checkpoint are only
+		// downloaded once the headers were verified to link to the
+		// checkpoint, which allows performing less validation for them.
 		// Once we have passed the final checkpoint, or checkpoints are
-		// disabled, use standard inv messages learn about the blocks
-		// and fully validate them.  Finally, regression test mode does
-		// not support the headers-first approach so do normal block
-		// downloads when in regression test mode.
-		if b.nextCheckpoint != nil &&
-			best.Height < b.nextCheckpoint.Height &&
-			!cfg.DisableCheckpoints {
-
-			err := bestPeer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+		// disabled, the blocks are fully validated.
+		//
+		// Otherwise, use standard inv messages to learn about new blocks.
+		if bestPeer.LastBlock() > best.Height {
+			b.resetHeaderState(&best.Hash, best.Height)
+			b.headersFirstMode = true
+			b.requestHeaders()
+		} else {
+			locator, err := b.chain.LatestBlockLocator()
 			if err != nil {
-				bmgrLog.Errorf("Failed to push getheadermsg for the "+
-					"latest blocks: %v", err)
+				bmgrLog.Errorf("Failed to get block locator for the "+
+					"latest block: %v", err)
 				return
 			}
-			b.headersFirstMode = true
-			bmgrLog.Infof("Downloading headers for blocks %d to "+
-				"%d from peer %s", best.Height+1,
-				b.nextCheckpoint.Height, bestPeer.Addr())
-		} else {
-			err := bestPeer.PushGetBlocksMsg(locator, &zeroHash)
+			err = bestPeer.PushGetBlocksMsg(locator, &zeroHash)
 			if err != nil {
 				bmgrLog.Errorf("Failed to push getblocksmsg for the "+
 					"latest blocks: %v", err)
 				return
 			}
+			b.syncBlocksRequested = true
 		}
-		b.syncPeer = bestPeer
-		b.syncHeightMtx.Lock()
-		b.syncHeight = bestPeer.LastBlock()
-		b.syncHeightMtx.Unlock()
 	} else {
 		bmgrLog.Warnf("No sync peer candidates available")
 	}
@@ -574,6 +722,17 @@ func (b *blockManager) handleDonePeerMsg(peers *list.List, sp *serverPeer) {
 		delete(b.requestedTxns, k)
 	}
 
+	// Request the blocks within the download window which were requested
+	// from the peer from other peers when in headers-first mode.  They are
+	// requested once new headers were downloaded when the peer is the sync
+	// peer, since the headers after the downloaded blocks are discarded.
+	if b.headersFirstMode {
+		b.releaseBlockRequests(sp)
+		if b.syncPeer != sp {
+			b.fetchHeaderBlocks()
+		}
+	}
+
 	// Remove requested blocks from the global map so that they will be
 	// fetched from elsewhere next time we get an inv.
 	// TODO(oga) we could possibly here check which peers have these blocks
@@ -582,6 +741,15 @@ func (b *blockManager) handleDonePeerMsg(peers *list.List, sp *serverPeer) {
 		delete(b.requestedBlocks, k)
 	}
 
+	// Remove the peer from the high-bandwidth compact block peers.
+	for i, hbPeer := range b.highBandwidthPeers {
+		if hbPeer == sp {
+			b.highBandwidthPeers = append(b.highBandwidthPeers[:i],
+				b.highBandwidthPeers[i+1:]...)
+			break
+		}
+	}
+
 	// Attempt to find a new peer to sync from if the quitting peer is the
 	// sync peer.  Also, reset the headers-first state if in headers-first
 	// mode so
@@ -618,9 +786,12 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 
 	// Process the transaction to include validation, insertion in the
 	// memory pool, orphan handling, etc.
+	// Transactions from peers with the relay permission are not rate
+	// limited.
 	allowOrphans := cfg.MaxOrphanTxs > 0
+	rateLimit := !tmsg.peer.permissions.has(permRelay)
 	acceptedTxs, err := b.server.txMemPool.ProcessTransaction(tmsg.tx,
-		allowOrphans, true, true)
+		allowOrphans, rateLimit, true)
 
 	// Remove transaction from request maps. Either the mempool/chain
 	// already knows about it and as such we shouldn't have any more
@@ -655,6 +826,9 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 		return
 	}
 
+	if len(acceptedTxs) > 0 {
+		tmsg.peer.recordTxRelay()
+	}
 	b.server.AnnounceNewTransactions(acceptedTxs)
 }
 
@@ -881,36 +1055,53 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 			return
 		}
 	}
+	bmsg.peer.lastProgress = time.Now()
 
-	// When in headers-first mode, if the block matches the hash of the
-	// first header in the list of headers that are being fetched, it's
-	// eligible for less validation since the headers have already been
-	// verified to link together and are valid up to the next checkpoint.
-	// Also, remove the list entry for all blocks except the checkpoint
-	// since it is needed to verify the next round of headers links
-	// properly.
-	isCheckpointBlock := false
-	behaviorFlags := blockchain.BFNone
+	// When in headers-first mode, blocks for the downloaded headers are held
+	// until the blocks for all of the previous headers were processed, so
+	// they are processed in order regardless of which peer delivered them
+	// first.
 	if b.headersFirstMode {
-		firstNodeEl := b.headerList.Front()
-		if firstNodeEl != nil {
-			firstNode := firstNodeEl.Value.(*headerNode)
-			if blockHash.IsEqual(firstNode.hash) {
-				behaviorFlags |= blockchain.BFFastAdd
-				if firstNode.hash.IsEqual(b.nextCheckpoint.Hash) {
-					isCheckpointBlock = true
-				} else {
-					b.headerList.Remove(firstNodeEl)
-				}
+		if e, ok := b.headerIndex[*blockHash]; ok {
+			node := e.Value.(*headerNode)
+			delete(bmsg.peer.requestedBlocks, *blockHash)
+			delete(b.requestedBlocks, *blockHash)
+			if node.peer != nil && node.peer != bmsg.peer {
+				delete(node.peer.requestedBlocks, *blockHash)
+			}
+			if node.block == nil {
+				b.holdHeaderBlock(node, bmsg)
 			}
+			b.processHeaderBlocks()
+			return
 		}
 	}
 
+	b.processBlock(bmsg, blockchain.BFNone)
+}
+
+// holdHeaderBlock holds the passed downloaded block for the passed header until
+// the blocks for all of the previous headers were processed and accounts for
+// its size.
+func (b *blockManager) holdHeaderBlock(node *headerNode, bmsg *blockMsg) {
+	node.block = bmsg
+	node.blockSize = int64(bmsg.block.MsgBlock().SerializeSize())
+	b.heldBlocksSize += node.blockSize
+}
+
+// processBlock processes a block received from a peer with the passed behavior
+// flags and updates the state of the chain and the height of the peer
+// accordingly.  It returns whether the block was accepted, which includes
+// blocks accepted as orphans.
+func (b *blockManager) processBlock(bmsg *blockMsg, behaviorFlags blockchain.BehaviorFlags) bool {
+	blockHash := bmsg.block.Hash()
+
 	// Remove block from request maps. Either chain will know about it and
 	// so we shouldn't have any more instances of trying to fetch it, or we
 	// will fail the insert and thus we'll retry next time we get an inv.
 	delete(bmsg.peer.requestedBlocks, *blockHash)
 	delete(b.requestedBlocks, *blockHash)
+	delete(bmsg.peer.partialBlocks, *blockHash)
 
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
@@ -938,7 +1129,7 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 		code, reason := mempool.ErrToRejectErr(err)
 		bmsg.peer.PushRejectMsg(wire.CmdBlock, code, reason,
 			blockHash, false)
-		return
+		return false
 	}
 
 	// Meta-data about the new block this peer is reporting. We use this
@@ -984,6 +1175,8 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 
 		onMainChain := !isOrphan && forkLen == 0
 		if onMainChain {
+			bmsg.peer.recordBlockRelay()
+
 			// A new block is connected, however, this new block may have
 			// votes in it that were hidden from the network and which
 			// validate our parent block. We should bolt these new votes
@@ -1016,6 +1209,12 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 			heightUpdate = best.Height
 			blkHashUpdate = &best.Hash
 
+			// Ask the peer to announce new blocks as compact
+			// blocks since it was able to deliver the new tip.
+			if b.current() {
+				b.updateHighBandwidthPeers(bmsg.peer)
+			}
+
 			// Clear the rejected transactions.
 			b.rejectedTxns = make(map[chainhash.Hash]struct{})
 
@@ -1034,119 +1233,622 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 	// chain is "current". This avoids sending a spammy amount of messages
 	// if we're syncing the chain from scratch.
 	if blkHashUpdate != nil && heightUpdate != 0 {
-		bmsg.peer.UpdateLastBlockHeight(heightUpdate)
+		// Blocks downloaded in headers-first mode are usually older than
+		// the latest block of the peer, so don't lower its height then.
+		if !b.headersFirstMode || heightUpdate > bmsg.peer.LastBlock() {
+			bmsg.peer.UpdateLastBlockHeight(heightUpdate)
+		}
 		if isOrphan || b.current() {
 			go b.server.UpdatePeerHeights(blkHashUpdate, heightUpdate,
 				bmsg.peer)
 		}
 	}
 
-	// Nothing more to do if we aren't in headers-first mode.
-	if !b.headersFirstMode {
-		return
+	return true
+}
+
+// processHeaderBlocks processes the downloaded blocks at the front of the
+// header list in order.  It then either switches to normal mode once the blocks
+// for all headers the sync peer has were processed, or requests more headers
+// and blocks as needed.
+func (b *blockManager) processHeaderBlocks() {
+	for e := b.headerList.Front(); e != nil; e = b.headerList.Front() {
+		node := e.Value.(*headerNode)
+		if node.block == nil && !node.have {
+			break
+		}
+		b.headerList.Remove(e)
+		delete(b.headerIndex, *node.hash)
+		b.heldBlocksSize -= node.blockSize
+		b.frontSince = time.Now()
+
+		// Skip blocks which are already known, such as those which were
+		// also received by other means while they were downloaded.
+		if node.have {
+			continue
+		}
+		if have, _ := b.chain.HaveBlock(node.hash); have {
+			continue
+		}
+
+		behaviorFlags := blockchain.BFNone
+		if node.fastAdd {
+			behaviorFlags |= blockchain.BFFastAdd
+		}
+		if !b.processBlock(node.block, behaviorFlags) {
+			// The block was rejected, so disconnect the peer which
+			// delivered it and start syncing again since the blocks for
+			// the remaining headers can not be processed.
+			node.block.peer.Disconnect()
+			best := b.chain.BestSnapshot()
+			b.resetHeaderState(&best.Hash, best.Height)
+			b.syncPeer = nil
+			b.startSync(b.candidatePeers)
+			return
+		}
 	}
 
-	// This is headers-first mode, so if the block is not a checkpoint
-	// request more blocks using the header list when the request queue is
-	// getting short.
-	if !isCheckpointBlock {
-		if b.startHeader != nil &&
-			len(bmsg.peer.requestedBlocks) < minInFlightBlocks {
-			b.fetchHeaderBlocks()
+	// Switch to normal mode once the blocks for all headers the sync peer
+	// has were processed by requesting the blocks after the latest one up
+	// to the end of the chain (zero hash).
+	if b.headersSynced && b.headerList.Len() == 0 {
+		b.headersFirstMode = false
+		bmgrLog.Infof("Processed the blocks for all downloaded headers " +
+			"-- switching to normal mode")
+		locator, err := b.chain.LatestBlockLocator()
+		if err != nil {
+			bmgrLog.Warnf("Failed to get block locator for the "+
+				"latest block: %v", err)
+			return
 		}
+		err = b.syncPeer.PushGetBlocksMsg(locator, &zeroHash)
+		if err != nil {
+			bmgrLog.Warnf("Failed to send getblocks message to peer "+
+				"%s: %v", b.syncPeer.Addr(), err)
+			return
+		}
+		b.syncRequested = time.Now()
+		b.syncBlocksRequested = true
 		return
 	}
 
-	// This is headers-first mode and the block is a checkpoint.  When
-	// there is a next checkpoint, get the next round of headers by asking
-	// for headers starting from the block after this one up to the next
-	// checkpoint.
-	prevHeight := b.nextCheckpoint.Height
-	prevHash := b.nextCheckpoint.Hash
-	b.nextCheckpoint = b.findNextHeaderCheckpoint(prevHeight)
-	if b.nextCheckpoint != nil {
-		locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
-		err := bmsg.peer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+	// Resume requesting headers once the blocks for half of the headers
+	// which were ahead were processed.
+	if b.headersDeferred && b.headerList.Len() < maxHeadersAhead/2 {
+		b.headersDeferred = false
+		b.requestHeaders()
+	}
+
+	b.fetchHeaderBlocks()
+}
+
+// requestFullBlock requests the passed block in full from the peer.  It is used
+// when a block can not be reconstructed from a compact block.
+func (b *blockManager) requestFullBlock(sp *serverPeer, hash *chainhash.Hash) {
+	delete(sp.partialBlocks, *hash)
+	gdmsg := wire.NewMsgGetDataSizeHint(1)
+	gdmsg.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, hash))
+	sp.QueueMessage(gdmsg, nil)
+}
+
+// processPartialBlock processes the block reconstructed from a compact block
+// once all of its transactions are known.  The block is requested in full
+// when it does not match its header.
+func (b *blockManager) processPartialBlock(sp *serverPeer, pb *partialBlock) {
+	hash := pb.header.BlockHash()
+	block, err := pb.block()
+	if err != nil {
+		bmgrLog.Debugf("Failed to reconstruct block %v from %s: %v",
+			hash, sp, err)
+		b.requestFullBlock(sp, &hash)
+		return
+	}
+
+	bmgrLog.Debugf("Reconstructed block %v from compact block of %s", hash,
+		sp)
+	b.handleBlockMsg(&blockMsg{block: block, peer: sp})
+}
+
+// handleCmpctBlockMsg handles cmpctblock messages from all peers.  The block is
+// reconstructed from the transactions of the memory pool and any transactions
+// which are missing are requested with a getblocktxn message.
+func (b *blockManager) handleCmpctBlockMsg(cmsg *cmpctBlockMsg) {
+	sp := cmsg.peer
+	header := &cmsg.block.Header
+	blockHash := header.BlockHash()
+
+	// Ensure the header has valid proof of work before doing any further
+	// work for the compact block.
+	err := blockchain.CheckProofOfWork(header, b.server.chainParams.PowLimit)
+	if err != nil {
+		bmgrLog.Warnf("Received compact block %v with invalid proof of "+
+			"work from peer %s -- disconnecting: %v", blockHash, sp, err)
+		sp.Disconnect()
+		return
+	}
+	iv := wire.NewInvVect(wire.InvTypeBlock, &blockHash)
+	sp.AddKnownInventory(iv)
+
+	// Compact blocks which were not requested are announcements of peers
+	// in high-bandwidth mode.  Ignore them unless they are from a peer which
+	// was asked to announce blocks that way, the chain is current, and the
+	// block is not already being requested from another peer.
+	_, requested := sp.requestedBlocks[blockHash]
+	if !requested {
+		sp.UpdateLastAnnouncedBlock(&blockHash)
+		if !b.isHighBandwidthPeer(sp) {
+			bmgrLog.Debugf("Ignoring unrequested compact block %v "+
+				"from %s which is not a high-bandwidth peer",
+				blockHash, sp)
+			return
+		}
+		if b.headersFirstMode || !b.current() {
+			return
+		}
+		if _, exists := b.requestedBlocks[blockHash]; exists {
+			return
+		}
+		haveBlock, err := b.haveInventory(iv)
 		if err != nil {
-			bmgrLog.Warnf("Failed to send getheaders message to "+
-				"peer %s: %v", bmsg.peer.Addr(), err)
+			bmgrLog.Warnf("Unexpected failure when checking for "+
+				"existing block during cmpctblock message "+
+				"processing: %v", err)
 			return
 		}
-		bmgrLog.Infof("Downloading headers for blocks %d to %d from "+
-			"peer %s", prevHeight+1, b.nextCheckpoint.Height,
-			b.syncPeer.Addr())
+		if haveBlock {
+			return
+		}
+	}
+
+	// Ensure the block connects to a known block at the height before it.
+	// Requested blocks which do not are fetched in full so they are handled
+	// like any other block, while announcements are ignored.
+	parent, err := b.chain.HeaderByHash(&header.PrevBlock)
+	if err != nil {
+		if requested {
+			b.requestFullBlock(sp, &blockHash)
+		}
+		return
+	}
+	if header.Height != parent.Height+1 {
+		bmgrLog.Warnf("Received compact block %v at height %d with "+
+			"parent at height %d from peer %s -- disconnecting",
+			blockHash, header.Height, parent.Height, sp)
+		sp.Disconnect()
 		return
 	}
 
-	// This is headers-first mode, the block is a checkpoint, and there are
-	// no more checkpoints, so switch to normal mode by requesting blocks
-	// from the block after this one up to the end of the chain (zero hash).
-	b.headersFirstMode = false
-	b.headerList.Init()
-	bmgrLog.Infof("Reached the final checkpoint -- switching to normal mode")
-	locator := blockchain.BlockLocator([]*chainhash.Hash{blockHash})
-	err = bmsg.peer.PushGetBlocksMsg(locator, &zeroHash)
+	if !requested {
+		b.requestedBlocks[blockHash] = struct{}{}
+		b.requestedEverBlocks[blockHash] = 0
+		b.limitMap(b.requestedBlocks, maxRequestedBlocks)
+		sp.requestedBlocks[blockHash] = struct{}{}
+	}
+
+	// Request the full block when too many blocks of the peer are being
+	// reconstructed already.
+	if len(sp.partialBlocks) >= maxPartialBlocks {
+		b.requestFullBlock(sp, &blockHash)
+		return
+	}
+
+	k0, k1 := cmsg.block.SipHashKeys()
+	pb, err := newPartialBlock(cmsg.block, b.server.txMemPool.ShortIDTxns(k0,
+		k1))
 	if err != nil {
-		bmgrLog.Warnf("Failed to send getblocks message to peer %s: %v",
-			bmsg.peer.Addr(), err)
+		bmgrLog.Debugf("Unable to use compact block %v from %s: %v",
+			blockHash, sp, err)
+		b.requestFullBlock(sp, &blockHash)
 		return
 	}
+
+	indexes, stakeIndexes := pb.missing()
+	if len(indexes) == 0 && len(stakeIndexes) == 0 {
+		b.processPartialBlock(sp, pb)
+		return
+	}
+
+	bmgrLog.Debugf("Requesting %d regular and %d stake transactions of "+
+		"compact block %v from %s", len(indexes), len(stakeIndexes),
+		blockHash, sp)
+	sp.partialBlocks[blockHash] = pb
+	sp.QueueMessage(wire.NewMsgGetBlockTxn(&blockHash, indexes,
+		stakeIndexes), nil)
 }
 
-// fetchHeaderBlocks creates and sends a request to the syncPeer for the next
-// list of blocks to be downloaded based on the current list of headers.
-func (b *blockManager) fetchHeaderBlocks() {
-	// Nothing to do if there is no start header.
-	if b.startHeader == nil {
-		bmgrLog.Warnf("fetchHeaderBlocks called with no start header")
+// handleBlockTxnMsg handles blocktxn messages from all peers.  The transactions
+// are used to complete the block which is reconstructed from a compact block.
+func (b *blockManager) handleBlockTxnMsg(bmsg *blockTxnMsg) {
+	sp := bmsg.peer
+	blockHash := &bmsg.blockTxn.BlockHash
+	pb, exists := sp.partialBlocks[*blockHash]
+	if !exists {
+		bmgrLog.Debugf("Ignoring unrequested blocktxn for block %v "+
+			"from %s", blockHash, sp)
 		return
 	}
+	delete(sp.partialBlocks, *blockHash)
 
-	// Build up a getdata request for the list of blocks the headers
-	// describe.  The size hint will be limited to wire.MaxInvPerMsg by
-	// the function, so no need to double check it here.
-	gdmsg := wire.NewMsgGetDataSizeHint(uint(b.headerList.Len()))
-	numRequested := 0
-	for e := b.startHeader; e != nil; e = e.Next() {
-		node, ok := e.Value.(*headerNode)
-		if !ok {
-			bmgrLog.Warn("Header list node type is not a headerNode")
+	if err := pb.fill(bmsg.blockTxn); err != nil {
+		bmgrLog.Debugf("Invalid blocktxn for block %v from %s: %v",
+			blockHash, sp, err)
+		b.requestFullBlock(sp, blockHash)
+		return
+	}
+	b.processPartialBlock(sp, pb)
+}
+
+// isHighBandwidthPeer returns whether the passed peer was asked to announce new
+// blocks by sending compact blocks directly.
+func (b *blockManager) isHighBandwidthPeer(sp *serverPeer) bool {
+	for _, hbPeer := range b.highBandwidthPeers {
+		if hbPeer == sp {
+			return true
+		}
+	}
+	return false
+}
+
+// updateHighBandwidthPeers asks the passed peer, which delivered a new tip
+// block, to announce new blocks by sending compact blocks directly.  Once there
+// are more than maxHighBandwidthPeers of them, the peer which was selected
+// first is asked to go back to announcing blocks via inventory vectors.
+func (b *blockManager) updateHighBandwidthPeers(sp *serverPeer) {
+	if !sp.WantsCmpctBlocks() || b.isHighBandwidthPeer(sp) {
+		return
+	}
+
+	sp.QueueMessage(wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion), nil)
+	b.highBandwidthPeers = append(b.highBandwidthPeers, sp)
+	if len(b.highBandwidthPeers) > maxHighBandwidthPeers {
+		oldest := b.highBandwidthPeers[0]
+		oldest.QueueMessage(wire.NewMsgSendCmpct(false,
+			wire.CmpctBlockVersion), nil)
+		b.highBandwidthPeers = b.highBandwidthPeers[1:]
+	}
+}
+
+// addHeader adds the passed downloaded header to the list of headers whose
+// blocks are fetched.
+func (b *blockManager) addHeader(node *headerNode) {
+	node.have, _ = b.chain.HaveBlock(node.hash)
+	b.headerIndex[*node.hash] = b.headerList.PushBack(node)
+}
+
+// downloadPeer returns the peer to request the block at the passed height from
+// in headers-first mode.  It is the candidate peer with the fewest blocks in
+// flight among those which have the block and may be sent more requests, or nil
+// when there is none.  The passed peer is never returned.
+func (b *blockManager) downloadPeer(height int64, exclude *serverPeer) *serverPeer {
+	var bestPeer *serverPeer
+	for e := b.candidatePeers.Front(); e != nil; e = e.Next() {
+		sp := e.Value.(*serverPeer)
+		if sp == exclude || !sp.Connected() || sp.LastBlock() < height {
 			continue
 		}
+		inFlight := len(sp.requestedBlocks)
+		if inFlight >= maxInFlightBlocksPerPeer {
+			continue
+		}
+		if bestPeer == nil || inFlight < len(bestPeer.requestedBlocks) {
+			bestPeer = sp
+		}
+	}
+	return bestPeer
+}
+
+// fetchHeaderBlocks requests the blocks within the download window which were
+// not requested yet.  The requests are spread across all candidate peers which
+// have the blocks.  Once the held blocks reach the maximum size, only the blocks
+// before the first held block are requested, since no more blocks can be
+// processed before they arrive.
+func (b *blockManager) fetchHeaderBlocks() {
+	requests := make(map[*serverPeer]*wire.MsgGetData)
+	now := time.Now()
+	heldFull := b.heldBlocksSize >= maxHeldBlocksSize
+	i := 0
+	for e := b.headerList.Front(); e != nil && i < blockDownloadWindow; e = e.Next() {
+		i++
+		node := e.Value.(*headerNode)
+		if node.block != nil && heldFull {
+			break
+		}
+		if node.have || node.block != nil || node.peer != nil {
+			continue
+		}
+
+		// Stop once there is no peer to request the block from.  The
+		// blocks for the following headers are requested as peers
+		// deliver the blocks in flight.
+		sp := b.downloadPeer(node.height, nil)
+		if sp == nil {
+			break
+		}
+		node.peer = sp
+		node.requested = now
+		b.requestedBlocks[*node.hash] = struct{}{}
+		b.requestedEverBlocks[*node.hash] = 0
+		sp.requestedBlocks[*node.hash] = struct{}{}
 
+		gdmsg, ok := requests[sp]
+		if !ok {
+			gdmsg = wire.NewMsgGetDataSizeHint(maxInFlightBlocksPerPeer)
+			requests[sp] = gdmsg
+		}
 		iv := wire.NewInvVect(wire.InvTypeBlock, node.hash)
-		haveInv, err := b.haveInventory(iv)
+		err := gdmsg.AddInvVect(iv)
 		if err != nil {
-			bmgrLog.Warnf("Unexpected failure when checking for "+
-				"existing inventory during header block "+
-				"fetch: %v", err)
+			bmgrLog.Warnf("Failed to add invvect while fetching "+
+				"block headers: %v", err)
+		}
+	}
+	for sp, gdmsg := range requests {
+		sp.QueueMessage(gdmsg, nil)
+	}
+}
+
+// releaseBlockRequests releases the blocks within the download window which
+// were requested from the passed peer and did not arrive yet, so they are
+// requested from other peers.
+func (b *blockManager) releaseBlockRequests(sp *serverPeer) {
+	for hash := range sp.requestedBlocks {
+		e, ok := b.headerIndex[hash]
+		if !ok {
 			continue
 		}
-		if !haveInv {
-			b.requestedBlocks[*node.hash] = struct{}{}
-			b.requestedEverBlocks[*node.hash] = 0
-			b.syncPeer.requestedBlocks[*node.hash] = struct{}{}
-			err = gdmsg.AddInvVect(iv)
-			if err != nil {
-				bmgrLog.Warnf("Failed to add invvect while fetching "+
-					"block headers: %v", err)
-			}
-			numRequested++
+		node := e.Value.(*headerNode)
+		if node.peer != sp || node.block != nil {
+			continue
 		}
-		b.startHeader = e.Next()
-		if numRequested >= wire.MaxInvPerMsg {
-			break
+		node.peer = nil
+		delete(sp.requestedBlocks, hash)
+		delete(b.requestedBlocks, hash)
+	}
+}
+
+// checkBlockStall disconnects the peer the next block to process in
+// headers-first mode was requested from when the block did not arrive within
+// blockStallTimeout while another peer may be asked for it instead.  The
+// blocks which were requested from the peer are requested from other peers.
+func (b *blockManager) checkBlockStall() {
+	if !b.headersFirstMode {
+		return
+	}
+	e := b.headerList.Front()
+	if e == nil {
+		return
+	}
+	node := e.Value.(*headerNode)
+	if node.peer == nil || node.block != nil {
+		return
+	}
+
+	// The block stalls the download since it became the next block to
+	// process or since it was requested, whichever is later.
+	since := node.requested
+	if b.frontSince.After(since) {
+		since = b.frontSince
+	}
+	if time.Since(since) < blockStallTimeout {
+		return
+	}
+	if b.downloadPeer(node.height, node.peer) == nil {
+		return
+	}
+
+	sp := node.peer
+	bmgrLog.Infof("Peer %s is stalling the download of block %v (height "+
+		"%d) -- disconnecting", sp, node.hash, node.height)
+	b.releaseBlockRequests(sp)
+	sp.Disconnect()
+	b.fetchHeaderBlocks()
+}
+
+// syncRequestsOutstanding returns whether headers or blocks were requested from
+// the sync peer which did not arrive yet.
+func (b *blockManager) syncRequestsOutstanding() bool {
+	if len(b.syncPeer.requestedBlocks) > 0 {
+		return true
+	}
+	if b.headersFirstMode {
+		return !b.headersSynced && !b.headersDeferred
+	}
+	return b.syncBlocksRequested
+}
+
+// requestWorkProof requests the headers after the latest known block from the
+// passed peer, which claims to have more blocks, to have it prove the work of
+// its chain.
+func (b *blockManager) requestWorkProof(sp *serverPeer) {
+	locator, err := b.chain.LatestBlockLocator()
+	if err != nil {
+		bmgrLog.Warnf("Failed to get block locator for the latest "+
+			"block: %v", err)
+		return
+	}
+	if err := sp.PushGetHeadersMsg(locator, &zeroHash); err != nil {
+		bmgrLog.Warnf("Failed to send getheaders message to peer %s: %v",
+			sp, err)
+		return
+	}
+	sp.workProofRequested = time.Now()
+	sp.workProofPending = true
+}
+
+// handleWorkProofHeaders handles the headers the passed peer sent in response
+// to a request for the proof of the work of its chain.  The headers must link
+// together starting at a known block and have valid proof of work.  The peer
+// is disconnected otherwise.
+func (b *blockManager) handleWorkProofHeaders(sp *serverPeer, headers []*wire.BlockHeader) {
+	sp.workProofPending = false
+	sp.provenWork = nil
+	if len(headers) == 0 {
+		return
+	}
+
+	// Headers which do not start at a known block prove nothing, which may
+	// happen when the peer is on a chain which forked before the blocks of
+	// the locator.
+	parentWork, err := b.chain.ChainWork(&headers[0].PrevBlock)
+	if err != nil {
+		bmgrLog.Debugf("Headers from peer %s do not connect to a known "+
+			"block", sp)
+		return
+	}
+	work := new(big.Int).Set(parentWork)
+	prevHash := headers[0].PrevBlock
+	powLimit := b.server.chainParams.PowLimit
+	for _, header := range headers {
+		if header.PrevBlock != prevHash {
+			bmgrLog.Warnf("Received block header that does not "+
+				"properly connect to the previous one from peer %s "+
+				"-- disconnecting", sp)
+			sp.Disconnect()
+			return
 		}
+		if err := blockchain.CheckProofOfWork(header, powLimit); err != nil {
+			bmgrLog.Warnf("Received block header with invalid proof "+
+				"of work from peer %s -- disconnecting: %v", sp, err)
+			sp.Disconnect()
+			return
+		}
+		work.Add(work, blockchain.CalcWork(header.Bits))
+		prevHash = header.BlockHash()
 	}
-	if len(gdmsg.InvList) > 0 {
-		b.syncPeer.QueueMessage(gdmsg, nil)
+	sp.provenWork = work
+}
+
+// checkSyncPeerStall demotes the sync peer when requests to it are outstanding
+// and it made no progress within syncPeerStallTimeout while the headers of
+// other candidate peers prove they have a chain with more work, and selects a
+// new sync peer.  Demoted peers remain candidates to download blocks from in
+// headers-first mode, but other peers are preferred when selecting the sync
+// peer.  Candidate peers which claim to have more blocks are asked for headers
+// to prove it.
+func (b *blockManager) checkSyncPeerStall() {
+	sp := b.syncPeer
+	if sp == nil || b.current() || !b.syncRequestsOutstanding() {
+		b.behindSince = time.Time{}
+		return
 	}
+
+	// Track since when the headers of other peers prove more work.
+	best := b.chain.BestSnapshot()
+	bestWork, err := b.chain.ChainWork(&best.Hash)
+	if err != nil {
+		bmgrLog.Errorf("Failed to get the work of the best chain: %v",
+			err)
+		return
+	}
+	now := time.Now()
+	behind := false
+	for e := b.candidatePeers.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*serverPeer)
+		if p == sp || !p.Connected() {
+			continue
+		}
+		if p.provenWork != nil && p.provenWork.Cmp(bestWork) > 0 {
+			behind = true
+		}
+		if p.LastBlock() > best.Height && !p.workProofPending &&
+			now.Sub(p.workProofRequested) >= syncPeerStallTimeout {
+
+			b.requestWorkProof(p)
+		}
+	}
+	if !behind {
+		b.behindSince = time.Time{}
+		return
+	}
+	if b.behindSince.IsZero() {
+		b.behindSince = now
+	}
+
+	// The sync peer stalls since the latest of when it last made progress,
+	// when blocks or headers were last requested from it, and when other
+	// peers were first seen to have more blocks.
+	since := sp.lastProgress
+	if b.syncRequested.After(since) {
+		since = b.syncRequested
+	}
+	if b.behindSince.After(since) {
+		since = b.behindSince
+	}
+	stalled := now.Sub(since)
+	if stalled < syncPeerStallTimeout {
+		return
+	}
+
+	bmgrLog.Infof("Sync peer %s made no progress for %v while other peers "+
+		"prove more work -- selecting a new sync peer", sp,
+		stalled.Truncate(time.Second))
+	sp.recordSyncStall(now)
+	b.syncPeer = nil
+	b.behindSince = time.Time{}
+	if b.headersFirstMode {
+		b.resetHeaderState(&best.Hash, best.Height)
+	}
+	b.startSync(b.candidatePeers)
+}
+
+// requestHeaders requests the headers which follow the latest downloaded
+// header from the sync peer.  They are requested up to the next checkpoint
+// when there is one.
+func (b *blockManager) requestHeaders() {
+	var locator blockchain.BlockLocator
+	if b.headerList.Len() == 0 && len(b.unverifiedHeaders) == 0 {
+		var err error
+		locator, err = b.chain.LatestBlockLocator()
+		if err != nil {
+			bmgrLog.Errorf("Failed to get block locator for the "+
+				"latest block: %v", err)
+			return
+		}
+	} else {
+		// Include the locator for the latest block after the header tip,
+		// so a peer which does not know the header tip, such as a new
+		// sync peer on another chain, responds with headers which
+		// connect to a known block.
+		latest, err := b.chain.LatestBlockLocator()
+		if err != nil {
+			bmgrLog.Errorf("Failed to get block locator for the "+
+				"latest block: %v", err)
+			return
+		}
+		locator = append(blockchain.BlockLocator{b.headerTip.hash},
+			latest...)
+	}
+
+	stopHash := &zeroHash
+	stopHeight := b.syncPeer.LastBlock()
+	if b.nextCheckpoint != nil {
+		stopHash = b.nextCheckpoint.Hash
+		stopHeight = b.nextCheckpoint.Height
+	}
+	err := b.syncPeer.PushGetHeadersMsg(locator, stopHash)
+	if err != nil {
+		bmgrLog.Warnf("Failed to send getheaders message to peer %s: %v",
+			b.syncPeer.Addr(), err)
+		return
+	}
+	b.syncRequested = time.Now()
+	bmgrLog.Debugf("Downloading headers for blocks %d to %d from peer %s",
+		b.headerTip.height+1, stopHeight, b.syncPeer.Addr())
 }
 
 // handleHeadersMsg handles headers messages from all peers.
 func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
-	// The remote peer is misbehaving if we didn't request headers.
+	// Headers requested to prove the work of the chain of the peer are
+	// handled separately.  They were requested before any headers which
+	// were requested since the peer became the sync peer, so they arrive
+	// first.
 	msg := hmsg.headers
+	if hmsg.peer.workProofPending {
+		b.handleWorkProofHeaders(hmsg.peer, msg.Headers)
+		return
+	}
+
+	// The remote peer is misbehaving if we didn't request headers.
 	numHeaders := len(msg.Headers)
 	if !b.headersFirstMode {
 		bmgrLog.Warnf("Got %d unrequested headers from %s -- "+
@@ -1155,54 +1857,80 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
-	// Nothing to do for an empty headers message.
-	if numHeaders == 0 {
+	// Headers are only requested from the sync peer, so ignore any from
+	// other peers, such as a late response from a previous sync peer.
+	if hmsg.peer != b.syncPeer {
+		bmgrLog.Debugf("Ignoring %d headers from %s which is not the "+
+			"sync peer", numHeaders, hmsg.peer)
 		return
 	}
+	hmsg.peer.lastProgress = time.Now()
 
-	// Process all of the received headers ensuring each one connects to the
-	// previous and that checkpoints match.
+	// Process all of the received headers ensuring each one has valid proof
+	// of work, connects to the previous, and that checkpoints match.
+	powLimit := b.server.chainParams.PowLimit
 	receivedCheckpoint := false
-	var finalHash *chainhash.Hash
-	for _, blockHeader := range msg.Headers {
+	for i, blockHeader := range msg.Headers {
 		blockHash := blockHeader.BlockHash()
-		finalHash = &blockHash
 
-		// Ensure there is a previous header to compare against.
-		prevNodeEl := b.headerList.Back()
-		if prevNodeEl == nil {
-			bmgrLog.Warnf("Header list does not contain a previous" +
-				"element as expected -- disconnecting peer")
+		// Ensure the header has valid proof of work, so the blocks for
+		// headers past the last checkpoint are not requested from peers
+		// unless the sync peer did the work to create them.
+		err := blockchain.CheckProofOfWork(blockHeader, powLimit)
+		if err != nil {
+			bmgrLog.Warnf("Received block header with invalid proof "+
+				"of work from peer %s -- disconnecting: %v",
+				hmsg.peer.Addr(), err)
 			hmsg.peer.Disconnect()
 			return
 		}
 
-		// Ensure the header properly connects to the previous one and
-		// add it to the list of headers.
-		node := headerNode{hash: &blockHash}
-		prevNode := prevNodeEl.Value.(*headerNode)
-		if prevNode.hash.IsEqual(&blockHeader.PrevBlock) {
-			node.height = prevNode.height + 1
-			e := b.headerList.PushBack(&node)
-			if b.startHeader == nil {
-				b.startHeader = e
+		// Ensure the header properly connects to the previous one.  The
+		// first header requested with a locator for the latest block may
+		// instead connect to an earlier known block when the peer is on
+		// another chain.  Any headers which were kept from a previous
+		// sync peer are discarded in that case.
+		prevNode := b.headerTip
+		if !prevNode.hash.IsEqual(&blockHeader.PrevBlock) {
+			prevNode = nil
+			if i == 0 && len(b.unverifiedHeaders) == 0 {
+				prevHeader, err := b.chain.HeaderByHash(
+					&blockHeader.PrevBlock)
+				if err == nil {
+					b.discardHeaders(b.headerList.Front())
+					prevNode = &headerNode{
+						height: int64(prevHeader.Height),
+						hash:   &blockHeader.PrevBlock,
+					}
+				}
 			}
-		} else {
+		}
+		if prevNode == nil {
 			bmgrLog.Warnf("Received block header that does not "+
 				"properly connect to the chain from peer %s "+
 				"-- disconnecting", hmsg.peer.Addr())
 			hmsg.peer.Disconnect()
 			return
 		}
+		node := &headerNode{
+			height:   prevNode.height + 1,
+			hash:     &blockHash,
+			prevHash: blockHeader.PrevBlock,
+		}
+		b.headerTip = node
+
+		// The blocks can be fetched right away with full validation once
+		// there are no more checkpoints.
+		if b.nextCheckpoint == nil {
+			b.addHeader(node)
+			continue
+		}
 
-		// Verify the header at the next checkpoint height matches.
+		// Verify the header at the next checkpoint height matches and
+		// fetch the blocks for all of the headers which link to it.
+		b.unverifiedHeaders = append(b.unverifiedHeaders, node)
 		if node.height == b.nextCheckpoint.Height {
-			if node.hash.IsEqual(b.nextCheckpoint.Hash) {
-				receivedCheckpoint = true
-				bmgrLog.Infof("Verified downloaded block "+
-					"header against checkpoint at height "+
-					"%d/hash %s", node.height, node.hash)
-			} else {
+			if !node.hash.IsEqual(b.nextCheckpoint.Hash) {
 				bmgrLog.Warnf("Block header at height %d/hash "+
 					"%s from peer %s does NOT match "+
 					"expected checkpoint hash of %s -- "+
@@ -1212,35 +1940,44 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 				hmsg.peer.Disconnect()
 				return
 			}
+			bmgrLog.Infof("Verified downloaded block header against "+
+				"checkpoint at height %d/hash %s", node.height,
+				node.hash)
+			for _, n := range b.unverifiedHeaders {
+				n.fastAdd = true
+				b.addHeader(n)
+			}
+			b.unverifiedHeaders = nil
+			b.nextCheckpoint = b.findNextHeaderCheckpoint(node.height)
+			receivedCheckpoint = true
 			break
 		}
 	}
 
-	// When this header is a checkpoint, switch to fetching the blocks for
-	// all of the headers since the last checkpoint.
-	if receivedCheckpoint {
-		// Since the first entry of the list is always the final block
-		// that is already in the database and is only used to ensure
-		// the next header links properly, it must be removed before
-		// fetching the blocks.
-		b.headerList.Remove(b.headerList.Front())
-		bmgrLog.Infof("Received %v block headers: Fetching blocks",
-			b.headerList.Len())
-		b.progressLogger.SetLastLogTime(time.Now())
-		b.fetchHeaderBlocks()
-		return
-	}
+	// The sync peer has no more headers when it sends fewer than the
+	// maximum number of headers per message without reaching the next
+	// checkpoint.  The blocks for any headers which could not be verified
+	// against the checkpoint are fetched with full validation in that case.
+	// Otherwise, request the next batch of headers unless too many headers
+	// are ahead of the processed blocks.
+	switch {
+	case !receivedCheckpoint && numHeaders < wire.MaxBlockHeadersPerMsg:
+		for _, n := range b.unverifiedHeaders {
+			b.addHeader(n)
+		}
+		b.unverifiedHeaders = nil
+		b.headersSynced = true
+		bmgrLog.Infof("Downloaded block headers up to height %d from "+
+			"peer %s", b.headerTip.height, hmsg.peer.Addr())
 
-	// This header is not a checkpoint, so request the next batch of
-	// headers starting from the latest known header and ending with the
-	// next checkpoint.
-	locator := blockchain.BlockLocator([]*chainhash.Hash{finalHash})
-	err := hmsg.peer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
-	if err != nil {
-		bmgrLog.Warnf("Failed to send getheaders message to "+
-			"peer %s: %v", hmsg.peer.Addr(), err)
-		return
+	case b.headerList.Len() >= maxHeadersAhead:
+		b.headersDeferred = true
+
+	default:
+		b.requestHeaders()
 	}
+
+	b.processHeaderBlocks()
 }
 
 // haveInventory returns whether or not the inventory represented by the passed
@@ -1299,6 +2036,11 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 		imsg.peer.UpdateLastAnnouncedBlock(&invVects[lastBlock].Hash)
 	}
 
+	// The inventory of the blocks requested from the sync peer arrived.
+	if lastBlock != -1 && imsg.peer == b.syncPeer {
+		b.syncBlocksRequested = false
+	}
+
 	// Ignore invs from peers that aren't the sync if we are not current.
 	// Helps prevent fetching a mass of orphans.
 	if imsg.peer != b.syncPeer && !b.current() {
@@ -1424,6 +2166,15 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 				b.requestedEverBlocks[iv.Hash] = 0
 				b.limitMap(b.requestedBlocks, maxRequestedBlocks)
 				imsg.peer.requestedBlocks[iv.Hash] = struct{}{}
+
+				// Request a compact block instead of the full
+				// block when the chain is current since most
+				// of its transactions are likely in the memory
+				// pool already.
+				if b.current() && imsg.peer.WantsCmpctBlocks() {
+					iv = wire.NewInvVect(wire.InvTypeCmpctBlock,
+						&iv.Hash)
+				}
 				gdmsg.AddInvVect(iv)
 				numRequested++
 			}
@@ -1476,14 +2227,16 @@ func (b *blockManager) limitMap(m map[chainhash.Hash]struct{}, limit int) {
 // important because the block manager controls which blocks are needed and how
 // the fetching should proceed.
 func (b *blockManager) blockHandler() {
-	candidatePeers := list.New()
+	stallTicker := time.NewTicker(blockStallTickInterval)
+	defer stallTicker.Stop()
+
 out:
 	for {
 		select {
 		case m := <-b.msgChan:
 			switch msg := m.(type) {
 			case *newPeerMsg:
-				b.handleNewPeerMsg(candidatePeers, msg.peer)
+				b.handleNewPeerMsg(b.candidatePeers, msg.peer)
 
 			case *txMsg:
 				b.handleTxMsg(msg)
@@ -1493,6 +2246,14 @@ out:
 				b.handleBlockMsg(msg)
 				msg.peer.blockProcessed <- struct{}{}
 
+			case *cmpctBlockMsg:
+				b.handleCmpctBlockMsg(msg)
+				msg.peer.blockProcessed <- struct{}{}
+
+			case *blockTxnMsg:
+				b.handleBlockTxnMsg(msg)
+				msg.peer.blockProcessed <- struct{}{}
+
 			case *invMsg:
 				b.handleInvMsg(msg)
 
@@ -1500,7 +2261,7 @@ out:
 				b.handleHeadersMsg(msg)
 
 			case *donePeerMsg:
-				b.handleDonePeerMsg(candidatePeers, msg.peer)
+				b.handleDonePeerMsg(b.candidatePeers, msg.peer)
 
 			case getSyncPeerMsg:
 				msg.reply <- b.syncPeer
@@ -1640,6 +2401,10 @@ out:
 					"handler: %T", msg)
 			}
 
+		case <-stallTicker.C:
+			b.checkBlockStall()
+			b.checkSyncPeerStall()
+
 		case <-b.quit:
 			break out
 		}
@@ -1706,7 +2471,7 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 
 		// Generate the inventory vector and relay it immediately.
 		iv := wire.NewInvVect(wire.InvTypeBlock, block.Hash())
-		b.server.RelayInventory(iv, block.MsgBlock().Header, true)
+		b.server.RelayInventory(iv, block, true)
 		b.announcedBlockMtx.Lock()
 		b.announcedBlock = block.Hash()
 		b.announcedBlockMtx.Unlock()
@@ -1795,7 +2560,7 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 		b.announcedBlockMtx.Unlock()
 		if !sent {
 			iv := wire.NewInvVect(wire.InvTypeBlock, blockHash)
-			b.server.RelayInventory(iv, block.MsgBlock().Header, true)
+			b.server.RelayInventory(iv, block, true)
 		}
 
 		if !b.server.feeEstimator.IsEnabled() {
@@ -2059,6 +2824,30 @@ func (b *blockManager) QueueBlock(block *bitumutil.Block, sp *serverPeer) {
 	b.msgChan <- &blockMsg{block: block, peer: sp}
 }
 
+// QueueCmpctBlock adds the passed cmpctblock message and peer to the block
+// handling queue.
+func (b *blockManager) QueueCmpctBlock(block *wire.MsgCmpctBlock, sp *serverPeer) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		sp.blockProcessed <- struct{}{}
+		return
+	}
+
+	b.msgChan <- &cmpctBlockMsg{block: block, peer: sp}
+}
+
+// QueueBlockTxn adds the passed blocktxn message and peer to the block handling
+// queue.
+func (b *blockManager) QueueBlockTxn(blockTxn *wire.MsgBlockTxn, sp *serverPeer) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		sp.blockProcessed <- struct{}{}
+		return
+	}
+
+	b.msgChan <- &blockTxnMsg{blockTxn: blockTxn, peer: sp}
+}
+
 // QueueInv adds the passed inv message and peer to the block handling queue.
 func (b *blockManager) QueueInv(inv *wire.MsgInv, sp *serverPeer) {
 	// No channel handling here because peers do not need to block on inv
@@ -2359,6 +3148,7 @@ func newBlockManager(s *server, indexManager blockchain.IndexManager, interrupt
 		requestedEverBlocks: make(map[chainhash.Hash]uint8),
 		progressLogger:      newBlockProgressLogger("Processed", bmgrLog),
 		msgChan:             make(chan interface{}, cfg.MaxPeers*3),
+		candidatePeers:      list.New(),
 		headerList:          list.New(),
 		AggressiveMining:    !cfg.NonAggressive,
 		quit:                make(chan struct{}),
@@ -2380,15 +3170,10 @@ func newBlockManager(s *server, indexManager blockchain.IndexManager, interrupt
 	}
 	best := bm.chain.BestSnapshot()
 	bm.chain.DisableCheckpoints(cfg.DisableCheckpoints)
-	if !cfg.DisableCheckpoints {
-		// Initialize the next checkpoint based on the current height.
-		bm.nextCheckpoint = bm.findNextHeaderCheckpoint(best.Height)
-		if bm.nextCheckpoint != nil {
-			bm.resetHeaderState(&best.Hash, best.Height)
-		}
-	} else {
+	if cfg.DisableCheckpoints {
 		bmgrLog.Info("Checkpoints are disabled")
 	}
+	bm.resetHeaderState(&best.Hash, best.Height)
 
 	// Dump the blockchain here if asked for it, and quit.
 	if cfg.DumpBlockchain != "" {
@@ -0,0 +1,684 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bitum-project/bitumd/bitumutil"
+	"github.com/bitum-project/bitumd/blockchain"
+	"github.com/bitum-project/bitumd/chaincfg"
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/database"
+	"github.com/bitum-project/bitumd/peer"
+	"github.com/bitum-project/bitumd/txscript"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+// testConn is a connection to a peer which reports TCP addresses, as required
+// by inbound peers.
+type testConn struct {
+	net.Conn
+}
+
+// LocalAddr returns the local address of the connection.
+func (c testConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 18555}
+}
+
+// RemoteAddr returns the remote address of the connection.
+func (c testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 18556}
+}
+
+// newTestBlockManager returns a block manager for a new chain of the regression
+// test network which only has the genesis block, along with a teardown function
+// the caller must invoke when done testing.  The teardown function also
+// disconnects the candidate peers of the block manager.
+func newTestBlockManager(t *testing.T) (*blockManager, func()) {
+	t.Helper()
+
+	// The block manager consults the global configuration.
+	if cfg == nil {
+		cfg = &config{}
+	}
+
+	dbPath, err := ioutil.TempDir("", "blockmanagertest")
+	if err != nil {
+		t.Fatalf("unable to create test db path: %v", err)
+	}
+	db, err := database.Create("ffldb", dbPath, wire.RegNet)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create test db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+
+	params := chaincfg.RegNetParams
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  blockchain.NewMedianTime(),
+		SigCache:    txscript.NewSigCache(1000),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create test chain: %v", err)
+	}
+
+	s := &server{
+		chainParams:  &params,
+		uploadTarget: newUploadTarget(0),
+	}
+	b := &blockManager{
+		server:              s,
+		chain:               chain,
+		requestedBlocks:     make(map[chainhash.Hash]struct{}),
+		requestedEverBlocks: make(map[chainhash.Hash]uint8),
+		candidatePeers:      list.New(),
+		headerList:          list.New(),
+		headerIndex:         make(map[chainhash.Hash]*list.Element),
+	}
+	best := chain.BestSnapshot()
+	b.resetHeaderState(&best.Hash, best.Height)
+	s.blockManager = b
+	return b, func() {
+		for e := b.candidatePeers.Front(); e != nil; e = e.Next() {
+			e.Value.(*serverPeer).Disconnect()
+		}
+		teardown()
+	}
+}
+
+// newTestServerPeer returns a connected peer of the passed block manager which
+// claims to have blocks up to the passed height.  It is added to the candidate
+// peers of the block manager.
+func newTestServerPeer(b *blockManager, lastBlock int64) *serverPeer {
+	sp := newServerPeer(b.server, false)
+	sp.Peer = peer.NewInboundPeer(&peer.Config{
+		ChainParams: b.server.chainParams,
+	})
+	conn, _ := net.Pipe()
+	sp.AssociateConnection(testConn{conn})
+	sp.UpdateLastBlockHeight(lastBlock)
+	b.candidatePeers.PushBack(sp)
+	return sp
+}
+
+// testHeaders returns the passed number of headers with valid proof of work
+// which extend the block with the passed hash and height.  The nonces of the
+// headers start at the passed nonce, which allows creating different chains.
+func testHeaders(t *testing.T, params *chaincfg.Params, prevHash chainhash.Hash, prevHeight uint32, num int, nonce uint32) []*wire.BlockHeader {
+	t.Helper()
+
+	headers := make([]*wire.BlockHeader, 0, num)
+	for i := 0; i < num; i++ {
+		header := &wire.BlockHeader{
+			Version:   1,
+			PrevBlock: prevHash,
+			Bits:      params.PowLimitBits,
+			Height:    prevHeight + uint32(i) + 1,
+			Timestamp: time.Unix(params.GenesisBlock.Header.Timestamp.Unix()+
+				int64(i+1), 0),
+			Nonce: nonce,
+		}
+		for blockchain.CheckProofOfWork(header, params.PowLimit) != nil {
+			header.Nonce++
+		}
+		headers = append(headers, header)
+		prevHash = header.BlockHash()
+	}
+	return headers
+}
+
+// TestWorkProof ensures the headers peers send to prove the work of their chain
+// are only accepted when they connect to a known block, link together, and have
+// valid proof of work.
+func TestWorkProof(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	params := b.server.chainParams
+	genesisHash := params.GenesisBlock.BlockHash()
+	genesisWork, err := b.chain.ChainWork(&genesisHash)
+	if err != nil {
+		t.Fatalf("ChainWork: unexpected error: %v", err)
+	}
+
+	// Headers which extend the genesis block prove its work plus theirs.
+	sp := newTestServerPeer(b, 3)
+	b.requestWorkProof(sp)
+	if !sp.workProofPending {
+		t.Fatal("work proof not pending after requesting it")
+	}
+	headers := testHeaders(t, params, genesisHash, 0, 3, 0)
+	b.handleHeadersMsg(&headersMsg{
+		headers: &wire.MsgHeaders{Headers: headers},
+		peer:    sp,
+	})
+	if sp.workProofPending {
+		t.Fatal("work proof still pending after receiving headers")
+	}
+	if sp.provenWork == nil || sp.provenWork.Cmp(genesisWork) <= 0 {
+		t.Fatalf("unexpected proven work %v for genesis work %v",
+			sp.provenWork, genesisWork)
+	}
+	if !sp.Connected() {
+		t.Fatal("peer with valid headers was disconnected")
+	}
+
+	// Headers which do not start at a known block prove nothing.
+	b.requestWorkProof(sp)
+	b.handleWorkProofHeaders(sp, headers[1:])
+	if sp.provenWork != nil {
+		t.Fatalf("unexpected proven work %v for headers which do not "+
+			"connect", sp.provenWork)
+	}
+
+	// Peers which send headers which do not link together or have invalid
+	// proof of work are disconnected.
+	tests := []struct {
+		name    string
+		headers func() []*wire.BlockHeader
+	}{{
+		name: "headers do not link",
+		headers: func() []*wire.BlockHeader {
+			return []*wire.BlockHeader{headers[0], headers[2]}
+		},
+	}, {
+		name: "invalid proof of work",
+		headers: func() []*wire.BlockHeader {
+			header := *headers[0]
+			header.Bits = 0x1d00ffff
+			return []*wire.BlockHeader{&header}
+		},
+	}}
+	for _, test := range tests {
+		sp := newTestServerPeer(b, 3)
+		b.requestWorkProof(sp)
+		b.handleWorkProofHeaders(sp, test.headers())
+		if sp.provenWork != nil {
+			t.Errorf("%s: unexpected proven work %v", test.name,
+				sp.provenWork)
+		}
+		if sp.Connected() {
+			t.Errorf("%s: peer was not disconnected", test.name)
+		}
+	}
+}
+
+// TestCheckSyncPeerStall ensures the sync peer is only demoted when requests to
+// it are outstanding and it made no progress within the timeout while another
+// peer proved it has a chain with more work.
+func TestCheckSyncPeerStall(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	params := b.server.chainParams
+	genesisHash := params.GenesisBlock.BlockHash()
+	syncPeer := newTestServerPeer(b, 3)
+	other := newTestServerPeer(b, 3)
+	b.syncPeer = syncPeer
+	b.syncBlocksRequested = true
+
+	// stall moves the times the sync peer could have made progress at past
+	// the timeout.
+	stall := func() {
+		past := time.Now().Add(-syncPeerStallTimeout - time.Second)
+		syncPeer.lastProgress = past
+		b.syncRequested = past
+		if !b.behindSince.IsZero() {
+			b.behindSince = past
+		}
+	}
+
+	// The other peer claims to have more blocks, so it is asked to prove
+	// it, but the sync peer is not demoted without the proof.
+	stall()
+	b.checkSyncPeerStall()
+	if !other.workProofPending {
+		t.Fatal("peer claiming more blocks was not asked for headers")
+	}
+	if b.syncPeer != syncPeer {
+		t.Fatal("sync peer demoted without proof of more work")
+	}
+
+	// The sync peer is not demoted without outstanding requests even once
+	// the other peer proved more work.
+	b.handleWorkProofHeaders(other, testHeaders(t, params, genesisHash, 0,
+		3, 0))
+	b.syncBlocksRequested = false
+	stall()
+	b.checkSyncPeerStall()
+	if b.syncPeer != syncPeer {
+		t.Fatal("sync peer demoted without outstanding requests")
+	}
+	if !b.behindSince.IsZero() {
+		t.Fatal("behind without outstanding requests")
+	}
+
+	// The sync peer is not demoted before the timeout since the other peer
+	// was first seen to prove more work.
+	b.syncBlocksRequested = true
+	stall()
+	b.checkSyncPeerStall()
+	if b.syncPeer != syncPeer {
+		t.Fatal("sync peer demoted before the timeout")
+	}
+	if b.behindSince.IsZero() {
+		t.Fatal("not behind after another peer proved more work")
+	}
+
+	// The sync peer is demoted once it stalled for the timeout and the other
+	// peer is selected as the new sync peer.
+	stall()
+	b.checkSyncPeerStall()
+	if b.syncPeer != other {
+		t.Fatalf("unexpected sync peer %v after stall, want %v",
+			b.syncPeer, other)
+	}
+	if stalls, _ := syncPeer.syncStallStats(); stalls != 1 {
+		t.Fatalf("unexpected number of sync stalls %d, want 1", stalls)
+	}
+	if !syncPeer.Connected() {
+		t.Fatal("demoted sync peer was disconnected")
+	}
+}
+
+// addTestHeaders adds the passed number of headers which extend the genesis
+// block to the headers whose blocks are fetched by the passed block manager in
+// headers-first mode and returns their nodes.
+func addTestHeaders(t *testing.T, b *blockManager, num int) []*headerNode {
+	t.Helper()
+
+	params := b.server.chainParams
+	genesisHash := params.GenesisBlock.BlockHash()
+	nodes := make([]*headerNode, 0, num)
+	for _, header := range testHeaders(t, params, genesisHash, 0, num, 0) {
+		hash := header.BlockHash()
+		node := &headerNode{
+			height:   int64(header.Height),
+			hash:     &hash,
+			prevHash: header.PrevBlock,
+		}
+		b.addHeader(node)
+		nodes = append(nodes, node)
+	}
+	b.headersFirstMode = true
+	b.headerTip = nodes[len(nodes)-1]
+	return nodes
+}
+
+// deliverTestBlock marks the block of the passed node as delivered by the peer
+// it was requested from like the block manager does once it arrives.
+func deliverTestBlock(b *blockManager, node *headerNode) {
+	sp := node.peer
+	delete(sp.requestedBlocks, *node.hash)
+	delete(b.requestedBlocks, *node.hash)
+	b.holdHeaderBlock(node, &blockMsg{
+		block: bitumutil.NewBlock(&wire.MsgBlock{}),
+		peer:  sp,
+	})
+}
+
+// TestDownloadPeer ensures blocks are requested from the connected candidate
+// peer with the fewest blocks in flight among those which have the block and
+// may be sent more requests.
+func TestDownloadPeer(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	p1 := newTestServerPeer(b, 2)
+	p2 := newTestServerPeer(b, 5)
+	p3 := newTestServerPeer(b, 5)
+	p3.Disconnect()
+	p2.requestedBlocks[chainhash.Hash{1}] = struct{}{}
+
+	tests := []struct {
+		name    string
+		height  int64
+		exclude *serverPeer
+		want    *serverPeer
+	}{
+		{"fewest in flight", 1, nil, p1},
+		{"only peer with block", 3, nil, p2},
+		{"excluded peer", 1, p1, p2},
+		{"no peer with block", 6, nil, nil},
+	}
+	for _, test := range tests {
+		sp := b.downloadPeer(test.height, test.exclude)
+		if sp != test.want {
+			t.Errorf("%s: unexpected peer %v, want %v", test.name, sp,
+				test.want)
+		}
+	}
+
+	// Peers with the maximum number of blocks in flight are not sent more
+	// requests.
+	for i := 0; i < maxInFlightBlocksPerPeer; i++ {
+		p2.requestedBlocks[chainhash.Hash{byte(i)}] = struct{}{}
+	}
+	if sp := b.downloadPeer(3, nil); sp != nil {
+		t.Errorf("unexpected peer %v with full peers", sp)
+	}
+}
+
+// TestFetchHeaderBlocks ensures the blocks which are not known yet are requested
+// spread across the candidate peers and released for other peers once they do
+// not arrive from the peer they were requested from.
+func TestFetchHeaderBlocks(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	p1 := newTestServerPeer(b, 40)
+	p2 := newTestServerPeer(b, 40)
+	nodes := addTestHeaders(t, b, 2*maxInFlightBlocksPerPeer+8)
+	nodes[0].have = true
+
+	// The blocks are requested from both peers up to the maximum number of
+	// blocks in flight for each, skipping the known block.
+	b.fetchHeaderBlocks()
+	if len(p1.requestedBlocks) != maxInFlightBlocksPerPeer ||
+		len(p2.requestedBlocks) != maxInFlightBlocksPerPeer {
+
+		t.Fatalf("unexpected blocks in flight %d and %d, want %d each",
+			len(p1.requestedBlocks), len(p2.requestedBlocks),
+			maxInFlightBlocksPerPeer)
+	}
+	if nodes[0].peer != nil {
+		t.Fatal("known block was requested")
+	}
+	for i, node := range nodes[1:] {
+		requested := i < 2*maxInFlightBlocksPerPeer
+		if (node.peer != nil) != requested {
+			t.Fatalf("block %d: unexpected requested state %v", i+1,
+				node.peer != nil)
+		}
+		if _, ok := b.requestedBlocks[*node.hash]; ok != requested {
+			t.Fatalf("block %d: unexpected global requested state %v",
+				i+1, ok)
+		}
+	}
+
+	// The blocks which were requested from the first peer and did not
+	// arrive are released while delivered blocks are kept.
+	var delivered *headerNode
+	for _, node := range nodes {
+		if node.peer == p1 {
+			delivered = node
+			deliverTestBlock(b, node)
+			break
+		}
+	}
+	b.releaseBlockRequests(p1)
+	if len(p1.requestedBlocks) != 0 {
+		t.Fatalf("unexpected blocks in flight %d after release",
+			len(p1.requestedBlocks))
+	}
+	if delivered.peer != p1 || delivered.block == nil {
+		t.Fatal("delivered block was released")
+	}
+	var released int
+	for _, node := range nodes {
+		if node.peer == nil && !node.have {
+			released++
+		}
+	}
+	unrequested := len(nodes) - 1 - 2*maxInFlightBlocksPerPeer
+	if want := maxInFlightBlocksPerPeer - 1 + unrequested; released != want {
+		t.Fatalf("unexpected number of unrequested blocks %d, want %d",
+			released, want)
+	}
+
+	// The released blocks are requested from a new peer.
+	p1.Disconnect()
+	p3 := newTestServerPeer(b, 40)
+	b.fetchHeaderBlocks()
+	if len(p3.requestedBlocks) != maxInFlightBlocksPerPeer {
+		t.Fatalf("unexpected blocks in flight %d for new peer, want %d",
+			len(p3.requestedBlocks), maxInFlightBlocksPerPeer)
+	}
+}
+
+// TestCheckBlockStall ensures the peer the next block to process was requested
+// from is disconnected once the block did not arrive within the timeout while
+// another peer may be asked for it, and the block is requested from that peer.
+func TestCheckBlockStall(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	p1 := newTestServerPeer(b, 2)
+	nodes := addTestHeaders(t, b, 2)
+	b.fetchHeaderBlocks()
+	if nodes[0].peer != p1 || nodes[1].peer != p1 {
+		t.Fatal("blocks were not requested from the only peer")
+	}
+
+	// The peer is not disconnected before the timeout or while there is no
+	// other peer to request the block from.
+	b.checkBlockStall()
+	if !p1.Connected() {
+		t.Fatal("peer disconnected before the timeout")
+	}
+	past := time.Now().Add(-blockStallTimeout - time.Second)
+	nodes[0].requested = past
+	b.frontSince = past
+	b.checkBlockStall()
+	if !p1.Connected() {
+		t.Fatal("peer disconnected without another peer")
+	}
+
+	// The peer is disconnected once another peer has the block and the
+	// blocks are requested from that peer.
+	p2 := newTestServerPeer(b, 2)
+	b.checkBlockStall()
+	if p1.Connected() {
+		t.Fatal("stalling peer was not disconnected")
+	}
+	for i, node := range nodes {
+		if node.peer != p2 {
+			t.Fatalf("block %d: unexpected peer %v, want %v", i,
+				node.peer, p2)
+		}
+	}
+}
+
+// TestProcessHeaderBlocks ensures the blocks for the downloaded headers are
+// processed in order regardless of the order they arrive in.
+func TestProcessHeaderBlocks(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	// The second node refers to the genesis block, so it is skipped as a
+	// known block once it is processed.
+	first := addTestHeaders(t, b, 1)[0]
+	genesisHash := b.server.chainParams.GenesisBlock.BlockHash()
+	second := &headerNode{height: 2, hash: &genesisHash}
+	b.addHeader(second)
+	second.have = false
+	b.holdHeaderBlock(second, &blockMsg{
+		block: bitumutil.NewBlock(&wire.MsgBlock{}),
+	})
+
+	// The block of the second header is held until the first one arrived.
+	b.processHeaderBlocks()
+	if b.headerList.Len() != 2 {
+		t.Fatalf("unexpected number of headers %d, want 2",
+			b.headerList.Len())
+	}
+	first.have = true
+	b.processHeaderBlocks()
+	if b.headerList.Len() != 0 || len(b.headerIndex) != 0 {
+		t.Fatalf("unexpected number of headers %d after processing, "+
+			"want 0", b.headerList.Len())
+	}
+	if b.heldBlocksSize != 0 {
+		t.Fatalf("unexpected held blocks size %d after processing",
+			b.heldBlocksSize)
+	}
+}
+
+// TestHeldBlocksSize ensures only the blocks needed to process the held blocks
+// are requested once the held blocks reach the maximum size and that the size
+// of discarded blocks is released.
+func TestHeldBlocksSize(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	newTestServerPeer(b, 6)
+	nodes := addTestHeaders(t, b, 6)
+	b.fetchHeaderBlocks()
+	deliverTestBlock(b, nodes[2])
+	deliverTestBlock(b, nodes[3])
+	heldSize := b.heldBlocksSize
+	if want := 2 * nodes[2].blockSize; heldSize != want || heldSize == 0 {
+		t.Fatalf("unexpected held blocks size %d, want %d", heldSize,
+			want)
+	}
+
+	// Release the requests for the blocks which did not arrive and fill up
+	// the held blocks.  Only the blocks before the first held block are
+	// requested again.
+	for _, node := range nodes {
+		if node.block == nil {
+			delete(node.peer.requestedBlocks, *node.hash)
+			delete(b.requestedBlocks, *node.hash)
+			node.peer = nil
+		}
+	}
+	b.heldBlocksSize = maxHeldBlocksSize
+	b.fetchHeaderBlocks()
+	for i, node := range nodes {
+		if want := i < 2; (node.peer != nil && node.block == nil) != want {
+			t.Fatalf("block %d: unexpected requested state %v", i,
+				node.peer != nil)
+		}
+	}
+
+	// The size of the held blocks is released when they are discarded.
+	b.discardHeaders(b.headerList.Front())
+	if want := maxHeldBlocksSize - heldSize; b.heldBlocksSize != want {
+		t.Fatalf("unexpected held blocks size %d after discarding, "+
+			"want %d", b.heldBlocksSize, want)
+	}
+}
+
+// TestHeadersInvalidWork ensures a sync peer which sends a block header with
+// invalid proof of work is disconnected and the header is not added.
+func TestHeadersInvalidWork(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	params := b.server.chainParams
+	genesisHash := params.GenesisBlock.BlockHash()
+	sp := newTestServerPeer(b, 3)
+	b.syncPeer = sp
+	b.headersFirstMode = true
+	headers := testHeaders(t, params, genesisHash, 0, 3, 0)
+	headers[2].Bits = 0x1d00ffff
+	b.handleHeadersMsg(&headersMsg{
+		headers: &wire.MsgHeaders{Headers: headers},
+		peer:    sp,
+	})
+	if sp.Connected() {
+		t.Fatal("sync peer with invalid proof of work was not " +
+			"disconnected")
+	}
+	if _, ok := b.headerIndex[headers[2].BlockHash()]; ok {
+		t.Fatal("header with invalid proof of work was added")
+	}
+}
+
+// TestResetHeaderState ensures the headers up to the last downloaded block are
+// kept when the header state is reset as long as they connect to the newest
+// block, and discarded once a new sync peer is on another chain.
+func TestResetHeaderState(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	params := b.server.chainParams
+	genesisHash := params.GenesisBlock.BlockHash()
+	p1 := newTestServerPeer(b, 5)
+	nodes := addTestHeaders(t, b, 5)
+	b.fetchHeaderBlocks()
+	deliverTestBlock(b, nodes[2])
+
+	// The headers up to the downloaded block are kept along with the
+	// requests for their blocks.
+	b.resetHeaderState(&genesisHash, 0)
+	if b.headerList.Len() != 3 || len(b.headerIndex) != 3 {
+		t.Fatalf("unexpected number of headers %d, want 3",
+			b.headerList.Len())
+	}
+	if b.headerTip != nodes[2] {
+		t.Fatalf("unexpected header tip %v, want %v", b.headerTip.hash,
+			nodes[2].hash)
+	}
+	for i, node := range nodes {
+		_, requested := b.requestedBlocks[*node.hash]
+		if want := i < 2; requested != want {
+			t.Fatalf("block %d: unexpected requested state %v", i,
+				requested)
+		}
+		if _, ok := p1.requestedBlocks[*node.hash]; ok != (i < 2) {
+			t.Fatalf("block %d: unexpected peer requested state %v",
+				i, ok)
+		}
+	}
+
+	// Headers from a new sync peer on another chain which connect to the
+	// genesis block replace the kept headers.
+	p2 := newTestServerPeer(b, 5)
+	b.syncPeer = p2
+	b.headersFirstMode = true
+	headers := testHeaders(t, params, genesisHash, 0, 4, 1000)
+	if headers[0].BlockHash() == *nodes[0].hash {
+		t.Fatal("headers of the other chain match the first chain")
+	}
+	b.handleHeadersMsg(&headersMsg{
+		headers: &wire.MsgHeaders{Headers: headers},
+		peer:    p2,
+	})
+	if !p2.Connected() {
+		t.Fatal("sync peer on another chain was disconnected")
+	}
+	if b.headerList.Len() != 4 {
+		t.Fatalf("unexpected number of headers %d, want 4",
+			b.headerList.Len())
+	}
+	for _, node := range nodes {
+		if _, ok := b.headerIndex[*node.hash]; ok {
+			t.Fatalf("header %v of the first chain was kept", node.hash)
+		}
+		if _, ok := b.requestedBlocks[*node.hash]; ok {
+			t.Fatalf("block %v of the first chain is requested",
+				node.hash)
+		}
+	}
+
+	// All headers are discarded when they do not connect to the newest
+	// block.
+	newest := headers[0].BlockHash()
+	b.holdHeaderBlock(b.headerList.Front().Value.(*headerNode), &blockMsg{
+		block: bitumutil.NewBlock(&wire.MsgBlock{}),
+	})
+	b.resetHeaderState(&newest, 1)
+	if b.headerList.Len() != 0 || len(b.headerIndex) != 0 {
+		t.Fatalf("unexpected number of headers %d, want 0",
+			b.headerList.Len())
+	}
+	if *b.headerTip.hash != newest || b.headerTip.height != 1 {
+		t.Fatalf("unexpected header tip %v at height %d",
+			b.headerTip.hash, b.headerTip.height)
+	}
+}
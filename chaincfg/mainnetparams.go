@@ -191,6 +191,34 @@ var MainNetParams = Params{
 				StartTime:  1548633600,
 				ExpireTime: 1577836800,
 			}},
+			7: {{
+				Vote: Vote{
+					Id:          VoteIDCodechainHead,
+					Description: "Require block headers to commit to a recent signed Codechain tree hash",
+					Mask:        0x0006,
+					Choices: []Choice{{
+						Id:          "abstain",
+						Description: "abstain voting for change",
+						Bits:        0x0000,
+						IsAbstain:   true,
+						IsNo:        false,
+					}, {
+						Id:          "no",
+						Description: "keep the existing consensus rules",
+						Bits:        0x0002,
+						IsAbstain:   false,
+						IsNo:        true,
+					}, {
+						Id:          "yes",
+						Description: "change to the new consensus rules",
+						Bits:        0x0004,
+						IsAbstain:   false,
+						IsNo:        false,
+					}},
+				},
+				StartTime:  1798761600,
+				ExpireTime: 1830297600,
+			}},
 		},
 	
 	// Enforce current block version once majority of the network has
@@ -203,6 +231,14 @@ var MainNetParams = Params{
 	BlockRejectNumRequired:  950,
 	BlockUpgradeNumToCheck:  1000,
 
+	// Allow block headers to commit to any of the last 5 signed tree
+	// hashes once the codechainhead agenda is active.
+	CodechainVersionWindow: 5,
+
+	// The Codechain hashchain of mainnet is pinned by its genesis head.
+	CodechainGenesisHead: hexDecode32("4fc99b1f42c57c3e0918162006ec85b8fbe2e0e25cf9f36ad287a9f56d2463e9"),
+	CodechainDir:         ".codechain_mainnet",
+
 	// AcceptNonStdTxs is a mempool param to either accept and relay
 	// non standard txs to the network or reject them
 	AcceptNonStdTxs: false,
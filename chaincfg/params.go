@@ -8,6 +8,7 @@ package chaincfg
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"math/big"
 	"time"
 
@@ -178,6 +179,11 @@ const (
 	// sequence lock functionality needed for Lightning Network (among other
 	// uses) defined by DCP0004.
 	VoteIDFixLNSeqLocks = "fixlnseqlocks"
+
+	// VoteIDCodechainHead is the vote ID for the agenda that requires the
+	// CodechainHead of a block header to commit to one of the most recent
+	// source tree hashes signed in the Codechain hashchain of the network.
+	VoteIDCodechainHead = "codechainhead"
 )
 
 // ConsensusDeployment defines details related to a specific consensus rule
@@ -364,6 +370,37 @@ type Params struct {
 	// The number of nodes to check.
 	BlockUpgradeNumToCheck uint64
 
+	// CodechainVersionWindow is the number of the most recent entries of
+	// CodechainTreeHashes a block header CodechainHead may commit to once
+	// the codechainhead agenda is active.
+	CodechainVersionWindow uint32
+
+	// CodechainTreeHashes are the source tree hashes signed in the
+	// Codechain hashchain of the network ordered from the first to the last
+	// one to be signed.  They are pinned like checkpoints, so every node
+	// running the same release agrees on the heads a block may commit to
+	// regardless of its own hashchain.  Since no other head is valid once
+	// the codechainhead agenda is active, the agenda must not start on a
+	// network before a release pins its tree hashes.
+	CodechainTreeHashes [][32]byte
+
+	// CodechainGenesisHead is the hash of an entry of the Codechain
+	// hashchain of the network which every hashchain accepted by the
+	// updater must contain.  A zero hash does not pin the hashchain, in
+	// which case CodechainSigners must be set.
+	CodechainGenesisHead [32]byte
+
+	// CodechainSigners are the ed25519 public keys which may start the
+	// Codechain hashchain of the network.  Nil does not restrict the
+	// signers, in which case the hashchain is pinned by
+	// CodechainGenesisHead.
+	CodechainSigners [][32]byte
+
+	// CodechainDir is the name of the directory of the Codechain
+	// repository which holds the hashchain and the patch files of the
+	// network in the source tree.
+	CodechainDir string
+
 	// AcceptNonStdTxs is a mempool param to either accept and relay
 	// non standard txs to the network or reject them
 	AcceptNonStdTxs bool
@@ -625,6 +662,19 @@ func hexDecode(hexStr string) []byte {
 	return b
 }
 
+// hexDecode32 converts the passed hex string into a 32-byte array.  Like
+// newHashFromStr, it panics on an error since it will only (and must only) be
+// called with hard-coded, and therefore known good, values.  Unlike
+// newHashFromStr, the byte order is not reversed.
+func hexDecode32(hexStr string) [32]byte {
+	var b [32]byte
+	if hex.DecodedLen(len(hexStr)) != len(b) {
+		panic(fmt.Sprintf("hex string %q is not 32 bytes", hexStr))
+	}
+	copy(b[:], hexDecode(hexStr))
+	return b
+}
+
 // BlockOneSubsidy returns the total subsidy of block height 1 for the
 // network.
 func (p *Params) BlockOneSubsidy() int64 {
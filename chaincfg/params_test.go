@@ -5,7 +5,12 @@
 
 package chaincfg
 
-import "testing"
+import (
+	"crypto/sha256"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
 
 // TestMustRegisterPanic ensures the mustRegister function panics when used to
 // register an invalid network.
@@ -23,3 +28,55 @@ func TestMustRegisterPanic(t *testing.T) {
 	// Intentionally try to register duplicate params to force a panic.
 	mustRegister(&MainNetParams)
 }
+
+// TestCodechainParams ensures the Codechain hashchain of every network is
+// pinned by a genesis head or signers, the directories are distinct, the
+// version window admits older tree hashes, and the throwaway signers of the
+// test networks are derived from their documented seeds.
+func TestCodechainParams(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		params *Params
+		seed   string
+	}{
+		{&MainNetParams, ""},
+		{&TestNetParams, ""},
+		{&SimNetParams, "bitum simnet codechain signer"},
+		{&RegNetParams, "bitum regnet codechain signer"},
+	}
+
+	var zero [32]byte
+	dirs := make(map[string]bool)
+	for _, test := range tests {
+		params := test.params
+		if params.CodechainGenesisHead == zero && params.CodechainSigners == nil {
+			t.Errorf("%s: hashchain is neither pinned by a genesis "+
+				"head nor by signers", params.Name)
+		}
+		if params.CodechainDir == "" || dirs[params.CodechainDir] {
+			t.Errorf("%s: missing or duplicate hashchain directory %q",
+				params.Name, params.CodechainDir)
+		}
+		dirs[params.CodechainDir] = true
+
+		// Block headers must be able to commit to at least one tree
+		// hash older than the last one so nodes have time to update.
+		if params.CodechainVersionWindow < 2 {
+			t.Errorf("%s: codechain version window %d is smaller "+
+				"than 2", params.Name, params.CodechainVersionWindow)
+		}
+
+		if test.seed == "" {
+			continue
+		}
+		seed := sha256.Sum256([]byte(test.seed))
+		pubKey := ed25519.NewKeyFromSeed(seed[:]).Public().(ed25519.PublicKey)
+		if len(params.CodechainSigners) != 1 ||
+			string(params.CodechainSigners[0][:]) != string(pubKey) {
+
+			t.Errorf("%s: signer is not derived from seed %q",
+				params.Name, test.seed)
+		}
+	}
+}
@@ -177,6 +177,34 @@ var RegNetParams = Params{
 			StartTime:  0,             // Always available for vote
 			ExpireTime: math.MaxInt64, // Never expires
 		}},
+		8: {{
+			Vote: Vote{
+				Id:          VoteIDCodechainHead,
+				Description: "Require block headers to commit to a recent signed Codechain tree hash",
+				Mask:        0x0006, // Bits 1 and 2
+				Choices: []Choice{{
+					Id:          "abstain",
+					Description: "abstain voting for change",
+					Bits:        0x0000,
+					IsAbstain:   true,
+					IsNo:        false,
+				}, {
+					Id:          "no",
+					Description: "keep the existing consensus rules",
+					Bits:        0x0002, // Bit 1
+					IsAbstain:   false,
+					IsNo:        true,
+				}, {
+					Id:          "yes",
+					Description: "change to the new consensus rules",
+					Bits:        0x0004, // Bit 2
+					IsAbstain:   false,
+					IsNo:        false,
+				}},
+			},
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		}},
 	},
 
 	// Enforce current block version once majority of the network has
@@ -189,6 +217,18 @@ var RegNetParams = Params{
 	BlockRejectNumRequired:  75,
 	BlockUpgradeNumToCheck:  100,
 
+	// Allow block headers to commit to any of the last 5 signed tree
+	// hashes once the codechainhead agenda is active.
+	CodechainVersionWindow: 5,
+
+	// The Codechain hashchain of regnet is started by a throwaway signer
+	// for local tests.  Its ed25519 secret key is derived from the seed
+	// sha256("bitum regnet codechain signer") and therefore publicly known.
+	CodechainSigners: [][32]byte{
+		hexDecode32("f03a14396e9fe482c98bb23248e9307ea41066a71bbc52d84dd0c08a89e30423"),
+	},
+	CodechainDir: ".codechain_regnet",
+
 	// AcceptNonStdTxs is a mempool param to either accept and relay
 	// non standard txs to the network or reject them
 	AcceptNonStdTxs: true,
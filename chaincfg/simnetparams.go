@@ -76,6 +76,18 @@ var SimNetParams = Params{
 	BlockRejectNumRequired:  75,
 	BlockUpgradeNumToCheck:  100,
 
+	// Allow block headers to commit to any of the last 5 signed tree
+	// hashes once the codechainhead agenda is active.
+	CodechainVersionWindow: 5,
+
+	// The Codechain hashchain of simnet is started by a throwaway signer
+	// for local tests.  Its ed25519 secret key is derived from the seed
+	// sha256("bitum simnet codechain signer") and therefore publicly known.
+	CodechainSigners: [][32]byte{
+		hexDecode32("085ad2f9f3708dd2e8ce475f088c644cd225d4fe60d1d6bc2be7211447d555fb"),
+	},
+	CodechainDir: ".codechain_simnet",
+
 	// AcceptNonStdTxs is a mempool param to either accept and relay
 	// non standard txs to the network or reject them
 	AcceptNonStdTxs: true,
@@ -92,6 +92,34 @@ var TestNetParams = Params{
 			StartTime:  1548633600, // Jan 28th, 2019
 			ExpireTime: 1580169600, // Jan 28th, 2020
 		}},
+		8: {{
+			Vote: Vote{
+				Id:          VoteIDCodechainHead,
+				Description: "Require block headers to commit to a recent signed Codechain tree hash",
+				Mask:        0x0006, // Bits 1 and 2
+				Choices: []Choice{{
+					Id:          "abstain",
+					Description: "abstain voting for change",
+					Bits:        0x0000,
+					IsAbstain:   true,
+					IsNo:        false,
+				}, {
+					Id:          "no",
+					Description: "keep the existing consensus rules",
+					Bits:        0x0002, // Bit 1
+					IsAbstain:   false,
+					IsNo:        true,
+				}, {
+					Id:          "yes",
+					Description: "change to the new consensus rules",
+					Bits:        0x0004, // Bit 2
+					IsAbstain:   false,
+					IsNo:        false,
+				}},
+			},
+			StartTime:  1798761600, // Jan 1st, 2027
+			ExpireTime: 1830297600, // Jan 1st, 2028
+		}},
 	},
 
 	// Enforce current block version once majority of the network has
@@ -104,6 +132,14 @@ var TestNetParams = Params{
 	BlockRejectNumRequired:  75,
 	BlockUpgradeNumToCheck:  100,
 
+	// Allow block headers to commit to any of the last 5 signed tree
+	// hashes once the codechainhead agenda is active.
+	CodechainVersionWindow: 5,
+
+	// The Codechain hashchain of testnet is pinned by its genesis head.
+	CodechainGenesisHead: hexDecode32("246d7e6ab314d694fd602b04d5aa46bd6713e667e0e1ad6675533787b2b3fe00"),
+	CodechainDir:         ".codechain_testnet",
+
 	// AcceptNonStdTxs is a mempool param to either accept and relay
 	// non standard txs to the network or reject them
 	AcceptNonStdTxs: true,
@@ -3,25 +3,26 @@
 // license that can be found in the LICENSE file.
 
 // bitumchain wraps codechain to handle the mainnet and testnet repositories.
+// The status, verify, and diff subcommands are implemented natively, so
+// releases can be audited without codechain.
 package main
 
 import (
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/bitum-project/bitumd/chaincfg"
 	"github.com/frankbraun/codechain/hashchain"
 )
 
-const (
-	mainnetDir = ".codechain_mainnet"
-	testnetDir = ".codechain_testnet"
+var (
+	mainnetDir = chaincfg.MainNetParams.CodechainDir
+	testnetDir = chaincfg.TestNetParams.CodechainDir
 )
 
 var treehash = "undefined"
@@ -43,19 +44,30 @@ func lastTreeHash(dir string) (string, error) {
 	return c.LastTreeHash(), nil
 }
 
-func readSourceLineComment() (string, error) {
-	buf, err := ioutil.ReadFile(filepath.Join(testnetDir, "hashchain"))
+func lastTreeComment(dir string) (string, error) {
+	c, err := hashchain.ReadFile(filepath.Join(dir, "hashchain"))
 	if err != nil {
 		return "", err
 	}
-	lines := bytes.Split(buf, []byte("\n"))
-	line := bytes.SplitN(lines[len(lines)-2], []byte(" "), 7)
-	return string(line[6]), nil
+	defer c.Close()
+	comments := c.TreeComments()
+	if len(comments) == 0 {
+		return "", fmt.Errorf("hashchain in %s has no tree comments", dir)
+	}
+	return comments[len(comments)-1], nil
 }
 
 func run(mainnet, testnet bool, args []string) error {
 	fmt.Printf("_binary_treehash=%s\n", treehash)
 
+	// handle native subcommands
+	if cmd, ok := nativeCommands[args[0]]; ok {
+		if len(args) > 1 {
+			return fmt.Errorf("%s takes no arguments", args[0])
+		}
+		return cmd(networks(mainnet, testnet))
+	}
+
 	// check if we are publishing on both nets
 	var sameOrigin bool
 	if !mainnet && !testnet && args[0] == "publish" {
@@ -95,7 +107,7 @@ func run(mainnet, testnet bool, args []string) error {
 			if sameOrigin {
 				// we just published a new source version and both previous
 				// versions are the same, read comment from hashchain
-				msg, err := readSourceLineComment()
+				msg, err := lastTreeComment(testnetDir)
 				if err != nil {
 					return err
 				}
@@ -128,13 +140,14 @@ func fatal(err error) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [options] codechain_command [codechain_options]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] status|verify|diff\n", os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
 func main() {
-	mainnet := flag.Bool("mainnet", false, "Call codechain only for mainnet")
-	testnet := flag.Bool("testnet", false, "Call codechain only for testnet")
+	mainnet := flag.Bool("mainnet", false, "Handle only mainnet")
+	testnet := flag.Bool("testnet", false, "Handle only testnet")
 	flag.Usage = usage
 	flag.Parse()
 	if flag.NArg() < 1 {
@@ -0,0 +1,237 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/patchfile"
+	"github.com/frankbraun/codechain/sync"
+	"github.com/frankbraun/codechain/tree"
+	"github.com/frankbraun/codechain/util/hex"
+)
+
+// excludePaths are the paths of the working tree which are not considered in
+// tree hash calculations, as in codechain with CODECHAIN_DIR and
+// CODECHAIN_EXCLUDE set to the mainnet and testnet directories.
+var excludePaths = []string{
+	".codechain",
+	mainnetDir,
+	testnetDir,
+	".git",
+	".gitignore",
+	".travis.yml",
+}
+
+// network is a Codechain repository handled by bitumchain.
+type network struct {
+	name string
+	dir  string
+}
+
+// chainInfo summarizes the hashchain of a network.
+type chainInfo struct {
+	head       [32]byte
+	treeHash   string
+	signed     []string
+	m, n       int
+	numSigners int
+}
+
+// lastSigned returns the last signed tree hash.
+func (ci *chainInfo) lastSigned() string {
+	return ci.signed[len(ci.signed)-1]
+}
+
+// nativeCommands are the subcommands bitumchain implements itself instead of
+// calling codechain.
+var nativeCommands = map[string]func(nets []network) error{
+	"status": status,
+	"verify": verify,
+	"diff":   diff,
+}
+
+// networks returns the networks selected by the -mainnet and -testnet
+// options, testnet first.
+func networks(mainnet, testnet bool) []network {
+	var nets []network
+	if testnet || !mainnet {
+		nets = append(nets, network{name: "testnet", dir: testnetDir})
+	}
+	if mainnet || !testnet {
+		nets = append(nets, network{name: "mainnet", dir: mainnetDir})
+	}
+	return nets
+}
+
+// readChainInfo reads and verifies the hashchain of the given network.
+func readChainInfo(net network) (*chainInfo, error) {
+	c, err := hashchain.ReadFile(filepath.Join(net.dir, "hashchain"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", net.name, err)
+	}
+	defer c.Close()
+	_, idx := c.LastSignedTreeHash()
+	return &chainInfo{
+		head:       c.Head(),
+		treeHash:   c.LastTreeHash(),
+		signed:     c.TreeHashes()[:idx+1],
+		m:          c.M(),
+		n:          c.N(),
+		numSigners: len(c.Signer()),
+	}, nil
+}
+
+// drift describes how the signed tree hashes of testnet and mainnet relate to
+// each other.  Mainnet is expected to follow testnet.
+func drift(testnet, mainnet *chainInfo) string {
+	t, m := testnet.signed, mainnet.signed
+	if len(t) == len(m) && t[len(t)-1] == m[len(m)-1] {
+		return "none"
+	}
+	index := func(hashes []string, treeHash string) int {
+		for i, h := range hashes {
+			if h == treeHash {
+				return i
+			}
+		}
+		return -1
+	}
+	if i := index(t, mainnet.lastSigned()); i >= 0 {
+		return fmt.Sprintf("testnet ahead by %d signed tree hashes",
+			len(t)-1-i)
+	}
+	if i := index(m, testnet.lastSigned()); i >= 0 {
+		return fmt.Sprintf("mainnet ahead by %d signed tree hashes",
+			len(m)-1-i)
+	}
+	return "diverged"
+}
+
+// status reports the heads and last signed tree hashes of the given networks
+// and whether testnet and mainnet drifted apart.
+func status(nets []network) error {
+	infos := make(map[string]*chainInfo)
+	for _, net := range nets {
+		ci, err := readChainInfo(net)
+		if err != nil {
+			return err
+		}
+		infos[net.name] = ci
+		fmt.Printf("%s_head=%x\n", net.name, ci.head[:])
+		fmt.Printf("%s_treehash=%s\n", net.name, ci.treeHash)
+		fmt.Printf("%s_signed_treehash=%s\n", net.name, ci.lastSigned())
+		fmt.Printf("%s_signed_treehashes=%d\n", net.name, len(ci.signed)-1)
+		fmt.Printf("%s_signers=%d (m=%d, n=%d)\n", net.name,
+			ci.numSigners, ci.m, ci.n)
+	}
+	if len(infos) == 2 {
+		fmt.Printf("drift=%s\n", drift(infos["testnet"], infos["mainnet"]))
+	}
+	return nil
+}
+
+// workingTreeHash returns the tree hash of the working tree.
+func workingTreeHash() (string, error) {
+	hash, err := tree.Hash(".", excludePaths)
+	if err != nil {
+		return "", err
+	}
+	return hex.Encode(hash[:]), nil
+}
+
+// verify verifies the hashchains and patch files of the given networks and
+// checks the working tree against their last signed tree hashes.
+func verify(nets []network) error {
+	workingHash, err := workingTreeHash()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("working_treehash=%s\n", workingHash)
+
+	failed := false
+	for _, net := range nets {
+		c, err := hashchain.ReadFile(filepath.Join(net.dir, "hashchain"))
+		if err != nil {
+			return fmt.Errorf("%s: %v", net.name, err)
+		}
+		lastSigned, _ := c.LastSignedTreeHash()
+		lastTreeHash := c.LastTreeHash()
+
+		// Rebuild the last published tree from the patch files, which
+		// verifies all of them.
+		tmpDir, err := ioutil.TempDir("", "bitumchain")
+		if err != nil {
+			c.Close()
+			return err
+		}
+		err = c.DeepVerify(tmpDir, filepath.Join(net.dir, "patches"),
+			excludePaths)
+		c.Close()
+		os.RemoveAll(tmpDir)
+		if err != nil {
+			return fmt.Errorf("%s: %v", net.name, err)
+		}
+		fmt.Printf("%s_patches=ok\n", net.name)
+
+		switch workingHash {
+		case lastSigned:
+			fmt.Printf("%s_working_tree=signed\n", net.name)
+		case lastTreeHash:
+			fmt.Printf("%s_working_tree=unsigned\n", net.name)
+			failed = true
+		default:
+			fmt.Printf("%s_working_tree=unpublished\n", net.name)
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("working tree does not match the last signed " +
+			"tree hash")
+	}
+	return nil
+}
+
+// diff prints the patch from the last signed tree of the given networks to
+// the working tree.
+func diff(nets []network) error {
+	for _, net := range nets {
+		c, err := hashchain.ReadFile(filepath.Join(net.dir, "hashchain"))
+		if err != nil {
+			return fmt.Errorf("%s: %v", net.name, err)
+		}
+		lastSigned, _ := c.LastSignedTreeHash()
+		treeHashes := c.TreeHashes()
+		c.Close()
+
+		tmpDir, err := ioutil.TempDir("", "bitumchain")
+		if err != nil {
+			return err
+		}
+		err = sync.Dir(tmpDir, lastSigned, filepath.Join(net.dir, "patches"),
+			treeHashes, excludePaths, true)
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return fmt.Errorf("%s: %v", net.name, err)
+		}
+		fmt.Printf("%s_signed_treehash=%s\n", net.name, lastSigned)
+		err = patchfile.Diff(patchfile.Version, os.Stdout, tmpDir, ".",
+			excludePaths)
+		os.RemoveAll(tmpDir)
+		switch err {
+		case nil:
+		case patchfile.ErrNoDifference:
+			fmt.Printf("%s_diff=none\n", net.name)
+		default:
+			return fmt.Errorf("%s: %v", net.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+// TestDrift ensures the drift between the signed tree hashes of testnet and
+// mainnet is described correctly.
+func TestDrift(t *testing.T) {
+	tests := []struct {
+		name    string
+		testnet []string
+		mainnet []string
+		want    string
+	}{{
+		name:    "in sync",
+		testnet: []string{"e", "a", "b"},
+		mainnet: []string{"e", "a", "b"},
+		want:    "none",
+	}, {
+		name:    "testnet ahead",
+		testnet: []string{"e", "a", "b", "c"},
+		mainnet: []string{"e", "a"},
+		want:    "testnet ahead by 2 signed tree hashes",
+	}, {
+		name:    "mainnet ahead",
+		testnet: []string{"e"},
+		mainnet: []string{"e", "a"},
+		want:    "mainnet ahead by 1 signed tree hashes",
+	}, {
+		name:    "mainnet skipped a release",
+		testnet: []string{"e", "a", "b", "c"},
+		mainnet: []string{"e", "b"},
+		want:    "testnet ahead by 1 signed tree hashes",
+	}, {
+		name:    "diverged",
+		testnet: []string{"e", "a", "b"},
+		mainnet: []string{"e", "a", "c"},
+		want:    "diverged",
+	}}
+
+	for _, test := range tests {
+		got := drift(&chainInfo{signed: test.testnet},
+			&chainInfo{signed: test.mainnet})
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -7,16 +7,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"time"
-
-	"github.com/bitum-project/bitumd/chaincfg/chainhash"
-	"github.com/bitum-project/bitumd/peer"
-	"github.com/bitum-project/bitumd/updater"
-	"github.com/bitum-project/bitumd/wire"
 )
 
 const (
@@ -25,64 +21,27 @@ const (
 	defaultNodeTimeout = time.Second * 10
 )
 
-func newMsgInvCodechainEntry(head [32]byte) *wire.MsgInv {
-	var h chainhash.Hash
-	copy(h[:], head[:])
-	invMsg := wire.NewMsgInvSizeHint(1)
-	iv := wire.NewInvVect(wire.InvTypeCodechainEntry, &h)
-	err := invMsg.AddInvVect(iv)
-	if err != nil {
-		panic(err)
+// normalizeAddress returns addr with the default port of the network added
+// if it does not have a port yet.
+func normalizeAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, activeNetParams.DefaultPort)
 	}
-	return invMsg
+	return addr
 }
 
-func pushUpdate(node string, head [32]byte) error {
-	// Connect to peer node.
-	verack := make(chan struct{})
-	config := peer.Config{
-		UserAgentName:  "bitumupdate",
-		ChainParams:    activeNetParams,
-		DisableRelayTx: true,
-		Listeners: peer.MessageListeners{
-			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
-				log.Printf("adding peer %v with services %v",
-					p.NA().IP.String(), p.Services())
-				verack <- struct{}{}
-			},
-		},
+// seedNodes returns the addresses the DNS seeds of the network resolve to.
+func seedNodes() []string {
+	var nodes []string
+	for _, seed := range activeNetParams.DNSSeeds {
+		addrs, err := net.LookupHost(seed.Host)
+		if err != nil {
+			log.Printf("failed to look up seed %s: %v", seed.Host, err)
+			continue
+		}
+		nodes = append(nodes, addrs...)
 	}
-	host := net.JoinHostPort(node, activeNetParams.DefaultPort)
-	p, err := peer.NewOutboundPeer(&config, host)
-	if err != nil {
-		return err
-	}
-	conn, err := net.DialTimeout("tcp", p.Addr(), defaultNodeTimeout)
-	if err != nil {
-		return err
-	}
-	p.AssociateConnection(conn)
-
-	// Wait for the verack message or timeout in case of failure.
-	select {
-	case <-verack:
-		log.Printf("verack on peer %v", p.Addr())
-	case <-time.After(defaultNodeTimeout):
-		err := fmt.Errorf("verack timeout on peer %v", p.Addr())
-		p.Disconnect()
-		return err
-	}
-
-	// Send current head as inventory message to peer.
-	done := make(chan struct{})
-	p.QueueMessage(newMsgInvCodechainEntry(head), done)
-	<-done
-	log.Print("inv message sent")
-
-	// TODO
-
-	p.Disconnect()
-	return nil
+	return nodes
 }
 
 func fatal(err error) {
@@ -95,13 +54,45 @@ func main() {
 	if err != nil {
 		fatal(err)
 	}
-	head, err := updater.Head(activeNetParams.Net)
+	u, err := loadUpdate(activeNetParams.CodechainDir)
 	if err != nil {
 		fatal(err)
 	}
-	log.Print("head:")
-	log.Printf("%x", *head)
-	if err := pushUpdate(cfg.Node, *head); err != nil {
-		fatal(err)
+	log.Printf("head: %x", u.head)
+	log.Printf("last signed tree hash: %x (%d of %d patch files present)",
+		u.lastSigned, len(u.patches), len(u.signed))
+
+	// Collect the nodes to push the update to, without duplicates.
+	nodes := cfg.Nodes
+	if cfg.Seeds {
+		nodes = append(nodes, seedNodes()...)
+	}
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, node := range nodes {
+		addr := normalizeAddress(node)
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		fatal(errors.New("no nodes to push the update to"))
+	}
+
+	// Push the update to all nodes concurrently and print a report.
+	reports := pushUpdates(addrs, u, cfg.Timeout)
+	var failed int
+	fmt.Println("report:")
+	for _, r := range reports {
+		fmt.Println(r)
+		if !r.ok() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fatal(fmt.Errorf("update not accepted by %d of %d nodes",
+			failed, len(reports)))
 	}
 }
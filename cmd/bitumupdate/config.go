@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/bitum-project/bitumd/chaincfg"
@@ -19,6 +20,7 @@ import (
 
 const (
 	defaultConfigFilename = "bitumupdate.conf"
+	defaultTimeout        = time.Second * 30
 )
 
 var (
@@ -34,8 +36,12 @@ var (
 //
 // See loadConfig for details on the configuration load process.
 type config struct {
-	Node    string `short:"n" long:"node" description:"IP address of a  working node"`
-	TestNet bool   `long:"testnet" description:"Use the test network"`
+	Nodes   []string      `short:"n" long:"node" description:"Address of a working node to push the update to (may be repeated)"`
+	Seeds   bool          `long:"seeds" description:"Push the update to the DNS seeds of the network"`
+	Timeout time.Duration `long:"timeout" description:"Time to wait for a node to announce the new head back"`
+	TestNet bool          `long:"testnet" description:"Use the test network"`
+	SimNet  bool          `long:"simnet" description:"Use the simulation test network"`
+	RegNet  bool          `long:"regnet" description:"Use the regression test network"`
 }
 
 func loadConfig() (*config, error) {
@@ -57,7 +63,9 @@ func loadConfig() (*config, error) {
 	}
 
 	// Default config.
-	cfg := config{}
+	cfg := config{
+		Timeout: defaultTimeout,
+	}
 
 	preCfg := cfg
 	preParser := flags.NewParser(&preCfg, flags.Default)
@@ -96,13 +104,28 @@ func loadConfig() (*config, error) {
 		return nil, err
 	}
 
-	if len(cfg.Node) == 0 {
-		return nil, errors.New("please specify a node")
+	if len(cfg.Nodes) == 0 && !cfg.Seeds {
+		return nil, errors.New("please specify a node or --seeds")
 	}
 
+	// Multiple networks can't be selected simultaneously.
+	numNets := 0
 	if cfg.TestNet {
+		numNets++
 		activeNetParams = &chaincfg.TestNetParams
 	}
+	if cfg.SimNet {
+		numNets++
+		activeNetParams = &chaincfg.SimNetParams
+	}
+	if cfg.RegNet {
+		numNets++
+		activeNetParams = &chaincfg.RegNetParams
+	}
+	if numNets > 1 {
+		return nil, errors.New("the testnet, simnet, and regnet params " +
+			"can't be used together -- choose one of the three")
+	}
 
 	return &cfg, nil
 }
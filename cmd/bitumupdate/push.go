@@ -0,0 +1,275 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/peer"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+// nodeReport describes the result of pushing an update to a single node.  A
+// node accepted the head or the patch file once it relays them, since nodes
+// only relay hashchain entries and patch files after verifying and storing
+// them.
+type nodeReport struct {
+	node          string
+	err           error
+	entriesServed int
+	patchesServed int
+	headAccepted  bool
+	patchAccepted bool
+	alreadyKnown  bool
+}
+
+// String returns a human-readable summary of the report.
+func (r *nodeReport) String() string {
+	if r.err != nil {
+		return fmt.Sprintf("%s: failed: %v", r.node, r.err)
+	}
+	var status string
+	switch {
+	case r.alreadyKnown:
+		status = "head already known"
+	case r.headAccepted:
+		status = "head accepted"
+	default:
+		status = "head not accepted"
+	}
+	if r.patchAccepted {
+		status += ", patch accepted"
+	}
+	return fmt.Sprintf("%s: %s (%d entries and %d patch files served)",
+		r.node, status, r.entriesServed, r.patchesServed)
+}
+
+// ok returns whether the node is known to have the pushed head.
+func (r *nodeReport) ok() bool {
+	return r.err == nil && (r.headAccepted || r.alreadyKnown)
+}
+
+// signal wraps a channel which is closed when the signal fires.  It may fire
+// any number of times.
+type signal struct {
+	once sync.Once
+	c    chan struct{}
+}
+
+// newSignal returns a new signal which has not fired yet.
+func newSignal() *signal {
+	return &signal{c: make(chan struct{})}
+}
+
+// fire fires the signal.
+func (s *signal) fire() {
+	s.once.Do(func() { close(s.c) })
+}
+
+// wait returns true if the signal fires before the timeout channel and false
+// otherwise.
+func (s *signal) wait(timeout <-chan time.Time) bool {
+	select {
+	case <-s.c:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// connectPeer connects to the given node with the given message listeners and
+// waits for the version handshake to complete.
+func connectPeer(addr string, listeners peer.MessageListeners) (*peer.Peer, error) {
+	verack := newSignal()
+	onVerAck := listeners.OnVerAck
+	listeners.OnVerAck = func(p *peer.Peer, msg *wire.MsgVerAck) {
+		if onVerAck != nil {
+			onVerAck(p, msg)
+		}
+		verack.fire()
+	}
+	config := peer.Config{
+		UserAgentName:  "bitumupdate",
+		ChainParams:    activeNetParams,
+		DisableRelayTx: true,
+		Listeners:      listeners,
+	}
+	p, err := peer.NewOutboundPeer(&config, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", p.Addr(), defaultNodeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p.AssociateConnection(conn)
+
+	// Wait for the verack message or timeout in case of failure.
+	select {
+	case <-verack.c:
+		log.Printf("%s: connected with services %v", addr, p.Services())
+	case <-time.After(defaultNodeTimeout):
+		p.Disconnect()
+		return nil, fmt.Errorf("verack timeout on peer %v", addr)
+	}
+	if p.ProtocolVersion() < wire.CodechainVersion {
+		p.Disconnect()
+		return nil, fmt.Errorf("peer %v does not support the updater "+
+			"(protocol version %d)", addr, p.ProtocolVersion())
+	}
+	return p, nil
+}
+
+// pushUpdate announces the head of the given update to the given node and
+// serves the hashchain entries and patch files it requests in turn.
+// Acceptance is detected by a second connection to the node, which only
+// observes the node relaying the new head and the last patch file.  Relaying
+// shows the node accepted them, but not that they propagate any further.
+// Nodes which do not relay them within the given timeout are asked for them
+// directly to detect nodes which already had the update.
+func pushUpdate(addr string, u *update, timeout time.Duration) *nodeReport {
+	r := &nodeReport{node: addr}
+	head := chainhash.Hash(u.head)
+	lastSigned := chainhash.Hash(u.lastSigned)
+	_, hasPatch := u.patches[lastSigned]
+
+	// Connect the observing peer first, so it does not miss the
+	// announcement of the node.
+	headSeen := newSignal()
+	patchSeen := newSignal()
+	observer, err := connectPeer(addr, peer.MessageListeners{
+		OnInv: func(p *peer.Peer, msg *wire.MsgInv) {
+			for _, iv := range msg.InvList {
+				switch {
+				case iv.Type == wire.InvTypeCodechainEntry &&
+					iv.Hash == head:
+					headSeen.fire()
+				case iv.Type == wire.InvTypePatch &&
+					iv.Hash == lastSigned:
+					patchSeen.fire()
+				}
+			}
+		},
+		OnCChain: func(p *peer.Peer, msg *wire.MsgCChain) {
+			for _, line := range msg.Lines {
+				if sha256.Sum256([]byte(line)) == u.head {
+					headSeen.fire()
+				}
+			}
+		},
+		OnPatch: func(p *peer.Peer, msg *wire.MsgPatch) {
+			if msg.TreeHash == lastSigned {
+				patchSeen.fire()
+			}
+		},
+	})
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer observer.Disconnect()
+
+	// Connect the pushing peer which serves the requests of the node.
+	var mtx sync.Mutex
+	pusher, err := connectPeer(addr, u.push.Listeners(
+		func(entries, patches int) {
+			mtx.Lock()
+			r.entriesServed += entries
+			r.patchesServed += patches
+			mtx.Unlock()
+		}))
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer pusher.Disconnect()
+
+	// Send current head as inventory message to the node.
+	done := make(chan struct{})
+	pusher.QueueMessage(u.push.HeadInvMsg(), done)
+	<-done
+	log.Printf("%s: announced head %x", addr, head[:])
+
+	headAccepted, alreadyKnown, patchAccepted := awaitAcceptance(hasPatch,
+		headSeen, patchSeen, time.After(timeout),
+		func() bool { return probeHead(observer, u, headSeen) },
+		func() bool { return probePatch(observer, u, patchSeen) })
+
+	// The listeners of the pushing peer might still be running, so copy the
+	// report under the lock.
+	mtx.Lock()
+	report := *r
+	mtx.Unlock()
+	report.headAccepted = headAccepted
+	report.alreadyKnown = alreadyKnown
+	report.patchAccepted = patchAccepted
+	return &report
+}
+
+// awaitAcceptance waits for the given signals which fire once the node relays
+// the head and, if the update has one, the last patch file.  It returns
+// whether the node accepted the head, already knew it, and accepted or already
+// knew the patch file.  The node does not relay entries and patch files it
+// already knew, so the given probe functions are used to ask for them
+// directly if they are not relayed before the deadline.
+func awaitAcceptance(hasPatch bool, headSeen, patchSeen *signal,
+	deadline <-chan time.Time, probeHead, probePatch func() bool) (bool, bool, bool) {
+
+	headAccepted := headSeen.wait(deadline)
+	alreadyKnown := false
+	if !headAccepted {
+		alreadyKnown = probeHead()
+	}
+	patchAccepted := false
+	if hasPatch && headAccepted {
+		patchAccepted = patchSeen.wait(deadline)
+	}
+	if hasPatch && (headAccepted || alreadyKnown) && !patchAccepted {
+		patchAccepted = probePatch()
+	}
+	return headAccepted, alreadyKnown, patchAccepted
+}
+
+// probeHead asks the node via the given observing peer for the head entry and
+// returns whether it has it.
+func probeHead(observer *peer.Peer, u *update, headSeen *signal) bool {
+	head := chainhash.Hash(u.head)
+	var start chainhash.Hash
+	if len(u.lines) > 1 {
+		start = sha256.Sum256([]byte(u.lines[len(u.lines)-2]))
+	}
+	observer.QueueMessage(wire.NewMsgGetCChain(&start, &head), nil)
+	return headSeen.wait(time.After(defaultNodeTimeout))
+}
+
+// probePatch asks the node via the given observing peer for the patch file of
+// the last signed tree hash and returns whether it has it.
+func probePatch(observer *peer.Peer, u *update, patchSeen *signal) bool {
+	lastSigned := chainhash.Hash(u.lastSigned)
+	observer.QueueMessage(wire.NewMsgGetPatch(&lastSigned), nil)
+	return patchSeen.wait(time.After(defaultNodeTimeout))
+}
+
+// pushUpdates pushes the given update to all given nodes concurrently and
+// returns the reports in the order of the nodes.
+func pushUpdates(nodes []string, u *update, timeout time.Duration) []*nodeReport {
+	reports := make([]*nodeReport, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			reports[i] = pushUpdate(node, u, timeout)
+		}(i, node)
+	}
+	wg.Wait()
+	return reports
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAwaitAcceptance ensures nodes are only reported to have accepted the
+// head and the patch file if they relay them or have them when asked, and
+// that they are only asked when they do not relay them in time.
+func TestAwaitAcceptance(t *testing.T) {
+	tests := []struct {
+		name           string
+		hasPatch       bool
+		headRelayed    bool // node relays the head in time
+		patchRelayed   bool // node relays the patch file in time
+		headProbed     bool // node has the head when asked
+		patchProbed    bool // node has the patch file when asked
+		wantHeadProbe  bool
+		wantPatchProbe bool
+		headAccepted   bool
+		alreadyKnown   bool
+		patchAccepted  bool
+	}{{
+		name:          "head and patch relayed",
+		hasPatch:      true,
+		headRelayed:   true,
+		patchRelayed:  true,
+		headAccepted:  true,
+		patchAccepted: true,
+	}, {
+		name:         "head relayed without patch",
+		headRelayed:  true,
+		headAccepted: true,
+	}, {
+		name:           "patch not relayed but known",
+		hasPatch:       true,
+		headRelayed:    true,
+		patchProbed:    true,
+		wantPatchProbe: true,
+		headAccepted:   true,
+		patchAccepted:  true,
+	}, {
+		name:           "patch rejected",
+		hasPatch:       true,
+		headRelayed:    true,
+		wantPatchProbe: true,
+		headAccepted:   true,
+	}, {
+		name:           "update already known",
+		hasPatch:       true,
+		headProbed:     true,
+		patchProbed:    true,
+		wantHeadProbe:  true,
+		wantPatchProbe: true,
+		alreadyKnown:   true,
+		patchAccepted:  true,
+	}, {
+		name:          "head rejected",
+		hasPatch:      true,
+		wantHeadProbe: true,
+	}}
+
+	for _, test := range tests {
+		headSeen := newSignal()
+		patchSeen := newSignal()
+		if test.headRelayed {
+			headSeen.fire()
+		}
+		if test.patchRelayed {
+			patchSeen.fire()
+		}
+		var headProbe, patchProbe bool
+		deadline := time.After(10 * time.Millisecond)
+		headAccepted, alreadyKnown, patchAccepted := awaitAcceptance(
+			test.hasPatch, headSeen, patchSeen, deadline,
+			func() bool {
+				headProbe = true
+				return test.headProbed
+			},
+			func() bool {
+				patchProbe = true
+				return test.patchProbed
+			})
+		if headProbe != test.wantHeadProbe {
+			t.Errorf("%s: unexpected head probe %v", test.name,
+				headProbe)
+		}
+		if patchProbe != test.wantPatchProbe {
+			t.Errorf("%s: unexpected patch probe %v", test.name,
+				patchProbe)
+		}
+		if headAccepted != test.headAccepted ||
+			alreadyKnown != test.alreadyKnown ||
+			patchAccepted != test.patchAccepted {
+
+			t.Errorf("%s: unexpected result -- got head accepted "+
+				"%v, already known %v, patch accepted %v",
+				test.name, headAccepted, alreadyKnown, patchAccepted)
+		}
+	}
+}
+
+// TestNodeReport ensures node reports are summarized as expected and only
+// nodes which are known to have the head are reported as ok.
+func TestNodeReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		report nodeReport
+		want   string
+		ok     bool
+	}{{
+		name: "accepted",
+		report: nodeReport{node: "a", entriesServed: 3,
+			patchesServed: 1, headAccepted: true, patchAccepted: true},
+		want: "a: head accepted, patch accepted (3 entries and 1 patch " +
+			"files served)",
+		ok: true,
+	}, {
+		name:   "already known",
+		report: nodeReport{node: "a", alreadyKnown: true},
+		want:   "a: head already known (0 entries and 0 patch files served)",
+		ok:     true,
+	}, {
+		name:   "not accepted",
+		report: nodeReport{node: "a", entriesServed: 3},
+		want:   "a: head not accepted (3 entries and 0 patch files served)",
+	}, {
+		name:   "failed",
+		report: nodeReport{node: "a", err: errors.New("timeout")},
+		want:   "a: failed: timeout",
+	}}
+
+	for _, test := range tests {
+		if got := test.report.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if ok := test.report.ok(); ok != test.ok {
+			t.Errorf("%s: got ok %v, want %v", test.name, ok, test.ok)
+		}
+	}
+}
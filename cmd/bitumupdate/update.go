@@ -0,0 +1,81 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/updater"
+	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/util/hex"
+)
+
+// update holds the hashchain and the patch files which are pushed to the
+// network.
+type update struct {
+	head  [32]byte
+	lines []string
+
+	// push serves the hashchain entries and patch files to the nodes.
+	push *updater.PushUpdate
+
+	// lastSigned is the last signed tree hash of the hashchain and
+	// patches maps the signed tree hashes to their patch files, if
+	// present.
+	lastSigned [32]byte
+	signed     [][32]byte
+	patches    map[chainhash.Hash][]byte
+}
+
+// loadUpdate loads the hashchain and the patch files of the signed tree hashes
+// from the Codechain repository in the given directory.
+func loadUpdate(dir string) (*update, error) {
+	hc, err := hashchain.ReadFile(filepath.Join(dir, "hashchain"))
+	if err != nil {
+		return nil, err
+	}
+	defer hc.Close()
+	var buf bytes.Buffer
+	if err := hc.Fprint(&buf); err != nil {
+		return nil, err
+	}
+
+	u := update{
+		head:    hc.Head(),
+		lines:   strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+		patches: make(map[chainhash.Hash][]byte),
+	}
+
+	treeHashes := hc.TreeHashes()
+	_, lastSigned := hc.LastSignedTreeHash()
+	for _, treeHash := range treeHashes[1 : lastSigned+1] {
+		h, err := hex.Decode(treeHash, 32)
+		if err != nil {
+			return nil, err
+		}
+		var th [32]byte
+		copy(th[:], h)
+		u.signed = append(u.signed, th)
+		u.lastSigned = th
+
+		patch, err := ioutil.ReadFile(filepath.Join(dir, "patches", treeHash))
+		if os.IsNotExist(err) {
+			log.Printf("patch file for tree hash %s missing", treeHash)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		u.patches[chainhash.Hash(th)] = patch
+	}
+	u.push = updater.NewPushUpdate(u.lines, u.patches)
+	return &u, nil
+}
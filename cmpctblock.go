@@ -0,0 +1,175 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bitum-project/bitumd/bitumutil"
+	"github.com/bitum-project/bitumd/blockchain"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+const (
+	// maxHighBandwidthPeers is the maximum number of peers which are asked
+	// to announce new blocks by sending compact blocks directly instead of
+	// inventory vectors or headers.
+	maxHighBandwidthPeers = 3
+
+	// maxPartialBlocks is the maximum number of blocks which are
+	// reconstructed from compact blocks of a single peer at once.
+	maxPartialBlocks = 3
+
+	// maxCmpctBlockDepth is the maximum number of blocks the main chain may
+	// extend beyond a block for it to be served as a compact block or for
+	// its transactions to be served with a blocktxn message.  Deeper
+	// blocks are served in full instead since they are unlikely to be
+	// reconstructed from the memory pool anyway.
+	maxCmpctBlockDepth = 10
+)
+
+var (
+	// errShortIDCollision describes an error where the short transaction
+	// IDs of a compact block are not unique, so the block has to be
+	// requested in full.
+	errShortIDCollision = errors.New("short transaction ID collision")
+
+	// errMerkleRootMismatch describes an error where the merkle roots of a
+	// reconstructed block do not match its header, which happens when a
+	// transaction of the memory pool shares a short transaction ID with a
+	// transaction of the block.
+	errMerkleRootMismatch = errors.New("reconstructed block does not " +
+		"match the merkle roots of its header")
+)
+
+// partialBlock houses a block which is reconstructed from a compact block and
+// the transactions of the memory pool.  Transactions which are not known yet
+// are nil until they are filled in from a blocktxn message.
+type partialBlock struct {
+	header wire.BlockHeader
+	txns   []*wire.MsgTx
+	stxns  []*wire.MsgTx
+}
+
+// newPartialTxTree returns the transactions of a transaction tree of a compact
+// block with the given short IDs and prefilled transactions.  Transactions are
+// looked up by short ID in the passed transactions, which are typically the
+// ones of the memory pool.
+func newPartialTxTree(shortIDs []uint64, prefilled []wire.PrefilledTx, poolTxns map[uint64]*bitumutil.Tx) ([]*wire.MsgTx, error) {
+	txns := make([]*wire.MsgTx, len(shortIDs)+len(prefilled))
+	for i, ptx := range prefilled {
+		if int(ptx.Index) >= len(txns) || ptx.Tx == nil ||
+			(i > 0 && ptx.Index <= prefilled[i-1].Index) {
+			return nil, fmt.Errorf("invalid prefilled transaction "+
+				"index %d", ptx.Index)
+		}
+		txns[ptx.Index] = ptx.Tx
+	}
+
+	// The short IDs fill the positions which are not prefilled in order.
+	seen := make(map[uint64]struct{}, len(shortIDs))
+	i := 0
+	for _, shortID := range shortIDs {
+		if _, ok := seen[shortID]; ok {
+			return nil, errShortIDCollision
+		}
+		seen[shortID] = struct{}{}
+
+		for txns[i] != nil {
+			i++
+		}
+		if tx := poolTxns[shortID]; tx != nil {
+			txns[i] = tx.MsgTx()
+		}
+		i++
+	}
+
+	return txns, nil
+}
+
+// newPartialBlock returns a partial block for the passed compact block with
+// all transactions filled in which are found in the passed transactions by
+// their short IDs.  See TxPool.ShortIDTxns.
+func newPartialBlock(msg *wire.MsgCmpctBlock, poolTxns map[uint64]*bitumutil.Tx) (*partialBlock, error) {
+	txns, err := newPartialTxTree(msg.ShortIDs, msg.PrefilledTxns, poolTxns)
+	if err != nil {
+		return nil, err
+	}
+	stxns, err := newPartialTxTree(msg.StakeShortIDs, msg.PrefilledSTxns,
+		poolTxns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &partialBlock{
+		header: msg.Header,
+		txns:   txns,
+		stxns:  stxns,
+	}, nil
+}
+
+// missingIndexes returns the indexes of the transactions which are not known.
+func missingIndexes(txns []*wire.MsgTx) []uint32 {
+	var indexes []uint32
+	for i, tx := range txns {
+		if tx == nil {
+			indexes = append(indexes, uint32(i))
+		}
+	}
+	return indexes
+}
+
+// missing returns the indexes of the regular and stake transactions of the
+// block which are not known yet.
+func (pb *partialBlock) missing() ([]uint32, []uint32) {
+	return missingIndexes(pb.txns), missingIndexes(pb.stxns)
+}
+
+// fill fills in the missing transactions of the block from the passed blocktxn
+// message.  The message must provide exactly the missing transactions of both
+// transaction trees in order.
+func (pb *partialBlock) fill(msg *wire.MsgBlockTxn) error {
+	indexes, stakeIndexes := pb.missing()
+	if len(msg.Transactions) != len(indexes) ||
+		len(msg.STransactions) != len(stakeIndexes) {
+
+		return fmt.Errorf("blocktxn provides %d regular and %d stake "+
+			"transactions, want %d and %d", len(msg.Transactions),
+			len(msg.STransactions), len(indexes), len(stakeIndexes))
+	}
+	for i, index := range indexes {
+		pb.txns[index] = msg.Transactions[i]
+	}
+	for i, index := range stakeIndexes {
+		pb.stxns[index] = msg.STransactions[i]
+	}
+	return nil
+}
+
+// block returns the reconstructed block.  An error is returned when there are
+// still missing transactions or the transactions do not match the merkle roots
+// of the block header.
+func (pb *partialBlock) block() (*bitumutil.Block, error) {
+	indexes, stakeIndexes := pb.missing()
+	if len(indexes) != 0 || len(stakeIndexes) != 0 {
+		return nil, fmt.Errorf("block is missing %d regular and %d "+
+			"stake transactions", len(indexes), len(stakeIndexes))
+	}
+
+	merkles := blockchain.BuildMsgTxMerkleTreeStore(pb.txns)
+	stakeMerkles := blockchain.BuildMsgTxMerkleTreeStore(pb.stxns)
+	if *merkles[len(merkles)-1] != pb.header.MerkleRoot ||
+		*stakeMerkles[len(stakeMerkles)-1] != pb.header.StakeRoot {
+
+		return nil, errMerkleRootMismatch
+	}
+
+	return bitumutil.NewBlock(&wire.MsgBlock{
+		Header:        pb.header,
+		Transactions:  pb.txns,
+		STransactions: pb.stxns,
+	}), nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/bitumutil"
+	"github.com/bitum-project/bitumd/blockchain"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+// testCmpctBlockTx returns a transaction which is distinguished by the passed
+// value.
+func testCmpctBlockTx(value int64) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, 0, []byte{0x51}))
+	tx.AddTxOut(wire.NewTxOut(value, []byte{0x51}))
+	return tx
+}
+
+// TestPartialBlock ensures blocks are reconstructed from compact blocks and
+// the transactions of the memory pool.
+func TestPartialBlock(t *testing.T) {
+	// Create a block with three regular and two stake transactions along
+	// with a compact block for it.
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{Height: 100},
+		Transactions: []*wire.MsgTx{testCmpctBlockTx(1),
+			testCmpctBlockTx(2), testCmpctBlockTx(3)},
+		STransactions: []*wire.MsgTx{testCmpctBlockTx(4),
+			testCmpctBlockTx(5)},
+	}
+	merkles := blockchain.BuildMsgTxMerkleTreeStore(block.Transactions)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	merkles = blockchain.BuildMsgTxMerkleTreeStore(block.STransactions)
+	block.Header.StakeRoot = *merkles[len(merkles)-1]
+	msg := wire.NewMsgCmpctBlockFromBlock(block, 1)
+	k0, k1 := msg.SipHashKeys()
+
+	// poolTxns returns the short IDs of the passed transactions.
+	poolTxns := func(txns ...*wire.MsgTx) map[uint64]*bitumutil.Tx {
+		m := make(map[uint64]*bitumutil.Tx)
+		for _, tx := range txns {
+			hash := tx.TxHashFull()
+			m[wire.ShortTxID(k0, k1, &hash)] = bitumutil.NewTx(tx)
+		}
+		return m
+	}
+
+	// Ensure the missing transactions are reported when only some of the
+	// transactions are in the pool.
+	pb, err := newPartialBlock(msg, poolTxns(block.Transactions[2],
+		block.STransactions[1]))
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	indexes, stakeIndexes := pb.missing()
+	if !reflect.DeepEqual(indexes, []uint32{1}) ||
+		!reflect.DeepEqual(stakeIndexes, []uint32{0}) {
+		t.Fatalf("missing: got %v and %v, want [1] and [0]", indexes,
+			stakeIndexes)
+	}
+	if _, err := pb.block(); err == nil {
+		t.Fatal("block: did not fail with missing transactions")
+	}
+
+	// Ensure filling in the wrong number of transactions fails.
+	blockTxn := wire.NewMsgBlockTxn(&zeroHash)
+	blockTxn.AddTransaction(block.Transactions[1])
+	if err := pb.fill(blockTxn); err == nil {
+		t.Fatal("fill: did not fail with missing stake transaction")
+	}
+
+	// Ensure the block is reconstructed once the missing transactions are
+	// filled in.
+	blockTxn.AddSTransaction(block.STransactions[0])
+	if err := pb.fill(blockTxn); err != nil {
+		t.Fatalf("fill: unexpected error: %v", err)
+	}
+	reconstructed, err := pb.block()
+	if err != nil {
+		t.Fatalf("block: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(reconstructed.MsgBlock(), block) {
+		t.Fatalf("block: reconstructed block does not match")
+	}
+
+	// Ensure a transaction of the pool with the short ID of a transaction
+	// of the block results in a merkle root mismatch.
+	wrongTxns := poolTxns(block.Transactions[1:]...)
+	hash := block.Transactions[1].TxHashFull()
+	wrongTxns[wire.ShortTxID(k0, k1, &hash)] = bitumutil.NewTx(
+		testCmpctBlockTx(6))
+	for shortID, tx := range poolTxns(block.STransactions...) {
+		wrongTxns[shortID] = tx
+	}
+	pb, err = newPartialBlock(msg, wrongTxns)
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	if _, err := pb.block(); err != errMerkleRootMismatch {
+		t.Fatalf("block: unexpected error - got %v, want %v", err,
+			errMerkleRootMismatch)
+	}
+
+	// Ensure short IDs which are not unique are rejected.
+	msg.StakeShortIDs[1] = msg.StakeShortIDs[0]
+	if _, err := newPartialBlock(msg, nil); err != errShortIDCollision {
+		t.Fatalf("newPartialBlock: unexpected error - got %v, want %v",
+			err, errShortIDCollision)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/btcsuite/go-socks/socks"
 	"github.com/decred/slog"
 	flags "github.com/jessevdk/go-flags"
+	"github.com/bitum-project/bitumd/addrmgr"
 	"github.com/bitum-project/bitumd/connmgr"
 	"github.com/bitum-project/bitumd/database"
 	_ "github.com/bitum-project/bitumd/database/ffldb"
@@ -31,6 +32,7 @@ import (
 	"github.com/bitum-project/bitumd/mempool"
 	"github.com/bitum-project/bitumd/sampleconfig"
 	"github.com/bitum-project/bitumd/bitumutil"
+	"github.com/bitum-project/bitumd/updater"
 )
 
 const (
@@ -41,6 +43,7 @@ const (
 	defaultLogFilename           = "bitumd.log"
 	defaultMaxSameIP             = 25
 	defaultMaxPeers              = 125
+	defaultBlockRelayOnlyConns   = 2
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
 	defaultMaxRPCClients         = 10
@@ -103,10 +106,15 @@ type config struct {
 	Listeners            []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 9208, testnet: 19208)"`
 	MaxSameIP            int           `long:"maxsameip" description:"Max number of connections with the same IP -- 0 to disable"`
 	MaxPeers             int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	MaxUploadTarget      uint64        `long:"maxuploadtarget" description:"Max number of MiB to upload to peers within a rolling 24 hour window -- historical blocks are no longer served to peers without the download permission once it is reached, or a single peer received an eighth of it -- 0 for no limit"`
+	BlockRelayOnlyConns  int           `long:"blockrelayonlyconns" description:"Number of outbound block-relay-only connections which relay no transactions or addresses in addition to the other outbound connections -- they are reconnected first at startup"`
+	ASMap                string        `long:"asmap" description:"File which maps IP address prefixes to autonomous system numbers (ASNs) in the asmap format -- peers are grouped by ASN instead of by network prefix for diversifying outbound connections when set"`
+	DiverseOutbound      bool          `long:"diverseoutbound" description:"Prefer outbound peers running different code versions (Codechain tree hashes) than the existing outbound peers"`
+	V2Transport          bool          `long:"v2transport" description:"Support the encrypted and authenticated v2 P2P transport and use it for outbound connections to peers which advertise it"`
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	BanDuration          time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
 	BanThreshold         uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
-	Whitelists           []string      `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
+	Whitelists           []string      `long:"whitelist" description:"Grant permissions to peers within an IP network or IP.  Optionally prefixed by comma separated permissions and @ (noban, relay, mempool, noconnlimit, download, all -- default: noban,noconnlimit) (eg. 192.168.1.0/24, ::1, or noban,mempool@10.0.0.0/8)"`
 	RPCUser              string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
 	RPCPass              string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCLimitUser         string        `long:"rpclimituser" description:"Username for limited RPC connections"`
@@ -166,6 +174,10 @@ type config struct {
 	DropExistsAddrIndex  bool          `long:"dropexistsaddrindex" description:"Deletes the exists address index from the database on start up and then exits."`
 	NoCFilters           bool          `long:"nocfilters" description:"Disable compact filtering (CF) support"`
 	DropCFIndex          bool          `long:"dropcfindex" description:"Deletes the index used for compact filtering (CF) support from the database on start up and then exits."`
+	NoUpdater            bool          `long:"noupdater" description:"Disable the Codechain updater which handles hashchain entries and patch files announced by peers"`
+	AutoUpdate           bool          `long:"autoupdate" description:"Automatically update the source tree in the data directory to the last signed tree hash of the Codechain hashchain"`
+	AutoUpdateProb       float64       `long:"autoupdateprobability" description:"Probability that the source tree is updated automatically on a given day"`
+	AutoUpdateWindow     uint32        `long:"autoupdatewindow" description:"Number of signed tree hashes the source tree may fall behind before it is updated automatically regardless of the probability"`
 	PipeRx               uint          `long:"piperx" description:"File descriptor of read end pipe to enable parent -> child process communication"`
 	PipeTx               uint          `long:"pipetx" description:"File descriptor of write end pipe to enable parent <- child process communication"`
 	LifetimeEvents       bool          `long:"lifetimeevents" description:"Send lifetime notifications over the TX pipe"`
@@ -176,7 +188,8 @@ type config struct {
 	dial                 func(string, string) (net.Conn, error)
 	miningAddrs          []bitumutil.Address
 	minRelayTxFee        bitumutil.Amount
-	whitelists           []*net.IPNet
+	whitelists           []*whitelist
+	asmap                *addrmgr.ASMap
 }
 
 // serviceOptions defines the configuration options for the daemon as a service on
@@ -438,6 +451,7 @@ func loadConfig() (*config, []string, error) {
 		DebugLevel:           defaultLogLevel,
 		MaxSameIP:            defaultMaxSameIP,
 		MaxPeers:             defaultMaxPeers,
+		BlockRelayOnlyConns:  defaultBlockRelayOnlyConns,
 		BanDuration:          defaultBanDuration,
 		BanThreshold:         defaultBanThreshold,
 		RPCMaxClients:        defaultMaxRPCClients,
@@ -463,6 +477,8 @@ func loadConfig() (*config, []string, error) {
 		NoExistsAddrIndex:    defaultNoExistsAddrIndex,
 		NoCFilters:           defaultNoCFilters,
 		AltDNSNames:          defaultAltDNSNames,
+		AutoUpdateProb:       updater.DefaultAutoUpdateProbability,
+		AutoUpdateWindow:     updater.DefaultAutoUpdateWindow,
 	}
 
 	// Service options which are only added on Windows.
@@ -738,36 +754,35 @@ func loadConfig() (*config, []string, error) {
 
 	// Validate any given whitelisted IP addresses and networks.
 	if len(cfg.Whitelists) > 0 {
-		var ip net.IP
-		cfg.whitelists = make([]*net.IPNet, 0, len(cfg.Whitelists))
+		cfg.whitelists = make([]*whitelist, 0, len(cfg.Whitelists))
 
 		for _, addr := range cfg.Whitelists {
-			_, ipnet, err := net.ParseCIDR(addr)
+			wl, err := parseWhitelist(addr)
 			if err != nil {
-				ip = net.ParseIP(addr)
-				if ip == nil {
-					str := "%s: the whitelist value of '%s' is invalid"
-					err = fmt.Errorf(str, funcName, addr)
-					fmt.Fprintln(os.Stderr, err)
-					fmt.Fprintln(os.Stderr, usageMessage)
-					return nil, nil, err
-				}
-				var bits int
-				if ip.To4() == nil {
-					// IPv6
-					bits = 128
-				} else {
-					bits = 32
-				}
-				ipnet = &net.IPNet{
-					IP:   ip,
-					Mask: net.CIDRMask(bits, bits),
-				}
+				str := "%s: the whitelist value of '%s' is invalid: %v"
+				err = fmt.Errorf(str, funcName, addr, err)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
 			}
-			cfg.whitelists = append(cfg.whitelists, ipnet)
+			cfg.whitelists = append(cfg.whitelists, wl)
 		}
 	}
 
+	// Load the asmap when one is given.
+	if cfg.ASMap != "" {
+		cfg.ASMap = cleanAndExpandPath(cfg.ASMap)
+		asmap, err := addrmgr.LoadASMap(cfg.ASMap)
+		if err != nil {
+			str := "%s: unable to load the asmap %s: %v"
+			err := fmt.Errorf(str, funcName, cfg.ASMap, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.asmap = asmap
+	}
+
 	// --addPeer and --connect do not mix.
 	if len(cfg.AddPeers) > 0 && len(cfg.ConnectPeers) > 0 {
 		str := "%s: the --addpeer and --connect options can not be " +
@@ -881,6 +896,16 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Don't allow a negative number of block-relay-only connections.
+	if cfg.BlockRelayOnlyConns < 0 {
+		str := "%s: the blockrelayonlyconns option may not be less " +
+			"than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.BlockRelayOnlyConns)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the block priority and minimum block sizes to max block size.
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
 	cfg.BlockMinSize = minUint32(cfg.BlockMinSize, cfg.BlockMaxSize)
@@ -934,6 +959,43 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// --autoupdate and --noupdater do not mix.
+	if cfg.AutoUpdate && cfg.NoUpdater {
+		err := fmt.Errorf("%s: the --autoupdate and --noupdater "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The automatic update probability must be a probability.
+	if cfg.AutoUpdateProb < 0 || cfg.AutoUpdateProb > 1 {
+		str := "%s: the autoupdateprobability option must be between " +
+			"0 and 1 -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.AutoUpdateProb)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The automatic update window must be smaller than the window of
+	// Codechain heads accepted in block headers, so the source tree is
+	// updated before blocks committing to its tree hash become invalid.
+	// It is only used when automatic updates are enabled.
+	maxWindow := int64(activeNetParams.CodechainVersionWindow) - 1
+	if cfg.AutoUpdate && (cfg.AutoUpdateWindow < 1 ||
+		int64(cfg.AutoUpdateWindow) > maxWindow) {
+
+		str := "%s: the autoupdatewindow option must be between 1 and " +
+			"%d on the %s network -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, maxWindow, activeNetParams.Name,
+			cfg.AutoUpdateWindow)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Check getwork keys are valid and saved parsed versions.
 	cfg.miningAddrs = make([]bitumutil.Address, 0, len(cfg.GetWorkKeys)+
 		len(cfg.MiningAddrs))
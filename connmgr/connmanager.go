@@ -53,16 +53,19 @@ const (
 )
 
 // ConnReq is the connection request to a network address. If permanent, the
-// connection will be retried on disconnection.
+// connection will be retried on disconnection.  If block-relay-only, the
+// connection is only intended to relay blocks, and a failed or disconnected
+// non-permanent connection is replaced by another block-relay-only connection.
 type ConnReq struct {
 	// The following variables must only be used atomically.
 	id    uint64
 	state uint32
 
-	retryCount uint32
-	conn       net.Conn
-	Addr       net.Addr
-	Permanent  bool
+	retryCount     uint32
+	conn           net.Conn
+	Addr           net.Addr
+	Permanent      bool
+	BlockRelayOnly bool
 }
 
 // updateState updates the state of the connection request.
@@ -117,6 +120,15 @@ type Config struct {
 	// maintain. Defaults to 8.
 	TargetOutbound uint32
 
+	// TargetBlockRelayOnly is the number of block-relay-only outbound
+	// network connections to maintain in addition to TargetOutbound.
+	TargetBlockRelayOnly uint32
+
+	// Anchors are the addresses the block-relay-only connections are
+	// made to first when the connection manager is started.  Any
+	// remaining block-relay-only connections are made to new addresses.
+	Anchors []net.Addr
+
 	// RetryDuration is the duration to wait before retrying connection
 	// requests. Defaults to 5s.
 	RetryDuration time.Duration
@@ -195,10 +207,10 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 				"-- retrying connection in: %v", maxFailedAttempts,
 				cm.cfg.RetryDuration)
 			time.AfterFunc(cm.cfg.RetryDuration, func() {
-				cm.NewConnReq()
+				cm.newConnReq(c.BlockRelayOnly)
 			})
 		} else {
-			go cm.NewConnReq()
+			go cm.newConnReq(c.BlockRelayOnly)
 		}
 	}
 }
@@ -243,7 +255,9 @@ out:
 						go cm.cfg.OnDisconnection(connReq)
 					}
 
-					if uint32(len(conns)) < cm.cfg.TargetOutbound && msg.retry {
+					target := cm.cfg.TargetOutbound +
+						cm.cfg.TargetBlockRelayOnly
+					if uint32(len(conns)) < target && msg.retry {
 						cm.handleFailedConn(connReq)
 					}
 				} else {
@@ -269,6 +283,12 @@ out:
 // NewConnReq creates a new connection request and connects to the
 // corresponding address.
 func (cm *ConnManager) NewConnReq() {
+	cm.newConnReq(false)
+}
+
+// newConnReq creates a new connection request which is block-relay-only as
+// specified and connects to the corresponding address.
+func (cm *ConnManager) newConnReq(blockRelayOnly bool) {
 	if atomic.LoadInt32(&cm.stop) != 0 {
 		return
 	}
@@ -276,7 +296,7 @@ func (cm *ConnManager) NewConnReq() {
 		return
 	}
 
-	c := &ConnReq{}
+	c := &ConnReq{BlockRelayOnly: blockRelayOnly}
 	atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
 
 	addr, err := cm.cfg.GetNewAddress()
@@ -367,7 +387,21 @@ func (cm *ConnManager) Start() {
 		}
 	}
 
-	for i := atomic.LoadUint64(&cm.connReqCount); i < uint64(cm.cfg.TargetOutbound); i++ {
+	// Connect to the anchors first and make the remaining block-relay-only
+	// connections to new addresses.  The connection requests made before
+	// starting count toward the target outbound connections.
+	numConnReqs := atomic.LoadUint64(&cm.connReqCount)
+	for i := uint32(0); i < cm.cfg.TargetBlockRelayOnly; i++ {
+		if int(i) < len(cm.cfg.Anchors) {
+			go cm.Connect(&ConnReq{
+				Addr:           cm.cfg.Anchors[i],
+				BlockRelayOnly: true,
+			})
+			continue
+		}
+		go cm.newConnReq(true)
+	}
+	for i := numConnReqs; i < uint64(cm.cfg.TargetOutbound); i++ {
 		go cm.NewConnReq()
 	}
 }
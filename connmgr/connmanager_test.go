@@ -215,6 +215,76 @@ func TestTargetOutbound(t *testing.T) {
 	cmgr.Stop()
 }
 
+// TestBlockRelayOnly tests the target number of block-relay-only connections
+// in addition to the target outbound connections.
+//
+// We wait until all connections are established, ensure the block-relay-only
+// connections include the anchor, then disconnect a block-relay-only
+// connection and wait for it to be replaced by another one.
+func TestBlockRelayOnly(t *testing.T) {
+	anchor := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 18555}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound:       2,
+		TargetBlockRelayOnly: 2,
+		Anchors:              []net.Addr{anchor},
+		Dial:                 mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+
+	var blockRelayOnly []*ConnReq
+	var anchorConnected bool
+	for i := 0; i < 4; i++ {
+		c := <-connected
+		if !c.BlockRelayOnly {
+			continue
+		}
+		blockRelayOnly = append(blockRelayOnly, c)
+		if c.Addr.String() == anchor.String() {
+			anchorConnected = true
+		}
+	}
+	if len(blockRelayOnly) != 2 {
+		t.Fatalf("block-relay-only: got %d connections, want 2",
+			len(blockRelayOnly))
+	}
+	if !anchorConnected {
+		t.Fatalf("block-relay-only: anchor %v is not connected", anchor)
+	}
+
+	select {
+	case c := <-connected:
+		t.Fatalf("block-relay-only: got unexpected connection - %v",
+			c.Addr)
+	case <-time.After(time.Millisecond):
+		break
+	}
+
+	cmgr.Disconnect(blockRelayOnly[0].ID())
+	select {
+	case c := <-connected:
+		if !c.BlockRelayOnly {
+			t.Fatalf("block-relay-only: connection %v replaced by "+
+				"a full relay connection", blockRelayOnly[0].Addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("block-relay-only: connection was not replaced")
+	}
+	cmgr.Stop()
+}
+
 // TestRetryPermanent tests that permanent connection requests are retried.
 //
 // We make a permanent connection request using Connect, disconnect it using
@@ -38,13 +38,36 @@ Application Options:
       --maxsameip=          Max number of connections with the same IP -- 0 to
                             disable (default: 5)
       --maxpeers=           Max number of inbound and outbound peers (125)
+      --maxuploadtarget=    Max number of MiB to upload to peers within a
+                            rolling 24 hour window -- historical blocks are no
+                            longer served to peers without the download
+                            permission once it is reached, or a single peer
+                            received an eighth of it -- 0 for no limit
+      --blockrelayonlyconns= Number of outbound block-relay-only connections
+                            which relay no transactions or addresses in
+                            addition to the other outbound connections -- they
+                            are reconnected first at startup (2)
+      --asmap=              File which maps IP address prefixes to autonomous
+                            system numbers (ASNs) in the asmap format -- peers
+                            are grouped by ASN instead of by network prefix
+                            for diversifying outbound connections when set
+      --diverseoutbound     Prefer outbound peers running different code
+                            versions (Codechain tree hashes) than the existing
+                            outbound peers
+      --v2transport         Support the encrypted and authenticated v2 P2P
+                            transport and use it for outbound connections to
+                            peers which advertise it
       --nobanning           Disable banning of misbehaving peers
       --banduration=        How long to ban misbehaving peers.  Valid time units
                             are {s, m, h}.  Minimum 1 second (24h0m0s)
       --banthreshold=       Maximum allowed ban score before disconnecting and
                             banning misbehaving peers.
-      --whitelist=          Add an IP network or IP that will not be banned.
-                            (eg. 192.168.1.0/24 or ::1)
+      --whitelist=          Grant permissions to peers within an IP network or
+                            IP.  Optionally prefixed by comma separated
+                            permissions and @ (noban, relay, mempool,
+                            noconnlimit, download, all -- default:
+                            noban,noconnlimit) (eg. 192.168.1.0/24, ::1, or
+                            noban,mempool@10.0.0.0/8)
   -u, --rpcuser=            Username for RPC connections
   -P, --rpcpass=            Password for RPC connections
       --rpclimituser=       Username for limited RPC connections
@@ -128,6 +151,18 @@ Application Options:
                             for the active network.
       --rejectnonstd        Reject non-standard transactions regardless of the
                             default settings for the active network.
+      --noupdater           Disable the Codechain updater which handles
+                            hashchain entries and patch files announced by
+                            peers
+      --autoupdate          Automatically update the source tree in the data
+                            directory to the last signed tree hash of the
+                            Codechain hashchain
+      --autoupdateprobability=
+                            Probability that the source tree is updated
+                            automatically on a given day (default: 0.1)
+      --autoupdatewindow=   Number of signed tree hashes the source tree may
+                            fall behind before it is updated automatically
+                            regardless of the probability (default: 3)
       --altdnsnames:        Specify additional dns names to use when
                             generating the rpc server certificate
                             [supports BITUMD_ALT_DNSNAMES environment variable]
@@ -0,0 +1,158 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+const (
+	// evictionProtectNetGroup is the number of inbound peers with distinct
+	// network groups which are protected from eviction.  The groups are
+	// selected by a keyed hash so they can't be predicted by an attacker.
+	evictionProtectNetGroup = 4
+
+	// evictionProtectPing is the number of inbound peers with the lowest
+	// ping times which are protected from eviction.
+	evictionProtectPing = 8
+
+	// evictionProtectTx is the number of inbound peers which most recently
+	// relayed transactions accepted to the memory pool that are protected
+	// from eviction.
+	evictionProtectTx = 4
+
+	// evictionProtectBlock is the number of inbound peers which most
+	// recently relayed blocks added to the main chain that are protected
+	// from eviction.
+	evictionProtectBlock = 4
+)
+
+// evictionCandidate describes an inbound peer which may be evicted to make room
+// for a new inbound peer.
+type evictionCandidate struct {
+	sp             *serverPeer
+	netGroup       uint64 // Keyed hash of the network group of the peer.
+	connTime       time.Time
+	pingMicros     int64 // Zero when no ping was answered yet.
+	lastBlockRelay time.Time
+	lastTxRelay    time.Time
+}
+
+// keyedNetGroup returns a hash of the passed network group keyed with the
+// passed key.
+func keyedNetGroup(key []byte, group string) uint64 {
+	b := make([]byte, 0, len(key)+len(group))
+	b = append(b, key...)
+	b = append(b, group...)
+	return binary.LittleEndian.Uint64(chainhash.HashB(b))
+}
+
+// newEvictionCandidate returns the eviction candidate for the passed inbound
+// peer with its network group keyed by the passed key.
+func newEvictionCandidate(sp *serverPeer, key []byte) *evictionCandidate {
+	stats := sp.StatsSnapshot()
+	lastBlockRelay, lastTxRelay := sp.relayTimes()
+	group := sp.server.addrManager.GroupKey(sp.netAddress())
+	return &evictionCandidate{
+		sp:             sp,
+		netGroup:       keyedNetGroup(key, group),
+		connTime:       stats.ConnTime,
+		pingMicros:     stats.LastPingMicros,
+		lastBlockRelay: lastBlockRelay,
+		lastTxRelay:    lastTxRelay,
+	}
+}
+
+// protectCandidates sorts the candidates so the ones to protect are first
+// according to the passed less function and removes up to n of them.  Ties are
+// broken in favor of the longest connected candidates.
+func protectCandidates(candidates []*evictionCandidate, n int,
+	less func(a, b *evictionCandidate) bool) []*evictionCandidate {
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.connTime.Before(b.connTime)
+	})
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	return candidates[n:]
+}
+
+// selectEvictionCandidate returns the inbound peer to evict among the passed
+// candidates, or nil when all of them are protected.
+//
+// Peers are protected from eviction by several characteristics which are
+// difficult for an attacker to obtain for many peers at once: their network
+// group, low ping times, recently relaying novel transactions and blocks, and
+// being connected the longest.  The youngest peer in the network group with the
+// most remaining candidates is evicted, so an attacker who holds many inbound
+// slots from a few network groups is evicted first.
+func selectEvictionCandidate(candidates []*evictionCandidate) *evictionCandidate {
+	remaining := make([]*evictionCandidate, len(candidates))
+	copy(remaining, candidates)
+
+	remaining = protectCandidates(remaining, evictionProtectNetGroup,
+		func(a, b *evictionCandidate) bool {
+			return a.netGroup > b.netGroup
+		})
+	remaining = protectCandidates(remaining, evictionProtectPing,
+		func(a, b *evictionCandidate) bool {
+			if a.pingMicros == 0 || b.pingMicros == 0 {
+				return b.pingMicros == 0 && a.pingMicros != 0
+			}
+			return a.pingMicros < b.pingMicros
+		})
+	remaining = protectCandidates(remaining, evictionProtectTx,
+		func(a, b *evictionCandidate) bool {
+			return a.lastTxRelay.After(b.lastTxRelay)
+		})
+	remaining = protectCandidates(remaining, evictionProtectBlock,
+		func(a, b *evictionCandidate) bool {
+			return a.lastBlockRelay.After(b.lastBlockRelay)
+		})
+	remaining = protectCandidates(remaining, len(remaining)/2,
+		func(a, b *evictionCandidate) bool {
+			return false
+		})
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	// Find the network group with the most candidates, preferring the group
+	// with the youngest candidate on ties, and evict its youngest candidate.
+	groups := make(map[uint64][]*evictionCandidate)
+	for _, c := range remaining {
+		groups[c.netGroup] = append(groups[c.netGroup], c)
+	}
+	var victim *evictionCandidate
+	var victimGroupSize int
+	for _, group := range groups {
+		youngest := group[0]
+		for _, c := range group[1:] {
+			if c.connTime.After(youngest.connTime) {
+				youngest = c
+			}
+		}
+		if victim == nil || len(group) > victimGroupSize ||
+			(len(group) == victimGroupSize &&
+				youngest.connTime.After(victim.connTime)) {
+
+			victim = youngest
+			victimGroupSize = len(group)
+		}
+	}
+	return victim
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSelectEvictionCandidate ensures the eviction policy protects peers by
+// their characteristics and evicts the youngest peer of the network group with
+// the most candidates.
+func TestSelectEvictionCandidate(t *testing.T) {
+	base := time.Unix(1560000000, 0)
+
+	// newCandidates returns 10 honest peers in distinct network groups
+	// followed by 30 younger peers of an attacker in a single network group
+	// which did not answer pings.
+	newCandidates := func() []*evictionCandidate {
+		var candidates []*evictionCandidate
+		for i := 0; i < 10; i++ {
+			candidates = append(candidates, &evictionCandidate{
+				netGroup:   uint64(i + 1),
+				connTime:   base.Add(time.Duration(i) * time.Minute),
+				pingMicros: int64(100 + i),
+			})
+		}
+		for i := 0; i < 30; i++ {
+			candidates = append(candidates, &evictionCandidate{
+				netGroup: 99,
+				connTime: base.Add(time.Hour +
+					time.Duration(i)*time.Minute),
+			})
+		}
+		return candidates
+	}
+
+	tests := []struct {
+		name   string
+		modify func([]*evictionCandidate)
+		want   int // Index of the evicted candidate, -1 for none.
+	}{{
+		name:   "youngest attacker peer",
+		modify: func([]*evictionCandidate) {},
+		want:   39,
+	}, {
+		name: "recent novel block protects",
+		modify: func(c []*evictionCandidate) {
+			c[39].lastBlockRelay = base.Add(2 * time.Hour)
+		},
+		want: 38,
+	}, {
+		name: "recent novel transactions protect",
+		modify: func(c []*evictionCandidate) {
+			c[39].lastTxRelay = base.Add(2 * time.Hour)
+			c[38].lastTxRelay = base.Add(2 * time.Hour)
+		},
+		want: 37,
+	}, {
+		name: "low ping protects",
+		modify: func(c []*evictionCandidate) {
+			c[39].pingMicros = 1
+		},
+		want: 38,
+	}, {
+		name: "largest network group is evicted from",
+		modify: func(c []*evictionCandidate) {
+			// Move the youngest attacker peers to a smaller
+			// network group.
+			c[37].netGroup = 98
+			c[39].netGroup = 98
+		},
+		want: 38,
+	}}
+
+	for _, test := range tests {
+		candidates := newCandidates()
+		test.modify(candidates)
+		victim := selectEvictionCandidate(candidates)
+		if victim != candidates[test.want] {
+			got := -1
+			for i, c := range candidates {
+				if c == victim {
+					got = i
+				}
+			}
+			t.Errorf("%s: evicted candidate %d, want %d", test.name,
+				got, test.want)
+		}
+	}
+
+	// Ensure no peer is evicted when all of them are protected.
+	candidates := newCandidates()[:12]
+	if victim := selectEvictionCandidate(candidates); victim != nil {
+		t.Errorf("evicted candidate connected at %v while all are "+
+			"protected", victim.connTime)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/bitum-project/bitumd/mempool"
 	"github.com/bitum-project/bitumd/peer"
 	"github.com/bitum-project/bitumd/txscript"
+	"github.com/bitum-project/bitumd/updater"
 	"github.com/decred/slog"
 	"github.com/jrick/logrotate/rotator"
 )
@@ -71,6 +72,7 @@ var (
 	srvrLog = backendLog.Logger("SRVR")
 	stkeLog = backendLog.Logger("STKE")
 	txmpLog = backendLog.Logger("TXMP")
+	updtLog = backendLog.Logger("UPDT")
 )
 
 // Initialize package-global logger variables.
@@ -85,6 +87,7 @@ func init() {
 	peer.UseLogger(peerLog)
 	stake.UseLogger(stkeLog)
 	txscript.UseLogger(scrpLog)
+	updater.UseLogger(updtLog)
 }
 
 // subsystemLoggers maps each subsystem identifier to its associated logger.
@@ -106,6 +109,7 @@ var subsystemLoggers = map[string]slog.Logger{
 	"SRVR": srvrLog,
 	"STKE": stkeLog,
 	"TXMP": txmpLog,
+	"UPDT": updtLog,
 }
 
 // initLogRotator initializes the logging rotater to write logs to logFile and
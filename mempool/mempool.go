@@ -64,6 +64,11 @@ const (
 	// maxNullDataOutputs is the maximum number of OP_RETURN null data
 	// pushes in a transaction, after which it is considered non-standard.
 	maxNullDataOutputs = 4
+
+	// maxShortIDCacheKeys is the maximum number of SipHash keys of compact
+	// blocks the short transaction IDs of the transactions in the pool are
+	// cached for.
+	maxShortIDCacheKeys = 8
 )
 
 // Config is a descriptor containing the memory pool configuration.
@@ -223,6 +228,12 @@ type TxPool struct {
 	votesMtx sync.RWMutex
 	votes    map[chainhash.Hash][]mining.VoteDesc
 
+	// shortIDs caches the transactions in the pool keyed by their short
+	// transaction IDs for the SipHash keys of recent compact blocks.  It is
+	// cleared whenever the pool changes.  It is protected by shortIDMtx.
+	shortIDMtx sync.Mutex
+	shortIDs   map[[2]uint64]map[uint64]*bitumutil.Tx
+
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
 }
@@ -618,6 +629,7 @@ func (mp *TxPool) removeTransaction(tx *bitumutil.Tx, removeRedeemers bool) {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		mp.clearShortIDs()
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 		// Inform associated fee estimator that the transaction has been removed
@@ -685,6 +697,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	for _, txIn := range msgTx.TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.clearShortIDs()
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -1552,6 +1565,57 @@ func (mp *TxPool) TxHashes() []*chainhash.Hash {
 	return hashes
 }
 
+// ShortIDTxns returns the transactions in the pool, including stake
+// transactions and votes, keyed by their short transaction IDs under the given
+// SipHash keys of a compact block.  See wire.ShortTxID for details.
+//
+// Short IDs which are shared by more than one transaction in the pool map to
+// nil since the transaction they refer to is ambiguous.
+//
+// The result is cached for the keys until the pool changes, so it must be
+// treated as read only.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ShortIDTxns(k0, k1 uint64) map[uint64]*bitumutil.Tx {
+	mp.mtx.RLock()
+	mp.shortIDMtx.Lock()
+	key := [2]uint64{k0, k1}
+	if txns, ok := mp.shortIDs[key]; ok {
+		mp.shortIDMtx.Unlock()
+		mp.mtx.RUnlock()
+		return txns
+	}
+
+	txns := make(map[uint64]*bitumutil.Tx, len(mp.pool))
+	for _, desc := range mp.pool {
+		fullHash := desc.Tx.MsgTx().TxHashFull()
+		shortID := wire.ShortTxID(k0, k1, &fullHash)
+		if _, ok := txns[shortID]; ok {
+			txns[shortID] = nil
+			continue
+		}
+		txns[shortID] = desc.Tx
+	}
+	if mp.shortIDs == nil || len(mp.shortIDs) >= maxShortIDCacheKeys {
+		mp.shortIDs = make(map[[2]uint64]map[uint64]*bitumutil.Tx)
+	}
+	mp.shortIDs[key] = txns
+	mp.shortIDMtx.Unlock()
+	mp.mtx.RUnlock()
+
+	return txns
+}
+
+// clearShortIDs clears the cached short transaction IDs of the transactions in
+// the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) clearShortIDs() {
+	mp.shortIDMtx.Lock()
+	mp.shortIDs = nil
+	mp.shortIDMtx.Unlock()
+}
+
 // TxDescs returns a slice of descriptors for all the transactions in the pool.
 // The descriptors are to be treated as read only.
 //
@@ -990,6 +990,67 @@ func TestVoteOrphan(t *testing.T) {
 	testPoolMembership(tc, vote, false, true)
 }
 
+// TestShortIDTxns ensures the transactions in the pool, including stake
+// transactions and votes, are returned by their short transaction IDs.
+func TestShortIDTxns(t *testing.T) {
+	t.Parallel()
+
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	// Create a regular transaction, a ticket purchase spending it, and a
+	// vote of the ticket and add them to the pool.
+	tx, err := harness.CreateTx(spendableOuts[0])
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	ticket, err := harness.CreateTicketPurchase(tx, 40000)
+	if err != nil {
+		t.Fatalf("unable to create ticket purchase transaction: %v", err)
+	}
+	harness.chain.SetHeight(harness.chainParams.StakeValidationHeight)
+	vote, err := harness.CreateVote(ticket)
+	if err != nil {
+		t.Fatalf("unable to create vote: %v", err)
+	}
+	for _, poolTx := range []*bitumutil.Tx{tx, ticket} {
+		_, err = harness.txPool.ProcessTransaction(poolTx, false, false,
+			true)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"transaction %v", err)
+		}
+	}
+
+	// Look up the short IDs before the vote is added to ensure the short
+	// IDs cached for the keys are not returned once the pool changed.
+	const k0, k1 = 0x0706050403020100, 0x0f0e0d0c0b0a0908
+	if txns := harness.txPool.ShortIDTxns(k0, k1); len(txns) != 2 {
+		t.Fatalf("ShortIDTxns: got %d transactions, want 2", len(txns))
+	}
+	harness.AddFakeUTXO(ticket, int64(ticket.MsgTx().TxIn[0].BlockHeight))
+	_, err = harness.txPool.ProcessTransaction(vote, false, false, true)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid vote %v", err)
+	}
+
+	// Ensure all transactions are returned by their short IDs.
+	txns := harness.txPool.ShortIDTxns(k0, k1)
+	if len(txns) != 3 {
+		t.Fatalf("ShortIDTxns: got %d transactions, want 3", len(txns))
+	}
+	for _, poolTx := range []*bitumutil.Tx{tx, ticket, vote} {
+		fullHash := poolTx.MsgTx().TxHashFull()
+		shortID := wire.ShortTxID(k0, k1, &fullHash)
+		if txns[shortID] != poolTx {
+			t.Fatalf("ShortIDTxns: transaction %v not found by short "+
+				"ID %x", poolTx.Hash(), shortID)
+		}
+	}
+}
+
 // TestRevocationOrphan ensures that revocations are orphaned when
 // referenced outputs spent are from missing transactions.
 func TestRevocationOrphan(t *testing.T) {
@@ -877,6 +877,14 @@ func handleTooFewVoters(subsidyCache *blockchain.SubsidyCache, nextHeight int64,
 				// Copy the rest of the header.
 				btMsgBlock.Header = topBlock.MsgBlock().Header
 
+				// Publish the Codechain source tree hash of this
+				// node rather than the one of the original miner
+				// when blocks may commit to it.
+				btMsgBlock.Header.CodechainHead = templateCodechainHead(
+					bm.server.CodechainHead(),
+					btMsgBlock.Header.CodechainHead,
+					bm.server.chainParams)
+
 				// Set a fresh timestamp.
 				ts := medianAdjustedTime(best, timeSource)
 				btMsgBlock.Header.Timestamp = ts
@@ -997,6 +1005,10 @@ type BlkTmplGenerator struct {
 	chain        *blockchain.BlockChain
 	blockManager *blockManager
 	timeSource   blockchain.MedianTimeSource
+
+	// codechainHead returns the Codechain source tree hash to publish in
+	// the headers of new block templates.
+	codechainHead func() [32]byte
 }
 
 // newBlkTmplGenerator returns a new block template generator for the given
@@ -1004,18 +1016,42 @@ type BlkTmplGenerator struct {
 func newBlkTmplGenerator(policy *mining.Policy, txSource mining.TxSource,
 	timeSource blockchain.MedianTimeSource, sigCache *txscript.SigCache,
 	chainParams *chaincfg.Params, chain *blockchain.BlockChain,
-	blockManager *blockManager) *BlkTmplGenerator {
+	blockManager *blockManager,
+	codechainHead func() [32]byte) *BlkTmplGenerator {
 	return &BlkTmplGenerator{
-		policy:       policy,
-		txSource:     txSource,
-		sigCache:     sigCache,
-		chainParams:  chainParams,
-		chain:        chain,
-		blockManager: blockManager,
-		timeSource:   timeSource,
+		policy:        policy,
+		txSource:      txSource,
+		sigCache:      sigCache,
+		chainParams:   chainParams,
+		chain:         chain,
+		blockManager:  blockManager,
+		timeSource:    timeSource,
+		codechainHead: codechainHead,
 	}
 }
 
+// templateCodechainHead returns the Codechain head to commit to in the header of
+// a block template given the source tree hash of this node.  It is only used
+// when it is one of the heads blocks may commit to per the tree hashes pinned
+// by the network parameters, and the most recent one of those is used
+// otherwise.  The passed fallback head is used for networks which do not pin
+// any tree hashes when this node does not have a tree hash either.
+func templateCodechainHead(head, fallbackHead [32]byte, params *chaincfg.Params) [32]byte {
+	allowed := blockchain.AllowedCodechainHeads(params)
+	for _, allowedHead := range allowed {
+		if head == allowedHead {
+			return head
+		}
+	}
+	if len(allowed) > 0 {
+		return allowed[len(allowed)-1]
+	}
+	if head != [32]byte{} {
+		return head
+	}
+	return fallbackHead
+}
+
 // NewBlockTemplate returns a new block template that is ready to be solved
 // using the transactions from the passed transaction source pool and a coinbase
 // that either pays to the passed address if it is not nil, or a coinbase that
@@ -1904,27 +1940,37 @@ mempoolLoop:
 		return nil, err
 	}
 
+	// Publish the Codechain source tree hash of this node when blocks may
+	// commit to it.
+	prevHeader, err := g.chain.HeaderByHash(&prevHash)
+	if err != nil {
+		return nil, err
+	}
+	codechainHead := templateCodechainHead(g.codechainHead(),
+		prevHeader.CodechainHead, g.chainParams)
+
 	// Create a new block ready to be solved.
 	merkles := blockchain.BuildMerkleTreeStore(blockTxnsRegular)
 	merklesStake := blockchain.BuildMerkleTreeStore(blockTxnsStake)
 
 	var msgBlock wire.MsgBlock
 	msgBlock.Header = wire.BlockHeader{
-		Version:      blockVersion,
-		PrevBlock:    prevHash,
-		MerkleRoot:   *merkles[len(merkles)-1],
-		StakeRoot:    *merklesStake[len(merklesStake)-1],
-		VoteBits:     votebits,
-		FinalState:   best.NextFinalState,
-		Voters:       uint16(voters),
-		FreshStake:   uint8(freshStake),
-		Revocations:  uint8(revocations),
-		PoolSize:     best.NextPoolSize,
-		Timestamp:    ts,
-		SBits:        best.NextStakeDiff,
-		Bits:         reqDifficulty,
-		StakeVersion: generatedStakeVersion,
-		Height:       uint32(nextBlockHeight),
+		Version:       blockVersion,
+		PrevBlock:     prevHash,
+		MerkleRoot:    *merkles[len(merkles)-1],
+		StakeRoot:     *merklesStake[len(merklesStake)-1],
+		VoteBits:      votebits,
+		FinalState:    best.NextFinalState,
+		Voters:        uint16(voters),
+		FreshStake:    uint8(freshStake),
+		Revocations:   uint8(revocations),
+		PoolSize:      best.NextPoolSize,
+		Timestamp:     ts,
+		SBits:         best.NextStakeDiff,
+		Bits:          reqDifficulty,
+		StakeVersion:  generatedStakeVersion,
+		Height:        uint32(nextBlockHeight),
+		CodechainHead: codechainHead,
 		// Size declared below
 	}
 
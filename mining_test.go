@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/bitum-project/bitumd/blockchain/stake"
+	"github.com/bitum-project/bitumd/chaincfg"
 )
 
 // TestStakeTxFeePrioHeap tests the priority heaps including the stake types for
@@ -126,3 +127,65 @@ func TestStakeTxFeePrioHeap(t *testing.T) {
 		}
 	}
 }
+
+// TestTemplateCodechainHead ensures block templates only commit to the
+// Codechain head of the node when blocks may commit to it.
+func TestTemplateCodechainHead(t *testing.T) {
+	pinned := chaincfg.RegNetParams
+	pinned.CodechainTreeHashes = [][32]byte{{0x01}, {0x02}, {0x03}}
+	pinned.CodechainVersionWindow = 2
+	unpinned := chaincfg.RegNetParams
+	unpinned.CodechainTreeHashes = nil
+
+	tests := []struct {
+		name     string
+		head     [32]byte
+		fallback [32]byte
+		params   *chaincfg.Params
+		want     [32]byte
+	}{{
+		name:     "allowed head",
+		head:     [32]byte{0x02},
+		fallback: [32]byte{0x03},
+		params:   &pinned,
+		want:     [32]byte{0x02},
+	}, {
+		name:     "head outside of window",
+		head:     [32]byte{0x01},
+		fallback: [32]byte{0x02},
+		params:   &pinned,
+		want:     [32]byte{0x03},
+	}, {
+		name:     "unsigned head",
+		head:     [32]byte{0xff},
+		fallback: [32]byte{0x02},
+		params:   &pinned,
+		want:     [32]byte{0x03},
+	}, {
+		name:     "no head",
+		head:     [32]byte{},
+		fallback: [32]byte{0x02},
+		params:   &pinned,
+		want:     [32]byte{0x03},
+	}, {
+		name:     "no pinned tree hashes",
+		head:     [32]byte{0xff},
+		fallback: [32]byte{0x02},
+		params:   &unpinned,
+		want:     [32]byte{0xff},
+	}, {
+		name:     "no pinned tree hashes and no head",
+		head:     [32]byte{},
+		fallback: [32]byte{0x02},
+		params:   &unpinned,
+		want:     [32]byte{0x02},
+	}}
+
+	for _, test := range tests {
+		got := templateCodechainHead(test.head, test.fallback, test.params)
+		if got != test.want {
+			t.Errorf("%q: unexpected head -- got %x, want %x", test.name,
+				got, test.want)
+		}
+	}
+}
@@ -70,6 +70,17 @@ Disconnect to disconnect from the peer and clean up all resources.
 WaitForDisconnect can be used to block until peer disconnection and resource
 cleanup has completed.
 
+Transports
+
+Messages are exchanged in plaintext with the v1 transport by default.  When the
+V2Transport field of the Config struct is set, outbound peers initiate the
+encrypted and authenticated v2 transport, which performs an ephemeral
+secp256k1 key exchange and sends every message in a ChaCha20-Poly1305 sealed
+frame, while inbound peers accept either transport.  Outbound peers fail to
+connect when the remote peer does not support the v2 transport, so callers are
+expected to fall back to the v1 transport for later connections.  The Transport
+function returns the transport negotiated with the peer.
+
 Callbacks
 
 In order to do anything useful with a peer, it is necessary to react to bitum
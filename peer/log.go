@@ -160,6 +160,9 @@ func messageSummary(msg wire.Message) string {
 	case *wire.MsgAddr:
 		return fmt.Sprintf("%d addr", len(msg.AddrList))
 
+	case *wire.MsgAddrV2:
+		return fmt.Sprintf("%d addr", len(msg.AddrList))
+
 	case *wire.MsgPing:
 		// No summary - perhaps add nonce.
 
@@ -197,6 +200,40 @@ func messageSummary(msg wire.Message) string {
 	case *wire.MsgHeaders:
 		return fmt.Sprintf("num %d", len(msg.Headers))
 
+	case *wire.MsgGetCChain:
+		return fmt.Sprintf("start %x, stop %x", msg.HeadStart[:],
+			msg.HeadStop[:])
+
+	case *wire.MsgCChain:
+		return fmt.Sprintf("start %x, num %d", msg.HeadStart[:],
+			len(msg.Lines))
+
+	case *wire.MsgGetPatch:
+		return fmt.Sprintf("tree %x", msg.TreeHash[:])
+
+	case *wire.MsgPatch:
+		return fmt.Sprintf("tree %x, size %d", msg.TreeHash[:],
+			len(msg.Patch))
+
+	case *wire.MsgSendCmpct:
+		return fmt.Sprintf("announce %t, version %d", msg.Announce,
+			msg.Version)
+
+	case *wire.MsgCmpctBlock:
+		header := &msg.Header
+		return fmt.Sprintf("hash %s, ver %d, %d short ids, %d stake "+
+			"short ids, %s", header.BlockHash(), header.Version,
+			len(msg.ShortIDs), len(msg.StakeShortIDs),
+			header.Timestamp)
+
+	case *wire.MsgGetBlockTxn:
+		return fmt.Sprintf("hash %s, %d tx, %d stx", msg.BlockHash,
+			len(msg.Indexes), len(msg.StakeIndexes))
+
+	case *wire.MsgBlockTxn:
+		return fmt.Sprintf("hash %s, %d tx, %d stx", msg.BlockHash,
+			len(msg.Transactions), len(msg.STransactions))
+
 	case *wire.MsgReject:
 		// Ensure the variable length strings don't contain any
 		// characters which are even remotely dangerous such as HTML
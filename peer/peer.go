@@ -28,7 +28,7 @@ import (
 
 const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
-	MaxProtocolVersion = wire.NodeCFVersion
+	MaxProtocolVersion = wire.AddrV2Version
 
 	// outputBufferSize is the number of elements the output channels use.
 	outputBufferSize = 5000
@@ -169,6 +169,18 @@ type MessageListeners struct {
 	// OnFeeFilter is invoked when a peer receives a feefilter wire message.
 	OnFeeFilter func(p *Peer, msg *wire.MsgFeeFilter)
 
+	// OnGetCChain is invoked when a peer receives a getcchain wire message.
+	OnGetCChain func(p *Peer, msg *wire.MsgGetCChain)
+
+	// OnCChain is invoked when a peer receives a cchain wire message.
+	OnCChain func(p *Peer, msg *wire.MsgCChain)
+
+	// OnGetPatch is invoked when a peer receives a getpatch wire message.
+	OnGetPatch func(p *Peer, msg *wire.MsgGetPatch)
+
+	// OnPatch is invoked when a peer receives a patch wire message.
+	OnPatch func(p *Peer, msg *wire.MsgPatch)
+
 	// OnVersion is invoked when a peer receives a version wire message.
 	// The caller may return a reject message in which case the message will
 	// be sent to the peer and the peer will be disconnected.
@@ -184,6 +196,27 @@ type MessageListeners struct {
 	// message.
 	OnSendHeaders func(p *Peer, msg *wire.MsgSendHeaders)
 
+	// OnSendCmpct is invoked when a peer receives a sendcmpct wire message.
+	OnSendCmpct func(p *Peer, msg *wire.MsgSendCmpct)
+
+	// OnCmpctBlock is invoked when a peer receives a cmpctblock wire
+	// message.
+	OnCmpctBlock func(p *Peer, msg *wire.MsgCmpctBlock)
+
+	// OnGetBlockTxn is invoked when a peer receives a getblocktxn wire
+	// message.
+	OnGetBlockTxn func(p *Peer, msg *wire.MsgGetBlockTxn)
+
+	// OnBlockTxn is invoked when a peer receives a blocktxn wire message.
+	OnBlockTxn func(p *Peer, msg *wire.MsgBlockTxn)
+
+	// OnSendAddrV2 is invoked when a peer receives a sendaddrv2 wire
+	// message.
+	OnSendAddrV2 func(p *Peer, msg *wire.MsgSendAddrV2)
+
+	// OnAddrV2 is invoked when a peer receives an addrv2 wire message.
+	OnAddrV2 func(p *Peer, msg *wire.MsgAddrV2)
+
 	// OnRead is invoked when a peer receives a wire message.  It consists
 	// of the number of bytes read, the message, and whether or not an error
 	// in the read occurred.  Typically, callers will opt to use the
@@ -252,6 +285,18 @@ type Config struct {
 	// not send inv messages for transactions.
 	DisableRelayTx bool
 
+	// TreeHash specifies the Codechain tree hash of the source tree the
+	// local peer is running.  It is advertised to remote peers which
+	// support wire.TreeHashVersion.  This field can be omitted in which
+	// case a zero tree hash is advertised.
+	TreeHash chainhash.Hash
+
+	// V2Transport specifies whether or not to use the encrypted and
+	// authenticated v2 transport.  Outbound peers initiate the v2 transport
+	// handshake and fail to connect when the remote peer does not support
+	// it, while inbound peers accept both the v2 and v1 transport.
+	V2Transport bool
+
 	// Listeners houses callback functions to be invoked on receiving peer
 	// messages.
 	Listeners MessageListeners
@@ -351,6 +396,8 @@ type StatsSnap struct {
 	TimeOffset     int64
 	Version        uint32
 	UserAgent      string
+	TreeHash       chainhash.Hash
+	Transport      TransportVersion
 	Inbound        bool
 	StartingHeight int64
 	LastBlock      int64
@@ -408,6 +455,12 @@ type Peer struct {
 
 	conn net.Conn
 
+	// These fields are set up by the transport negotiation before any
+	// messages are exchanged and are only used by the goroutines reading and
+	// writing messages afterwards.
+	connReader io.Reader
+	v2         *v2Transport
+
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
 	addr    string
@@ -418,11 +471,17 @@ type Peer struct {
 	na                   *wire.NetAddress
 	id                   int32
 	userAgent            string
+	treeHash             chainhash.Hash
 	services             wire.ServiceFlag
 	versionKnown         bool
 	advertisedProtoVer   uint32 // protocol version advertised by remote
 	protocolVersion      uint32 // negotiated protocol version
 	sendHeadersPreferred bool   // peer sent a sendheaders message
+	cmpctBlockVersion    uint64 // compact block version from sendcmpct
+	cmpctAnnounce        bool   // peer wants blocks announced as compact
+	sendAddrV2           bool   // peer sent a sendaddrv2 message
+	transport            TransportVersion
+	v2Rejected           bool // remote peer rejected the v2 transport
 	versionSent          bool
 	verAckReceived       bool
 
@@ -496,6 +555,14 @@ func (p *Peer) AddKnownInventory(invVect *wire.InvVect) {
 	p.knownInventory.Add(invVect)
 }
 
+// IsKnownInventory returns whether or not the peer is known to have the passed
+// inventory.
+//
+// This function is safe for concurrent access.
+func (p *Peer) IsKnownInventory(invVect *wire.InvVect) bool {
+	return p.knownInventory.Exists(invVect)
+}
+
 // StatsSnapshot returns a snapshot of the current peer flags and statistics.
 //
 // This function is safe for concurrent access.
@@ -506,8 +573,10 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 	id := p.id
 	addr := p.addr
 	userAgent := p.userAgent
+	treeHash := p.treeHash
 	services := p.services
 	protocolVersion := p.advertisedProtoVer
+	transport := p.transport
 	p.flagsMtx.Unlock()
 
 	// Get a copy of all relevant flags and stats.
@@ -515,6 +584,8 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		ID:             id,
 		Addr:           addr,
 		UserAgent:      userAgent,
+		TreeHash:       treeHash,
+		Transport:      transport,
 		Services:       services,
 		LastSend:       p.LastSend(),
 		LastRecv:       p.LastRecv(),
@@ -595,6 +666,19 @@ func (p *Peer) UserAgent() string {
 	return userAgent
 }
 
+// TreeHash returns the Codechain tree hash of the source tree the remote peer
+// is running.  It is zero if the remote peer did not advertise it, such as
+// peers with a protocol version prior to wire.TreeHashVersion.
+//
+// This function is safe for concurrent access.
+func (p *Peer) TreeHash() chainhash.Hash {
+	p.flagsMtx.Lock()
+	treeHash := p.treeHash
+	p.flagsMtx.Unlock()
+
+	return treeHash
+}
+
 // LastAnnouncedBlock returns the last announced block of the remote peer.
 //
 // This function is safe for concurrent access.
@@ -772,6 +856,71 @@ func (p *Peer) WantsHeaders() bool {
 	return sendHeadersPreferred
 }
 
+// WantsCmpctBlocks returns if the peer sent a sendcmpct message for a supported
+// compact block version and thus is able to handle cmpctblock messages.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	wantsCmpctBlocks := p.cmpctBlockVersion == wire.CmpctBlockVersion
+	p.flagsMtx.Unlock()
+
+	return wantsCmpctBlocks
+}
+
+// WantsCmpctAnnouncements returns if the peer wants new blocks to be announced
+// by sending cmpctblock messages directly instead of inventory vectors or
+// headers (high-bandwidth mode).
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsCmpctAnnouncements() bool {
+	p.flagsMtx.Lock()
+	wantsAnnouncements := p.cmpctAnnounce &&
+		p.cmpctBlockVersion == wire.CmpctBlockVersion
+	p.flagsMtx.Unlock()
+
+	return wantsAnnouncements
+}
+
+// WantsAddrV2 returns if the peer wants addrv2 messages instead of addr
+// messages.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsAddrV2() bool {
+	p.flagsMtx.Lock()
+	sendAddrV2 := p.sendAddrV2
+	p.flagsMtx.Unlock()
+
+	return sendAddrV2
+}
+
+// Transport returns the transport negotiated with the remote peer.  It is zero
+// until the negotiation completed.
+//
+// This function is safe for concurrent access.
+func (p *Peer) Transport() TransportVersion {
+	p.flagsMtx.Lock()
+	transport := p.transport
+	p.flagsMtx.Unlock()
+
+	return transport
+}
+
+// V2TransportRejected returns whether the remote peer rejected the v2 transport
+// handshake initiated by the local outbound peer, which indicates it only
+// supports the v1 transport.  Failures of the handshake for other reasons, such
+// as the connection timing out or being closed in the middle of the handshake,
+// do not count as a rejection.
+//
+// This function is safe for concurrent access.
+func (p *Peer) V2TransportRejected() bool {
+	p.flagsMtx.Lock()
+	rejected := p.v2Rejected
+	p.flagsMtx.Unlock()
+
+	return rejected
+}
+
 // PushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.  This function is useful over manually sending the message via
 // QueueMessage since it automatically limits the addresses to the maximum
@@ -807,6 +956,37 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, er
 	return msg.AddrList, nil
 }
 
+// PushAddrV2Msg sends an addrv2 message to the connected peer using the
+// provided addresses.  It behaves like PushAddrMsg and should only be used for
+// peers that requested addrv2 messages.  See WantsAddrV2.
+//
+// This function is safe for concurrent access.
+func (p *Peer) PushAddrV2Msg(addresses []*wire.NetAddressV2) ([]*wire.NetAddressV2, error) {
+	// Nothing to send.
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+
+	msg := wire.NewMsgAddrV2()
+	msg.AddrList = make([]*wire.NetAddressV2, len(addresses))
+	copy(msg.AddrList, addresses)
+
+	// Randomize the addresses sent if there are more than the maximum allowed.
+	if len(msg.AddrList) > wire.MaxAddrPerMsg {
+		// Shuffle the address list.
+		for i := range msg.AddrList {
+			j := rand.Intn(i + 1)
+			msg.AddrList[i], msg.AddrList[j] = msg.AddrList[j], msg.AddrList[i]
+		}
+
+		// Truncate it to the maximum size.
+		msg.AddrList = msg.AddrList[:wire.MaxAddrPerMsg]
+	}
+
+	p.QueueMessage(msg, nil)
+	return msg.AddrList, nil
+}
+
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
 // and stop hash.  It will ignore back-to-back duplicate requests.
 //
@@ -958,8 +1138,17 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 
 // readMessage reads the next wire message from the peer with logging.
 func (p *Peer) readMessage() (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageN(p.conn, p.ProtocolVersion(),
-		p.cfg.ChainParams.Net)
+	var n int
+	var msg wire.Message
+	var buf []byte
+	var err error
+	if p.v2 != nil {
+		n, msg, buf, err = p.v2.readMessage(p.connReader,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net)
+	} else {
+		n, msg, buf, err = wire.ReadMessageN(p.connReader,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net)
+	}
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
@@ -1021,8 +1210,15 @@ func (p *Peer) writeMessage(msg wire.Message) error {
 	}))
 
 	// Write the message to the peer.
-	n, err := wire.WriteMessageN(p.conn, msg, p.ProtocolVersion(),
-		p.cfg.ChainParams.Net)
+	var n int
+	var err error
+	if p.v2 != nil {
+		n, err = p.v2.writeMessage(p.conn, msg, p.ProtocolVersion(),
+			p.cfg.ChainParams.Net)
+	} else {
+		n, err = wire.WriteMessageN(p.conn, msg, p.ProtocolVersion(),
+			p.cfg.ChainParams.Net)
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
@@ -1078,11 +1274,16 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		pendingResponses[wire.CmdInv] = deadline
 
 	case wire.CmdGetData:
-		// Expects a block, tx, or notfound message.
+		// Expects a block, cmpctblock, tx, or notfound message.
 		pendingResponses[wire.CmdBlock] = deadline
+		pendingResponses[wire.CmdCmpctBlock] = deadline
 		pendingResponses[wire.CmdTx] = deadline
 		pendingResponses[wire.CmdNotFound] = deadline
 
+	case wire.CmdGetBlockTxn:
+		// Expects a blocktxn message.
+		pendingResponses[wire.CmdBlockTxn] = deadline
+
 	case wire.CmdGetHeaders:
 		// Expects a headers message.  Use a longer deadline since it
 		// can take a while for the remote peer to load all of the
@@ -1139,10 +1340,13 @@ out:
 				switch msgCmd := msg.message.Command(); msgCmd {
 				case wire.CmdBlock:
 					fallthrough
+				case wire.CmdCmpctBlock:
+					fallthrough
 				case wire.CmdTx:
 					fallthrough
 				case wire.CmdNotFound:
 					delete(pendingResponses, wire.CmdBlock)
+					delete(pendingResponses, wire.CmdCmpctBlock)
 					delete(pendingResponses, wire.CmdTx)
 					delete(pendingResponses, wire.CmdNotFound)
 
@@ -1423,6 +1627,26 @@ out:
 				p.cfg.Listeners.OnFeeFilter(p, msg)
 			}
 
+		case *wire.MsgGetCChain:
+			if p.cfg.Listeners.OnGetCChain != nil {
+				p.cfg.Listeners.OnGetCChain(p, msg)
+			}
+
+		case *wire.MsgCChain:
+			if p.cfg.Listeners.OnCChain != nil {
+				p.cfg.Listeners.OnCChain(p, msg)
+			}
+
+		case *wire.MsgGetPatch:
+			if p.cfg.Listeners.OnGetPatch != nil {
+				p.cfg.Listeners.OnGetPatch(p, msg)
+			}
+
+		case *wire.MsgPatch:
+			if p.cfg.Listeners.OnPatch != nil {
+				p.cfg.Listeners.OnPatch(p, msg)
+			}
+
 		case *wire.MsgReject:
 			if p.cfg.Listeners.OnReject != nil {
 				p.cfg.Listeners.OnReject(p, msg)
@@ -1437,6 +1661,50 @@ out:
 				p.cfg.Listeners.OnSendHeaders(p, msg)
 			}
 
+		case *wire.MsgSendCmpct:
+			// Only the latest compact block version which is
+			// supported is recorded so peers are able to announce
+			// multiple versions in order of preference.
+			if msg.Version == wire.CmpctBlockVersion {
+				p.flagsMtx.Lock()
+				p.cmpctBlockVersion = msg.Version
+				p.cmpctAnnounce = msg.Announce
+				p.flagsMtx.Unlock()
+			}
+
+			if p.cfg.Listeners.OnSendCmpct != nil {
+				p.cfg.Listeners.OnSendCmpct(p, msg)
+			}
+
+		case *wire.MsgCmpctBlock:
+			if p.cfg.Listeners.OnCmpctBlock != nil {
+				p.cfg.Listeners.OnCmpctBlock(p, msg)
+			}
+
+		case *wire.MsgGetBlockTxn:
+			if p.cfg.Listeners.OnGetBlockTxn != nil {
+				p.cfg.Listeners.OnGetBlockTxn(p, msg)
+			}
+
+		case *wire.MsgBlockTxn:
+			if p.cfg.Listeners.OnBlockTxn != nil {
+				p.cfg.Listeners.OnBlockTxn(p, msg)
+			}
+
+		case *wire.MsgSendAddrV2:
+			p.flagsMtx.Lock()
+			p.sendAddrV2 = true
+			p.flagsMtx.Unlock()
+
+			if p.cfg.Listeners.OnSendAddrV2 != nil {
+				p.cfg.Listeners.OnSendAddrV2(p, msg)
+			}
+
+		case *wire.MsgAddrV2:
+			if p.cfg.Listeners.OnAddrV2 != nil {
+				p.cfg.Listeners.OnAddrV2(p, msg)
+			}
+
 		default:
 			log.Debugf("Received unhandled message of type %v "+
 				"from %v", rmsg.Command(), p)
@@ -1821,10 +2089,11 @@ func (p *Peer) readRemoteVersionMsg() error {
 	p.timeOffset = msg.Timestamp.Unix() - time.Now().Unix()
 	p.statsMtx.Unlock()
 
-	// Set the peer's ID and user agent.
+	// Set the peer's ID, user agent, and tree hash.
 	p.flagsMtx.Lock()
 	p.id = atomic.AddInt32(&nodeCount, 1)
 	p.userAgent = msg.UserAgent
+	p.treeHash = msg.TreeHash
 	p.flagsMtx.Unlock()
 
 	// Invoke the callback if specified.  In the case the callback returns a
@@ -1916,6 +2185,9 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 	// Advertise if inv messages for transactions are desired.
 	msg.DisableRelayTx = p.cfg.DisableRelayTx
 
+	// Advertise the tree hash of the running source tree.
+	msg.TreeHash = p.cfg.TreeHash
+
 	return msg, nil
 }
 
@@ -1936,10 +2208,14 @@ func (p *Peer) writeLocalVersionMsg() error {
 	return nil
 }
 
-// negotiateInboundProtocol waits to receive a version message from the peer
-// then sends our version message. If the events do not occur in that order then
-// it returns an error.
+// negotiateInboundProtocol negotiates the transport, waits to receive a version
+// message from the peer then sends our version message. If the events do not
+// occur in that order then it returns an error.
 func (p *Peer) negotiateInboundProtocol() error {
+	if err := p.negotiateInboundTransport(); err != nil {
+		return err
+	}
+
 	if err := p.readRemoteVersionMsg(); err != nil {
 		return err
 	}
@@ -1947,10 +2223,14 @@ func (p *Peer) negotiateInboundProtocol() error {
 	return p.writeLocalVersionMsg()
 }
 
-// negotiateOutboundProtocol sends our version message then waits to receive a
-// version message from the peer.  If the events do not occur in that order then
-// it returns an error.
+// negotiateOutboundProtocol negotiates the transport, sends our version message
+// then waits to receive a version message from the peer.  If the events do not
+// occur in that order then it returns an error.
 func (p *Peer) negotiateOutboundProtocol() error {
+	if err := p.negotiateOutboundTransport(); err != nil {
+		return err
+	}
+
 	if err := p.writeLocalVersionMsg(); err != nil {
 		return err
 	}
@@ -2006,6 +2286,7 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}
 
 	p.conn = conn
+	p.connReader = conn
 	p.timeConnected = time.Now()
 
 	if p.inbound {
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net"
 	"strconv"
 	"sync"
@@ -93,6 +94,7 @@ func pipe(c1, c2 *conn) (*conn, *conn) {
 // peerStats holds the expected peer stats used for testing peer.
 type peerStats struct {
 	wantUserAgent       string
+	wantTreeHash        chainhash.Hash
 	wantServices        wire.ServiceFlag
 	wantProtocolVersion uint32
 	wantConnected       bool
@@ -115,6 +117,11 @@ func testPeer(t *testing.T, p *peer.Peer, s peerStats) {
 		return
 	}
 
+	if p.TreeHash() != s.wantTreeHash {
+		t.Errorf("testPeer: wrong TreeHash - got %v, want %v", p.TreeHash(), s.wantTreeHash)
+		return
+	}
+
 	if p.Services() != s.wantServices {
 		t.Errorf("testPeer: wrong Services - got %v, want %v", p.Services(), s.wantServices)
 		return
@@ -228,9 +235,11 @@ func TestPeerConnection(t *testing.T) {
 		UserAgentVersion: "1.0",
 		ChainParams:      &chaincfg.MainNetParams,
 		Services:         0,
+		TreeHash:         chainhash.Hash{0x01, 0x02, 0x03},
 	}
 	wantStats := peerStats{
 		wantUserAgent:       wire.DefaultUserAgent + "peer:1.0/",
+		wantTreeHash:        chainhash.Hash{0x01, 0x02, 0x03},
 		wantServices:        0,
 		wantProtocolVersion: peer.MaxProtocolVersion,
 		wantConnected:       true,
@@ -240,8 +249,8 @@ func TestPeerConnection(t *testing.T) {
 		wantLastPingNonce:   uint64(0),
 		wantLastPingMicros:  int64(0),
 		wantTimeOffset:      int64(0),
-		wantBytesSent:       158, // 134 version + 24 verack
-		wantBytesReceived:   158,
+		wantBytesSent:       190, // 166 version + 24 verack
+		wantBytesReceived:   190,
 	}
 	tests := []struct {
 		name  string
@@ -386,6 +395,18 @@ func TestPeerListeners(t *testing.T) {
 			OnFeeFilter: func(p *peer.Peer, msg *wire.MsgFeeFilter) {
 				ok <- msg
 			},
+			OnGetCChain: func(p *peer.Peer, msg *wire.MsgGetCChain) {
+				ok <- msg
+			},
+			OnCChain: func(p *peer.Peer, msg *wire.MsgCChain) {
+				ok <- msg
+			},
+			OnGetPatch: func(p *peer.Peer, msg *wire.MsgGetPatch) {
+				ok <- msg
+			},
+			OnPatch: func(p *peer.Peer, msg *wire.MsgPatch) {
+				ok <- msg
+			},
 			OnVersion: func(p *peer.Peer, msg *wire.MsgVersion) *wire.MsgReject {
 				ok <- msg
 				return nil
@@ -399,6 +420,24 @@ func TestPeerListeners(t *testing.T) {
 			OnSendHeaders: func(p *peer.Peer, msg *wire.MsgSendHeaders) {
 				ok <- msg
 			},
+			OnSendCmpct: func(p *peer.Peer, msg *wire.MsgSendCmpct) {
+				ok <- msg
+			},
+			OnCmpctBlock: func(p *peer.Peer, msg *wire.MsgCmpctBlock) {
+				ok <- msg
+			},
+			OnGetBlockTxn: func(p *peer.Peer, msg *wire.MsgGetBlockTxn) {
+				ok <- msg
+			},
+			OnBlockTxn: func(p *peer.Peer, msg *wire.MsgBlockTxn) {
+				ok <- msg
+			},
+			OnSendAddrV2: func(p *peer.Peer, msg *wire.MsgSendAddrV2) {
+				ok <- msg
+			},
+			OnAddrV2: func(p *peer.Peer, msg *wire.MsgAddrV2) {
+				ok <- msg
+			},
 		},
 		UserAgentName:    "peer",
 		UserAgentVersion: "1.0",
@@ -523,6 +562,22 @@ func TestPeerListeners(t *testing.T) {
 			"OnFeeFilter",
 			wire.NewMsgFeeFilter(15000),
 		},
+		{
+			"OnGetCChain",
+			wire.NewMsgGetCChain(&chainhash.Hash{}, &chainhash.Hash{}),
+		},
+		{
+			"OnCChain",
+			wire.NewMsgCChain(&chainhash.Hash{}),
+		},
+		{
+			"OnGetPatch",
+			wire.NewMsgGetPatch(&chainhash.Hash{}),
+		},
+		{
+			"OnPatch",
+			wire.NewMsgPatch(&chainhash.Hash{}, []byte("abc")),
+		},
 		// only one version message is allowed
 		// only one verack message is allowed
 		{
@@ -533,6 +588,30 @@ func TestPeerListeners(t *testing.T) {
 			"OnSendHeaders",
 			wire.NewMsgSendHeaders(),
 		},
+		{
+			"OnSendCmpct",
+			wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion),
+		},
+		{
+			"OnCmpctBlock",
+			wire.NewMsgCmpctBlock(&wire.BlockHeader{}, 0),
+		},
+		{
+			"OnGetBlockTxn",
+			wire.NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{1}, nil),
+		},
+		{
+			"OnBlockTxn",
+			wire.NewMsgBlockTxn(&chainhash.Hash{}),
+		},
+		{
+			"OnSendAddrV2",
+			wire.NewMsgSendAddrV2(),
+		},
+		{
+			"OnAddrV2",
+			wire.NewMsgAddrV2(),
+		},
 	}
 	t.Logf("Running %d tests", len(tests))
 	for _, test := range tests {
@@ -545,6 +624,16 @@ func TestPeerListeners(t *testing.T) {
 			return
 		}
 	}
+
+	// Ensure the sendcmpct message requested high-bandwidth mode.
+	if !inPeer.WantsCmpctBlocks() || !inPeer.WantsCmpctAnnouncements() {
+		t.Errorf("TestPeerListeners: compact blocks not requested")
+	}
+
+	// Ensure the sendaddrv2 message requested addrv2 messages.
+	if !inPeer.WantsAddrV2() {
+		t.Errorf("TestPeerListeners: addrv2 messages not requested")
+	}
 	inPeer.Disconnect()
 	outPeer.Disconnect()
 }
@@ -667,6 +756,14 @@ func TestOutboundPeer(t *testing.T) {
 		t.Errorf("PushAddrMsg: unexpected err %v\n", err)
 		return
 	}
+	var addrsV2 []*wire.NetAddressV2
+	for _, na := range addrs {
+		addrsV2 = append(addrsV2, wire.NewNetAddressV2FromLegacy(na))
+	}
+	if _, err := p2.PushAddrV2Msg(addrsV2); err != nil {
+		t.Errorf("PushAddrV2Msg: unexpected err %v\n", err)
+		return
+	}
 	if err := p2.PushGetBlocksMsg(nil, &chainhash.Hash{}); err != nil {
 		t.Errorf("PushGetBlocksMsg: unexpected err %v\n", err)
 		return
@@ -752,6 +849,177 @@ func TestDuplicateVersionMsg(t *testing.T) {
 	}
 }
 
+// TestPeerTransport ensures peers negotiate the expected transport depending on
+// whether or not each of them is configured to use the v2 transport.
+func TestPeerTransport(t *testing.T) {
+	tests := []struct {
+		name          string
+		outV2         bool                  // outbound uses v2 transport
+		inV2          bool                  // inbound accepts v2 transport
+		wantConnect   bool                  // peers are expected to connect
+		wantTransport peer.TransportVersion // expected transport
+	}{
+		{"v1 to v1", false, false, true, peer.TransportV1},
+		{"v1 to v2", false, true, true, peer.TransportV1},
+		{"v2 to v2", true, true, true, peer.TransportV2},
+		{"v2 to v1", true, false, false, 0},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for _, test := range tests {
+		verack := make(chan struct{}, 2)
+		newPeerCfg := func(v2Transport bool) *peer.Config {
+			return &peer.Config{
+				Listeners: peer.MessageListeners{
+					OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+						verack <- struct{}{}
+					},
+				},
+				UserAgentName:    "peer",
+				UserAgentVersion: "1.0",
+				ChainParams:      &chaincfg.MainNetParams,
+				V2Transport:      v2Transport,
+			}
+		}
+		inConn, outConn := pipe(
+			&conn{laddr: "10.0.0.1:9208", raddr: "10.0.0.2:9208"},
+			&conn{laddr: "10.0.0.2:9208", raddr: "10.0.0.1:9208"},
+		)
+		outPeer, err := peer.NewOutboundPeer(newPeerCfg(test.outV2),
+			inConn.laddr)
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err: %v", test.name,
+				err)
+		}
+		outPeer.AssociateConnection(outConn)
+		inPeer := peer.NewInboundPeer(newPeerCfg(test.inV2))
+		inPeer.AssociateConnection(inConn)
+
+		if !test.wantConnect {
+			// Ensure the inbound peer rejects the v2 handshake and
+			// disconnects, then close its side of the connection
+			// since the fake connections don't do that on their own.
+			disconnected := make(chan struct{}, 2)
+			go func() {
+				inPeer.WaitForDisconnect()
+				disconnected <- struct{}{}
+			}()
+			select {
+			case <-disconnected:
+			case <-verack:
+				t.Fatalf("%s: unexpected verack", test.name)
+			case <-time.After(time.Second):
+				t.Fatalf("%s: inbound peer did not disconnect",
+					test.name)
+			}
+			// Like a real connection, accept the remainder of the
+			// outbound public key before closing.
+			go io.Copy(ioutil.Discard, inConn.Reader)
+			inConn.Writer.(*io.PipeWriter).Close()
+
+			// Ensure the outbound peer fails the v2 handshake and
+			// disconnects without negotiating the protocol version.
+			go func() {
+				outPeer.WaitForDisconnect()
+				disconnected <- struct{}{}
+			}()
+			select {
+			case <-disconnected:
+			case <-time.After(time.Second):
+				t.Fatalf("%s: outbound peer did not disconnect",
+					test.name)
+			}
+			if outPeer.VersionKnown() {
+				t.Errorf("%s: unexpected version negotiation",
+					test.name)
+			}
+			if transport := outPeer.Transport(); transport != 0 {
+				t.Errorf("%s: unexpected outbound transport %v",
+					test.name, transport)
+			}
+			if !outPeer.V2TransportRejected() {
+				t.Errorf("%s: v2 transport not rejected", test.name)
+			}
+			continue
+		}
+
+		// Wait for the veracks from the protocol version negotiation
+		// which are only received when the transport works.
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second):
+				t.Fatalf("%s: verack timeout", test.name)
+			}
+		}
+		if transport := outPeer.Transport(); transport != test.wantTransport {
+			t.Errorf("%s: unexpected outbound transport - got %v, "+
+				"want %v", test.name, transport, test.wantTransport)
+		}
+		if transport := inPeer.Transport(); transport != test.wantTransport {
+			t.Errorf("%s: unexpected inbound transport - got %v, "+
+				"want %v", test.name, transport, test.wantTransport)
+		}
+		snap := inPeer.StatsSnapshot()
+		if snap.Transport != test.wantTransport {
+			t.Errorf("%s: unexpected snapshot transport - got %v, "+
+				"want %v", test.name, snap.Transport,
+				test.wantTransport)
+		}
+		if outPeer.V2TransportRejected() {
+			t.Errorf("%s: unexpected v2 transport rejection", test.name)
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+		inPeer.WaitForDisconnect()
+		outPeer.WaitForDisconnect()
+	}
+}
+
+// TestPeerTransportFailure ensures a v2 transport handshake which fails after
+// the remote peer started to answer it is not treated as a rejection of the v2
+// transport.
+func TestPeerTransportFailure(t *testing.T) {
+	inConn, outConn := pipe(
+		&conn{laddr: "10.0.0.1:9208", raddr: "10.0.0.2:9208"},
+		&conn{laddr: "10.0.0.2:9208", raddr: "10.0.0.1:9208"},
+	)
+	outPeer, err := peer.NewOutboundPeer(&peer.Config{
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0",
+		ChainParams:      &chaincfg.MainNetParams,
+		V2Transport:      true,
+	}, inConn.laddr)
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err: %v", err)
+	}
+	outPeer.AssociateConnection(outConn)
+
+	// Read the public key of the outbound peer, answer with the start of
+	// one and close the connection.
+	go func() {
+		io.ReadFull(inConn, make([]byte, 33))
+		inConn.Write([]byte{0x02, 0x01, 0x02, 0x03, 0x04})
+		inConn.Reader.(*io.PipeReader).Close()
+		inConn.Writer.(*io.PipeWriter).Close()
+	}()
+
+	disconnected := make(chan struct{})
+	go func() {
+		outPeer.WaitForDisconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("outbound peer did not disconnect")
+	}
+	if outPeer.V2TransportRejected() {
+		t.Error("unexpected v2 transport rejection")
+	}
+}
+
 func init() {
 	// Allow self connection when running the tests.
 	peer.TstAllowSelfConns()
@@ -0,0 +1,343 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/bitum-project/bitumd/bitumec/secp256k1"
+	"github.com/bitum-project/bitumd/wire"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// TransportVersion identifies the transport used to exchange wire messages
+// with a remote peer.
+type TransportVersion uint8
+
+const (
+	// TransportV1 is the plaintext transport where every message is framed
+	// by the 24-byte message header which consists of the network magic,
+	// the command, the payload length, and the payload checksum.
+	TransportV1 TransportVersion = 1
+
+	// TransportV2 is the encrypted and authenticated transport.  The peers
+	// perform an ephemeral secp256k1 Diffie-Hellman key exchange and
+	// every v1 encoded message is then sent in a frame which is encrypted
+	// and authenticated with ChaCha20-Poly1305.
+	TransportV2 TransportVersion = 2
+)
+
+// Map of transport versions back to their names for pretty printing.
+var transportStrings = map[TransportVersion]string{
+	TransportV1: "v1",
+	TransportV2: "v2",
+}
+
+// String returns the TransportVersion in human-readable form.
+func (v TransportVersion) String() string {
+	if s, ok := transportStrings[v]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown TransportVersion (%d)", uint8(v))
+}
+
+const (
+	// v2PubKeySize is the size of the ephemeral public keys exchanged by
+	// the v2 transport handshake.  The keys are sent in compressed form,
+	// so their first byte is never the first byte of a network magic which
+	// allows inbound peers to detect the transport in use.
+	v2PubKeySize = secp256k1.PubKeyBytesLenCompressed
+
+	// v2LengthSize is the size of the plaintext length field which precedes
+	// the contents of every v2 frame.
+	v2LengthSize = 4
+
+	// maxV2FrameContents is the maximum size of the contents of a v2 frame,
+	// which is a v1 encoded message.
+	maxV2FrameContents = wire.MessageHeaderSize + wire.MaxMessagePayload
+)
+
+// v2KeySalt is the salt used to derive the v2 transport keys from the shared
+// secret of the key exchange.  The network magic is appended to it so keys
+// are never shared between networks.
+var v2KeySalt = []byte("bitum v2 transport")
+
+var (
+	// errV2Handshake is returned when the v2 transport handshake with a
+	// remote peer fails, which typically means the remote peer does not
+	// support the v2 transport.
+	errV2Handshake = errors.New("v2 transport handshake failed")
+
+	// errV2Auth is returned when a v2 frame fails authentication.
+	errV2Auth = errors.New("v2 transport frame failed authentication")
+)
+
+// v2Transport houses the state of an established v2 transport.  Each direction
+// uses its own key and a nonce which is a counter of the sealed messages.
+//
+// The send state must only be used by the goroutine writing messages and the
+// receive state must only be used by the goroutine reading messages.
+type v2Transport struct {
+	sendAEAD  cipher.AEAD
+	sendNonce uint64
+	recvAEAD  cipher.AEAD
+	recvNonce uint64
+}
+
+// newV2Transport derives the keys of a v2 transport from the result of the
+// key exchange between the provided local private key and remote public key.
+// Both public keys are bound to the keys so that tampering with either of them
+// results in a failure to authenticate the first frame.
+func newV2Transport(net wire.CurrencyNet, initiator bool, privKey *secp256k1.PrivateKey, remotePubKey *secp256k1.PublicKey) (*v2Transport, error) {
+	localPubKey := privKey.PubKey().SerializeCompressed()
+	initiatorPubKey, responderPubKey := localPubKey, remotePubKey.SerializeCompressed()
+	if !initiator {
+		initiatorPubKey, responderPubKey = responderPubKey, initiatorPubKey
+	}
+
+	salt := make([]byte, len(v2KeySalt)+4)
+	copy(salt, v2KeySalt)
+	binary.LittleEndian.PutUint32(salt[len(v2KeySalt):], uint32(net))
+	info := make([]byte, 0, 2*v2PubKeySize)
+	info = append(info, initiatorPubKey...)
+	info = append(info, responderPubKey...)
+
+	// The first key encrypts the frames sent by the initiator and the
+	// second key those sent by the responder.
+	secret := secp256k1.GenerateSharedSecret(privKey, remotePubKey)
+	kdf := hkdf.New(sha256.New, secret, salt, info)
+	var keys [2 * chacha20poly1305.KeySize]byte
+	if _, err := io.ReadFull(kdf, keys[:]); err != nil {
+		return nil, err
+	}
+	initiatorAEAD, err := chacha20poly1305.New(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	responderAEAD, err := chacha20poly1305.New(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, err
+	}
+
+	if initiator {
+		return &v2Transport{sendAEAD: initiatorAEAD, recvAEAD: responderAEAD}, nil
+	}
+	return &v2Transport{sendAEAD: responderAEAD, recvAEAD: initiatorAEAD}, nil
+}
+
+// nonce returns the nonce for the passed message counter and increments it.
+func nonce(counter *uint64) []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[:], *counter)
+	*counter++
+	return nonce[:]
+}
+
+// writeMessage writes a v2 frame which contains the v1 encoding of the passed
+// message to w.  It returns the number of bytes written.
+func (t *v2Transport) writeMessage(w io.Writer, msg wire.Message, pver uint32, net wire.CurrencyNet) (int, error) {
+	var contents bytes.Buffer
+	_, err := wire.WriteMessageN(&contents, msg, pver, net)
+	if err != nil {
+		return 0, err
+	}
+
+	// The frame consists of the sealed length of the contents followed by
+	// the sealed contents.
+	overhead := t.sendAEAD.Overhead()
+	frame := make([]byte, 0, v2LengthSize+contents.Len()+2*overhead)
+	var length [v2LengthSize]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(contents.Len()))
+	frame = t.sendAEAD.Seal(frame, nonce(&t.sendNonce), length[:], nil)
+	frame = t.sendAEAD.Seal(frame, nonce(&t.sendNonce), contents.Bytes(), nil)
+	return w.Write(frame)
+}
+
+// readMessage reads a v2 frame from r and decodes the v1 encoded message it
+// contains.  It returns the number of bytes read along with the message and
+// its raw payload.
+func (t *v2Transport) readMessage(r io.Reader, pver uint32, net wire.CurrencyNet) (int, wire.Message, []byte, error) {
+	overhead := t.recvAEAD.Overhead()
+	sealedLength := make([]byte, v2LengthSize+overhead)
+	n, err := io.ReadFull(r, sealedLength)
+	if err != nil {
+		return n, nil, nil, err
+	}
+	length, err := t.recvAEAD.Open(sealedLength[:0], nonce(&t.recvNonce),
+		sealedLength, nil)
+	if err != nil {
+		return n, nil, nil, errV2Auth
+	}
+	contentsLen := binary.LittleEndian.Uint32(length)
+	if contentsLen > maxV2FrameContents {
+		str := fmt.Sprintf("v2 frame contents are larger than the max "+
+			"allowed [size %d, max %d]", contentsLen,
+			maxV2FrameContents)
+		return n, nil, nil, errors.New(str)
+	}
+
+	sealedContents := make([]byte, int(contentsLen)+overhead)
+	m, err := io.ReadFull(r, sealedContents)
+	n += m
+	if err != nil {
+		return n, nil, nil, err
+	}
+	contents, err := t.recvAEAD.Open(sealedContents[:0],
+		nonce(&t.recvNonce), sealedContents, nil)
+	if err != nil {
+		return n, nil, nil, errV2Auth
+	}
+
+	cr := bytes.NewReader(contents)
+	_, msg, payload, err := wire.ReadMessageN(cr, pver, net)
+	if err != nil {
+		return n, nil, nil, err
+	}
+	if cr.Len() != 0 {
+		str := fmt.Sprintf("v2 frame contains %d trailing bytes after "+
+			"the %v message", cr.Len(), msg.Command())
+		return n, nil, nil, errors.New(str)
+	}
+	return n, msg, payload, nil
+}
+
+// writeV2PubKey generates an ephemeral key pair for the v2 transport handshake
+// and sends its public key to the remote peer.
+func (p *Peer) writeV2PubKey() (*secp256k1.PrivateKey, error) {
+	privKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.conn.Write(privKey.PubKey().SerializeCompressed())
+	atomic.AddUint64(&p.bytesSent, uint64(n))
+	return privKey, err
+}
+
+// readV2PubKey reads the remaining bytes of the ephemeral public key of the
+// remote peer after the provided prefix which has already been read.
+func (p *Peer) readV2PubKey(prefix []byte) (*secp256k1.PublicKey, error) {
+	pubKey := make([]byte, v2PubKeySize)
+	copy(pubKey, prefix)
+	n, err := io.ReadFull(p.connReader, pubKey[len(prefix):])
+	atomic.AddUint64(&p.bytesReceived, uint64(len(prefix)+n))
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1.ParsePubKey(pubKey)
+}
+
+// isConnReset returns whether the passed error indicates the remote peer reset
+// the connection, which happens when it closes the connection without reading
+// all of the data that was sent to it.
+func isConnReset(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	return ok && sysErr.Err == syscall.ECONNRESET
+}
+
+// setTransport records the transport negotiated with the remote peer.
+func (p *Peer) setTransport(version TransportVersion, t *v2Transport) {
+	p.v2 = t
+	p.flagsMtx.Lock()
+	p.transport = version
+	p.flagsMtx.Unlock()
+}
+
+// negotiateOutboundTransport initiates the v2 transport handshake when the
+// peer is configured to use it and otherwise selects the v1 transport.
+func (p *Peer) negotiateOutboundTransport() error {
+	if !p.cfg.V2Transport {
+		p.setTransport(TransportV1, nil)
+		return nil
+	}
+
+	privKey, err := p.writeV2PubKey()
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+
+	// A remote peer which only supports the v1 transport fails to parse the
+	// public key as a v1 message header, so it either answers with a v1
+	// message, which starts with the network magic, or closes the
+	// connection without answering.
+	var prefix [4]byte
+	_, err = io.ReadFull(p.conn, prefix[:])
+	if err == io.EOF || isConnReset(err) || (err == nil &&
+		binary.LittleEndian.Uint32(prefix[:]) == uint32(p.cfg.ChainParams.Net)) {
+
+		p.flagsMtx.Lock()
+		p.v2Rejected = true
+		p.flagsMtx.Unlock()
+		return fmt.Errorf("%v: rejected by the remote peer", errV2Handshake)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+	remotePubKey, err := p.readV2PubKey(prefix[:])
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+	t, err := newV2Transport(p.cfg.ChainParams.Net, true, privKey,
+		remotePubKey)
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+	p.setTransport(TransportV2, t)
+	return nil
+}
+
+// negotiateInboundTransport detects the transport the remote peer initiated
+// when the peer is configured to accept the v2 transport and otherwise selects
+// the v1 transport.  A v1 connection is detected by the network magic every v1
+// message starts with, in which case the bytes that were read to detect it are
+// replayed to the v1 message reader.
+func (p *Peer) negotiateInboundTransport() error {
+	if !p.cfg.V2Transport {
+		p.setTransport(TransportV1, nil)
+		return nil
+	}
+
+	var prefix [4]byte
+	_, err := io.ReadFull(p.conn, prefix[:])
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(prefix[:]) == uint32(p.cfg.ChainParams.Net) {
+		p.connReader = io.MultiReader(bytes.NewReader(prefix[:]), p.conn)
+		p.setTransport(TransportV1, nil)
+		return nil
+	}
+
+	remotePubKey, err := p.readV2PubKey(prefix[:])
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+	privKey, err := p.writeV2PubKey()
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+	t, err := newV2Transport(p.cfg.ChainParams.Net, false, privKey,
+		remotePubKey)
+	if err != nil {
+		return fmt.Errorf("%v: %v", errV2Handshake, err)
+	}
+	p.setTransport(TransportV2, t)
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/bitumec/secp256k1"
+	"github.com/bitum-project/bitumd/wire"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// newV2TransportPair returns the initiator and responder sides of a v2
+// transport established over the passed network.
+func newV2TransportPair(t *testing.T, initiatorNet, responderNet wire.CurrencyNet) (*v2Transport, *v2Transport) {
+	t.Helper()
+
+	initiatorKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("GeneratePrivateKey: unexpected err: %v", err)
+	}
+	responderKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("GeneratePrivateKey: unexpected err: %v", err)
+	}
+	initiator, err := newV2Transport(initiatorNet, true, initiatorKey,
+		responderKey.PubKey())
+	if err != nil {
+		t.Fatalf("newV2Transport: unexpected err: %v", err)
+	}
+	responder, err := newV2Transport(responderNet, false, responderKey,
+		initiatorKey.PubKey())
+	if err != nil {
+		t.Fatalf("newV2Transport: unexpected err: %v", err)
+	}
+	return initiator, responder
+}
+
+// TestV2Transport ensures messages written by one side of a v2 transport are
+// read by the other side, are not sent in plaintext, and that frames which
+// were tampered with or sealed with the keys of another network are rejected.
+func TestV2Transport(t *testing.T) {
+	pver := MaxProtocolVersion
+	net := wire.MainNet
+	initiator, responder := newV2TransportPair(t, net, net)
+
+	// Ensure messages are read in both directions.
+	msgs := []wire.Message{
+		wire.NewMsgPing(0x0102030405060708),
+		wire.NewMsgVerAck(),
+		wire.NewMsgPong(0x0807060504030201),
+	}
+	for i, msg := range msgs {
+		for _, dir := range []struct{ w, r *v2Transport }{
+			{initiator, responder},
+			{responder, initiator},
+		} {
+			var buf bytes.Buffer
+			n, err := dir.w.writeMessage(&buf, msg, pver, net)
+			if err != nil {
+				t.Fatalf("writeMessage #%d: unexpected err: %v", i,
+					err)
+			}
+			if n != buf.Len() {
+				t.Errorf("writeMessage #%d: wrong byte count - "+
+					"got %d, want %d", i, n, buf.Len())
+			}
+			if bytes.Contains(buf.Bytes(), []byte(msg.Command())) {
+				t.Errorf("writeMessage #%d: frame contains "+
+					"plaintext command %q", i, msg.Command())
+			}
+
+			n, readMsg, _, err := dir.r.readMessage(&buf, pver, net)
+			if err != nil {
+				t.Fatalf("readMessage #%d: unexpected err: %v", i,
+					err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("readMessage #%d: %d unread bytes", i,
+					buf.Len())
+			}
+			if !reflect.DeepEqual(readMsg, msg) {
+				t.Errorf("readMessage #%d\n got: %s want: %s", i,
+					spew.Sdump(readMsg), spew.Sdump(msg))
+			}
+		}
+	}
+
+	// Ensure every byte of a frame is authenticated.
+	var frame bytes.Buffer
+	_, err := initiator.writeMessage(&frame, wire.NewMsgVerAck(), pver, net)
+	if err != nil {
+		t.Fatalf("writeMessage: unexpected err: %v", err)
+	}
+	for i := 0; i < frame.Len(); i++ {
+		tampered := make([]byte, frame.Len())
+		copy(tampered, frame.Bytes())
+		tampered[i] ^= 0x01
+
+		// Use a copy of the responder so every tampered frame is read
+		// with the nonce the original frame was sealed with.
+		r := *responder
+		_, _, _, err := r.readMessage(bytes.NewReader(tampered), pver, net)
+		if err != errV2Auth {
+			t.Errorf("readMessage: tampered byte %d - got err %v, "+
+				"want %v", i, err, errV2Auth)
+		}
+	}
+
+	// Ensure frames of a replayed or reordered message are rejected since
+	// the nonce of the receiver moved on.
+	_, _, _, err = responder.readMessage(&frame, pver, net)
+	if err != nil {
+		t.Fatalf("readMessage: unexpected err: %v", err)
+	}
+	_, err = initiator.writeMessage(&frame, wire.NewMsgVerAck(), pver, net)
+	if err != nil {
+		t.Fatalf("writeMessage: unexpected err: %v", err)
+	}
+	replay := frame.Bytes()
+	_, _, _, err = responder.readMessage(bytes.NewReader(replay), pver, net)
+	if err != nil {
+		t.Fatalf("readMessage: unexpected err: %v", err)
+	}
+	_, _, _, err = responder.readMessage(bytes.NewReader(replay), pver, net)
+	if err != errV2Auth {
+		t.Errorf("readMessage: replayed frame - got err %v, want %v", err,
+			errV2Auth)
+	}
+
+	// Ensure keys are not shared between networks.
+	initiator, responder = newV2TransportPair(t, wire.MainNet, wire.TestNet)
+	frame.Reset()
+	_, err = initiator.writeMessage(&frame, wire.NewMsgVerAck(), pver, net)
+	if err != nil {
+		t.Fatalf("writeMessage: unexpected err: %v", err)
+	}
+	_, _, _, err = responder.readMessage(&frame, pver, net)
+	if err != errV2Auth {
+		t.Errorf("readMessage: other network - got err %v, want %v", err,
+			errV2Auth)
+	}
+
+	// Ensure a truncated frame results in an error.
+	frame.Reset()
+	initiator, responder = newV2TransportPair(t, net, net)
+	_, err = initiator.writeMessage(&frame, wire.NewMsgVerAck(), pver, net)
+	if err != nil {
+		t.Fatalf("writeMessage: unexpected err: %v", err)
+	}
+	truncated := bytes.NewReader(frame.Bytes()[:frame.Len()-1])
+	_, _, _, err = responder.readMessage(truncated, pver, net)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("readMessage: truncated frame - got err %v, want %v",
+			err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// peerPermissions is a bitmask of the permissions granted to peers whose
+// address matches a whitelist.
+type peerPermissions uint8
+
+const (
+	// permNoBan indicates the peer is never banned.  Misbehaving is logged
+	// instead of increasing its ban score and the peer may connect while
+	// its address is banned.  Requests from the peer are still rate
+	// limited, so flooding it disconnects the peer without banning it.
+	permNoBan peerPermissions = 1 << iota
+
+	// permRelay indicates transactions from the peer are always relayed.
+	// They are accepted even when --blocksonly is set and are not subject
+	// to the rate limiting of free and low-fee transactions.
+	permRelay
+
+	// permMemPool indicates the peer may request the contents of the
+	// memory pool without being rate limited.
+	permMemPool
+
+	// permNoConnLimit indicates the peer is exempt from the --maxsameip
+	// limit and, when it is inbound, from the --maxpeers limit.
+	permNoConnLimit
+
+	// permDownload indicates the peer is served historical blocks even when
+	// the --maxuploadtarget is reached.
+	permDownload
+
+	// permAll grants all permissions.
+	permAll = permNoBan | permRelay | permMemPool | permNoConnLimit |
+		permDownload
+
+	// permDefault is granted by whitelists which do not specify any
+	// permissions.  It matches the behavior of whitelists before
+	// permissions could be specified.
+	permDefault = permNoBan | permNoConnLimit
+)
+
+// permissionNames maps the names of the permissions accepted by --whitelist
+// to the permissions.  orderedPermissionNames is used to print them in a
+// stable order.
+var permissionNames = map[string]peerPermissions{
+	"noban":       permNoBan,
+	"relay":       permRelay,
+	"mempool":     permMemPool,
+	"noconnlimit": permNoConnLimit,
+	"download":    permDownload,
+	"all":         permAll,
+}
+var orderedPermissionNames = []string{"noban", "relay", "mempool",
+	"noconnlimit", "download"}
+
+// has returns whether all of the passed permissions are granted.
+func (p peerPermissions) has(perms peerPermissions) bool {
+	return p&perms == perms
+}
+
+// String returns the permissions as a comma separated list of their names.
+func (p peerPermissions) String() string {
+	names := make([]string, 0, len(orderedPermissionNames))
+	for _, name := range orderedPermissionNames {
+		if p.has(permissionNames[name]) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// whitelist grants permissions to the peers within an IP network.
+type whitelist struct {
+	ipnet *net.IPNet
+	perms peerPermissions
+}
+
+// parseWhitelist parses a --whitelist value.  The value is an IP address or
+// network, optionally prefixed with a comma separated list of permissions and
+// an @ sign, such as noban,mempool@192.168.1.0/24.  The noban and noconnlimit
+// permissions are granted when none are specified.
+func parseWhitelist(s string) (*whitelist, error) {
+	perms := permDefault
+	addr := s
+	if i := strings.LastIndex(s, "@"); i != -1 {
+		perms = 0
+		addr = s[i+1:]
+		for _, name := range strings.Split(s[:i], ",") {
+			perm, ok := permissionNames[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown permission %q", name)
+			}
+			perms |= perm
+		}
+	}
+
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address or network %q",
+				addr)
+		}
+		var bits int
+		if ip.To4() == nil {
+			// IPv6
+			bits = 128
+		} else {
+			bits = 32
+		}
+		ipnet = &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		}
+	}
+	return &whitelist{ipnet: ipnet, perms: perms}, nil
+}
+
+// whitelistPermissions returns the permissions granted to the IP address by
+// all whitelists which include it.
+func whitelistPermissions(addr net.Addr) peerPermissions {
+	if len(cfg.whitelists) == 0 {
+		return 0
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		srvrLog.Warnf("Unable to SplitHostPort on '%s': %v", addr, err)
+		return 0
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		srvrLog.Warnf("Unable to parse IP '%s'", addr)
+		return 0
+	}
+
+	var perms peerPermissions
+	for _, wl := range cfg.whitelists {
+		if wl.ipnet.Contains(ip) {
+			perms |= wl.perms
+		}
+	}
+	return perms
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// TestParseWhitelist ensures whitelist values are parsed into the expected
+// networks and permissions.
+func TestParseWhitelist(t *testing.T) {
+	tests := []struct {
+		in    string
+		ipnet string
+		perms peerPermissions
+		err   bool
+	}{
+		{in: "127.0.0.1", ipnet: "127.0.0.1/32", perms: permDefault},
+		{in: "::1", ipnet: "::1/128", perms: permDefault},
+		{in: "192.168.1.5/24", ipnet: "192.168.1.0/24", perms: permDefault},
+		{in: "noban@10.0.0.0/8", ipnet: "10.0.0.0/8", perms: permNoBan},
+		{
+			in:    "noban,mempool@fd00::/16",
+			ipnet: "fd00::/16",
+			perms: permNoBan | permMemPool,
+		},
+		{
+			in:    "relay,noconnlimit@1.2.3.4",
+			ipnet: "1.2.3.4/32",
+			perms: permRelay | permNoConnLimit,
+		},
+		{in: "download@1.2.3.4", ipnet: "1.2.3.4/32", perms: permDownload},
+		{in: "all@1.2.3.4", ipnet: "1.2.3.4/32", perms: permAll},
+		{in: "bogus@1.2.3.4", err: true},
+		{in: "@1.2.3.4", err: true},
+		{in: "noban@", err: true},
+		{in: "1.2.3", err: true},
+	}
+
+	for _, test := range tests {
+		wl, err := parseWhitelist(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseWhitelist(%q): unexpected success",
+					test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWhitelist(%q): unexpected error: %v", test.in,
+				err)
+			continue
+		}
+		if wl.ipnet.String() != test.ipnet {
+			t.Errorf("parseWhitelist(%q): got network %v, want %v",
+				test.in, wl.ipnet, test.ipnet)
+		}
+		if wl.perms != test.perms {
+			t.Errorf("parseWhitelist(%q): got permissions %v, want %v",
+				test.in, wl.perms, test.perms)
+		}
+	}
+}
+
+// TestPeerPermissionsString ensures permissions are printed as the expected
+// comma separated list of their names.
+func TestPeerPermissionsString(t *testing.T) {
+	tests := []struct {
+		perms peerPermissions
+		want  string
+	}{
+		{0, ""},
+		{permNoBan, "noban"},
+		{permMemPool | permNoBan, "noban,mempool"},
+		{permAll, "noban,relay,mempool,noconnlimit,download"},
+	}
+
+	for _, test := range tests {
+		if got := test.perms.String(); got != test.want {
+			t.Errorf("String: got %q, want %q", got, test.want)
+		}
+	}
+}
@@ -756,6 +756,49 @@ func (c *Client) GetStakeVersions(hash string, count int32) (*bitumjson.GetStake
 	return c.GetStakeVersionsAsync(hash, count).Receive()
 }
 
+// FutureGetUpdateInfoResult is a future promise to deliver the result of a
+// GetUpdateInfoAsync RPC invocation (or an applicable error).
+type FutureGetUpdateInfoResult chan *response
+
+// Receive waits for the response promised by the future and returns the
+// updater state and the CodechainHead distribution of recent blocks.
+func (r FutureGetUpdateInfoResult) Receive() (*bitumjson.GetUpdateInfoResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a bitumjson.GetUpdateInfoResult.
+	var guir bitumjson.GetUpdateInfoResult
+	err = json.Unmarshal(res, &guir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &guir, nil
+}
+
+// GetUpdateInfoAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See GetUpdateInfo for the blocking version and more details.
+//
+// NOTE: This is a bitumd extension.
+func (c *Client) GetUpdateInfoAsync(numBlocks *int) FutureGetUpdateInfoResult {
+	cmd := bitumjson.NewGetUpdateInfoCmd(numBlocks)
+	return c.sendCmd(cmd)
+}
+
+// GetUpdateInfo returns the state of the Codechain updater and the
+// distribution of the CodechainHead values over the given number of most
+// recent main chain blocks.  Passing nil tallies the default number of blocks.
+//
+// NOTE: This is a bitumd extension.
+func (c *Client) GetUpdateInfo(numBlocks *int) (*bitumjson.GetUpdateInfoResult, error) {
+	return c.GetUpdateInfoAsync(numBlocks).Receive()
+}
+
 // FutureGetTicketPoolValueResult is a future promise to deliver the result of a
 // GetTicketPoolValueAsync RPC invocation (or an applicable error).
 type FutureGetTicketPoolValueResult chan *response
@@ -34,6 +34,25 @@ func (cmd AddNodeCommand) String() string {
 	return string(cmd)
 }
 
+// SetBanCommand enumerates the available commands that the SetBan function
+// accepts.
+type SetBanCommand string
+
+// Constants used to indicate the command for the SetBan function.
+const (
+	// SBAdd indicates the specified IP address or subnet should be banned.
+	SBAdd SetBanCommand = "add"
+
+	// SBRemove indicates the ban of the specified IP address or subnet
+	// should be removed.
+	SBRemove SetBanCommand = "remove"
+)
+
+// String returns the SetBanCommand in human-readable form.
+func (cmd SetBanCommand) String() string {
+	return string(cmd)
+}
+
 // FutureAddNodeResult is a future promise to deliver the result of an
 // AddNodeAsync RPC invocation (or an applicable error).
 type FutureAddNodeResult chan *response
@@ -284,3 +303,99 @@ func (c *Client) GetNetTotalsAsync() FutureGetNetTotalsResult {
 func (c *Client) GetNetTotals() (*bitumjson.GetNetTotalsResult, error) {
 	return c.GetNetTotalsAsync().Receive()
 }
+
+// FutureSetBanResult is a future promise to deliver the result of a
+// SetBanAsync RPC invocation (or an applicable error).
+type FutureSetBanResult chan *response
+
+// Receive waits for the response promised by the future and returns an error if
+// any occurred when performing the specified command.
+func (r FutureSetBanResult) Receive() error {
+	_, err := receiveFuture(r)
+	return err
+}
+
+// SetBanAsync returns an instance of a type that can be used to get the result
+// of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See SetBan for the blocking version and more details.
+func (c *Client) SetBanAsync(subnet string, command SetBanCommand, banTime *int64, absolute *bool) FutureSetBanResult {
+	cmd := bitumjson.NewSetBanCmd(subnet, bitumjson.SetBanSubCmd(command),
+		banTime, absolute)
+	return c.sendCmd(cmd)
+}
+
+// SetBan attempts to perform the passed command on the passed IP address or
+// subnet in CIDR notation.  For example, it can be used to ban a subnet or to
+// remove the ban of a subnet.
+//
+// The ban time is the number of seconds the subnet is banned for, or the unix
+// time the ban expires when absolute is set.  Passing nil for either of them
+// bans the subnet for the default ban duration of the server.
+func (c *Client) SetBan(subnet string, command SetBanCommand, banTime *int64, absolute *bool) error {
+	return c.SetBanAsync(subnet, command, banTime, absolute).Receive()
+}
+
+// FutureListBannedResult is a future promise to deliver the result of a
+// ListBannedAsync RPC invocation (or an applicable error).
+type FutureListBannedResult chan *response
+
+// Receive waits for the response promised by the future and returns the banned
+// subnets.
+func (r FutureListBannedResult) Receive() ([]bitumjson.ListBannedResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of listbanned result objects.
+	var banned []bitumjson.ListBannedResult
+	err = json.Unmarshal(res, &banned)
+	if err != nil {
+		return nil, err
+	}
+
+	return banned, nil
+}
+
+// ListBannedAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See ListBanned for the blocking version and more details.
+func (c *Client) ListBannedAsync() FutureListBannedResult {
+	cmd := bitumjson.NewListBannedCmd()
+	return c.sendCmd(cmd)
+}
+
+// ListBanned returns the banned IP addresses and subnets.
+func (c *Client) ListBanned() ([]bitumjson.ListBannedResult, error) {
+	return c.ListBannedAsync().Receive()
+}
+
+// FutureClearBannedResult is a future promise to deliver the result of a
+// ClearBannedAsync RPC invocation (or an applicable error).
+type FutureClearBannedResult chan *response
+
+// Receive waits for the response promised by the future and returns an error if
+// any occurred when clearing the bans.
+func (r FutureClearBannedResult) Receive() error {
+	_, err := receiveFuture(r)
+	return err
+}
+
+// ClearBannedAsync returns an instance of a type that can be used to get the
+// result of the RPC at some future time by invoking the Receive function on the
+// returned instance.
+//
+// See ClearBanned for the blocking version and more details.
+func (c *Client) ClearBannedAsync() FutureClearBannedResult {
+	cmd := bitumjson.NewClearBannedCmd()
+	return c.sendCmd(cmd)
+}
+
+// ClearBanned removes all bans.
+func (c *Client) ClearBanned() error {
+	return c.ClearBannedAsync().Receive()
+}
@@ -170,6 +170,7 @@ type commandHandler func(*rpcServer, interface{}, <-chan struct{}) (interface{},
 var rpcHandlers map[string]commandHandler
 var rpcHandlersBeforeInit = map[string]commandHandler{
 	"addnode":               handleAddNode,
+	"clearbanned":           handleClearBanned,
 	"createrawsstx":         handleCreateRawSStx,
 	"createrawssrtx":        handleCreateRawSSRtx,
 	"createrawtransaction":  handleCreateRawTransaction,
@@ -218,10 +219,12 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getstakeversioninfo":   handleGetStakeVersionInfo,
 	"getstakeversions":      handleGetStakeVersions,
 	"getticketpoolvalue":    handleGetTicketPoolValue,
+	"getupdateinfo":         handleGetUpdateInfo,
 	"getvoteinfo":           handleGetVoteInfo,
 	"gettxout":              handleGetTxOut,
 	"getwork":               handleGetWork,
 	"help":                  handleHelp,
+	"listbanned":            handleListBanned,
 	"livetickets":           handleLiveTickets,
 	"missedtickets":         handleMissedTickets,
 	"node":                  handleNode,
@@ -230,6 +233,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"rebroadcastmissed":     handleRebroadcastMissed,
 	"rebroadcastwinners":    handleRebroadcastWinners,
 	"sendrawtransaction":    handleSendRawTransaction,
+	"setban":                handleSetBan,
 	"setgenerate":           handleSetGenerate,
 	"stop":                  handleStop,
 	"submitblock":           handleSubmitBlock,
@@ -594,6 +598,69 @@ func handleNode(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return nil, nil
 }
 
+// handleClearBanned handles clearbanned commands.
+func handleClearBanned(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	s.server.ClearBanned()
+
+	// no data returned unless an error.
+	return nil, nil
+}
+
+// handleListBanned handles listbanned commands.
+func handleListBanned(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	bans := s.server.BannedSubnets()
+	results := make([]bitumjson.ListBannedResult, 0, len(bans))
+	for _, ban := range bans {
+		results = append(results, bitumjson.ListBannedResult{
+			Address:     ban.subnet.String(),
+			BanCreated:  ban.created.Unix(),
+			BannedUntil: ban.until.Unix(),
+			BanReason:   ban.reason,
+		})
+	}
+	return results, nil
+}
+
+// handleSetBan handles setban commands.
+func handleSetBan(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*bitumjson.SetBanCmd)
+
+	subnet, err := parseSubnet(c.Subnet)
+	if err != nil {
+		return nil, rpcInvalidError("%v: %v", c.SubCmd, err)
+	}
+
+	switch c.SubCmd {
+	case "add":
+		// The ban time is either the number of seconds to ban the
+		// subnet for or the unix time the ban expires when absolute is
+		// set.  Zero selects the default ban duration.
+		var banTime int64
+		if c.BanTime != nil {
+			banTime = *c.BanTime
+		}
+		absolute := c.Absolute != nil && *c.Absolute
+		var until time.Time
+		until, err = banUntil(banTime, absolute, cfg.BanDuration,
+			time.Now())
+		if err != nil {
+			return nil, rpcInvalidError("%v: %v", c.SubCmd, err)
+		}
+		err = s.server.BanSubnet(subnet, until)
+	case "remove":
+		err = s.server.UnbanSubnet(subnet)
+	default:
+		return nil, rpcInvalidError("Invalid subcommand for setban")
+	}
+
+	if err != nil {
+		return nil, rpcInvalidError("%v: %v", c.SubCmd, err)
+	}
+
+	// no data returned unless an error.
+	return nil, nil
+}
+
 // peerExists determines if a certain peer is currently connected given
 // information about all currently connected peers. Peer existence is
 // determined using either a target address or node id.
@@ -1880,6 +1947,7 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		Difficulty:    getDifficultyRatio(blockHeader.Bits),
 		ChainWork:     fmt.Sprintf("%064x", chainWork),
 		ExtraData:     hex.EncodeToString(blockHeader.ExtraData[:]),
+		CodechainHead: hex.EncodeToString(blockHeader.CodechainHead[:]),
 		NextHash:      nextHashString,
 	}
 
@@ -2105,6 +2173,7 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 		Nonce:         blockHeader.Nonce,
 		ExtraData:     hex.EncodeToString(blockHeader.ExtraData[:]),
 		StakeVersion:  blockHeader.StakeVersion,
+		CodechainHead: hex.EncodeToString(blockHeader.CodechainHead[:]),
 		Difficulty:    getDifficultyRatio(blockHeader.Bits),
 		ChainWork:     fmt.Sprintf("%064x", chainWork),
 		PreviousHash:  blockHeader.PrevBlock.String(),
@@ -3267,10 +3336,19 @@ func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 // handleGetNetTotals implements the getnettotals command.
 func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	totalBytesRecv, totalBytesSent := s.server.NetTotals()
+	target := s.server.uploadTarget
+	reached := target.reached()
 	reply := &bitumjson.GetNetTotalsResult{
 		TotalBytesRecv: totalBytesRecv,
 		TotalBytesSent: totalBytesSent,
 		TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		UploadTarget: bitumjson.UploadTargetResult{
+			TimeFrame:             int64(uploadTargetTimeframe / time.Second),
+			Target:                target.target,
+			TargetReached:         reached,
+			ServeHistoricalBlocks: !reached,
+			BytesLeft:             target.remaining(),
+		},
 	}
 	return reply, nil
 }
@@ -3393,12 +3471,23 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 			TimeOffset:     statsSnap.TimeOffset,
 			Version:        statsSnap.Version,
 			SubVer:         statsSnap.UserAgent,
+			Transport:      statsSnap.Transport.String(),
 			Inbound:        statsSnap.Inbound,
+			BlockRelayOnly: p.blockRelayOnly,
+			MappedAS:       s.server.addrManager.ASN(p.netAddress()),
 			StartingHeight: statsSnap.StartingHeight,
 			CurrentHeight:  statsSnap.LastBlock,
 			BanScore:       int32(p.banScore.Int()),
 			SyncNode:       p == syncPeer,
 		}
+		if statsSnap.TreeHash != zeroHash {
+			info.TreeHash = hex.EncodeToString(statsSnap.TreeHash[:])
+		}
+		var lastSyncStall time.Time
+		info.SyncStalls, lastSyncStall = p.syncStallStats()
+		if info.SyncStalls > 0 {
+			info.LastSyncStall = lastSyncStall.Unix()
+		}
 		if p.LastPingNonce() != 0 {
 			wait := float64(time.Since(statsSnap.LastPingTime).Nanoseconds())
 			// We actually want microseconds.
@@ -3752,6 +3841,90 @@ func handleGetTicketPoolValue(s *rpcServer, cmd interface{}, closeChan <-chan st
 	return amt.ToCoin(), nil
 }
 
+// handleGetUpdateInfo implements the getupdateinfo command.
+func handleGetUpdateInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*bitumjson.GetUpdateInfoCmd)
+
+	numBlocks := int64(1000)
+	if c.Blocks != nil {
+		numBlocks = int64(*c.Blocks)
+	}
+	if numBlocks <= 0 {
+		return nil, rpcInvalidError("Number of blocks must be positive")
+	}
+
+	result := bitumjson.GetUpdateInfoResult{
+		TreeHash: treehash,
+	}
+
+	// Report the state of the local hashchain when the updater is enabled.
+	// The tree hash of the running binary can only be signed if the local
+	// hashchain knows about it.
+	if um := s.server.updateManager; um != nil {
+		head := um.Head()
+		lastSigned := um.LastSignedTreeHash()
+		result.HashchainHead = hex.EncodeToString(head[:])
+		result.LastSignedTreeHash = hex.EncodeToString(lastSigned[:])
+		for _, signed := range um.SignedTreeHashes() {
+			if hex.EncodeToString(signed[:]) == treehash {
+				result.TreeHashSigned = true
+				break
+			}
+		}
+
+		status := um.AutoUpdateStatus()
+		result.AutoUpdate = &bitumjson.AutoUpdateResult{
+			Enabled:        status.Enabled,
+			Probability:    status.Probability,
+			Window:         status.Window,
+			SourceTreeHash: status.SourceTreeHash,
+			TargetTreeHash: status.TargetTreeHash,
+			Decision:       status.Decision.String(),
+		}
+		if !status.LastCheck.IsZero() {
+			result.AutoUpdate.LastCheck = status.LastCheck.Unix()
+		}
+	}
+
+	// Tally the CodechainHead values of the requested number of most recent
+	// main chain blocks.
+	best := s.chain.BestSnapshot()
+	result.EndHeight = best.Height
+	result.StartHeight = best.Height - numBlocks + 1
+	if result.StartHeight < 0 {
+		result.StartHeight = 0
+	}
+	counts := make(map[[32]byte]uint32)
+	for height := result.StartHeight; height <= result.EndHeight; height++ {
+		header, err := s.chain.HeaderByHeight(height)
+		if err != nil {
+			context := "Failed to fetch block header"
+			return nil, rpcInternalError(err.Error(), context)
+		}
+		counts[header.CodechainHead]++
+	}
+
+	// Order the CodechainHead values by the number of blocks which published
+	// them, most popular first.
+	result.CodechainHeads = make([]bitumjson.CodechainHeadCount, 0, len(counts))
+	for head, count := range counts {
+		result.CodechainHeads = append(result.CodechainHeads,
+			bitumjson.CodechainHeadCount{
+				CodechainHead: hex.EncodeToString(head[:]),
+				Count:         count,
+			})
+	}
+	sort.Slice(result.CodechainHeads, func(i, j int) bool {
+		a, b := result.CodechainHeads[i], result.CodechainHeads[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.CodechainHead < b.CodechainHead
+	})
+
+	return &result, nil
+}
+
 // handleGetVoteInfo implements the getvoteinfo command.
 func handleGetVoteInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c, ok := cmd.(*bitumjson.GetVoteInfoCmd)
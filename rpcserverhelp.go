@@ -40,6 +40,26 @@ var helpDescsEnUS = map[string]string{
 	"node-target":        "Either the IP address and port of the peer to operate on, or a valid peer ID.",
 	"node-connectsubcmd": "'perm' to make the connected peer a permanent one, 'temp' to try a single connect to a peer",
 
+	// SetBanCmd help.
+	"setban--synopsis": "Attempts to add or remove the ban of an IP address or subnet.\n" +
+		"Peers within a newly banned subnet are disconnected.  Bans are saved to disk and survive restarts.",
+	"setban-subnet":   "IP address or subnet in CIDR notation (e.g. 192.168.0.0/16) to operate on",
+	"setban-subcmd":   "'add' to ban the IP address or subnet, 'remove' to remove its ban",
+	"setban-bantime":  "Number of seconds to ban for, or the unix time the ban expires when absolute is set (0 for the --banduration of the server, at most 100 years)",
+	"setban-absolute": "Whether or not bantime is the unix time the ban expires",
+
+	// ListBannedCmd help.
+	"listbanned--synopsis": "Returns all banned IP addresses and subnets.",
+
+	// ListBannedResult help.
+	"listbannedresult-address":     "The banned IP address or subnet in CIDR notation",
+	"listbannedresult-bancreated":  "The unix time the ban was created",
+	"listbannedresult-banneduntil": "The unix time the ban expires",
+	"listbannedresult-banreason":   "The reason for the ban",
+
+	// ClearBannedCmd help.
+	"clearbanned--synopsis": "Removes all bans.",
+
 	// TransactionInput help.
 	"transactioninput-amount": "The previous output amount",
 	"transactioninput-txid":   "The hash of the input transaction",
@@ -319,6 +339,7 @@ var helpDescsEnUS = map[string]string{
 	"getblockverboseresult-finalstate":        "The block's finalstate",
 	"getblockverboseresult-extradata":         "Extra data field for the requested block",
 	"getblockverboseresult-stakeversion":      "Stake Version of the block",
+	"getblockverboseresult-codechainhead":     "The Codechain source tree hash the miner of the block was running",
 
 	// GetBlockCountCmd help.
 	"getblockcount--synopsis": "Returns the number of blocks in the longest block chain.",
@@ -361,6 +382,7 @@ var helpDescsEnUS = map[string]string{
 	"getblockheaderverboseresult-stakeroot":         "The merkle root of the stake transaction tree",
 	"getblockheaderverboseresult-extradata":         "Extra data field for the requested block",
 	"getblockheaderverboseresult-stakeversion":      "The stake version of the block",
+	"getblockheaderverboseresult-codechainhead":     "The Codechain source tree hash the miner of the block was running",
 
 	// GetBlockSubsidyCmd help.
 	"getblocksubsidy--synopsis": "Returns information regarding subsidy amounts.",
@@ -502,6 +524,27 @@ var helpDescsEnUS = map[string]string{
 	"versionbits-version":                  "The version of the vote.",
 	"versionbits-bits":                     "The bits assigned by the vote.",
 
+	// GetUpdateInfoCmd help.
+	"getupdateinfo--synopsis":                "Returns the state of the Codechain updater and the distribution of the CodechainHead values of recent main chain blocks.",
+	"getupdateinfo-blocks":                   "The number of most recent main chain blocks to tally.",
+	"getupdateinforesult-treehash":           "The Codechain source tree hash the running binary was built from.",
+	"getupdateinforesult-treehashsigned":     "Whether the tree hash of the running binary is signed in the local hashchain.",
+	"getupdateinforesult-hashchainhead":      "The head of the local hashchain (omitted when the updater is disabled).",
+	"getupdateinforesult-lastsignedtreehash": "The last signed tree hash of the local hashchain (omitted when the updater is disabled).",
+	"getupdateinforesult-autoupdate":         "The automatic update settings and the outcome of the last automatic update check (omitted when the updater is disabled).",
+	"autoupdateresult-enabled":               "Whether automatic updates of the source tree are enabled.",
+	"autoupdateresult-probability":           "The probability that the source tree is updated on a given day.",
+	"autoupdateresult-window":                "The number of signed tree hashes the source tree may fall behind before it is updated regardless of the probability.",
+	"autoupdateresult-lastcheck":             "The time of the last automatic update check in seconds since 1 Jan 1970 GMT, or 0 if none happened yet.",
+	"autoupdateresult-sourcetreehash":        "The tree hash of the source tree at the last check.",
+	"autoupdateresult-targettreehash":        "The last signed tree hash at the last check.",
+	"autoupdateresult-decision":              "The outcome of the last check (none, up to date, source tree not signed, patch files missing, skipped, updated, forced update, or failed).",
+	"getupdateinforesult-startheight":        "The height of the first tallied block.",
+	"getupdateinforesult-endheight":          "The height of the last tallied block.",
+	"getupdateinforesult-codechainheads":     "The CodechainHead values of the tallied blocks, most popular first.",
+	"codechainheadcount-codechainhead":       "The CodechainHead published in the block headers.",
+	"codechainheadcount-count":               "The number of tallied blocks which published it.",
+
 	// GetVoteInfo
 	"getvoteinfo--synopsis":           "Returns the vote info statistics.",
 	"getvoteinfo-version":             "The stake version.",
@@ -613,6 +656,14 @@ var helpDescsEnUS = map[string]string{
 	"getnettotalsresult-totalbytesrecv": "Total bytes received",
 	"getnettotalsresult-totalbytessent": "Total bytes sent",
 	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
+	"getnettotalsresult-uploadtarget":   "The upload target set by --maxuploadtarget",
+
+	// UploadTargetResult help.
+	"uploadtargetresult-timeframe":             "Length of the rolling window the target applies to in seconds",
+	"uploadtargetresult-target":                "Max bytes to upload within the window, 0 for no limit",
+	"uploadtargetresult-targetreached":         "Whether the target is reached",
+	"uploadtargetresult-servehistoricalblocks": "Whether historical blocks are served to peers without the download permission",
+	"uploadtargetresult-bytesleft":             "Bytes left to upload within the window, 0 when there is no target",
 
 	// GetPeerInfoResult help.
 	"getpeerinforesult-id":             "A unique node ID",
@@ -630,11 +681,17 @@ var helpDescsEnUS = map[string]string{
 	"getpeerinforesult-pingwait":       "Number of microseconds a queued ping has been waiting for a response",
 	"getpeerinforesult-version":        "The protocol version of the peer",
 	"getpeerinforesult-subver":         "The user agent of the peer",
+	"getpeerinforesult-treehash":       "The Codechain tree hash of the source tree the peer is running (omitted if not advertised)",
+	"getpeerinforesult-transport":      "The transport used with the peer (v1: plaintext, v2: encrypted and authenticated)",
 	"getpeerinforesult-inbound":        "Whether or not the peer is an inbound connection",
+	"getpeerinforesult-blockrelayonly": "Whether or not the peer is an outbound block-relay-only connection which relays no transactions or addresses",
+	"getpeerinforesult-mappedas":       "The autonomous system number (ASN) of the peer according to the asmap (omitted if no asmap is used or the peer is not mapped)",
 	"getpeerinforesult-startingheight": "The latest block height the peer knew about when the connection was established",
 	"getpeerinforesult-currentheight":  "The current height of the peer",
 	"getpeerinforesult-banscore":       "The ban score",
 	"getpeerinforesult-syncnode":       "Whether or not the peer is the sync peer",
+	"getpeerinforesult-syncstalls":     "The number of times the peer was replaced as the sync peer for making no progress while other peers had more blocks",
+	"getpeerinforesult-lastsyncstall":  "The time the peer was last replaced as the sync peer for stalling in seconds since 1 Jan 1970 GMT (omitted if it never was)",
 
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
@@ -943,6 +1000,7 @@ var helpDescsEnUS = map[string]string{
 // pointer to the type (or nil to indicate no return value).
 var rpcResultTypes = map[string][]interface{}{
 	"addnode":               nil,
+	"clearbanned":           nil,
 	"createrawsstx":         {(*string)(nil)},
 	"createrawssrtx":        {(*string)(nil)},
 	"createrawtransaction":  {(*string)(nil)},
@@ -992,10 +1050,12 @@ var rpcResultTypes = map[string][]interface{}{
 	"getrawtransaction":     {(*string)(nil), (*bitumjson.TxRawResult)(nil)},
 	"getticketpoolvalue":    {(*float64)(nil)},
 	"gettxout":              {(*bitumjson.GetTxOutResult)(nil)},
+	"getupdateinfo":         {(*bitumjson.GetUpdateInfoResult)(nil)},
 	"getvoteinfo":           {(*bitumjson.GetVoteInfoResult)(nil)},
 	"getwork":               {(*bitumjson.GetWorkResult)(nil), (*bool)(nil)},
 	"getcoinsupply":         {(*int64)(nil)},
 	"help":                  {(*string)(nil), (*string)(nil)},
+	"listbanned":            {(*[]bitumjson.ListBannedResult)(nil)},
 	"livetickets":           {(*bitumjson.LiveTicketsResult)(nil)},
 	"missedtickets":         {(*bitumjson.MissedTicketsResult)(nil)},
 	"node":                  nil,
@@ -1004,6 +1064,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"rebroadcastwinners":    nil,
 	"searchrawtransactions": {(*string)(nil), (*[]bitumjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":    {(*string)(nil)},
+	"setban":                nil,
 	"setgenerate":           nil,
 	"stop":                  {(*string)(nil)},
 	"submitblock":           {nil, (*string)(nil)},
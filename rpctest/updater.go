@@ -0,0 +1,156 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/peer"
+	"github.com/bitum-project/bitumd/updater"
+	"github.com/bitum-project/bitumd/wire"
+)
+
+const (
+	// updatePeerTimeout is the time to wait for a harness node to complete
+	// the version handshake with a peer or to answer a request.
+	updatePeerTimeout = time.Second * 10
+)
+
+// Update is a Codechain hashchain along with the patch files of its signed
+// tree hashes which is pushed to harness nodes.
+type Update struct {
+	// Lines are the lines of the hashchain.
+	Lines []string
+
+	// Patches maps signed tree hashes to the patch files which result in
+	// them.
+	Patches map[chainhash.Hash][]byte
+}
+
+// Head returns the head of the hashchain of the update, which is the hash of
+// its last line.
+func (u *Update) Head() chainhash.Hash {
+	return sha256.Sum256([]byte(u.Lines[len(u.Lines)-1]))
+}
+
+// UpdatePeer is a peer connected to a harness node which announces an update
+// to it and serves the hashchain entries and patch files it requests.
+type UpdatePeer struct {
+	peer *peer.Peer
+	push *updater.PushUpdate
+}
+
+// Announce announces the head of the update to the harness node, which
+// requests the hashchain entries it does not know yet in turn.
+func (p *UpdatePeer) Announce() {
+	p.peer.QueueMessage(p.push.HeadInvMsg(), nil)
+}
+
+// Disconnect disconnects the peer from the harness node.
+func (p *UpdatePeer) Disconnect() {
+	p.peer.Disconnect()
+}
+
+// WaitForDisconnect returns true if the harness node disconnects the peer
+// within the given timeout, for example because it banned the peer for
+// repeatedly sending invalid hashchain entries, and false otherwise.
+func (p *UpdatePeer) WaitForDisconnect(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.peer.WaitForDisconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// connectPeer connects a peer with the given message listeners to the harness
+// node and waits for the version handshake to complete.
+func (h *Harness) connectPeer(listeners peer.MessageListeners) (*peer.Peer, error) {
+	verack := make(chan struct{}, 1)
+	onVerAck := listeners.OnVerAck
+	listeners.OnVerAck = func(p *peer.Peer, msg *wire.MsgVerAck) {
+		if onVerAck != nil {
+			onVerAck(p, msg)
+		}
+		verack <- struct{}{}
+	}
+	config := peer.Config{
+		UserAgentName:  "rpctest",
+		ChainParams:    h.ActiveNet,
+		DisableRelayTx: true,
+		Listeners:      listeners,
+	}
+	p, err := peer.NewOutboundPeer(&config, h.node.config.listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", p.Addr(), updatePeerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p.AssociateConnection(conn)
+
+	select {
+	case <-verack:
+	case <-time.After(updatePeerTimeout):
+		p.Disconnect()
+		return nil, fmt.Errorf("verack timeout on peer %v", p.Addr())
+	}
+	return p, nil
+}
+
+// PushUpdate connects a peer to the harness node, announces the head of the
+// given update, and serves the hashchain entries and patch files the node
+// requests in turn, the same way bitumupdate does.
+//
+// The returned peer must be disconnected by the caller.
+func (h *Harness) PushUpdate(u *Update) (*UpdatePeer, error) {
+	push := updater.NewPushUpdate(u.Lines, u.Patches)
+	p, err := h.connectPeer(push.Listeners(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	up := &UpdatePeer{peer: p, push: push}
+	up.Announce()
+	return up, nil
+}
+
+// FetchPatch requests the patch file for the given tree hash from the harness
+// node via the peer-to-peer protocol and returns it.  An error is returned if
+// the node does not send it in time, which is the case if it does not have it.
+func (h *Harness) FetchPatch(treeHash *chainhash.Hash) ([]byte, error) {
+	patches := make(chan []byte, 1)
+	p, err := h.connectPeer(peer.MessageListeners{
+		OnPatch: func(p *peer.Peer, msg *wire.MsgPatch) {
+			if msg.TreeHash == *treeHash {
+				patches <- msg.Patch
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer p.Disconnect()
+
+	p.QueueMessage(wire.NewMsgGetPatch(treeHash), nil)
+	select {
+	case patch := <-patches:
+		return patch, nil
+	case <-time.After(updatePeerTimeout):
+		return nil, fmt.Errorf("no patch file for tree hash %s",
+			hex.EncodeToString(treeHash[:]))
+	}
+}
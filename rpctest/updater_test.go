@@ -0,0 +1,307 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file is ignored during the regular tests due to the following build tag.
+// +build rpctest
+
+package rpctest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitum-project/bitumd/chaincfg"
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/patchfile"
+	"github.com/frankbraun/codechain/tree"
+	"golang.org/x/crypto/ed25519"
+)
+
+// regnetSigner returns the throwaway signer which starts the regnet hashchain.
+func regnetSigner() ed25519.PrivateKey {
+	seed := sha256.Sum256([]byte("bitum regnet codechain signer"))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+// newTestUpdate creates a regnet hashchain in the given directory and returns
+// it as an update along with the tree hash it publishes and the secret key of
+// the second signer.  The regnet signer adds a second signer and raises the
+// signature threshold to 2, so the published tree hash is only signed once
+// both signers signed it.
+func newTestUpdate(t *testing.T, dir string) (*Update, chainhash.Hash, ed25519.PrivateKey) {
+	t.Helper()
+
+	secKey := func(key ed25519.PrivateKey) [64]byte {
+		var sec [64]byte
+		copy(sec[:], key)
+		return sec
+	}
+	regnetKey := regnetSigner()
+	_, secondKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create the source tree and its patch file.
+	emptyDir := filepath.Join(dir, "empty")
+	srcDir := filepath.Join(dir, "src")
+	for _, d := range []string{emptyDir, srcDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(srcDir, "README.md"),
+		[]byte("bitum rpctest release\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	treeHash, err := tree.Hash(srcDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch bytes.Buffer
+	err = patchfile.Diff(patchfile.Version, &patch, emptyDir, srcDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create the hashchain.
+	hc, _, err := hashchain.Start(filepath.Join(dir, "hashchain"),
+		secKey(regnetKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+	var pub [32]byte
+	var sig [64]byte
+	copy(pub[:], secondKey[32:])
+	copy(sig[:], ed25519.Sign(secondKey, pub[:]))
+	steps := []func() (string, error){
+		func() (string, error) { return hc.AddKey(1, pub, sig, nil) },
+		func() (string, error) {
+			return hc.Signature(hc.Head(), secKey(regnetKey), false)
+		},
+		func() (string, error) { return hc.SignatureControl(2) },
+		func() (string, error) {
+			return hc.Signature(hc.Head(), secKey(regnetKey), false)
+		},
+		func() (string, error) {
+			return hc.Source(*treeHash, secKey(secondKey),
+				[]byte("release 1"))
+		},
+		func() (string, error) {
+			return hc.Signature(hc.Head(), secKey(secondKey), false)
+		},
+		func() (string, error) {
+			return hc.Signature(hc.Head(), secKey(regnetKey), false)
+		},
+	}
+	for _, step := range steps {
+		if _, err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := hc.Fprint(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Update{
+		Lines: strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"),
+		Patches: map[chainhash.Hash][]byte{
+			chainhash.Hash(*treeHash): patch.Bytes(),
+		},
+	}
+	return u, chainhash.Hash(*treeHash), secondKey
+}
+
+// setUpRegNetNodes creates and sets up the given number of regnet harnesses.
+func setUpRegNetNodes(t *testing.T, num int) []*Harness {
+	t.Helper()
+
+	nodes := make([]*Harness, 0, num)
+	for i := 0; i < num; i++ {
+		h, err := New(&chaincfg.RegNetParams, nil, nil)
+		if err != nil {
+			tearDownNodes(nodes)
+			t.Fatalf("unable to create regnet harness: %v", err)
+		}
+		if err := h.SetUp(false, 0); err != nil {
+			_ = h.TearDown()
+			tearDownNodes(nodes)
+			t.Fatalf("unable to set up regnet harness: %v", err)
+		}
+		nodes = append(nodes, h)
+	}
+	return nodes
+}
+
+// tearDownNodes tears down the given harnesses.
+func tearDownNodes(nodes []*Harness) {
+	for _, h := range nodes {
+		_ = h.TearDown()
+	}
+}
+
+// waitForHead blocks until the given harness reports the given hashchain head
+// or the timeout expires.
+func waitForHead(h *Harness, head string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := h.Node.GetUpdateInfo(nil)
+		if err != nil {
+			return err
+		}
+		if info.HashchainHead == head {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("hashchain head %s, want %s",
+				info.HashchainHead, head)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// TestUpdatePropagation ensures an update pushed to one node reaches every
+// node of the network, so that all of them agree on the hashchain head and
+// the last signed tree hash and serve the patch file.
+func TestUpdatePropagation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpctest-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	u, treeHash, _ := newTestUpdate(t, dir)
+
+	// Connect the nodes in a line, so the update has to be relayed more
+	// than once to reach the last node.
+	nodes := setUpRegNetNodes(t, 3)
+	defer tearDownNodes(nodes)
+	for i := 1; i < len(nodes); i++ {
+		if err := ConnectNode(nodes[i], nodes[i-1]); err != nil {
+			t.Fatalf("unable to connect nodes: %v", err)
+		}
+	}
+
+	pusher, err := nodes[0].PushUpdate(u)
+	if err != nil {
+		t.Fatalf("unable to push update: %v", err)
+	}
+	defer pusher.Disconnect()
+
+	// Wait for the first node to accept the update before joining the
+	// nodes, since they agree on the empty hashchain until then.
+	head := u.Head()
+	wantHead := hex.EncodeToString(head[:])
+	if err := waitForHead(nodes[0], wantHead, updatePeerTimeout); err != nil {
+		t.Fatalf("update not accepted: %v", err)
+	}
+	if err := JoinNodes(nodes, Updates); err != nil {
+		t.Fatalf("unable to join nodes on updates: %v", err)
+	}
+
+	wantTreeHash := hex.EncodeToString(treeHash[:])
+	for i, h := range nodes {
+		info, err := h.Node.GetUpdateInfo(nil)
+		if err != nil {
+			t.Fatalf("node %d: getupdateinfo failed: %v", i, err)
+		}
+		if info.HashchainHead != wantHead {
+			t.Errorf("node %d: hashchain head %s, want %s", i,
+				info.HashchainHead, wantHead)
+		}
+		if info.LastSignedTreeHash != wantTreeHash {
+			t.Errorf("node %d: last signed tree hash %s, want %s", i,
+				info.LastSignedTreeHash, wantTreeHash)
+		}
+
+		// The patch file is relayed after the hashchain entries, so
+		// give it some time to arrive.
+		var patch []byte
+		for try := 0; try < 3; try++ {
+			patch, err = h.FetchPatch(&treeHash)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Errorf("node %d: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(patch, u.Patches[treeHash]) {
+			t.Errorf("node %d: patch file does not match", i)
+		}
+	}
+}
+
+// TestUpdateInvalidSignature ensures a node rejects hashchain entries with an
+// invalid signature, keeps its hashchain, and bans the peer which sends them.
+func TestUpdateInvalidSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpctest-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	u, _, secondKey := newTestUpdate(t, dir)
+
+	// Append a source entry for another tree hash by the second signer
+	// which is signed by a key which is not a signer.
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTree := sha256.Sum256([]byte("bogus tree"))
+	datum := strings.SplitN(u.Lines[len(u.Lines)-1], " ", 3)[1]
+	head := u.Head()
+	encode := base64.RawURLEncoding.EncodeToString
+	u.Lines = append(u.Lines, fmt.Sprintf("%x %s source %x %s %s", head[:],
+		datum, otherTree[:], encode(secondKey[32:]),
+		encode(ed25519.Sign(otherKey, otherTree[:]))))
+	u.Patches = nil
+
+	nodes := setUpRegNetNodes(t, 1)
+	defer tearDownNodes(nodes)
+	before, err := nodes[0].Node.GetUpdateInfo(nil)
+	if err != nil {
+		t.Fatalf("getupdateinfo failed: %v", err)
+	}
+
+	pusher, err := nodes[0].PushUpdate(u)
+	if err != nil {
+		t.Fatalf("unable to push update: %v", err)
+	}
+	defer pusher.Disconnect()
+
+	// Invalid hashchain entries increase the ban score of the peer by the
+	// ban threshold, so the peer is banned once it sends them again.
+	disconnected := pusher.WaitForDisconnect(time.Second * 2)
+	for try := 0; try < 3 && !disconnected; try++ {
+		pusher.Announce()
+		disconnected = pusher.WaitForDisconnect(time.Second * 2)
+	}
+	if !disconnected {
+		t.Fatal("peer sending an invalid signature was not banned")
+	}
+
+	after, err := nodes[0].Node.GetUpdateInfo(nil)
+	if err != nil {
+		t.Fatalf("getupdateinfo failed: %v", err)
+	}
+	if after.HashchainHead != before.HashchainHead {
+		t.Fatalf("hashchain head changed from %s to %s",
+			before.HashchainHead, after.HashchainHead)
+	}
+}
@@ -26,6 +26,10 @@ const (
 	// Mempools is a JoinType which blocks until all nodes have identical
 	// mempool.
 	Mempools
+
+	// Updates is a JoinType which blocks until all nodes report the same
+	// hashchain head and last signed tree hash.
+	Updates
 )
 
 // JoinNodes is a synchronization tool used to block until all passed nodes are
@@ -40,6 +44,8 @@ func JoinNodes(nodes []*Harness, joinType JoinType) error {
 		return syncBlocks(nodes)
 	case Mempools:
 		return syncMempools(nodes)
+	case Updates:
+		return syncUpdates(nodes)
 	}
 	return nil
 }
@@ -76,6 +82,35 @@ func syncMempools(nodes []*Harness) error {
 	return nil
 }
 
+// syncUpdates blocks until all nodes report the same hashchain head and last
+// signed tree hash.
+func syncUpdates(nodes []*Harness) error {
+	updatesMatch := false
+
+	for !updatesMatch {
+	retry:
+		states := make(map[[2]string]struct{})
+
+		for _, node := range nodes {
+			info, err := node.Node.GetUpdateInfo(nil)
+			if err != nil {
+				return err
+			}
+
+			state := [2]string{info.HashchainHead, info.LastSignedTreeHash}
+			states[state] = struct{}{}
+			if len(states) > 1 {
+				time.Sleep(time.Millisecond * 100)
+				goto retry
+			}
+		}
+
+		updatesMatch = true
+	}
+
+	return nil
+}
+
 // syncBlocks blocks until all nodes report the same block height.
 func syncBlocks(nodes []*Harness) error {
 	blocksMatch := false
@@ -115,6 +115,38 @@ const FileContents = `[Application Options]
 ; Maximum number of inbound and outbound peers.
 ; maxpeers=8
 
+; Maximum number of MiB to upload to peers within a rolling 24 hour window.
+; Once it is reached, historical blocks (older than a week) are no longer served
+; to peers without the download whitelist permission.  The same applies to a
+; single peer once the block data sent to it reached an eighth of the target.
+; 0 disables the limit.
+; maxuploadtarget=5000
+
+; Number of outbound block-relay-only connections made in addition to the other
+; outbound connections.  No transactions or addresses are relayed with them,
+; which makes them harder to discover for an attacker.  They are saved on
+; shutdown and reconnected first at the next startup.
+; blockrelayonlyconns=2
+
+; File which maps IP address prefixes to the autonomous system numbers (ASNs) of
+; the networks announcing them, in the compact asmap format used by Bitcoin
+; Core.  When set, peers are grouped by ASN instead of by network prefix (/16
+; for IPv4, /32 for IPv6), so a single large network operator is not able to
+; fill many outbound connection slots.
+; asmap=~/.bitumd/ip_asn.map
+
+; Prefer outbound peers which run a different code version (Codechain tree
+; hash) than the existing outbound peers, so a bug in a single release can not
+; partition the network.  The code versions of addresses are learned from
+; previous connections to them.
+; diverseoutbound=1
+
+; Support the encrypted and authenticated v2 P2P transport.  Inbound peers may
+; use either transport, while outbound connections use the v2 transport when the
+; peer advertises support for it and fall back to the unencrypted v1 transport
+; when the v2 handshake fails.
+; v2transport=1
+
 ; Disable banning of misbehaving peers.
 ; nobanning=1
 
@@ -127,11 +159,26 @@ const FileContents = `[Application Options]
 ; banduration=11h30m15s
 
 ; Add whitelisted IP networks and IPs. Connected peers whose IP matches a
-; whitelist will not have their ban score increased.
+; whitelist are granted the permissions of the whitelist.  The permissions may
+; be specified as a comma separated list followed by an @ sign in front of the
+; IP network or IP.  Only the noban and noconnlimit permissions are granted when
+; none are specified.  The available permissions are:
+;   noban       - Never ban the peer for misbehaving and allow it to connect
+;                 while its address is banned.  Flooding the server with
+;                 requests still disconnects the peer.
+;   relay       - Always relay transactions from the peer.  They are accepted
+;                 even with blocksonly and not rate limited.
+;   mempool     - Allow the peer to request the mempool without rate limiting.
+;   noconnlimit - Exempt the peer from maxsameip and, for inbound peers,
+;                 maxpeers.
+;   download    - Serve historical blocks to the peer even when the
+;                 maxuploadtarget is reached.
+;   all         - All of the above.
 ; whitelist=127.0.0.1
 ; whitelist=::1
 ; whitelist=192.168.0.0/24
 ; whitelist=fd00::/16
+; whitelist=noban,mempool@10.0.0.0/8
 
 ; Disable DNS seeding for peers.  By default, when bitumd starts, it will use
 ; DNS to query for available peers to connect with.
@@ -254,6 +301,24 @@ const FileContents = `[Application Options]
 ; rejectnonstd=1
 
 
+; ------------------------------------------------------------------------------
+; Updater Settings
+; ------------------------------------------------------------------------------
+
+; Disable the Codechain updater.  Hashchain entries and patch files announced
+; by remote peers are ignored when the updater is disabled.
+; noupdater=1
+
+; Automatically update the source tree in the src directory of the data
+; directory to the last signed tree hash of the Codechain hashchain.  Once a day
+; the source tree is updated with the given probability, or regardless of it
+; once it falls behind by the given number of signed tree hashes.  Source trees
+; which are not at a signed tree hash are never updated.
+; autoupdate=1
+; autoupdateprobability=0.1
+; autoupdatewindow=3
+
+
 ; ------------------------------------------------------------------------------
 ; Optional Transaction Indexes
 ; ------------------------------------------------------------------------------
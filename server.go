@@ -8,11 +8,14 @@ package main
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"net"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -37,6 +40,7 @@ import (
 	"github.com/bitum-project/bitumd/mining"
 	"github.com/bitum-project/bitumd/peer"
 	"github.com/bitum-project/bitumd/txscript"
+	"github.com/bitum-project/bitumd/updater"
 	"github.com/bitum-project/bitumd/wire"
 )
 
@@ -59,7 +63,25 @@ const (
 	connectionRetryInterval = time.Second * 5
 
 	// maxProtocolVersion is the max protocol version the server supports.
-	maxProtocolVersion = wire.NodeCFVersion
+	maxProtocolVersion = wire.AddrV2Version
+
+	// maxAddrTreeHashes is the maximum number of addresses for which the
+	// tree hash advertised by the last outbound connection is remembered.
+	maxAddrTreeHashes = 1000
+
+	// maxV1TransportAddrs is the maximum number of addresses for which a
+	// rejected v2 transport handshake is remembered.
+	maxV1TransportAddrs = 1000
+
+	// v1TransportAddrTimeout is the duration after which a rejected v2
+	// transport handshake with an address is forgotten, so the v2 transport
+	// is tried again once the remote peer might have been upgraded.
+	v1TransportAddrTimeout = 24 * time.Hour
+
+	// diverseOutboundTries is the number of tries after which addresses
+	// of peers running the same code version as an existing outbound peer
+	// are allowed when preferring diverse outbound peers.
+	diverseOutboundTries = 40
 )
 
 var (
@@ -115,13 +137,29 @@ type updatePeerHeightsMsg struct {
 }
 
 // peerState maintains state of inbound, persistent, outbound peers as well
-// as banned peers and outbound groups.
+// as banned peers, outbound groups, and the code versions of outbound peers.
 type peerState struct {
 	inboundPeers    map[int32]*serverPeer
 	outboundPeers   map[int32]*serverPeer
 	persistentPeers map[int32]*serverPeer
-	banned          map[string]time.Time
+	banned          *banList
 	outboundGroups  map[string]int
+
+	// outboundTreeHashes counts the outbound peers by the tree hash of the
+	// source tree they advertised and addrTreeHashes tracks the tree hash
+	// advertised by the last outbound connection to an address.
+	outboundTreeHashes map[chainhash.Hash]int
+	addrTreeHashes     map[string]chainhash.Hash
+
+	// v1TransportAddrs tracks the addresses which rejected an outbound v2
+	// transport handshake along with the time they did, so that later
+	// connections to them fall back to the v1 transport until the entry
+	// expires.
+	v1TransportAddrs map[string]time.Time
+
+	// evictionKey is the random key the network groups of inbound peers
+	// are hashed with when selecting a peer to evict.
+	evictionKey [16]byte
 }
 
 // ConnectionsWithIP returns the number of connections with the given IP.
@@ -145,6 +183,76 @@ func (ps *peerState) ConnectionsWithIP(ip net.IP) int {
 	return total
 }
 
+// addOutboundTreeHash accounts for the tree hash advertised by the passed
+// outbound peer and remembers it for the address of the peer.  Peers which did
+// not advertise a tree hash are ignored.
+func (ps *peerState) addOutboundTreeHash(sp *serverPeer) {
+	treeHash := sp.TreeHash()
+	if treeHash == zeroHash {
+		return
+	}
+	ps.outboundTreeHashes[treeHash]++
+
+	// Evict an arbitrary address to make room for a new one when the
+	// limit is reached.
+	key := addrmgr.NetAddressKeyV2(sp.netAddress())
+	_, known := ps.addrTreeHashes[key]
+	if !known && len(ps.addrTreeHashes) >= maxAddrTreeHashes {
+		for k := range ps.addrTreeHashes {
+			delete(ps.addrTreeHashes, k)
+			break
+		}
+	}
+	ps.addrTreeHashes[key] = treeHash
+}
+
+// addV1TransportAddr remembers that the address with the passed key rejected
+// the v2 transport handshake at the passed time.
+func (ps *peerState) addV1TransportAddr(key string, now time.Time) {
+	// Remove expired addresses, or evict an arbitrary one if there are
+	// none, to make room for a new one when the limit is reached.
+	_, known := ps.v1TransportAddrs[key]
+	if !known && len(ps.v1TransportAddrs) >= maxV1TransportAddrs {
+		for k, added := range ps.v1TransportAddrs {
+			if now.Sub(added) >= v1TransportAddrTimeout {
+				delete(ps.v1TransportAddrs, k)
+			}
+		}
+		if len(ps.v1TransportAddrs) >= maxV1TransportAddrs {
+			for k := range ps.v1TransportAddrs {
+				delete(ps.v1TransportAddrs, k)
+				break
+			}
+		}
+	}
+	ps.v1TransportAddrs[key] = now
+}
+
+// isV1TransportAddr returns whether the address with the passed key rejected
+// the v2 transport handshake within v1TransportAddrTimeout of the passed time.
+// The address is forgotten once the timeout passed.
+func (ps *peerState) isV1TransportAddr(key string, now time.Time) bool {
+	added, ok := ps.v1TransportAddrs[key]
+	if ok && now.Sub(added) >= v1TransportAddrTimeout {
+		delete(ps.v1TransportAddrs, key)
+		return false
+	}
+	return ok
+}
+
+// removeOutboundTreeHash removes the tree hash advertised by the passed
+// outbound peer from the count of outbound peers by tree hash.
+func (ps *peerState) removeOutboundTreeHash(sp *serverPeer) {
+	treeHash := sp.TreeHash()
+	if treeHash == zeroHash {
+		return
+	}
+	ps.outboundTreeHashes[treeHash]--
+	if ps.outboundTreeHashes[treeHash] <= 0 {
+		delete(ps.outboundTreeHashes, treeHash)
+	}
+}
+
 // Count returns the count of all known peers.
 func (ps *peerState) Count() int {
 	return len(ps.inboundPeers) + len(ps.outboundPeers) +
@@ -191,6 +299,7 @@ type server struct {
 	txMemPool            *mempool.TxPool
 	feeEstimator         *fees.Estimator
 	cpuMiner             *CPUMiner
+	updateManager        *updater.UpdateManager
 	modifyRebroadcastInv chan interface{}
 	newPeers             chan *serverPeer
 	donePeers            chan *serverPeer
@@ -205,6 +314,7 @@ type server struct {
 	db                   database.DB
 	timeSource           blockchain.MedianTimeSource
 	services             wire.ServiceFlag
+	uploadTarget         *uploadTarget
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -222,15 +332,19 @@ type serverPeer struct {
 	*peer.Peer
 
 	connReq         *connmgr.ConnReq
+	netAddr         *wire.NetAddressV2
 	server          *server
 	persistent      bool
+	blockRelayOnly  bool
+	v2Transport     bool
 	continueHash    *chainhash.Hash
 	relayMtx        sync.Mutex
 	disableRelayTx  bool
-	isWhitelisted   bool
+	permissions     peerPermissions
 	requestQueue    []*wire.InvVect
 	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]struct{}
+	partialBlocks   map[chainhash.Hash]*partialBlock
 	knownAddresses  map[string]struct{}
 	banScore        connmgr.DynamicBanScore
 	quit            chan struct{}
@@ -239,9 +353,45 @@ type serverPeer struct {
 	// request.  It is used to prevent more than one response per connection.
 	addrsSent bool
 
+	// lastProgress is the last time the peer delivered a requested block or
+	// headers.  It is only accessed by the block manager.
+	lastProgress time.Time
+
+	// provenWork is the total work of the chain the headers the peer sent
+	// in response to the latest getheaders message of the block manager
+	// prove it has, or nil when they proved none.  workProofRequested is
+	// when the headers were requested and workProofPending whether they
+	// did not arrive yet.  They are only accessed by the block manager.
+	provenWork         *big.Int
+	workProofRequested time.Time
+	workProofPending   bool
+
+	// syncStalls is the number of times the peer was demoted as the sync
+	// peer for stalling the sync and lastSyncStall is when it was last
+	// demoted.  They are protected by syncStallMtx.
+	syncStallMtx  sync.Mutex
+	syncStalls    uint32
+	lastSyncStall time.Time
+
+	// lastBlockRelay and lastTxRelay are the last times the peer relayed a
+	// block which was added to the main chain and transactions which were
+	// accepted to the memory pool.  They are protected by relayTimesMtx.
+	relayTimesMtx  sync.Mutex
+	lastBlockRelay time.Time
+	lastTxRelay    time.Time
+
+	// uploadTarget tracks the block data sent to the peer against its
+	// share of the upload target of the server.
+	uploadTarget *uploadTarget
+
 	// The following chans are used to sync blockmanager and server.
 	txProcessed    chan struct{}
 	blockProcessed chan struct{}
+
+	// The following chans are used to sync the update manager and server.
+	invProcessed    chan error
+	cchainProcessed chan error
+	patchProcessed  chan error
 }
 
 // newServerPeer returns a new serverPeer instance. The peer needs to be set by
@@ -252,10 +402,15 @@ func newServerPeer(s *server, isPersistent bool) *serverPeer {
 		persistent:      isPersistent,
 		requestedTxns:   make(map[chainhash.Hash]struct{}),
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
+		partialBlocks:   make(map[chainhash.Hash]*partialBlock),
 		knownAddresses:  make(map[string]struct{}),
 		quit:            make(chan struct{}),
 		txProcessed:     make(chan struct{}, 1),
 		blockProcessed:  make(chan struct{}, 1),
+		invProcessed:    make(chan error, 1),
+		cchainProcessed: make(chan error, 1),
+		patchProcessed:  make(chan error, 1),
+		uploadTarget:    s.uploadTarget.peerTarget(),
 	}
 }
 
@@ -266,6 +421,85 @@ func (sp *serverPeer) newestBlock() (*chainhash.Hash, int64, error) {
 	return &best.Hash, best.Height, nil
 }
 
+// netAddress returns the address of the peer as a wire.NetAddressV2.  This is
+// the address an outbound connection was made to, which might not be
+// representable by the legacy address of the peer (see NA), such as for Tor v3
+// onion addresses.
+func (sp *serverPeer) netAddress() *wire.NetAddressV2 {
+	if sp.netAddr != nil {
+		return sp.netAddr
+	}
+	return wire.NewNetAddressV2FromLegacy(sp.NA())
+}
+
+// banIP returns the IP address bans are checked against for the peer or nil
+// when the peer has no IP address which can be banned, such as peers connected
+// via Tor v3 onion services.
+func (sp *serverPeer) banIP() net.IP {
+	host, _, err := net.SplitHostPort(sp.Addr())
+	if err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip
+		}
+	}
+	na := sp.NA()
+	if na == nil || na.IP == nil || na.IP.IsUnspecified() {
+		return nil
+	}
+	return na.IP
+}
+
+// recordSyncStall records that the peer was demoted as the sync peer for
+// stalling the sync at the passed time.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) recordSyncStall(t time.Time) {
+	sp.syncStallMtx.Lock()
+	sp.syncStalls++
+	sp.lastSyncStall = t
+	sp.syncStallMtx.Unlock()
+}
+
+// syncStallStats returns the number of times the peer was demoted as the sync
+// peer for stalling the sync and the time it was last demoted.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) syncStallStats() (uint32, time.Time) {
+	sp.syncStallMtx.Lock()
+	defer sp.syncStallMtx.Unlock()
+	return sp.syncStalls, sp.lastSyncStall
+}
+
+// recordBlockRelay records that the peer relayed a block which was added to the
+// main chain.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) recordBlockRelay() {
+	sp.relayTimesMtx.Lock()
+	sp.lastBlockRelay = time.Now()
+	sp.relayTimesMtx.Unlock()
+}
+
+// recordTxRelay records that the peer relayed transactions which were accepted
+// to the memory pool.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) recordTxRelay() {
+	sp.relayTimesMtx.Lock()
+	sp.lastTxRelay = time.Now()
+	sp.relayTimesMtx.Unlock()
+}
+
+// relayTimes returns the last times the peer relayed a block which was added to
+// the main chain and transactions which were accepted to the memory pool.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) relayTimes() (time.Time, time.Time) {
+	sp.relayTimesMtx.Lock()
+	defer sp.relayTimesMtx.Unlock()
+	return sp.lastBlockRelay, sp.lastTxRelay
+}
+
 // addKnownAddresses adds the given addresses to the set of known addreses to
 // the peer to prevent sending duplicate addresses.
 func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddress) {
@@ -280,6 +514,20 @@ func (sp *serverPeer) addressKnown(na *wire.NetAddress) bool {
 	return exists
 }
 
+// addKnownAddressesV2 adds the given addresses to the set of known addreses to
+// the peer to prevent sending duplicate addresses.
+func (sp *serverPeer) addKnownAddressesV2(addresses []*wire.NetAddressV2) {
+	for _, na := range addresses {
+		sp.knownAddresses[addrmgr.NetAddressKeyV2(na)] = struct{}{}
+	}
+}
+
+// addressKnownV2 true if the given address is already known to the peer.
+func (sp *serverPeer) addressKnownV2(na *wire.NetAddressV2) bool {
+	_, exists := sp.knownAddresses[addrmgr.NetAddressKeyV2(na)]
+	return exists
+}
+
 // setDisableRelayTx toggles relaying of transactions for the given peer.
 // It is safe for concurrent access.
 func (sp *serverPeer) setDisableRelayTx(disable bool) {
@@ -299,6 +547,13 @@ func (sp *serverPeer) relayTxDisabled() bool {
 	return isDisabled
 }
 
+// blocksOnly returns whether only blocks are relayed with the peer, which is the
+// case for block-relay-only peers and, unless the peer has the relay
+// permission, for all peers when running in blocksonly mode.
+func (sp *serverPeer) blocksOnly() bool {
+	return sp.blockRelayOnly || (cfg.BlocksOnly && !sp.permissions.has(permRelay))
+}
+
 // pushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.
 func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddress) {
@@ -318,6 +573,26 @@ func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddress) {
 	sp.addKnownAddresses(known)
 }
 
+// pushAddrV2Msg sends an addrv2 message for the provided addresses to the
+// connected peer.  Like pushAddrMsg, addresses already known to the peer are
+// filtered.
+func (sp *serverPeer) pushAddrV2Msg(addresses []*wire.NetAddressV2) {
+	// Filter addresses already known to the peer.
+	addrs := make([]*wire.NetAddressV2, 0, len(addresses))
+	for _, addr := range addresses {
+		if !sp.addressKnownV2(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	known, err := sp.PushAddrV2Msg(addrs)
+	if err != nil {
+		peerLog.Errorf("Can't push address message to %s: %v", sp.Peer, err)
+		sp.Disconnect()
+		return
+	}
+	sp.addKnownAddressesV2(known)
+}
+
 // addBanScore increases the persistent and decaying ban score fields by the
 // values passed as parameters. If the resulting score exceeds half of the ban
 // threshold, a warning is logged including the reason provided. Further, if
@@ -328,9 +603,15 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 	if cfg.DisableBanning {
 		return
 	}
-	if sp.isWhitelisted {
+	// Peers with the noban permission are only punished for flooding the
+	// server with requests, which disconnects them without banning them.
+	noBan := sp.permissions.has(permNoBan)
+	if noBan && persistent > 0 {
 		peerLog.Debugf("Misbehaving whitelisted peer %s: %s", sp, reason)
-		return
+		if transient == 0 {
+			return
+		}
+		persistent = 0
 	}
 
 	warnThreshold := cfg.BanThreshold >> 1
@@ -349,6 +630,12 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 		peerLog.Warnf("Misbehaving peer %s: %s -- ban score increased to %d",
 			sp, reason, score)
 		if score > cfg.BanThreshold {
+			if noBan {
+				peerLog.Warnf("Misbehaving whitelisted peer %s -- "+
+					"disconnecting", sp)
+				sp.Disconnect()
+				return
+			}
 			peerLog.Warnf("Misbehaving peer %s -- banning and disconnecting",
 				sp)
 			sp.server.BanPeer(sp)
@@ -381,7 +668,7 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 	remoteAddr := sp.NA()
 	addrManager := sp.server.addrManager
 	if !cfg.SimNet && !isInbound {
-		addrManager.SetServices(remoteAddr, msg.Services)
+		addrManager.SetServicesV2(sp.netAddress(), msg.Services)
 	}
 
 	// Ignore peers that have a protcol version that is too old.  The peer
@@ -402,16 +689,28 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 		return wire.NewMsgReject(msg.Command(), wire.RejectNonstandard, reason)
 	}
 
+	// Request addresses to be sent in addrv2 messages from peers which
+	// understand them.  This is done before requesting known addresses
+	// below so the response is able to include addresses which are not
+	// representable by addr messages.  Addresses are not relayed with
+	// block-relay-only peers.
+	if p.ProtocolVersion() >= wire.AddrV2Version && !sp.blockRelayOnly {
+		p.QueueMessage(wire.NewMsgSendAddrV2(), nil)
+	}
+
 	// Update the address manager and request known addresses from the
 	// remote peer for outbound connections.  This is skipped when running
 	// on the simulation test network since it is only intended to connect
 	// to specified peers and actively avoids advertising and connecting to
-	// discovered peers.
+	// discovered peers.  Addresses are neither advertised to nor requested
+	// from block-relay-only peers.
 	if !cfg.SimNet && !isInbound {
 		// Advertise the local address when the server accepts incoming
 		// connections and it believes itself to be close to the best
 		// known tip.
-		if !cfg.DisableListen && sp.server.blockManager.IsCurrent() {
+		if !cfg.DisableListen && !sp.blockRelayOnly &&
+			sp.server.blockManager.IsCurrent() {
+
 			// Get address that best matches.
 			lna := addrManager.GetBestLocalAddress(remoteAddr)
 			if addrmgr.IsRoutable(lna) {
@@ -423,16 +722,25 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 
 		// Request known addresses if the server address manager needs
 		// more.
-		if addrManager.NeedMoreAddresses() {
+		if !sp.blockRelayOnly && addrManager.NeedMoreAddresses() {
 			p.QueueMessage(wire.NewMsgGetAddr(), nil)
 		}
 
 		// Mark the address as a known good address.
-		addrManager.Good(remoteAddr)
+		addrManager.GoodV2(sp.netAddress())
 	}
 
-	// Choose whether or not to relay transactions.
-	sp.setDisableRelayTx(msg.DisableRelayTx)
+	// Choose whether or not to relay transactions.  They are never relayed
+	// to block-relay-only peers.
+	sp.setDisableRelayTx(msg.DisableRelayTx || sp.blockRelayOnly)
+
+	// Signal support for compact blocks to peers which understand them.
+	// New blocks are only requested to be announced as compact blocks
+	// once the peer delivered a new tip block.
+	if p.ProtocolVersion() >= wire.CmpctBlocksVersion {
+		p.QueueMessage(wire.NewMsgSendCmpct(false, wire.CmpctBlockVersion),
+			nil)
+	}
 
 	// Add the remote peer time as a sample for creating an offset against
 	// the local clock to keep the network time in sync.
@@ -450,11 +758,18 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 // and sends an inventory message with the contents of the memory pool up to the
 // maximum inventory allowed per message.
 func (sp *serverPeer) OnMemPool(p *peer.Peer, msg *wire.MsgMemPool) {
+	// Transactions are not relayed with block-relay-only peers.
+	if sp.blockRelayOnly {
+		return
+	}
+
 	// A decaying ban score increase is applied to prevent flooding.
 	// The ban score accumulates and passes the ban threshold if a burst of
 	// mempool messages comes from a peer. The score decays each minute to
-	// half of its value.
-	sp.addBanScore(0, 33, "mempool")
+	// half of its value.  Peers with the mempool permission are exempt.
+	if !sp.permissions.has(permMemPool) {
+		sp.addBanScore(0, 33, "mempool")
+	}
 
 	// Generate inventory message with the available transactions in the
 	// transaction memory pool.  Limit it to the max allowed inventory
@@ -592,8 +907,8 @@ func (sp *serverPeer) OnMiningState(p *peer.Peer, msg *wire.MsgMiningState) {
 // serialize all transactions through a single thread transactions don't rely on
 // the previous one in a linear fashion like blocks.
 func (sp *serverPeer) OnTx(p *peer.Peer, msg *wire.MsgTx) {
-	if cfg.BlocksOnly {
-		peerLog.Tracef("Ignoring tx %v from %v - blocksonly enabled",
+	if sp.blocksOnly() {
+		peerLog.Tracef("Ignoring tx %v from %v - only relaying blocks",
 			msg.TxHash(), p)
 		return
 	}
@@ -638,12 +953,88 @@ func (sp *serverPeer) OnBlock(p *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	<-sp.blockProcessed
 }
 
+// OnCmpctBlock is invoked when a peer receives a cmpctblock wire message.  It
+// blocks until the compact block has been processed by the block manager, which
+// either processes the reconstructed block or requests the transactions it is
+// missing.
+func (sp *serverPeer) OnCmpctBlock(p *peer.Peer, msg *wire.MsgCmpctBlock) {
+	sp.server.blockManager.QueueCmpctBlock(msg, sp)
+	<-sp.blockProcessed
+}
+
+// OnGetBlockTxn is invoked when a peer receives a getblocktxn wire message.  It
+// responds with a blocktxn message holding the requested transactions of the
+// block.
+func (sp *serverPeer) OnGetBlockTxn(p *peer.Peer, msg *wire.MsgGetBlockTxn) {
+	block, err := sp.server.blockManager.chain.BlockByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to fetch block %v requested by getblocktxn "+
+			"from %s: %v", msg.BlockHash, sp, err)
+		return
+	}
+
+	msgBlock := block.MsgBlock()
+	if !sp.server.allowBlockUpload(sp, &msg.BlockHash, &msgBlock.Header) {
+		return
+	}
+
+	// Serve the full block instead when it is too deep in the main chain to
+	// be reconstructed from a compact block.
+	best := sp.server.blockManager.chain.BestSnapshot()
+	if best.Height-int64(msgBlock.Header.Height) > maxCmpctBlockDepth {
+		sp.QueueMessage(msgBlock, nil)
+		return
+	}
+	blockTxn := wire.NewMsgBlockTxn(&msg.BlockHash)
+	for _, index := range msg.Indexes {
+		if int(index) >= len(msgBlock.Transactions) {
+			sp.addBanScore(100, 0, "invalid getblocktxn index")
+			return
+		}
+		blockTxn.AddTransaction(msgBlock.Transactions[index])
+	}
+	for _, index := range msg.StakeIndexes {
+		if int(index) >= len(msgBlock.STransactions) {
+			sp.addBanScore(100, 0, "invalid getblocktxn index")
+			return
+		}
+		blockTxn.AddSTransaction(msgBlock.STransactions[index])
+	}
+	sp.QueueMessage(blockTxn, nil)
+}
+
+// OnBlockTxn is invoked when a peer receives a blocktxn wire message.  It blocks
+// until the transactions have been used to complete the block by the block
+// manager and the block has been processed.
+func (sp *serverPeer) OnBlockTxn(p *peer.Peer, msg *wire.MsgBlockTxn) {
+	sp.server.blockManager.QueueBlockTxn(msg, sp)
+	<-sp.blockProcessed
+}
+
 // OnInv is invoked when a peer receives an inv wire message and is used to
 // examine the inventory being advertised by the remote peer and react
-// accordingly.  We pass the message down to blockmanager which will call
+// accordingly.  Codechain entries and patch files are passed to the update
+// manager, everything else is passed down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(p *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
+	// Split off the updater inventory.  It is only handled when the update
+	// manager is enabled and never reaches the block manager.
+	msg, updaterInv, err := updater.SplitMsgInv(msg)
+	if err != nil {
+		peerLog.Errorf("Failed to split inventory message: %v", err)
+		return
+	}
+	if len(updaterInv.InvList) > 0 && sp.server.updateManager != nil {
+		sp.server.updateManager.QueueInv(updaterInv, p, sp.invProcessed)
+		err := <-sp.invProcessed
+		if rerr, ok := err.(updater.RuleError); ok {
+			peerLog.Infof("Unsatisfied hashchain announcement from "+
+				"%s: %v", sp, rerr)
+			sp.addBanScore(0, 50, "unsatisfied hashchain announcement")
+		}
+	}
+
+	if !sp.blocksOnly() {
 		if len(msg.InvList) > 0 {
 			sp.server.blockManager.QueueInv(msg, sp)
 		}
@@ -719,6 +1110,8 @@ func (sp *serverPeer) OnGetData(p *peer.Peer, msg *wire.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan)
 		case wire.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan)
+		case wire.InvTypeCmpctBlock:
+			err = sp.server.pushCmpctBlockMsg(sp, &iv.Hash, c, waitChan)
 		default:
 			peerLog.Warnf("Unknown type in inventory request %d",
 				iv.Type)
@@ -1000,6 +1393,89 @@ func (sp *serverPeer) OnGetCFTypes(p *peer.Peer, msg *wire.MsgGetCFTypes) {
 	sp.QueueMessage(cfTypesMsg, nil)
 }
 
+// OnGetCChain is invoked when a peer receives a getcchain wire message.  It
+// hands the request to the update manager which replies with the requested
+// hashchain entries, if known.
+func (sp *serverPeer) OnGetCChain(p *peer.Peer, msg *wire.MsgGetCChain) {
+	// Ignore request if the updater is disabled.
+	if sp.server.updateManager == nil {
+		return
+	}
+
+	sp.server.updateManager.QueueGetCChain(msg, p)
+}
+
+// OnCChain is invoked when a peer receives a cchain wire message.  It blocks
+// until the hashchain entries have been verified and increases the ban score
+// of the peer if they are invalid.
+func (sp *serverPeer) OnCChain(p *peer.Peer, msg *wire.MsgCChain) {
+	// Ignore hashchain entries if the updater is disabled.
+	if sp.server.updateManager == nil {
+		return
+	}
+
+	// Queue the hashchain entries up to be handled by the update manager
+	// and intentionally block further receives until they are verified.
+	sp.server.updateManager.QueueCChain(msg, p, sp.cchainProcessed)
+	err := <-sp.cchainProcessed
+	if rerr, ok := err.(updater.RuleError); ok {
+		peerLog.Infof("Rejected hashchain entries from %s: %v", sp, rerr)
+		switch rerr.ErrorCode {
+		case updater.ErrBrokenLink:
+			// The entries might have been sent for an outdated
+			// request, so only increase the transient ban score.
+			sp.addBanScore(0, 50, "unconnected hashchain entries")
+		case updater.ErrUnsatisfiedEntries:
+			sp.addBanScore(0, 50, "unsatisfied hashchain announcement")
+		default:
+			sp.addBanScore(100, 0, "invalid hashchain entries")
+		}
+	}
+}
+
+// OnGetPatch is invoked when a peer receives a getpatch wire message.  It
+// hands the request to the update manager which replies with the requested
+// patch file, if known.
+func (sp *serverPeer) OnGetPatch(p *peer.Peer, msg *wire.MsgGetPatch) {
+	// Ignore request if the updater is disabled.
+	if sp.server.updateManager == nil {
+		return
+	}
+
+	sp.server.updateManager.QueueGetPatch(msg, p)
+}
+
+// OnPatch is invoked when a peer receives a patch wire message.  It blocks
+// until the patch file has been verified and increases the ban score of the
+// peer if it is invalid.
+func (sp *serverPeer) OnPatch(p *peer.Peer, msg *wire.MsgPatch) {
+	// Ignore patch files if the updater is disabled.
+	if sp.server.updateManager == nil {
+		return
+	}
+
+	// Add the patch file to the known inventory for the peer.
+	iv := wire.NewInvVect(wire.InvTypePatch, &msg.TreeHash)
+	p.AddKnownInventory(iv)
+
+	// Queue the patch file up to be handled by the update manager and
+	// intentionally block further receives until it is verified.  This
+	// prevents a malicious peer from queuing up a bunch of large bogus
+	// patch files.
+	sp.server.updateManager.QueuePatch(msg, p, sp.patchProcessed)
+	err := <-sp.patchProcessed
+	if rerr, ok := err.(updater.RuleError); ok {
+		peerLog.Infof("Rejected patch file %x from %s: %v",
+			msg.TreeHash[:], sp, rerr)
+		switch rerr.ErrorCode {
+		case updater.ErrInvalidPatch:
+			sp.addBanScore(100, 0, "invalid patch")
+		default:
+			sp.addBanScore(0, 50, "unrequested patch")
+		}
+	}
+}
+
 // OnGetAddr is invoked when a peer receives a getaddr wire message and is used
 // to provide the peer with known addresses from the address manager.
 func (sp *serverPeer) OnGetAddr(p *peer.Peer, msg *wire.MsgGetAddr) {
@@ -1025,11 +1501,14 @@ func (sp *serverPeer) OnGetAddr(p *peer.Peer, msg *wire.MsgGetAddr) {
 	}
 	sp.addrsSent = true
 
-	// Get the current known addresses from the address manager.
-	addrCache := sp.server.addrManager.AddressCache()
-
-	// Push the addresses.
-	sp.pushAddrMsg(addrCache)
+	// Push the current known addresses from the address manager.  Peers
+	// which requested addrv2 messages are also sent addresses which are
+	// not representable by addr messages.
+	if sp.WantsAddrV2() {
+		sp.pushAddrV2Msg(sp.server.addrManager.AddressCacheV2())
+		return
+	}
+	sp.pushAddrMsg(sp.server.addrManager.AddressCache())
 }
 
 // OnAddr is invoked when a peer receives an addr wire message and is used to
@@ -1038,8 +1517,9 @@ func (sp *serverPeer) OnAddr(p *peer.Peer, msg *wire.MsgAddr) {
 	// Ignore addresses when running on the simulation test network.  This
 	// helps prevent the network from becoming another public test network
 	// since it will not be able to learn about other peers that have not
-	// specifically been provided.
-	if cfg.SimNet {
+	// specifically been provided.  Addresses from block-relay-only peers
+	// are ignored as well since addresses are not relayed with them.
+	if cfg.SimNet || sp.blockRelayOnly {
 		return
 	}
 
@@ -1077,6 +1557,51 @@ func (sp *serverPeer) OnAddr(p *peer.Peer, msg *wire.MsgAddr) {
 	sp.server.addrManager.AddAddresses(msg.AddrList, p.NA())
 }
 
+// OnAddrV2 is invoked when a peer receives an addrv2 wire message and is used
+// to notify the server about advertised addresses.  It is handled like an addr
+// message (see OnAddr).
+func (sp *serverPeer) OnAddrV2(p *peer.Peer, msg *wire.MsgAddrV2) {
+	// Ignore addresses when running on the simulation test network.  This
+	// helps prevent the network from becoming another public test network
+	// since it will not be able to learn about other peers that have not
+	// specifically been provided.  Addresses from block-relay-only peers
+	// are ignored as well since addresses are not relayed with them.
+	if cfg.SimNet || sp.blockRelayOnly {
+		return
+	}
+
+	// A message that has no addresses is invalid.
+	if len(msg.AddrList) == 0 {
+		peerLog.Errorf("Command [%s] from %s does not contain any addresses",
+			msg.Command(), p)
+		p.Disconnect()
+		return
+	}
+
+	now := time.Now()
+	for _, na := range msg.AddrList {
+		// Don't add more address if we're disconnecting.
+		if !p.Connected() {
+			return
+		}
+
+		// Set the timestamp to 5 days ago if it's more than 24 hours
+		// in the future so this address is one of the first to be
+		// removed when space is needed.
+		if na.Timestamp.After(now.Add(time.Minute * 10)) {
+			na.Timestamp = now.Add(-1 * time.Hour * 24 * 5)
+		}
+
+		// Add address to known addresses for this peer.
+		sp.addKnownAddressesV2([]*wire.NetAddressV2{na})
+	}
+
+	// Add addresses to server address manager.  The address manager handles
+	// the details of things such as preventing duplicate addresses, max
+	// addresses, addresses of unsupported networks, and last seen updates.
+	sp.server.addrManager.AddAddressesV2(msg.AddrList, sp.netAddress())
+}
+
 // OnRead is invoked when a peer receives a message and it is used to update
 // the bytes received by the server.
 func (sp *serverPeer) OnRead(p *peer.Peer, bytesRead int, msg wire.Message, err error) {
@@ -1087,6 +1612,13 @@ func (sp *serverPeer) OnRead(p *peer.Peer, bytesRead int, msg wire.Message, err
 // the bytes sent by the server.
 func (sp *serverPeer) OnWrite(p *peer.Peer, bytesWritten int, msg wire.Message, err error) {
 	sp.server.AddBytesSent(uint64(bytesWritten))
+
+	// Tally the block data sent to the peer against its share of the upload
+	// target.
+	switch msg.(type) {
+	case *wire.MsgBlock, *wire.MsgCmpctBlock, *wire.MsgBlockTxn:
+		sp.uploadTarget.addBytes(uint64(bytesWritten))
+	}
 }
 
 // randomUint16Number returns a random uint16 in a specified input range.  Note
@@ -1195,6 +1727,27 @@ func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<-
 	return nil
 }
 
+// allowBlockUpload returns whether data of the block with the passed hash and
+// header may be served to the passed peer.  Historical blocks are no longer
+// served to peers without the download permission once the upload target or
+// the share of it for the peer is reached.  Such peers are disconnected so they
+// sync from other peers instead.
+func (s *server) allowBlockUpload(sp *serverPeer, hash *chainhash.Hash, header *wire.BlockHeader) bool {
+	if time.Since(header.Timestamp) <= historicalBlockAge ||
+		sp.permissions.has(permDownload) {
+
+		return true
+	}
+	if !s.uploadTarget.reached() && !sp.uploadTarget.reached() {
+		return true
+	}
+
+	peerLog.Infof("Upload target reached -- disconnecting peer %s "+
+		"requesting historical block %v", sp, hash)
+	sp.Disconnect()
+	return false
+}
+
 // pushBlockMsg sends a block message for the provided block hash to the
 // connected peer.  An error is returned if the block hash is not known.
 func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{}, waitChan <-chan struct{}) error {
@@ -1209,6 +1762,13 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 		return err
 	}
 
+	if !s.allowBlockUpload(sp, hash, &block.MsgBlock().Header) {
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return errUploadTargetReached
+	}
+
 	// Once we have fetched data wait for any previous operation to finish.
 	if waitChan != nil {
 		<-waitChan
@@ -1240,6 +1800,60 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 	return nil
 }
 
+// newCmpctBlockMsg returns a compact block for the passed block with a random
+// nonce.
+func newCmpctBlockMsg(block *bitumutil.Block) (*wire.MsgCmpctBlock, error) {
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewMsgCmpctBlockFromBlock(block.MsgBlock(), nonce), nil
+}
+
+// pushCmpctBlockMsg sends a cmpctblock message for the provided block hash to
+// the connected peer.  An error is returned if the block hash is not known.
+func (s *server) pushCmpctBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{}, waitChan <-chan struct{}) error {
+	block, err := sp.server.blockManager.chain.BlockByHash(hash)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch requested compact block hash "+
+			"%v: %v", hash, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+	// Serve the full block instead when it is too deep in the main chain to
+	// be reconstructed from a compact block.
+	best := sp.server.blockManager.chain.BestSnapshot()
+	if best.Height-block.Height() > maxCmpctBlockDepth {
+		return s.pushBlockMsg(sp, hash, doneChan, waitChan)
+	}
+	if !s.allowBlockUpload(sp, hash, &block.MsgBlock().Header) {
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return errUploadTargetReached
+	}
+	msg, err := newCmpctBlockMsg(block)
+	if err != nil {
+		peerLog.Errorf("Failed to create compact block: %v", err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
+	// Once we have fetched data wait for any previous operation to finish.
+	if waitChan != nil {
+		<-waitChan
+	}
+
+	sp.QueueMessage(msg, doneChan)
+	return nil
+}
+
 // handleUpdatePeerHeight updates the heights of all peers who were known to
 // announce a block we recently accepted.
 func (s *server) handleUpdatePeerHeights(state *peerState, umsg updatePeerHeightsMsg) {
@@ -1282,30 +1896,24 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		return false
 	}
 
-	// Disconnect banned peers.
-	host, _, err := net.SplitHostPort(sp.Addr())
-	if err != nil {
-		srvrLog.Debugf("can't split hostport %v", err)
-		sp.Disconnect()
-		return false
-	}
-	if banEnd, ok := state.banned[host]; ok {
-		if time.Now().Before(banEnd) {
+	// Disconnect banned peers unless they have the noban permission.
+	if ip := sp.banIP(); ip != nil && !sp.permissions.has(permNoBan) {
+		if banEnd, ok := state.banned.bannedUntil(ip); ok {
 			srvrLog.Debugf("Peer %s is banned for another %v - disconnecting",
-				host, time.Until(banEnd))
+				ip, time.Until(banEnd))
 			sp.Disconnect()
 			return false
 		}
-
-		srvrLog.Infof("Peer %s is no longer banned", host)
-		delete(state.banned, host)
 	}
 
 	// Limit max number of connections from a single IP.  However, allow
-	// whitelisted inbound peers and localhost connections regardless.
-	isInboundWhitelisted := sp.isWhitelisted && sp.Inbound()
+	// inbound peers with the noconnlimit permission and localhost
+	// connections regardless.  Peers without an IP, such as Tor v3 onion
+	// peers, are not limited either.
+	noConnLimit := sp.permissions.has(permNoConnLimit) && sp.Inbound()
 	peerIP := sp.NA().IP
-	if cfg.MaxSameIP > 0 && !isInboundWhitelisted && !peerIP.IsLoopback() &&
+	if cfg.MaxSameIP > 0 && !noConnLimit && !peerIP.IsLoopback() &&
+		!peerIP.IsUnspecified() &&
 		state.ConnectionsWithIP(peerIP)+1 > cfg.MaxSameIP {
 		srvrLog.Infof("Max connections with %s reached [%d] - "+
 			"disconnecting peer", sp, cfg.MaxSameIP)
@@ -1313,9 +1921,13 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		return false
 	}
 
-	// Limit max number of total peers.  However, allow whitelisted inbound
-	// peers regardless.
-	if state.Count()+1 > cfg.MaxPeers && !isInboundWhitelisted {
+	// Limit max number of total peers.  However, allow inbound peers with
+	// the noconnlimit permission regardless.  Make room for new inbound
+	// peers by evicting an existing inbound peer when possible.
+	if state.Count()+1 > cfg.MaxPeers && !noConnLimit && sp.Inbound() {
+		s.evictInboundPeer(state)
+	}
+	if state.Count()+1 > cfg.MaxPeers && !noConnLimit {
 		srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
 			cfg.MaxPeers, sp)
 		sp.Disconnect()
@@ -1329,7 +1941,8 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	if sp.Inbound() {
 		state.inboundPeers[sp.ID()] = sp
 	} else {
-		state.outboundGroups[addrmgr.GroupKey(sp.NA())]++
+		state.outboundGroups[s.addrManager.GroupKey(sp.netAddress())]++
+		state.addOutboundTreeHash(sp)
 		if sp.persistent {
 			state.persistentPeers[sp.ID()] = sp
 		} else {
@@ -1340,9 +1953,65 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	return true
 }
 
+// evictInboundPeer disconnects and removes an inbound peer selected by the
+// eviction policy (see selectEvictionCandidate) to make room for a new inbound
+// peer.  Peers with the noban permission are never evicted.  It returns
+// whether a peer was evicted.  It is invoked from the peerHandler goroutine.
+func (s *server) evictInboundPeer(state *peerState) bool {
+	candidates := make([]*evictionCandidate, 0, len(state.inboundPeers))
+	for _, sp := range state.inboundPeers {
+		if !sp.Connected() || sp.permissions.has(permNoBan) {
+			continue
+		}
+		candidates = append(candidates, newEvictionCandidate(sp,
+			state.evictionKey[:]))
+	}
+	victim := selectEvictionCandidate(candidates)
+	if victim == nil {
+		return false
+	}
+
+	srvrLog.Infof("Evicting inbound peer %s to make room for a new "+
+		"inbound peer", victim.sp)
+	victim.sp.Disconnect()
+	delete(state.inboundPeers, victim.sp.ID())
+	return true
+}
+
+// saveAnchors saves the addresses of the connected block-relay-only peers to the
+// anchors file in the data directory so they are reconnected first on the next
+// startup.  It is invoked from the peerHandler goroutine.
+func (s *server) saveAnchors(state *peerState) {
+	var addrs []string
+	for _, sp := range state.outboundPeers {
+		if sp.blockRelayOnly && sp.Connected() && sp.connReq != nil {
+			addrs = append(addrs, sp.connReq.Addr.String())
+		}
+	}
+	if len(addrs) == 0 {
+		return
+	}
+
+	anchorsFile := filepath.Join(cfg.DataDir, anchorsFilename)
+	if err := saveAnchors(anchorsFile, addrs); err != nil {
+		srvrLog.Errorf("Unable to save anchors to %s: %v", anchorsFile, err)
+		return
+	}
+	srvrLog.Infof("Saved %d anchors to %s", len(addrs), anchorsFile)
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It is
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
+	// Remember outbound peers which rejected the v2 transport handshake
+	// so the next connections to them use the v1 transport.  Peers which
+	// disconnected before or after the handshake for other reasons are
+	// not remembered.
+	if !sp.Inbound() && sp.v2Transport && sp.V2TransportRejected() {
+		key := addrmgr.NetAddressKeyV2(sp.netAddress())
+		state.addV1TransportAddr(key, time.Now())
+	}
+
 	var list map[int32]*serverPeer
 	if sp.persistent {
 		list = state.persistentPeers
@@ -1353,7 +2022,8 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	}
 	if _, ok := list[sp.ID()]; ok {
 		if !sp.Inbound() && sp.VersionKnown() {
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.netAddress())]--
+			state.removeOutboundTreeHash(sp)
 		}
 		if !sp.Inbound() && sp.connReq != nil {
 			s.connManager.Disconnect(sp.connReq.ID())
@@ -1370,7 +2040,7 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	// Update the address' last seen time if the peer has acknowledged
 	// our version and has sent us its version as well.
 	if sp.VerAckReceived() && sp.VersionKnown() && sp.NA() != nil {
-		s.addrManager.Connected(sp.NA())
+		s.addrManager.ConnectedV2(sp.netAddress())
 	}
 
 	// If we get here it means that either we didn't know about the peer
@@ -1380,35 +2050,82 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 // handleBanPeerMsg deals with banning peers.  It is invoked from the
 // peerHandler goroutine.
 func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
-	host, _, err := net.SplitHostPort(sp.Addr())
+	ip := sp.banIP()
+	if ip == nil {
+		srvrLog.Debugf("Can't ban peer %s without an IP address", sp)
+		return
+	}
+	subnet, err := parseSubnet(ip.String())
 	if err != nil {
-		srvrLog.Debugf("can't split ban peer %s %v", sp.Addr(), err)
+		srvrLog.Debugf("Can't ban peer %s: %v", sp, err)
 		return
 	}
 	direction := directionString(sp.Inbound())
-	srvrLog.Infof("Banned peer %s (%s) for %v", host, direction,
+	srvrLog.Infof("Banned peer %s (%s) for %v", ip, direction,
 		cfg.BanDuration)
-	state.banned[host] = time.Now().Add(cfg.BanDuration)
+	state.banned.ban(subnet, time.Now().Add(cfg.BanDuration),
+		banReasonMisbehaving)
+	s.saveBanList(state)
+}
+
+// saveBanList saves the ban list so that bans survive restarts.  It is invoked
+// from the peerHandler goroutine whenever the ban list changes.
+func (s *server) saveBanList(state *peerState) {
+	if err := state.banned.save(); err != nil {
+		srvrLog.Errorf("Unable to save ban list to %s: %v",
+			state.banned.file, err)
+	}
 }
 
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
 // known to have it.  It is invoked from the peerHandler goroutine.
 func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
+	// The compact block is only created once it is needed by a peer.
+	var cmpctBlock *wire.MsgCmpctBlock
+
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
 		}
 
+		// If the inventory is a block and the peer asked for compact
+		// block announcements, send it a compact block right away
+		// unless it is already known to have the block.
+		if msg.invVect.Type == wire.InvTypeBlock &&
+			sp.WantsCmpctAnnouncements() &&
+			!sp.IsKnownInventory(msg.invVect) {
+
+			block, ok := msg.data.(*bitumutil.Block)
+			if !ok {
+				peerLog.Warnf("Underlying data for compact " +
+					"block is not a block")
+				return
+			}
+			if cmpctBlock == nil {
+				var err error
+				cmpctBlock, err = newCmpctBlockMsg(block)
+				if err != nil {
+					peerLog.Errorf("Failed to create compact "+
+						"block: %v", err)
+					return
+				}
+			}
+			sp.AddKnownInventory(msg.invVect)
+			sp.QueueMessage(cmpctBlock, nil)
+			return
+		}
+
 		// If the inventory is a block and the peer prefers headers,
 		// generate and send a headers message instead of an inventory
 		// message.
 		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsHeaders() {
-			blockHeader, ok := msg.data.(wire.BlockHeader)
+			block, ok := msg.data.(*bitumutil.Block)
 			if !ok {
 				peerLog.Warnf("Underlying data for headers" +
-					" is not a block header")
+					" is not a block")
 				return
 			}
+			blockHeader := block.MsgBlock().Header
 			msgHeaders := wire.NewMsgHeaders()
 			if err := msgHeaders.AddBlockHeader(&blockHeader); err != nil {
 				peerLog.Errorf("Failed to add block"+
@@ -1471,6 +2188,16 @@ type getOutboundGroup struct {
 	reply chan int
 }
 
+type getOutboundTreeHash struct {
+	key   string
+	reply chan int
+}
+
+type getV1TransportAddr struct {
+	key   string
+	reply chan bool
+}
+
 type getAddedNodesMsg struct {
 	reply chan []*serverPeer
 }
@@ -1491,6 +2218,21 @@ type removeNodeMsg struct {
 	reply chan error
 }
 
+type setBanMsg struct {
+	subnet *net.IPNet
+	add    bool
+	until  time.Time
+	reply  chan error
+}
+
+type listBannedMsg struct {
+	reply chan []banEntry
+}
+
+type clearBannedMsg struct {
+	reply chan struct{}
+}
+
 // handleQuery is the central handler for all queries and commands from other
 // goroutines related to peer state.
 func (s *server) handleQuery(state *peerState, querymsg interface{}) {
@@ -1548,7 +2290,8 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		found := disconnectPeer(state.persistentPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.netAddress())]--
+			state.removeOutboundTreeHash(sp)
 		})
 
 		if found {
@@ -1563,6 +2306,15 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		} else {
 			msg.reply <- 0
 		}
+	case getOutboundTreeHash:
+		treeHash, ok := state.addrTreeHashes[msg.key]
+		if ok {
+			msg.reply <- state.outboundTreeHashes[treeHash]
+		} else {
+			msg.reply <- 0
+		}
+	case getV1TransportAddr:
+		msg.reply <- state.isV1TransportAddr(msg.key, time.Now())
 	// Request a list of the persistent (added) peers.
 	case getAddedNodesMsg:
 		// Respond with a slice of the relevant peers.
@@ -1584,7 +2336,8 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.netAddress())]--
+			state.removeOutboundTreeHash(sp)
 		})
 		if found {
 			// If there are multiple outbound connections to the same
@@ -1592,7 +2345,8 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 			// peers are found.
 			for found {
 				found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
-					state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+					state.outboundGroups[s.addrManager.GroupKey(sp.netAddress())]--
+					state.removeOutboundTreeHash(sp)
 				})
 			}
 			msg.reply <- nil
@@ -1600,6 +2354,50 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		}
 
 		msg.reply <- errors.New("peer not found")
+
+	case setBanMsg:
+		if !msg.add {
+			if !state.banned.unban(msg.subnet) {
+				msg.reply <- errors.New("subnet is not banned")
+				return
+			}
+			srvrLog.Infof("Unbanned %v", msg.subnet)
+			s.saveBanList(state)
+			msg.reply <- nil
+			return
+		}
+
+		if state.banned.isSubnetBanned(msg.subnet) {
+			msg.reply <- errors.New("subnet is already banned")
+			return
+		}
+		srvrLog.Infof("Banned %v until %v", msg.subnet, msg.until)
+		state.banned.ban(msg.subnet, msg.until, banReasonManual)
+		s.saveBanList(state)
+
+		// Disconnect all peers within the newly banned subnet unless
+		// they have the noban permission.
+		state.forAllPeers(func(sp *serverPeer) {
+			ip := sp.banIP()
+			if ip != nil && msg.subnet.Contains(ip) &&
+				!sp.permissions.has(permNoBan) {
+
+				sp.Disconnect()
+			}
+		})
+		msg.reply <- nil
+
+	case listBannedMsg:
+		if state.banned.removeExpired() > 0 {
+			s.saveBanList(state)
+		}
+		msg.reply <- state.banned.entries()
+
+	case clearBannedMsg:
+		state.banned.clear()
+		srvrLog.Infof("Cleared all bans")
+		s.saveBanList(state)
+		msg.reply <- struct{}{}
 	}
 }
 
@@ -1642,6 +2440,9 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnMiningState:    sp.OnMiningState,
 			OnTx:             sp.OnTx,
 			OnBlock:          sp.OnBlock,
+			OnCmpctBlock:     sp.OnCmpctBlock,
+			OnGetBlockTxn:    sp.OnGetBlockTxn,
+			OnBlockTxn:       sp.OnBlockTxn,
 			OnInv:            sp.OnInv,
 			OnHeaders:        sp.OnHeaders,
 			OnGetData:        sp.OnGetData,
@@ -1650,21 +2451,28 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnGetCFilter:     sp.OnGetCFilter,
 			OnGetCFHeaders:   sp.OnGetCFHeaders,
 			OnGetCFTypes:     sp.OnGetCFTypes,
+			OnGetCChain:      sp.OnGetCChain,
+			OnCChain:         sp.OnCChain,
+			OnGetPatch:       sp.OnGetPatch,
+			OnPatch:          sp.OnPatch,
 			OnGetAddr:        sp.OnGetAddr,
 			OnAddr:           sp.OnAddr,
+			OnAddrV2:         sp.OnAddrV2,
 			OnRead:           sp.OnRead,
 			OnWrite:          sp.OnWrite,
 		},
 		NewestBlock:       sp.newestBlock,
-		HostToNetAddress:  sp.server.addrManager.HostToNetAddress,
+		HostToNetAddress:  sp.server.hostToNetAddress,
 		Proxy:             cfg.Proxy,
 		UserAgentName:     userAgentName,
 		UserAgentVersion:  userAgentVersion,
 		UserAgentComments: userAgentComments,
 		ChainParams:       sp.server.chainParams,
 		Services:          sp.server.services,
-		DisableRelayTx:    cfg.BlocksOnly,
+		DisableRelayTx:    sp.blocksOnly(),
 		ProtocolVersion:   maxProtocolVersion,
+		TreeHash:          chainhash.Hash(compiledTreeHash()),
+		V2Transport:       cfg.V2Transport,
 	}
 }
 
@@ -1674,7 +2482,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 // for disconnection.
 func (s *server) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	sp.permissions = whitelistPermissions(conn.RemoteAddr())
 	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
@@ -1687,17 +2495,63 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	sp.blockRelayOnly = c.BlockRelayOnly
+	sp.v2Transport = s.useV2Transport(c)
+	sp.permissions = whitelistPermissions(conn.RemoteAddr())
+	peerCfg := newPeerConfig(sp)
+	peerCfg.V2Transport = sp.v2Transport
+	p, err := peer.NewOutboundPeer(peerCfg, c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
 		s.connManager.Disconnect(c.ID())
 	}
 	sp.Peer = p
 	sp.connReq = c
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+
+	// Track the onion address the connection was made to since Tor v3
+	// onion addresses are not representable by the address of the peer.
+	if addr, ok := c.Addr.(*onionAddr); ok {
+		na, err := s.addrManager.HostToNetAddressV2(addr.host,
+			uint16(addr.port), 0)
+		if err == nil {
+			sp.netAddr = na
+		}
+	}
+
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
-	s.addrManager.Attempt(sp.NA())
+	s.addrManager.AttemptV2(sp.netAddress())
+}
+
+// useV2Transport returns whether or not to initiate the v2 transport for the
+// passed outbound connection request.  It is initiated to permanent peers and
+// addresses which are known to advertise support for it unless a previous v2
+// transport handshake with the address failed.
+func (s *server) useV2Transport(c *connmgr.ConnReq) bool {
+	if !cfg.V2Transport {
+		return false
+	}
+
+	host, portStr, err := net.SplitHostPort(c.Addr.String())
+	if err != nil {
+		return false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return false
+	}
+	na, err := s.addrManager.HostToNetAddressV2(host, uint16(port), 0)
+	if err != nil {
+		return false
+	}
+	if s.V1TransportAddr(addrmgr.NetAddressKeyV2(na)) {
+		return false
+	}
+	if c.Permanent {
+		return true
+	}
+	services, _ := s.addrManager.KnownServices(na)
+	return hasServices(services, wire.SFNodeP2PV2)
 }
 
 // peerDoneHandler handles peer disconnects by notifiying the server that it's
@@ -1710,6 +2564,9 @@ func (s *server) peerDoneHandler(sp *serverPeer) {
 	if sp.VersionKnown() {
 		s.blockManager.DonePeer(sp)
 	}
+	if s.updateManager != nil {
+		s.updateManager.DonePeer(sp.Peer)
+	}
 	close(sp.quit)
 }
 
@@ -1724,6 +2581,9 @@ func (s *server) peerHandler() {
 	// in this handler.
 	s.addrManager.Start()
 	s.blockManager.Start()
+	if s.updateManager != nil {
+		s.updateManager.Start()
+	}
 
 	srvrLog.Tracef("Starting peer handler")
 
@@ -1731,8 +2591,22 @@ func (s *server) peerHandler() {
 		inboundPeers:    make(map[int32]*serverPeer),
 		persistentPeers: make(map[int32]*serverPeer),
 		outboundPeers:   make(map[int32]*serverPeer),
-		banned:          make(map[string]time.Time),
+		banned:          newBanList(filepath.Join(cfg.DataDir, banListFilename)),
 		outboundGroups:  make(map[string]int),
+
+		outboundTreeHashes: make(map[chainhash.Hash]int),
+		addrTreeHashes:     make(map[string]chainhash.Hash),
+		v1TransportAddrs:   make(map[string]time.Time),
+	}
+	rand.Read(state.evictionKey[:])
+
+	// Load the bans which were saved before the last shutdown.
+	if err := state.banned.load(); err != nil {
+		srvrLog.Errorf("Unable to load ban list from %s: %v",
+			state.banned.file, err)
+	} else if len(state.banned.bans) > 0 {
+		srvrLog.Infof("Loaded %d bans from %s", len(state.banned.bans),
+			state.banned.file)
 	}
 
 	if !cfg.DisableDNSSeed {
@@ -1780,7 +2654,10 @@ out:
 			s.handleQuery(state, qmsg)
 
 		case <-s.quit:
-			// Disconnect all peers on server shutdown.
+			// Save the block-relay-only peers so they are reconnected
+			// first on the next startup and disconnect all peers on
+			// server shutdown.
+			s.saveAnchors(state)
 			state.forAllPeers(func(sp *serverPeer) {
 				srvrLog.Tracef("Shutdown peer %s", sp)
 				sp.Disconnect()
@@ -1790,6 +2667,9 @@ out:
 	}
 
 	s.connManager.Stop()
+	if s.updateManager != nil {
+		s.updateManager.Stop()
+	}
 	s.blockManager.Stop()
 	s.addrManager.Stop()
 
@@ -1828,6 +2708,34 @@ func (s *server) RelayInventory(invVect *wire.InvVect, data interface{}, immedia
 	s.relayInv <- relayMsg{invVect: invVect, data: data, immediate: immediate}
 }
 
+// compiledTreeHash returns the Codechain source tree hash compiled into the
+// binary.  It is the zero hash when the tree hash was not set during the build
+// process or is not valid, since the source tree of the running binary is
+// unknown then.  It is the only tree hash advertised to peers, so they can rely
+// on it to describe the code the node is running.
+func compiledTreeHash() [32]byte {
+	var hash [32]byte
+	b, err := hex.DecodeString(treehash)
+	if err == nil && len(b) == len(hash) {
+		copy(hash[:], b)
+	}
+	return hash
+}
+
+// CodechainHead returns the Codechain source tree hash which is published in
+// the CodechainHead field of the headers of mined blocks.  It is the tree hash
+// compiled into the binary, if set, and the last signed tree hash of the local
+// hashchain otherwise.
+//
+// This function is safe for concurrent access.
+func (s *server) CodechainHead() [32]byte {
+	head := compiledTreeHash()
+	if head == [32]byte{} && s.updateManager != nil {
+		return s.updateManager.LastSignedTreeHash()
+	}
+	return head
+}
+
 // BroadcastMessage sends msg to all peers currently connected to the server
 // except those in the passed peers to exclude.
 func (s *server) BroadcastMessage(msg wire.Message, exclPeers ...*serverPeer) {
@@ -1854,6 +2762,56 @@ func (s *server) OutboundGroupCount(key string) int {
 	return <-replyChan
 }
 
+// OutboundTreeHashCount returns the number of outbound peers running the code
+// version, identified by the tree hash of its source tree, which the last
+// outbound connection to the given address advertised.  It returns zero if the
+// code version of the address is unknown.
+func (s *server) OutboundTreeHashCount(key string) int {
+	replyChan := make(chan int)
+	s.query <- getOutboundTreeHash{key: key, reply: replyChan}
+	return <-replyChan
+}
+
+// V1TransportAddr returns whether or not the given address recently rejected an
+// outbound v2 transport handshake, in which case connections to it must use
+// the v1 transport.
+func (s *server) V1TransportAddr(key string) bool {
+	replyChan := make(chan bool)
+	s.query <- getV1TransportAddr{key: key, reply: replyChan}
+	return <-replyChan
+}
+
+// BanSubnet bans the passed subnet until the provided time and disconnects all
+// peers within it.  An error is returned if the subnet is already banned.
+func (s *server) BanSubnet(subnet *net.IPNet, until time.Time) error {
+	replyChan := make(chan error)
+	s.query <- setBanMsg{subnet: subnet, add: true, until: until,
+		reply: replyChan}
+	return <-replyChan
+}
+
+// UnbanSubnet removes the ban of the passed subnet.  An error is returned if
+// the subnet is not banned.
+func (s *server) UnbanSubnet(subnet *net.IPNet) error {
+	replyChan := make(chan error)
+	s.query <- setBanMsg{subnet: subnet, add: false, reply: replyChan}
+	return <-replyChan
+}
+
+// BannedSubnets returns all currently banned subnets.
+func (s *server) BannedSubnets() []banEntry {
+	replyChan := make(chan []banEntry)
+	s.query <- listBannedMsg{reply: replyChan}
+	return <-replyChan
+}
+
+// ClearBanned removes all bans.
+func (s *server) ClearBanned() {
+	replyChan := make(chan struct{})
+	s.query <- clearBannedMsg{reply: replyChan}
+	<-replyChan
+}
+
 // AddedNodeInfo returns an array of bitumjson.GetAddedNodeInfoResult structures
 // describing the persistent (added) nodes.
 func (s *server) AddedNodeInfo() []*serverPeer {
@@ -1940,6 +2898,7 @@ func (s *server) ConnectNode(addr string, permanent bool) error {
 // for the server.  It is safe for concurrent access.
 func (s *server) AddBytesSent(bytesSent uint64) {
 	atomic.AddUint64(&s.bytesSent, bytesSent)
+	s.uploadTarget.addBytes(bytesSent)
 }
 
 // AddBytesReceived adds the passed number of bytes to the total bytes received
@@ -2322,8 +3281,16 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if cfg.NoCFilters {
 		services &^= wire.SFNodeCF
 	}
+	if cfg.V2Transport {
+		services |= wire.SFNodeP2PV2
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, bitumdLookup)
+	if cfg.asmap != nil {
+		amgr.SetASMap(cfg.asmap)
+		srvrLog.Infof("Grouping peers by ASN using asmap %s (version %s)",
+			cfg.ASMap, cfg.asmap.Version())
+	}
 
 	var listeners []net.Listener
 	var nat NAT
@@ -2463,6 +3430,7 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		broadcast:            make(chan broadcastMsg, cfg.MaxPeers),
 		quit:                 make(chan struct{}),
 		modifyRebroadcastInv: make(chan interface{}),
+		uploadTarget:         newUploadTarget(cfg.MaxUploadTarget * 1024 * 1024),
 		peerHeightsUpdate:    make(chan updatePeerHeightsMsg),
 		nat:                  nat,
 		db:                   db,
@@ -2534,6 +3502,31 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if len(indexes) > 0 {
 		indexManager = indexers.NewManager(db, indexes, chainParams)
 	}
+
+	// Create the update manager which handles Codechain entries and patch
+	// files unless the updater is disabled.
+	if !cfg.NoUpdater {
+		um, err := updater.NewUpdateManager(&updater.Config{
+			ChainParams: s.chainParams,
+			MaxPeers:    cfg.MaxPeers,
+			TreeHash:    treehash,
+			DB:          s.db,
+			DataDir:     cfg.DataDir,
+			RelayInventory: func(invVect *wire.InvVect) {
+				s.RelayInventory(invVect, nil, true)
+			},
+			AutoUpdate:            cfg.AutoUpdate,
+			AutoUpdateProbability: cfg.AutoUpdateProb,
+			AutoUpdateWindow:      cfg.AutoUpdateWindow,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.updateManager = um
+	} else {
+		updtLog.Info("Updater is disabled")
+	}
+
 	bm, err := newBlockManager(&s, indexManager, interrupt)
 	if err != nil {
 		return nil, err
@@ -2589,7 +3582,8 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		TxMinFreeFee:      cfg.minRelayTxFee,
 	}
 	blockTemplateGenerator := newBlkTmplGenerator(&policy, s.txMemPool,
-		s.timeSource, s.sigCache, s.chainParams, bm.chain, bm)
+		s.timeSource, s.sigCache, s.chainParams, bm.chain, bm,
+		s.CodechainHead)
 	s.cpuMiner = newCPUMiner(&cpuminerConfig{
 		ChainParams:                s.chainParams,
 		PermitConnectionlessMining: cfg.SimNet,
@@ -2610,7 +3604,7 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if !cfg.SimNet && len(cfg.ConnectPeers) == 0 {
 		newAddressFunc = func() (net.Addr, error) {
 			for tries := 0; tries < 100; tries++ {
-				addr := s.addrManager.GetAddress()
+				addr := s.addrManager.GetAddressV2()
 				if addr == nil {
 					break
 				}
@@ -2621,11 +3615,20 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 				// in the same group so that we are not connecting
 				// to the same network segment at the expense of
 				// others.
-				key := addrmgr.GroupKey(addr.NetAddress())
+				key := s.addrManager.GroupKey(addr.NetAddressV2())
 				if s.OutboundGroupCount(key) != 0 {
 					continue
 				}
 
+				// Prefer addresses which ran a different code version
+				// than the existing outbound peers the last time they
+				// were connected to, unless failed too many times.
+				addrKey := addrmgr.NetAddressKeyV2(addr.NetAddressV2())
+				if cfg.DiverseOutbound && tries < diverseOutboundTries &&
+					s.OutboundTreeHashCount(addrKey) != 0 {
+					continue
+				}
+
 				// only allow recent nodes (10mins) after we failed 30
 				// times
 				if tries < 30 && time.Since(addr.LastAttempt()) < 10*time.Minute {
@@ -2633,13 +3636,12 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 				}
 
 				// allow nondefault ports after 50 failed tries.
-				if fmt.Sprintf("%d", addr.NetAddress().Port) !=
+				if fmt.Sprintf("%d", addr.NetAddressV2().Port) !=
 					activeNetParams.DefaultPort && tries < 50 {
 					continue
 				}
 
-				addrString := addrmgr.NetAddressKey(addr.NetAddress())
-				return addrStringToNetAddr(addrString)
+				return addrStringToNetAddr(addrKey)
 			}
 
 			return nil, errors.New("no valid connect address")
@@ -2651,14 +3653,52 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if cfg.MaxPeers < targetOutbound {
 		targetOutbound = cfg.MaxPeers
 	}
+
+	// Block-relay-only connections are made in addition to the automatic
+	// outbound connections, so they are only made when new addresses are
+	// connected to.  The block-relay-only peers saved on the last shutdown
+	// are reconnected first.
+	var targetBlockRelayOnly int
+	var anchors []net.Addr
+	if newAddressFunc != nil {
+		targetBlockRelayOnly = cfg.BlockRelayOnlyConns
+		if cfg.MaxPeers-targetOutbound < targetBlockRelayOnly {
+			targetBlockRelayOnly = cfg.MaxPeers - targetOutbound
+		}
+
+		anchorsFile := filepath.Join(cfg.DataDir, anchorsFilename)
+		anchorAddrs, err := loadAnchors(anchorsFile)
+		if err != nil {
+			srvrLog.Warnf("Unable to load anchors from %s: %v",
+				anchorsFile, err)
+		}
+		for _, addr := range anchorAddrs {
+			if len(anchors) == targetBlockRelayOnly {
+				break
+			}
+			netAddr, err := addrStringToNetAddr(addr)
+			if err != nil {
+				srvrLog.Debugf("Ignoring anchor %s: %v", addr, err)
+				continue
+			}
+			anchors = append(anchors, netAddr)
+		}
+		if len(anchors) > 0 {
+			srvrLog.Infof("Loaded %d anchors from %s", len(anchors),
+				anchorsFile)
+		}
+	}
+
 	cmgr, err := connmgr.New(&connmgr.Config{
-		Listeners:      listeners,
-		OnAccept:       s.inboundPeerConnected,
-		RetryDuration:  connectionRetryInterval,
-		TargetOutbound: uint32(targetOutbound),
-		Dial:           bitumdDial,
-		OnConnection:   s.outboundPeerConnected,
-		GetNewAddress:  newAddressFunc,
+		Listeners:            listeners,
+		OnAccept:             s.inboundPeerConnected,
+		RetryDuration:        connectionRetryInterval,
+		TargetOutbound:       uint32(targetOutbound),
+		TargetBlockRelayOnly: uint32(targetBlockRelayOnly),
+		Anchors:              anchors,
+		Dial:                 bitumdDial,
+		OnConnection:         s.outboundPeerConnected,
+		GetNewAddress:        newAddressFunc,
 	})
 	if err != nil {
 		return nil, err
@@ -2699,6 +3739,40 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	return &s, nil
 }
 
+// onionAddr implements the net.Addr interface and represents a Tor onion
+// service address.
+type onionAddr struct {
+	host string
+	port int
+}
+
+// Network returns the network of the address, which is always "tcp".  This is
+// part of the net.Addr interface.
+func (a *onionAddr) Network() string {
+	return "tcp"
+}
+
+// String returns the address in the form of 'host:port'.  This is part of the
+// net.Addr interface.
+func (a *onionAddr) String() string {
+	return net.JoinHostPort(a.host, strconv.Itoa(a.port))
+}
+
+// hostToNetAddress returns a netaddress given a host address like the address
+// manager does (see addrmgr.AddrManager.HostToNetAddress).  Tor v3 onion
+// addresses, which are not representable by a wire.NetAddress, result in an
+// unspecified address.
+func (s *server) hostToNetAddress(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddress, error) {
+	na, err := s.addrManager.HostToNetAddressV2(host, port, services)
+	if err != nil {
+		return nil, err
+	}
+	if legacy, ok := na.ToLegacy(); ok {
+		return legacy, nil
+	}
+	return wire.NewNetAddressIPPort(net.IPv6unspecified, port, services), nil
+}
+
 // addrStringToNetAddr takes an address in the form of 'host:port' and returns
 // a net.Addr which maps to the original address with any host names resolved
 // to IP addresses.
@@ -2708,6 +3782,16 @@ func addrStringToNetAddr(addr string) (net.Addr, error) {
 		return nil, err
 	}
 
+	// Onion addresses can not be resolved to an IP address, so they are
+	// dialed by name via Tor instead.
+	if strings.HasSuffix(host, ".onion") {
+		port, err := strconv.Atoi(strPort)
+		if err != nil {
+			return nil, err
+		}
+		return &onionAddr{host: host, port: port}, nil
+	}
+
 	// Attempt to look up an IP address associated with the parsed host.
 	// The bitumdLookup function will transparently handle performing the
 	// lookup over Tor if necessary.
@@ -2751,29 +3835,3 @@ func dynamicTickDuration(remaining time.Duration) time.Duration {
 	}
 	return time.Hour
 }
-
-// isWhitelisted returns whether the IP address is included in the whitelisted
-// networks and IPs.
-func isWhitelisted(addr net.Addr) bool {
-	if len(cfg.whitelists) == 0 {
-		return false
-	}
-
-	host, _, err := net.SplitHostPort(addr.String())
-	if err != nil {
-		srvrLog.Warnf("Unable to SplitHostPort on '%s': %v", addr, err)
-		return false
-	}
-	ip := net.ParseIP(host)
-	if ip == nil {
-		srvrLog.Warnf("Unable to parse IP '%s'", addr)
-		return false
-	}
-
-	for _, ipnet := range cfg.whitelists {
-		if ipnet.Contains(ip) {
-			return true
-		}
-	}
-	return false
-}
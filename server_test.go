@@ -0,0 +1,98 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestCompiledTreeHash ensures the tree hash advertised to peers is the one
+// compiled into the binary and the zero hash when it is unknown.
+func TestCompiledTreeHash(t *testing.T) {
+	defer func(orig string) { treehash = orig }(treehash)
+
+	tests := []struct {
+		name     string
+		treehash string
+		want     [32]byte
+	}{{
+		name:     "compiled tree hash",
+		treehash: "0100000000000000000000000000000000000000000000000000000000000002",
+		want:     [32]byte{0: 0x01, 31: 0x02},
+	}, {
+		name:     "undefined tree hash",
+		treehash: "undefined",
+		want:     [32]byte{},
+	}, {
+		name:     "short tree hash",
+		treehash: "0102",
+		want:     [32]byte{},
+	}}
+
+	for _, test := range tests {
+		treehash = test.treehash
+		if got := compiledTreeHash(); got != test.want {
+			t.Errorf("%q: unexpected tree hash -- got %x, want %x",
+				test.name, got, test.want)
+		}
+	}
+
+	// The server publishes the compiled tree hash in mined blocks too.
+	treehash = tests[0].treehash
+	s := &server{}
+	if got := s.CodechainHead(); got != tests[0].want {
+		t.Errorf("unexpected Codechain head -- got %x, want %x", got,
+			tests[0].want)
+	}
+}
+
+// TestV1TransportAddrs ensures addresses which rejected the v2 transport are
+// remembered until they expire and the number of them is limited.
+func TestV1TransportAddrs(t *testing.T) {
+	state := &peerState{v1TransportAddrs: make(map[string]time.Time)}
+	now := time.Unix(1550000000, 0)
+
+	state.addV1TransportAddr("10.0.0.1:9108", now)
+	if !state.isV1TransportAddr("10.0.0.1:9108", now.Add(time.Hour)) {
+		t.Fatal("address not remembered")
+	}
+	if state.isV1TransportAddr("10.0.0.2:9108", now) {
+		t.Fatal("unexpected unknown address")
+	}
+	expiry := now.Add(v1TransportAddrTimeout)
+	if state.isV1TransportAddr("10.0.0.1:9108", expiry) {
+		t.Fatal("address not expired")
+	}
+	if len(state.v1TransportAddrs) != 0 {
+		t.Fatal("expired address not removed")
+	}
+
+	// Fill the map with one old address and ensure it is the one removed
+	// to make room for a new one.
+	state.addV1TransportAddr("old", now)
+	for i := 1; i < maxV1TransportAddrs; i++ {
+		state.addV1TransportAddr(fmt.Sprintf("addr%d", i), expiry)
+	}
+	state.addV1TransportAddr("new", expiry)
+	if len(state.v1TransportAddrs) != maxV1TransportAddrs {
+		t.Fatalf("unexpected number of addresses -- got %d, want %d",
+			len(state.v1TransportAddrs), maxV1TransportAddrs)
+	}
+	if _, ok := state.v1TransportAddrs["old"]; ok {
+		t.Fatal("expired address not removed")
+	}
+
+	// Ensure an arbitrary address is evicted when none expired.
+	state.addV1TransportAddr("newer", expiry)
+	if len(state.v1TransportAddrs) != maxV1TransportAddrs {
+		t.Fatalf("unexpected number of addresses -- got %d, want %d",
+			len(state.v1TransportAddrs), maxV1TransportAddrs)
+	}
+	if !state.isV1TransportAddr("newer", expiry) {
+		t.Fatal("new address not remembered")
+	}
+}
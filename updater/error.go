@@ -0,0 +1,112 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"fmt"
+)
+
+// ErrorCode identifies a kind of error.
+type ErrorCode int
+
+// These constants are used to identify a specific RuleError.
+const (
+	// ErrInvalidPatch indicates a patch file does not lead from the
+	// preceding signed tree hash to the signed tree hash it was sent for.
+	ErrInvalidPatch ErrorCode = iota
+
+	// ErrUnsignedPatch indicates a patch file was sent for a tree hash
+	// which is not a signed tree hash of the local hashchain.
+	ErrUnsignedPatch
+
+	// ErrMalformedEntry indicates a hashchain entry cannot be parsed, has
+	// the wrong number of type fields, has an unknown type, is a cstart
+	// entry which does not start the hashchain, or has a time before the
+	// one of the preceding entry.
+	ErrMalformedEntry
+
+	// ErrBrokenLink indicates a hashchain entry does not refer to the
+	// hash of the preceding entry.
+	ErrBrokenLink
+
+	// ErrBadSignature indicates the signature of a hashchain entry does
+	// not verify.
+	ErrBadSignature
+
+	// ErrUnknownSigner indicates a source or signature entry was created
+	// with a key which is not a signer of the hashchain.
+	ErrUnknownSigner
+
+	// ErrUnknownLinkHash indicates a signature entry signs a hashchain
+	// entry which does not exist.
+	ErrUnknownLinkHash
+
+	// ErrDuplicateTreeHash indicates a source entry publishes a tree hash
+	// which has already been published.
+	ErrDuplicateTreeHash
+
+	// ErrInvalidKeyChange indicates an addkey entry adds a key which is
+	// already a signer or with a weight out of range, or a remkey entry
+	// removes a key which is not a signer.
+	ErrInvalidKeyChange
+
+	// ErrInvalidThreshold indicates a sigctl entry sets a signature
+	// threshold m which is not positive or larger than the total weight
+	// of the signers n, or a remkey entry would lower n below m.
+	ErrInvalidThreshold
+
+	// ErrUnsatisfiedEntries indicates a peer did not send the hashchain
+	// entries up to a head it announced, either by sending all of the
+	// entries it has without reaching the head or by not sending them in
+	// time.
+	ErrUnsatisfiedEntries
+
+	// numErrorCodes is the maximum error code number used in tests.
+	numErrorCodes
+)
+
+// Map of ErrorCode values back to their constant names for pretty printing.
+var errorCodeStrings = map[ErrorCode]string{
+	ErrInvalidPatch:       "ErrInvalidPatch",
+	ErrUnsignedPatch:      "ErrUnsignedPatch",
+	ErrMalformedEntry:     "ErrMalformedEntry",
+	ErrBrokenLink:         "ErrBrokenLink",
+	ErrBadSignature:       "ErrBadSignature",
+	ErrUnknownSigner:      "ErrUnknownSigner",
+	ErrUnknownLinkHash:    "ErrUnknownLinkHash",
+	ErrDuplicateTreeHash:  "ErrDuplicateTreeHash",
+	ErrInvalidKeyChange:   "ErrInvalidKeyChange",
+	ErrInvalidThreshold:   "ErrInvalidThreshold",
+	ErrUnsatisfiedEntries: "ErrUnsatisfiedEntries",
+}
+
+// String returns the ErrorCode as a human-readable name.
+func (e ErrorCode) String() string {
+	if s := errorCodeStrings[e]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError identifies a rule violation.  It is used to indicate that
+// processing of a hashchain entry or patch file received from a peer failed
+// due to one of the validation rules.  The caller can use type assertions to
+// determine if a failure was specifically due to a rule violation and access
+// the ErrorCode field to ascertain the specific reason for the rule
+// violation.
+type RuleError struct {
+	ErrorCode   ErrorCode // Describes the kind of error
+	Description string    // Human readable description of the issue
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+// ruleError creates an RuleError given a set of arguments.
+func ruleError(c ErrorCode, desc string) RuleError {
+	return RuleError{ErrorCode: c, Description: desc}
+}
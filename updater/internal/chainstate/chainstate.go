@@ -7,79 +7,482 @@ package chainstate
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/bitum-project/bitumd/database"
 	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/patchfile"
 	"github.com/frankbraun/codechain/tree"
 	"github.com/frankbraun/codechain/util/file"
 	"github.com/frankbraun/codechain/util/hex"
 )
 
-// ChainState holds the hashchain state for the Bitum updater.
-type ChainState struct {
-	hashchainEntries map[string]bool
-	head             [32]byte
-	patchFiles       map[string]bool
+var (
+	// ErrNoLines is returned by Verify and Append if no hashchain lines
+	// were given.
+	ErrNoLines = errors.New("chainstate: no hashchain lines given")
+
+	// ErrTreeHashNotSigned is returned by AddPatch if the tree hash of the
+	// patch is not a signed tree hash of the hashchain.
+	ErrTreeHashNotSigned = errors.New("chainstate: tree hash is not signed")
+
+	// ErrPatchMissing is returned by AddPatch if the patch files for the
+	// preceding tree hashes are not known yet.
+	ErrPatchMissing = errors.New("chainstate: preceding patch files missing")
+)
+
+// PatchError is returned by AddPatch if a patch file does not lead from the
+// preceding signed tree hash to the signed tree hash it was announced for.
+type PatchError struct {
+	TreeHash string // Tree hash the patch file was announced for
+	Err      error  // Reason the patch file is invalid
 }
 
-// New returns a new ChainState.
-func New(dataDir string) (*ChainState, error) {
-	var cs ChainState
-	cs.hashchainEntries = make(map[string]bool)
-	cs.patchFiles = make(map[string]bool)
+// Error satisfies the error interface and prints human-readable errors.
+func (e PatchError) Error() string {
+	return fmt.Sprintf("chainstate: invalid patch file for tree hash %s: %v",
+		e.TreeHash, e.Err)
+}
 
-	hashchainFile := filepath.Join(dataDir, "src", ".codechain", "hashchain")
-	exists, err := file.Exists(hashchainFile)
-	if err != nil {
+// ChainState holds the hashchain state for the Bitum updater.  The state is
+// stored in the database and updated incrementally as new hashchain entries
+// and patch files arrive.  Only the head and the tree hashes are kept in
+// memory.
+type ChainState struct {
+	db         database.DB
+	head       [32]byte
+	numLines   uint32
+	treeHashes []string
+	lastSigned int
+	treeDir    string
+}
+
+// New returns a new ChainState which is stored in the given database.  The
+// chain state is created in the database if it does not exist yet, in which
+// case the hashchain file and patch files of the given data directory are
+// imported.
+func New(db database.DB, dataDir string) (*ChainState, error) {
+	cs := ChainState{
+		db:      db,
+		treeDir: filepath.Join(dataDir, "updater", "tree"),
+	}
+	if err := os.MkdirAll(cs.treeDir, 0755); err != nil {
 		return nil, err
 	}
-
-	// add empty hash
-	log.Debug("Adding hashchain entries...")
-	cs.hashchainEntries[tree.EmptyHash] = true
-	head, err := hex.Decode(tree.EmptyHash, 32)
-	if err != nil {
+	if err := cs.upgradeDB(dataDir); err != nil {
 		return nil, err
 	}
-	copy(cs.head[:], head)
-	log.Debug(tree.EmptyHash)
-	if exists {
-		// add other entries
-		hc, err := hashchain.ReadFile(hashchainFile)
+	if err := cs.loadState(); err != nil {
+		return nil, err
+	}
+	log.Debugf("Loaded updater chain state: %d hashchain entries, head %x",
+		cs.numLines, cs.head[:])
+	return &cs, nil
+}
+
+// loadState loads the head and the tree hashes from the database.
+func (cs *ChainState) loadState() error {
+	return cs.db.View(func(dbTx database.Tx) error {
+		state, err := dbFetchState(dbTx)
 		if err != nil {
-			return nil, err
-		}
-		defer hc.Close()
-		var buf bytes.Buffer
-		if err := hc.Fprint(&buf); err != nil {
-			return nil, err
+			return err
 		}
-		for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
-			hash := sha256.Sum256(line)
-			hashStr := hex.Encode(hash[:])
-			log.Debug(hashStr)
-			cs.hashchainEntries[hashStr] = true
+		treeHashes, err := dbFetchTreeHashes(dbTx, state.numTreeHashes)
+		if err != nil {
+			return err
 		}
-		head := hc.Head()
-		copy(cs.head[:], head[:])
-	}
-
-	return &cs, nil
+		cs.head = state.head
+		cs.numLines = state.numLines
+		cs.treeHashes = treeHashes
+		cs.lastSigned = int(state.lastSigned)
+		return nil
+	})
 }
 
-// Close chain state.
+// Close chain state.  The database is owned by the caller and remains open.
 func (cs *ChainState) Close() {
-	// TODO
 }
 
-// EntryIsKnown returns truf the given hashchainEntry is known and false
-// otherwise.
+// EntryIsKnown returns true if the given hashchainEntry is known and false
+// otherwise.  The empty tree hash is always known, because it denotes the
+// beginning of the hashchain.
 func (cs *ChainState) EntryIsKnown(hashchainEntry string) bool {
-	return cs.hashchainEntries[hashchainEntry]
+	if hashchainEntry == tree.EmptyHash {
+		return true
+	}
+	entry, err := hex.Decode(hashchainEntry, 32)
+	if err != nil {
+		return false
+	}
+	var hash [32]byte
+	copy(hash[:], entry)
+	var known bool
+	err = cs.db.View(func(dbTx database.Tx) error {
+		_, known = dbFetchEntryPosition(dbTx, hash)
+		return nil
+	})
+	return err == nil && known
+}
+
+// CheckHead makes sure the given head is an entry of the hashchain.
+// Otherwise, hashchain.ErrHeadNotFound is returned.
+func (cs *ChainState) CheckHead(head [32]byte) error {
+	if !cs.EntryIsKnown(hex.Encode(head[:])) {
+		return hashchain.ErrHeadNotFound
+	}
+	return nil
 }
 
 // Head returns the hash of the last known hashchain entry.
 func (cs *ChainState) Head() [32]byte {
 	return cs.head
 }
+
+// StartLine returns the first hashchain line, which starts the hashchain, or
+// the empty string if no hashchain entries are known yet.
+func (cs *ChainState) StartLine() string {
+	var zero [32]byte
+	lines := cs.Lines(zero, zero, 1)
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[0]
+}
+
+// IsEmpty returns true if no hashchain entries are known yet.
+func (cs *ChainState) IsEmpty() bool {
+	return cs.numLines == 0
+}
+
+// Lines returns up to max hashchain lines following the entry start up to
+// and including the entry stop.  A zero start (or the empty hash) denotes the
+// beginning of the hashchain and a zero stop denotes the current head.  Nil is
+// returned if start or stop are not known or stop precedes start.
+func (cs *ChainState) Lines(start, stop [32]byte, max int) []string {
+	var lines []string
+	err := cs.db.View(func(dbTx database.Tx) error {
+		var zero [32]byte
+		begin := uint32(0)
+		if start != zero && hex.Encode(start[:]) != tree.EmptyHash {
+			pos, ok := dbFetchEntryPosition(dbTx, start)
+			if !ok {
+				return nil
+			}
+			begin = pos + 1
+		}
+		end := cs.numLines
+		if stop != zero {
+			pos, ok := dbFetchEntryPosition(dbTx, stop)
+			if !ok {
+				return nil
+			}
+			end = pos + 1
+		}
+		if end < begin {
+			return nil
+		}
+		if end-begin > uint32(max) {
+			end = begin + uint32(max)
+		}
+		lines = make([]string, 0, end-begin)
+		for pos := begin; pos < end; pos++ {
+			line, err := dbFetchLine(dbTx, pos)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to fetch hashchain lines: %v", err)
+		return nil
+	}
+	return lines
+}
+
+// Verify verifies the hashchain which results from appending the given
+// lines to the known hashchain and returns it.  The returned hashchain is not
+// backed by a file.
+func (cs *ChainState) Verify(lines []string) (*hashchain.HashChain, error) {
+	if len(lines) == 0 {
+		return nil, ErrNoLines
+	}
+	var buf bytes.Buffer
+	err := cs.db.View(func(dbTx database.Tx) error {
+		for pos := uint32(0); pos < cs.numLines; pos++ {
+			line, err := dbFetchLine(dbTx, pos)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(line + "\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+	return hashchain.Read(&buf)
+}
+
+// Append verifies the given hashchain lines, stores them in the database, and
+// updates the chain state accordingly.
+func (cs *ChainState) Append(lines []string) error {
+	src, err := cs.Verify(lines)
+	if err != nil {
+		return err
+	}
+	treeHashes := src.TreeHashes()
+	_, lastSigned := src.LastSignedTreeHash()
+	state := dbState{
+		head:          src.Head(),
+		numLines:      cs.numLines + uint32(len(lines)),
+		numTreeHashes: uint32(len(treeHashes)),
+		lastSigned:    uint32(lastSigned),
+	}
+	err = cs.db.Update(func(dbTx database.Tx) error {
+		for i, line := range lines {
+			err := dbPutLine(dbTx, cs.numLines+uint32(i), line)
+			if err != nil {
+				return err
+			}
+		}
+		for pos := len(cs.treeHashes); pos < len(treeHashes); pos++ {
+			err := dbPutTreeHash(dbTx, uint32(pos), treeHashes[pos])
+			if err != nil {
+				return err
+			}
+		}
+		return dbPutState(dbTx, &state)
+	})
+	if err != nil {
+		return err
+	}
+	cs.head = state.head
+	cs.numLines = state.numLines
+	cs.treeHashes = treeHashes
+	cs.lastSigned = lastSigned
+	return nil
+}
+
+// signedTreeHashIndex returns the position of the given tree hash in the list
+// of tree hashes and true, if it is signed.  Otherwise, false is returned.
+func (cs *ChainState) signedTreeHashIndex(treeHash string) (int, bool) {
+	for i := 1; i <= cs.lastSigned; i++ {
+		if cs.treeHashes[i] == treeHash {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// LastSignedTreeHash returns the last signed tree hash of the hashchain, which
+// is the empty tree hash if no source tree has been signed yet.
+func (cs *ChainState) LastSignedTreeHash() string {
+	return cs.treeHashes[cs.lastSigned]
+}
+
+// SignedTreeHashes returns the signed tree hashes of the hashchain ordered from
+// oldest to newest.  The empty tree hash is not included.
+func (cs *ChainState) SignedTreeHashes() []string {
+	signed := make([]string, cs.lastSigned)
+	copy(signed, cs.treeHashes[1:cs.lastSigned+1])
+	return signed
+}
+
+// IsTreeHash returns true if the given tree hash is a signed or unsigned tree
+// hash of the hashchain and false otherwise.
+func (cs *ChainState) IsTreeHash(treeHash string) bool {
+	for _, h := range cs.treeHashes {
+		if h == treeHash {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSignedTreeHash returns true if the given tree hash is a signed tree hash
+// of the hashchain and false otherwise.  The empty tree hash is not
+// considered to be signed, because there is no patch file leading to it.
+func (cs *ChainState) IsSignedTreeHash(treeHash string) bool {
+	_, ok := cs.signedTreeHashIndex(treeHash)
+	return ok
+}
+
+// PatchIsKnown returns true if the patch file for the given tree hash is known
+// and false otherwise.
+func (cs *ChainState) PatchIsKnown(treeHash string) bool {
+	hash, err := hex.Decode(treeHash, 32)
+	if err != nil {
+		return false
+	}
+	var known bool
+	err = cs.db.View(func(dbTx database.Tx) error {
+		known = dbPatchExists(dbTx, hash)
+		return nil
+	})
+	return err == nil && known
+}
+
+// Patch returns the patch file for the given tree hash.
+func (cs *ChainState) Patch(treeHash string) ([]byte, error) {
+	hash, err := hex.Decode(treeHash, 32)
+	if err != nil {
+		return nil, err
+	}
+	var patch []byte
+	err = cs.db.View(func(dbTx database.Tx) error {
+		patch = dbFetchPatch(dbTx, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		return nil, fmt.Errorf("chainstate: unknown patch file %s", treeHash)
+	}
+	return patch, nil
+}
+
+// MissingPatches returns the signed tree hashes up to and including the given
+// one for which the patch files are not known, in hashchain order.
+func (cs *ChainState) MissingPatches(treeHash string) []string {
+	idx, ok := cs.signedTreeHashIndex(treeHash)
+	if !ok {
+		return nil
+	}
+	var missing []string
+	for i := 1; i <= idx; i++ {
+		if !cs.PatchIsKnown(cs.treeHashes[i]) {
+			missing = append(missing, cs.treeHashes[i])
+		}
+	}
+	return missing
+}
+
+// checkPatchHeader makes sure the patch file starts at the tree hash prev,
+// ends at the tree hash cur, and only refers to relative paths within the
+// tree it is applied to.
+func checkPatchHeader(patch []byte, prev, cur string) error {
+	lines := strings.Split(strings.TrimSuffix(string(patch), "\n"), "\n")
+	if len(lines) < 3 {
+		return errors.New("patch file too short")
+	}
+	if lines[1] != "treehash "+prev {
+		return fmt.Errorf("patch file does not start at tree hash %s", prev)
+	}
+	if lines[len(lines)-1] != "treehash "+cur {
+		return fmt.Errorf("patch file does not end at tree hash %s", cur)
+	}
+	for _, line := range lines[2 : len(lines)-1] {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 || (fields[0] != "-" && fields[0] != "+") ||
+			(fields[1] != "f" && fields[1] != "x") {
+			continue
+		}
+		name := fields[3]
+		if filepath.IsAbs(name) || filepath.Clean(name) != name ||
+			name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("patch file contains invalid path %q", name)
+		}
+	}
+	return nil
+}
+
+// syncTree brings the verification tree to the tree hash at the given
+// position by applying the known patch files from the beginning, if
+// necessary.
+func (cs *ChainState) syncTree(idx int) error {
+	hash, err := tree.Hash(cs.treeDir, nil)
+	if err != nil {
+		return err
+	}
+	if hex.Encode(hash[:]) == cs.treeHashes[idx] {
+		return nil
+	}
+	log.Debugf("Rebuilding verification tree for %s", cs.treeHashes[idx])
+	if err := file.RemoveAll(cs.treeDir, nil); err != nil {
+		return err
+	}
+	for i := 1; i <= idx; i++ {
+		patch, err := cs.Patch(cs.treeHashes[i])
+		if err != nil {
+			return err
+		}
+		err = patchfile.Apply(cs.treeDir, bytes.NewReader(patch), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPatch verifies the given patch file for the given signed tree hash and
+// stores it in the database.  The patch file must apply cleanly to the
+// source tree of the preceding tree hash and result in the given tree hash.
+// Therefore, the patch files for all preceding tree hashes must be known.
+func (cs *ChainState) AddPatch(treeHash string, patch []byte) error {
+	idx, ok := cs.signedTreeHashIndex(treeHash)
+	if !ok {
+		return ErrTreeHashNotSigned
+	}
+	for i := 1; i < idx; i++ {
+		if !cs.PatchIsKnown(cs.treeHashes[i]) {
+			return ErrPatchMissing
+		}
+	}
+	err := checkPatchHeader(patch, cs.treeHashes[idx-1], treeHash)
+	if err != nil {
+		return PatchError{TreeHash: treeHash, Err: err}
+	}
+	if err := cs.syncTree(idx - 1); err != nil {
+		return err
+	}
+	err = patchfile.Apply(cs.treeDir, bytes.NewReader(patch), nil)
+	if err != nil {
+		return PatchError{TreeHash: treeHash, Err: err}
+	}
+	hash, err := hex.Decode(treeHash, 32)
+	if err != nil {
+		return err
+	}
+	return cs.db.Update(func(dbTx database.Tx) error {
+		return dbPutPatch(dbTx, hash, patch)
+	})
+}
+
+// BuildTree builds the source tree of the given signed tree hash in the given
+// directory, which must not exist yet, by applying the known patch files from
+// the beginning of the hashchain.  The tree hash of the resulting source tree
+// is verified.
+func (cs *ChainState) BuildTree(dir, treeHash string) error {
+	idx, ok := cs.signedTreeHashIndex(treeHash)
+	if !ok {
+		return ErrTreeHashNotSigned
+	}
+	if len(cs.MissingPatches(treeHash)) > 0 {
+		return ErrPatchMissing
+	}
+	if err := cs.syncTree(idx); err != nil {
+		return err
+	}
+	if err := file.CopyDir(cs.treeDir, dir); err != nil {
+		return err
+	}
+	hash, err := tree.Hash(dir, nil)
+	if err != nil {
+		return err
+	}
+	if hex.Encode(hash[:]) != treeHash {
+		return fmt.Errorf("chainstate: source tree has tree hash %x "+
+			"instead of %s", hash[:], treeHash)
+	}
+	return nil
+}
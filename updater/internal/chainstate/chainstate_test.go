@@ -0,0 +1,228 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chainstate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitum-project/bitumd/database"
+	_ "github.com/bitum-project/bitumd/database/ffldb"
+	"github.com/bitum-project/bitumd/wire"
+	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/patchfile"
+	"github.com/frankbraun/codechain/tree"
+	"github.com/frankbraun/codechain/util/hex"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testHashchain creates a hashchain file in dir which contains a single signed
+// source tree and returns its lines, the signed tree hash, and a patch file
+// leading to it.
+func testHashchain(t *testing.T, dir string) ([]string, string, []byte) {
+	t.Helper()
+
+	emptyDir := filepath.Join(dir, "empty")
+	srcDir := filepath.Join(dir, "tree")
+	for _, d := range []string{emptyDir, srcDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ioutil.WriteFile(filepath.Join(srcDir, "main.go"),
+		[]byte("package main\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch bytes.Buffer
+	if err := patchfile.Diff(1, &patch, emptyDir, srcDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	treeHash, err := tree.Hash(srcDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, sec, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secKey [64]byte
+	copy(secKey[:], sec)
+	filename := filepath.Join(dir, "hashchain")
+	hc, _, err := hashchain.Start(filename, secKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+	if _, err := hc.Source(*treeHash, secKey, []byte("initial")); err != nil {
+		t.Fatal(err)
+	}
+	linkHash := hc.LinkHash(hex.Encode(treeHash[:]))
+	if _, err := hc.Signature(linkHash, secKey, false); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := hc.Fprint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	return lines, hex.Encode(treeHash[:]), patch.Bytes()
+}
+
+// TestChainState ensures the chain state imports an existing hashchain file
+// and patch files, stores new entries and patch files in the database, and
+// survives a restart.
+func TestChainState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lines, treeHash, patch := testHashchain(t, dir)
+
+	// Place the first two lines into the hashchain file of the source tree
+	// and the patch file into the legacy patch directory to exercise the
+	// migration.
+	dataDir := filepath.Join(dir, "data")
+	codechainDir := filepath.Join(dataDir, "src", ".codechain")
+	patchDir := filepath.Join(dataDir, "updater", "patches")
+	for _, d := range []string{codechainDir, patchDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(codechainDir, "hashchain"),
+		[]byte(strings.Join(lines[:2], "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(patchDir, treeHash), patch, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := filepath.Join(dir, "db")
+	db, err := database.Create("ffldb", dbPath, wire.MainNet)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cs, err := New(db, dataDir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	head := sha256.Sum256([]byte(lines[1]))
+	if cs.Head() != head {
+		t.Fatalf("unexpected head after import -- got %x, want %x",
+			cs.Head(), head)
+	}
+	if cs.IsSignedTreeHash(treeHash) || !cs.IsTreeHash(treeHash) {
+		t.Fatal("imported tree hash should be unsigned")
+	}
+	if cs.PatchIsKnown(treeHash) {
+		t.Fatal("patch file for unsigned tree hash should be skipped")
+	}
+	if _, err := os.Stat(patchDir); !os.IsNotExist(err) {
+		t.Fatalf("legacy patch directory should be removed: %v", err)
+	}
+
+	// Append the signature line and add the patch file.
+	if err := cs.Append(lines[2:]); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if !cs.IsSignedTreeHash(treeHash) {
+		t.Fatal("tree hash should be signed")
+	}
+	if err := cs.AddPatch(treeHash, patch); err != nil {
+		t.Fatalf("AddPatch: %v", err)
+	}
+
+	// Reopen the chain state and ensure it is loaded from the database.
+	if err := os.Remove(filepath.Join(codechainDir, "hashchain")); err != nil {
+		t.Fatal(err)
+	}
+	cs, err = New(db, dataDir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	head = sha256.Sum256([]byte(lines[len(lines)-1]))
+	if cs.Head() != head {
+		t.Fatalf("unexpected head after restart -- got %x, want %x",
+			cs.Head(), head)
+	}
+	if err := cs.CheckHead(sha256.Sum256([]byte(lines[0]))); err != nil {
+		t.Fatalf("CheckHead: %v", err)
+	}
+	if err := cs.CheckHead([32]byte{0x01}); err != hashchain.ErrHeadNotFound {
+		t.Fatalf("CheckHead: unexpected error %v", err)
+	}
+	if got := cs.SignedTreeHashes(); len(got) != 1 || got[0] != treeHash {
+		t.Fatalf("unexpected signed tree hashes %v", got)
+	}
+	if cs.LastSignedTreeHash() != treeHash {
+		t.Fatalf("unexpected last signed tree hash %s",
+			cs.LastSignedTreeHash())
+	}
+	stored, err := cs.Patch(treeHash)
+	if err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if !bytes.Equal(stored, patch) {
+		t.Fatal("stored patch file differs")
+	}
+	if missing := cs.MissingPatches(treeHash); len(missing) != 0 {
+		t.Fatalf("unexpected missing patch files %v", missing)
+	}
+
+	// Ensure the source tree of the signed tree hash is built and the empty
+	// tree hash is rejected.
+	buildDir := filepath.Join(dir, "build")
+	if err := cs.BuildTree(buildDir, treeHash); err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	built, err := tree.Hash(buildDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.Encode(built[:]) != treeHash {
+		t.Fatalf("unexpected tree hash of built tree %x", built[:])
+	}
+	err = cs.BuildTree(filepath.Join(dir, "build2"), tree.EmptyHash)
+	if err != ErrTreeHashNotSigned {
+		t.Fatalf("BuildTree: unexpected error %v", err)
+	}
+
+	// Ensure the stored lines are served as expected.
+	var zero [32]byte
+	got := cs.Lines(zero, zero, 100)
+	if strings.Join(got, "\n") != strings.Join(lines, "\n") {
+		t.Fatalf("unexpected lines %v", got)
+	}
+	got = cs.Lines(sha256.Sum256([]byte(lines[0])), zero, 1)
+	if len(got) != 1 || got[0] != lines[1] {
+		t.Fatalf("unexpected lines %v", got)
+	}
+	if got := cs.Lines([32]byte{0x01}, zero, 100); got != nil {
+		t.Fatalf("unexpected lines for unknown start %v", got)
+	}
+
+	// Appending lines which do not extend the hashchain must fail without
+	// changing the state.
+	if err := cs.Append(lines[1:2]); err == nil {
+		t.Fatal("Append: expected error for invalid lines")
+	}
+	if cs.Head() != head {
+		t.Fatal("head changed after invalid append")
+	}
+}
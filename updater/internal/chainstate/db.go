@@ -0,0 +1,302 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chainstate
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bitum-project/bitumd/database"
+	"github.com/frankbraun/codechain/tree"
+	"github.com/frankbraun/codechain/util/hex"
+)
+
+const (
+	// currentDatabaseVersion indicates what the current database version
+	// of the updater chain state is.
+	currentDatabaseVersion = 1
+
+	// stateSize is the size of the serialized chain state.
+	stateSize = 32 + 4 + 4 + 4
+)
+
+var (
+	// byteOrder is the preferred byte order used for serializing numeric
+	// fields for storage in the database.
+	byteOrder = binary.LittleEndian
+
+	// stateBucketName is the name of the db bucket used to house the
+	// updater chain state.
+	stateBucketName = []byte("updaterstate")
+
+	// versionKeyName is the name of the db key used to store the version
+	// of the updater chain state.
+	versionKeyName = []byte("version")
+
+	// stateKeyName is the name of the db key used to store the head and
+	// the counters of the updater chain state.
+	stateKeyName = []byte("state")
+
+	// linesBucketName is the name of the db bucket used to house the
+	// hashchain lines by their position.
+	linesBucketName = []byte("lines")
+
+	// entriesBucketName is the name of the db bucket used to house the
+	// position of each hashchain line by its hash.
+	entriesBucketName = []byte("entries")
+
+	// treeHashesBucketName is the name of the db bucket used to house the
+	// tree hashes of the hashchain by their position.
+	treeHashesBucketName = []byte("treehashes")
+
+	// patchesBucketName is the name of the db bucket used to house the
+	// verified patch files by the tree hash they result in.
+	patchesBucketName = []byte("patches")
+)
+
+// -----------------------------------------------------------------------------
+// The updater chain state is stored in its own bucket which contains the
+// version, the serialized state, and nested buckets for the hashchain lines,
+// the hashchain entries, the tree hashes, and the patch files.
+//
+// The serialized format for the version is:
+//
+//   <version>
+//
+//   Field           Type             Size
+//   version         uint32           4 bytes
+//
+// The serialized format for the state is:
+//
+//   <head><num lines><num tree hashes><last signed>
+//
+//   Field           Type             Size
+//   head            [32]byte         32 bytes
+//   num lines       uint32           4 bytes
+//   num tree hashes uint32           4 bytes
+//   last signed     uint32           4 bytes
+//
+// The lines bucket maps the big endian uint32 position of a hashchain line to
+// the line itself, so a cursor iterates the lines in hashchain order.
+//
+// The entries bucket maps the SHA256 hash of a hashchain line to its uint32
+// position.
+//
+// The tree hashes bucket maps the big endian uint32 position of a tree hash to
+// the 32 byte tree hash.  The first tree hash is always the empty tree hash
+// and all tree hashes up to and including the last signed position are
+// signed.
+//
+// The patches bucket maps a 32 byte tree hash to the patch file which results
+// in it.
+// -----------------------------------------------------------------------------
+
+// dbState is the updater chain state as stored in the database.
+type dbState struct {
+	head          [32]byte
+	numLines      uint32
+	numTreeHashes uint32
+	lastSigned    uint32
+}
+
+// serializeState returns the serialization of the passed state.
+func serializeState(state *dbState) []byte {
+	serialized := make([]byte, stateSize)
+	copy(serialized, state.head[:])
+	byteOrder.PutUint32(serialized[32:36], state.numLines)
+	byteOrder.PutUint32(serialized[36:40], state.numTreeHashes)
+	byteOrder.PutUint32(serialized[40:44], state.lastSigned)
+	return serialized
+}
+
+// deserializeState deserializes the passed serialized state.
+func deserializeState(serialized []byte) (*dbState, error) {
+	if len(serialized) < stateSize {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "unexpected end of data for updater state",
+		}
+	}
+
+	var state dbState
+	copy(state.head[:], serialized[:32])
+	state.numLines = byteOrder.Uint32(serialized[32:36])
+	state.numTreeHashes = byteOrder.Uint32(serialized[36:40])
+	state.lastSigned = byteOrder.Uint32(serialized[40:44])
+	return &state, nil
+}
+
+// positionKey returns the key for the given position, which sorts in
+// position order.
+func positionKey(pos uint32) []byte {
+	var key [4]byte
+	binary.BigEndian.PutUint32(key[:], pos)
+	return key[:]
+}
+
+// dbFetchVersion uses an existing database transaction to retrieve the
+// version of the updater chain state.  It returns zero if the chain state has
+// not been created yet.
+func dbFetchVersion(dbTx database.Tx) uint32 {
+	bucket := dbTx.Metadata().Bucket(stateBucketName)
+	if bucket == nil {
+		return 0
+	}
+	serialized := bucket.Get(versionKeyName)
+	if len(serialized) < 4 {
+		return 0
+	}
+	return byteOrder.Uint32(serialized)
+}
+
+// dbPutVersion uses an existing database transaction to update the version of
+// the updater chain state.
+func dbPutVersion(dbTx database.Tx, version uint32) error {
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], version)
+	bucket := dbTx.Metadata().Bucket(stateBucketName)
+	return bucket.Put(versionKeyName, serialized[:])
+}
+
+// dbFetchState uses an existing database transaction to retrieve the updater
+// chain state.
+func dbFetchState(dbTx database.Tx) (*dbState, error) {
+	bucket := dbTx.Metadata().Bucket(stateBucketName)
+	return deserializeState(bucket.Get(stateKeyName))
+}
+
+// dbPutState uses an existing database transaction to update the updater
+// chain state.
+func dbPutState(dbTx database.Tx, state *dbState) error {
+	bucket := dbTx.Metadata().Bucket(stateBucketName)
+	return bucket.Put(stateKeyName, serializeState(state))
+}
+
+// dbPutLine uses an existing database transaction to store the hashchain line
+// at the given position.
+func dbPutLine(dbTx database.Tx, pos uint32, line string) error {
+	bucket := dbTx.Metadata().Bucket(stateBucketName)
+	key := positionKey(pos)
+	if err := bucket.Bucket(linesBucketName).Put(key, []byte(line)); err != nil {
+		return err
+	}
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], pos)
+	hash := sha256.Sum256([]byte(line))
+	return bucket.Bucket(entriesBucketName).Put(hash[:], serialized[:])
+}
+
+// dbFetchLine uses an existing database transaction to retrieve the hashchain
+// line at the given position.
+func dbFetchLine(dbTx database.Tx, pos uint32) (string, error) {
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(linesBucketName)
+	line := bucket.Get(positionKey(pos))
+	if line == nil {
+		return "", database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("missing hashchain line at "+
+				"position %d", pos),
+		}
+	}
+	return string(line), nil
+}
+
+// dbFetchEntryPosition uses an existing database transaction to retrieve the
+// position of the hashchain entry with the given hash.  False is returned if
+// the entry is not known.
+func dbFetchEntryPosition(dbTx database.Tx, hash [32]byte) (uint32, bool) {
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(entriesBucketName)
+	serialized := bucket.Get(hash[:])
+	if len(serialized) < 4 {
+		return 0, false
+	}
+	return byteOrder.Uint32(serialized), true
+}
+
+// dbPutTreeHash uses an existing database transaction to store the tree hash
+// at the given position.
+func dbPutTreeHash(dbTx database.Tx, pos uint32, treeHash string) error {
+	hash, err := hex.Decode(treeHash, 32)
+	if err != nil {
+		return err
+	}
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(treeHashesBucketName)
+	return bucket.Put(positionKey(pos), hash)
+}
+
+// dbFetchTreeHashes uses an existing database transaction to retrieve the
+// first num tree hashes in hashchain order.
+func dbFetchTreeHashes(dbTx database.Tx, num uint32) ([]string, error) {
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(treeHashesBucketName)
+	treeHashes := make([]string, 0, num)
+	for pos := uint32(0); pos < num; pos++ {
+		hash := bucket.Get(positionKey(pos))
+		if len(hash) != 32 {
+			return nil, database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("missing tree hash at "+
+					"position %d", pos),
+			}
+		}
+		treeHashes = append(treeHashes, hex.Encode(hash))
+	}
+	return treeHashes, nil
+}
+
+// dbPatchExists uses an existing database transaction to determine if the
+// patch file for the given tree hash is stored.
+func dbPatchExists(dbTx database.Tx, treeHash []byte) bool {
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(patchesBucketName)
+	return bucket.Get(treeHash) != nil
+}
+
+// dbFetchPatch uses an existing database transaction to retrieve the patch
+// file for the given tree hash.  Nil is returned if it is not stored.
+func dbFetchPatch(dbTx database.Tx, treeHash []byte) []byte {
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(patchesBucketName)
+	patch := bucket.Get(treeHash)
+	if patch == nil {
+		return nil
+	}
+
+	// The returned slice is only valid during the transaction.
+	return append([]byte(nil), patch...)
+}
+
+// dbPutPatch uses an existing database transaction to store the patch file for
+// the given tree hash.
+func dbPutPatch(dbTx database.Tx, treeHash, patch []byte) error {
+	bucket := dbTx.Metadata().Bucket(stateBucketName).Bucket(patchesBucketName)
+	return bucket.Put(treeHash, patch)
+}
+
+// dbCreateState uses an existing database transaction to create the buckets
+// of the updater chain state and to store an empty state which only contains
+// the empty tree hash.
+func dbCreateState(dbTx database.Tx) error {
+	bucket, err := dbTx.Metadata().CreateBucket(stateBucketName)
+	if err != nil {
+		return err
+	}
+	for _, name := range [][]byte{linesBucketName, entriesBucketName,
+		treeHashesBucketName, patchesBucketName} {
+
+		if _, err := bucket.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	if err := dbPutTreeHash(dbTx, 0, tree.EmptyHash); err != nil {
+		return err
+	}
+	var state dbState
+	head, err := hex.Decode(tree.EmptyHash, 32)
+	if err != nil {
+		return err
+	}
+	copy(state.head[:], head)
+	state.numTreeHashes = 1
+	return dbPutState(dbTx, &state)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chainstate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitum-project/bitumd/database"
+	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/util/file"
+	"github.com/frankbraun/codechain/util/hex"
+)
+
+// upgradeToVersion1 creates the updater chain state in the database and
+// imports the hashchain file of the source tree as well as the patch files
+// which were stored in the data directory by prior versions, if they exist.
+func (cs *ChainState) upgradeToVersion1(dataDir string) error {
+	log.Info("Creating updater chain state in the database")
+	err := cs.db.Update(func(dbTx database.Tx) error {
+		return dbCreateState(dbTx)
+	})
+	if err != nil {
+		return err
+	}
+	if err := cs.loadState(); err != nil {
+		return err
+	}
+
+	hashchainFile := filepath.Join(dataDir, "src", ".codechain", "hashchain")
+	if err := cs.importHashchainFile(hashchainFile); err != nil {
+		return err
+	}
+	patchDir := filepath.Join(dataDir, "updater", "patches")
+	if err := cs.importPatchFiles(patchDir); err != nil {
+		return err
+	}
+
+	return cs.db.Update(func(dbTx database.Tx) error {
+		return dbPutVersion(dbTx, 1)
+	})
+}
+
+// importHashchainFile verifies the given hashchain file and appends its lines
+// to the chain state, if it exists.
+func (cs *ChainState) importHashchainFile(filename string) error {
+	exists, err := file.Exists(filename)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	log.Infof("Importing hashchain %s", filename)
+	hc, err := hashchain.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	defer hc.Close()
+	var buf bytes.Buffer
+	if err := hc.Fprint(&buf); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	return cs.Append(lines)
+}
+
+// importPatchFiles stores the patch files of the given directory for signed
+// tree hashes in the chain state and removes the directory afterwards.  The
+// patch files have been verified when they were added to the directory.
+func (cs *ChainState) importPatchFiles(patchDir string) error {
+	exists, err := file.Exists(patchDir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	log.Infof("Importing patch files from %s", patchDir)
+	fis, err := ioutil.ReadDir(patchDir)
+	if err != nil {
+		return err
+	}
+	err = cs.db.Update(func(dbTx database.Tx) error {
+		for _, fi := range fis {
+			if !cs.IsSignedTreeHash(fi.Name()) {
+				log.Warnf("Skipping patch file %s: tree hash is "+
+					"not signed", fi.Name())
+				continue
+			}
+			treeHash, err := hex.Decode(fi.Name(), 32)
+			if err != nil {
+				return err
+			}
+			patch, err := ioutil.ReadFile(filepath.Join(patchDir,
+				fi.Name()))
+			if err != nil {
+				return err
+			}
+			if err := dbPutPatch(dbTx, treeHash, patch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(patchDir)
+}
+
+// upgradeDB upgrades old versions of the updater chain state to the newest
+// version by applying all possible upgrades iteratively.
+func (cs *ChainState) upgradeDB(dataDir string) error {
+	var version uint32
+	err := cs.db.View(func(dbTx database.Tx) error {
+		version = dbFetchVersion(dbTx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version > currentDatabaseVersion {
+		return fmt.Errorf("chainstate: unknown database version %d "+
+			"(current version %d)", version, currentDatabaseVersion)
+	}
+
+	// Create the chain state and import the hashchain file if needed.
+	// A partially created chain state is removed first.
+	if version == 0 {
+		err := cs.db.Update(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			if meta.Bucket(stateBucketName) == nil {
+				return nil
+			}
+			return meta.DeleteBucket(stateBucketName)
+		})
+		if err != nil {
+			return err
+		}
+		if err := cs.upgradeToVersion1(dataDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
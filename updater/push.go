@@ -0,0 +1,158 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/peer"
+	"github.com/bitum-project/bitumd/wire"
+	"github.com/frankbraun/codechain/tree"
+)
+
+// PushUpdate is a Codechain hashchain along with the patch files of its signed
+// tree hashes which is pushed to nodes.  Nodes are sent the head of the
+// hashchain and request the entries and patch files they do not know yet in
+// turn.
+type PushUpdate struct {
+	lines     []string
+	lineIndex map[chainhash.Hash]int
+
+	// patches maps tree hashes to their patch files and treeHashes lists
+	// the tree hashes of the patch files in the order they are published
+	// in the hashchain.
+	patches    map[chainhash.Hash][]byte
+	treeHashes []chainhash.Hash
+}
+
+// NewPushUpdate returns an update which serves the given hashchain lines and
+// patch files.  Patch files for tree hashes which are not published in the
+// hashchain are never announced.
+func NewPushUpdate(lines []string, patches map[chainhash.Hash][]byte) *PushUpdate {
+	u := PushUpdate{
+		lines:     lines,
+		lineIndex: make(map[chainhash.Hash]int, len(lines)),
+		patches:   patches,
+	}
+	for i, line := range lines {
+		u.lineIndex[sha256.Sum256([]byte(line))] = i
+
+		_, _, linkType, fields, err := parseEntry(line)
+		if err != nil || linkType != "source" || len(fields) == 0 {
+			continue
+		}
+		treeHash, err := decodeHash(fields[0])
+		if err != nil {
+			continue
+		}
+		if _, ok := patches[treeHash]; ok {
+			u.treeHashes = append(u.treeHashes, treeHash)
+		}
+	}
+	return &u
+}
+
+// Head returns the head of the hashchain, which is the hash of its last line.
+func (u *PushUpdate) Head() chainhash.Hash {
+	return sha256.Sum256([]byte(u.lines[len(u.lines)-1]))
+}
+
+// HeadInvMsg returns an inv message which announces the head of the hashchain.
+func (u *PushUpdate) HeadInvMsg() *wire.MsgInv {
+	head := u.Head()
+	invMsg := wire.NewMsgInvSizeHint(1)
+	invMsg.AddInvVect(wire.NewInvVect(wire.InvTypeCodechainEntry, &head))
+	return invMsg
+}
+
+// LinesMsg returns the cchain message which answers the given getcchain
+// message.  Nil is returned if the requested entries are not known.
+func (u *PushUpdate) LinesMsg(msg *wire.MsgGetCChain) *wire.MsgCChain {
+	var zero chainhash.Hash
+	begin := 0
+	if msg.HeadStart != zero &&
+		hex.EncodeToString(msg.HeadStart[:]) != tree.EmptyHash {
+
+		idx, ok := u.lineIndex[msg.HeadStart]
+		if !ok {
+			return nil
+		}
+		begin = idx + 1
+	}
+	end := len(u.lines)
+	if msg.HeadStop != zero {
+		idx, ok := u.lineIndex[msg.HeadStop]
+		if !ok {
+			return nil
+		}
+		end = idx + 1
+	}
+	if end <= begin {
+		return nil
+	}
+	if end-begin > wire.MaxCChainLinesPerMsg {
+		end = begin + wire.MaxCChainLinesPerMsg
+	}
+
+	reply := wire.NewMsgCChain(&msg.HeadStart)
+	for _, line := range u.lines[begin:end] {
+		if err := reply.AddLine(line); err != nil {
+			return nil
+		}
+	}
+	return reply
+}
+
+// PatchInvMsg returns an inv message which announces the patch files in the
+// order their tree hashes are published in the hashchain.
+func (u *PushUpdate) PatchInvMsg() *wire.MsgInv {
+	invMsg := wire.NewMsgInvSizeHint(uint(len(u.treeHashes)))
+	for i := range u.treeHashes {
+		iv := wire.NewInvVect(wire.InvTypePatch, &u.treeHashes[i])
+		if err := invMsg.AddInvVect(iv); err != nil {
+			break
+		}
+	}
+	return invMsg
+}
+
+// Listeners returns the message listeners of a peer which serves the
+// hashchain entries and patch files requested by a node.  The patch files are
+// announced once the node requested the head entry, since nodes only request
+// patch files for signed tree hashes.  The served function, if not nil, is
+// called with the number of entries and patch files served by every reply.
+func (u *PushUpdate) Listeners(served func(entries, patches int)) peer.MessageListeners {
+	head := u.Head()
+	return peer.MessageListeners{
+		OnGetCChain: func(p *peer.Peer, msg *wire.MsgGetCChain) {
+			reply := u.LinesMsg(msg)
+			if reply == nil {
+				return
+			}
+			p.QueueMessage(reply, nil)
+			if served != nil {
+				served(len(reply.Lines), 0)
+			}
+			last := reply.Lines[len(reply.Lines)-1]
+			if sha256.Sum256([]byte(last)) == head &&
+				len(u.treeHashes) > 0 {
+
+				p.QueueMessage(u.PatchInvMsg(), nil)
+			}
+		},
+		OnGetPatch: func(p *peer.Peer, msg *wire.MsgGetPatch) {
+			patch, ok := u.patches[msg.TreeHash]
+			if !ok {
+				return
+			}
+			p.QueueMessage(wire.NewMsgPatch(&msg.TreeHash, patch), nil)
+			if served != nil {
+				served(0, 1)
+			}
+		},
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/wire"
+	"github.com/frankbraun/codechain/tree"
+)
+
+// testPushUpdate returns a push update for a hashchain of the given number of
+// entries in which every third entry publishes a tree hash, along with the
+// hashes of its entries and the published tree hashes.  The patch files of all
+// tree hashes but the first one are known.
+func testPushUpdate(numLines int) (*PushUpdate, []chainhash.Hash, []chainhash.Hash) {
+	var lines []string
+	var hashes, treeHashes []chainhash.Hash
+	patches := make(map[chainhash.Hash][]byte)
+	prev, _ := decodeHash(tree.EmptyHash)
+	for i := 0; i < numLines; i++ {
+		var line string
+		if i%3 == 1 {
+			treeHash := chainhash.Hash(sha256.Sum256([]byte(
+				fmt.Sprintf("tree %d", i))))
+			line = testEntry(prev, int64(i), "source",
+				hex.EncodeToString(treeHash[:]), encode(make([]byte, 32)),
+				encode(make([]byte, 64)))
+			treeHashes = append(treeHashes, treeHash)
+			if len(treeHashes) > 1 {
+				patches[treeHash] = []byte(fmt.Sprintf("patch %d", i))
+			}
+		} else {
+			line = testEntry(prev, int64(i), "signtr",
+				hex.EncodeToString(prev[:]), encode(make([]byte, 32)),
+				encode(make([]byte, 64)))
+		}
+		lines = append(lines, line)
+		prev = sha256.Sum256([]byte(line))
+		hashes = append(hashes, prev)
+	}
+
+	// A patch file for a tree hash which is not published in the hashchain
+	// must never be announced.
+	patches[chainhash.Hash{0x01}] = []byte("unpublished")
+
+	return NewPushUpdate(lines, patches), hashes, treeHashes
+}
+
+// TestPushUpdateLinesMsg ensures the cchain messages served for getcchain
+// messages contain the requested hashchain entries.
+func TestPushUpdateLinesMsg(t *testing.T) {
+	numLines := wire.MaxCChainLinesPerMsg + 10
+	u, hashes, _ := testPushUpdate(numLines)
+	if u.Head() != hashes[numLines-1] {
+		t.Fatalf("unexpected head -- got %v, want %v", u.Head(),
+			hashes[numLines-1])
+	}
+
+	var zero chainhash.Hash
+	emptyHash, _ := decodeHash(tree.EmptyHash)
+	tests := []struct {
+		name       string
+		start      chainhash.Hash
+		stop       chainhash.Hash
+		begin, end int // served entries, none if end is 0
+	}{
+		{"all from zero start", zero, zero, 0, wire.MaxCChainLinesPerMsg},
+		{"all from empty start", emptyHash, zero, 0,
+			wire.MaxCChainLinesPerMsg},
+		{"up to stop", zero, hashes[4], 0, 5},
+		{"range", hashes[4], hashes[9], 5, 10},
+		{"rest", hashes[numLines-6], zero, numLines - 5, numLines},
+		{"start is head", hashes[numLines-1], zero, 0, 0},
+		{"stop before start", hashes[9], hashes[4], 0, 0},
+		{"unknown start", chainhash.Hash{0x01}, zero, 0, 0},
+		{"unknown stop", zero, chainhash.Hash{0x01}, 0, 0},
+	}
+	for _, test := range tests {
+		msg := wire.NewMsgGetCChain(&test.start, &test.stop)
+		reply := u.LinesMsg(msg)
+		if test.end == 0 {
+			if reply != nil {
+				t.Errorf("%s: unexpected reply with %d entries",
+					test.name, len(reply.Lines))
+			}
+			continue
+		}
+		if reply == nil {
+			t.Errorf("%s: no reply", test.name)
+			continue
+		}
+		if reply.HeadStart != test.start {
+			t.Errorf("%s: unexpected start -- got %v, want %v",
+				test.name, reply.HeadStart, test.start)
+		}
+		if len(reply.Lines) != test.end-test.begin {
+			t.Errorf("%s: unexpected number of entries -- got %d, "+
+				"want %d", test.name, len(reply.Lines),
+				test.end-test.begin)
+			continue
+		}
+		for i, line := range reply.Lines {
+			if sha256.Sum256([]byte(line)) != hashes[test.begin+i] {
+				t.Errorf("%s: unexpected entry %d", test.name, i)
+				break
+			}
+		}
+	}
+}
+
+// TestPushUpdatePatchInvMsg ensures the patch files of tree hashes published
+// in the hashchain are announced in the order they are published.
+func TestPushUpdatePatchInvMsg(t *testing.T) {
+	u, _, treeHashes := testPushUpdate(20)
+
+	var got []chainhash.Hash
+	for _, iv := range u.PatchInvMsg().InvList {
+		if iv.Type != wire.InvTypePatch {
+			t.Fatalf("unexpected inventory type %v", iv.Type)
+		}
+		got = append(got, iv.Hash)
+	}
+	if want := treeHashes[1:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected announced patch files -- got %v, want %v",
+			got, want)
+	}
+
+	// The head announcement only contains the head.
+	invList := u.HeadInvMsg().InvList
+	if len(invList) != 1 || invList[0].Type != wire.InvTypeCodechainEntry ||
+		invList[0].Hash != u.Head() {
+
+		t.Fatalf("unexpected head announcement %v", invList)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/frankbraun/codechain/tree"
+	"github.com/frankbraun/codechain/util/file"
+	"github.com/frankbraun/codechain/util/hex"
+)
+
+const (
+	// autoUpdateInterval is the interval at which the update manager
+	// decides whether to update the source tree automatically.  The
+	// configured probability applies per interval.
+	autoUpdateInterval = 24 * time.Hour
+
+	// DefaultAutoUpdateProbability is the default probability that the
+	// source tree is updated to the last signed tree hash on a given day.
+	DefaultAutoUpdateProbability = 0.1
+
+	// DefaultAutoUpdateWindow is the default number of signed tree hashes
+	// the source tree may fall behind before it is updated regardless of
+	// the probability.
+	DefaultAutoUpdateWindow = 3
+)
+
+// excludePaths are the paths of the source tree which are not considered in
+// tree hash calculations, as in Codechain.
+var excludePaths = []string{
+	".codechain",
+	".codechain_mainnet",
+	".codechain_testnet",
+	".git",
+	".gitignore",
+	".travis.yml",
+}
+
+// UpdateDecision describes the outcome of an automatic update check.
+type UpdateDecision int
+
+// These constants define the possible outcomes of an automatic update check.
+const (
+	// DecisionNone indicates no automatic update check has happened yet.
+	DecisionNone UpdateDecision = iota
+
+	// DecisionUpToDate indicates the source tree is at the last signed
+	// tree hash.
+	DecisionUpToDate
+
+	// DecisionUnknownSource indicates the source tree is not at a signed
+	// tree hash, for example because it was modified locally.  Such source
+	// trees are never updated automatically.
+	DecisionUnknownSource
+
+	// DecisionPatchesMissing indicates an update is due but not all patch
+	// files leading to the last signed tree hash are known yet.
+	DecisionPatchesMissing
+
+	// DecisionSkipped indicates the source tree is behind but was not
+	// picked for an update in this interval.
+	DecisionSkipped
+
+	// DecisionUpdated indicates the source tree was picked for an update
+	// and updated to the last signed tree hash.
+	DecisionUpdated
+
+	// DecisionForcedUpdate indicates the source tree was updated to the last
+	// signed tree hash because it fell behind by the configured window.
+	DecisionForcedUpdate
+
+	// DecisionFailed indicates the automatic update check or the update
+	// itself failed.
+	DecisionFailed
+)
+
+// Map of UpdateDecision values back to their descriptions for pretty printing.
+var updateDecisionStrings = map[UpdateDecision]string{
+	DecisionNone:           "none",
+	DecisionUpToDate:       "up to date",
+	DecisionUnknownSource:  "source tree not signed",
+	DecisionPatchesMissing: "patch files missing",
+	DecisionSkipped:        "skipped",
+	DecisionUpdated:        "updated",
+	DecisionForcedUpdate:   "forced update",
+	DecisionFailed:         "failed",
+}
+
+// String returns the UpdateDecision as a human-readable description.
+func (d UpdateDecision) String() string {
+	if s := updateDecisionStrings[d]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown UpdateDecision (%d)", int(d))
+}
+
+// AutoUpdateStatus describes the configuration of the automatic updates and
+// the outcome of the last automatic update check.
+type AutoUpdateStatus struct {
+	Enabled     bool
+	Probability float64
+	Window      uint32
+
+	// LastCheck is the time of the last automatic update check, which is
+	// the zero time if no check has happened yet.  SourceTreeHash is the
+	// tree hash the source tree was at and TargetTreeHash the last signed
+	// tree hash at the time.
+	LastCheck      time.Time
+	SourceTreeHash string
+	TargetTreeHash string
+	Decision       UpdateDecision
+}
+
+// decideUpdate decides whether the source tree, which is at the signed tree
+// hash with the given (one-based) index, or the empty tree for index zero, is
+// updated to the last signed tree hash with the given index.  The update is
+// forced once the source tree is behind by window signed tree hashes and
+// happens with the given probability otherwise, using draw as the random
+// number in [0, 1).
+func decideUpdate(srcIdx, lastIdx int, window uint32, probability, draw float64) UpdateDecision {
+	behind := lastIdx - srcIdx
+	switch {
+	case behind <= 0:
+		return DecisionUpToDate
+	case behind >= int(window):
+		return DecisionForcedUpdate
+	case draw < probability:
+		return DecisionUpdated
+	default:
+		return DecisionSkipped
+	}
+}
+
+// sourceTreeHash returns the tree hash of the source tree in the given
+// directory, which is the empty tree hash if it does not exist.
+func sourceTreeHash(srcDir string) (string, error) {
+	exists, err := file.Exists(srcDir)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return tree.EmptyHash, nil
+	}
+	hash, err := tree.Hash(srcDir, excludePaths)
+	if err != nil {
+		return "", err
+	}
+	return hex.Encode(hash[:]), nil
+}
+
+// checkAutoUpdate decides whether to update the source tree of the data
+// directory to the last signed tree hash and does so, if picked.  It must be
+// run from the update handler.
+func (u *UpdateManager) checkAutoUpdate() {
+	status := u.AutoUpdateStatus()
+	status.LastCheck = time.Now()
+	status.TargetTreeHash = u.chainState.LastSignedTreeHash()
+	status.Decision = u.decideAutoUpdate(&status)
+
+	switch status.Decision {
+	case DecisionUpdated, DecisionForcedUpdate:
+		if err := u.applyUpdate(status.TargetTreeHash); err != nil {
+			log.Errorf("Failed to update source tree to %s: %v",
+				status.TargetTreeHash, err)
+			status.Decision = DecisionFailed
+			break
+		}
+		log.Infof("Updated source tree from %s to %s (%v)",
+			status.SourceTreeHash, status.TargetTreeHash,
+			status.Decision)
+	default:
+		log.Infof("Automatic update check: %v (source tree %s, last "+
+			"signed tree hash %s)", status.Decision,
+			status.SourceTreeHash, status.TargetTreeHash)
+	}
+
+	u.stateMtx.Lock()
+	u.autoUpdate = status
+	u.stateMtx.Unlock()
+}
+
+// decideAutoUpdate determines the tree hash of the source tree and decides
+// whether it is updated to the target tree hash of the given status.
+func (u *UpdateManager) decideAutoUpdate(status *AutoUpdateStatus) UpdateDecision {
+	srcHash, err := sourceTreeHash(u.srcDir())
+	if err != nil {
+		log.Errorf("Failed to determine tree hash of source tree: %v", err)
+		return DecisionFailed
+	}
+	status.SourceTreeHash = srcHash
+
+	// Only source trees at a signed tree hash are updated, so local
+	// modifications are never overwritten.
+	signed := u.chainState.SignedTreeHashes()
+	srcIdx := -1
+	if srcHash == tree.EmptyHash {
+		srcIdx = 0
+	}
+	for i, treeHash := range signed {
+		if treeHash == srcHash {
+			srcIdx = i + 1
+			break
+		}
+	}
+	if srcIdx < 0 {
+		return DecisionUnknownSource
+	}
+
+	decision := decideUpdate(srcIdx, len(signed), status.Window,
+		status.Probability, u.rand.Float64())
+	if decision == DecisionUpdated || decision == DecisionForcedUpdate {
+		if len(u.chainState.MissingPatches(status.TargetTreeHash)) > 0 {
+			return DecisionPatchesMissing
+		}
+	}
+	return decision
+}
+
+// srcDir returns the directory of the source tree in the data directory.
+func (u *UpdateManager) srcDir() string {
+	return filepath.Join(u.cfg.DataDir, "src")
+}
+
+// applyUpdate replaces the source tree of the data directory with the source
+// tree of the given signed tree hash.  The new source tree is built in a
+// staging directory first, so the source tree is left untouched if building
+// it fails.  Paths which are excluded from tree hashes are kept.
+func (u *UpdateManager) applyUpdate(treeHash string) error {
+	if !u.chainState.IsSignedTreeHash(treeHash) {
+		return fmt.Errorf("tree hash %s is not signed", treeHash)
+	}
+
+	srcDir := u.srcDir()
+	stagingDir := filepath.Join(u.cfg.DataDir, "updater", "staging")
+	oldDir := filepath.Join(u.cfg.DataDir, "updater", "src.old")
+	for _, dir := range []string{stagingDir, oldDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	if err := u.chainState.BuildTree(stagingDir, treeHash); err != nil {
+		return err
+	}
+
+	exists, err := file.Exists(srcDir)
+	if err != nil {
+		return err
+	}
+	if exists {
+		for _, name := range excludePaths {
+			path := filepath.Join(srcDir, name)
+			exists, err := file.Exists(path)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			err = os.Rename(path, filepath.Join(stagingDir, name))
+			if err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(srcDir, oldDir); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(stagingDir, srcDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// AutoUpdateStatus returns the configuration of the automatic updates and the
+// outcome of the last automatic update check.
+//
+// This function is safe for concurrent access.
+func (u *UpdateManager) AutoUpdateStatus() AutoUpdateStatus {
+	u.stateMtx.RLock()
+	defer u.stateMtx.RUnlock()
+	return u.autoUpdate
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"testing"
+)
+
+// TestDecideUpdate ensures the automatic update decision honors the
+// probability and forces updates once the source tree falls behind by the
+// window.
+func TestDecideUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		srcIdx      int
+		lastIdx     int
+		window      uint32
+		probability float64
+		draw        float64
+		want        UpdateDecision
+	}{
+		{"no signed tree hash", 0, 0, 3, 0.1, 0.0, DecisionUpToDate},
+		{"at last signed", 4, 4, 3, 0.1, 0.0, DecisionUpToDate},
+		{"one behind, picked", 3, 4, 3, 0.1, 0.05, DecisionUpdated},
+		{"one behind, not picked", 3, 4, 3, 0.1, 0.1, DecisionSkipped},
+		{"two behind, not picked", 2, 4, 3, 0.1, 0.5, DecisionSkipped},
+		{"window behind", 1, 4, 3, 0.1, 0.5, DecisionForcedUpdate},
+		{"empty tree, window behind", 0, 3, 3, 0.0, 0.5, DecisionForcedUpdate},
+		{"never picked", 3, 4, 3, 0.0, 0.0, DecisionSkipped},
+		{"always picked", 3, 4, 3, 1.0, 0.999, DecisionUpdated},
+		{"window of one", 3, 4, 1, 0.0, 0.5, DecisionForcedUpdate},
+	}
+
+	for _, test := range tests {
+		got := decideUpdate(test.srcIdx, test.lastIdx, test.window,
+			test.probability, test.draw)
+		if got != test.want {
+			t.Errorf("%s: unexpected decision -- got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestUpdateDecisionStringer tests the stringized output for the
+// UpdateDecision type.
+func TestUpdateDecisionStringer(t *testing.T) {
+	tests := []struct {
+		in   UpdateDecision
+		want string
+	}{
+		{DecisionNone, "none"},
+		{DecisionUpToDate, "up to date"},
+		{DecisionUnknownSource, "source tree not signed"},
+		{DecisionPatchesMissing, "patch files missing"},
+		{DecisionSkipped, "skipped"},
+		{DecisionUpdated, "updated"},
+		{DecisionForcedUpdate, "forced update"},
+		{DecisionFailed, "failed"},
+		{0xffff, "Unknown UpdateDecision (65535)"},
+	}
+
+	// Detect additional decisions that don't have the stringer added.
+	if len(tests)-1 != len(updateDecisionStrings) {
+		t.Errorf("It appears a decision was added without adding an " +
+			"associated stringer test")
+	}
+
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+		}
+	}
+}
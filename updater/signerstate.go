@@ -0,0 +1,628 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/frankbraun/codechain/hashchain/linktype"
+	"github.com/frankbraun/codechain/tree"
+	"github.com/frankbraun/codechain/util/hex"
+	"github.com/frankbraun/codechain/util/time"
+	"golang.org/x/crypto/ed25519"
+)
+
+// entryKind identifies the kind of operation a hashchain entry performs once
+// it is confirmed by enough signatures.
+type entryKind int
+
+const (
+	// entryNone is an entry which has nothing to confirm, such as cstart
+	// and signtr entries and entries which have been confirmed already.
+	entryNone entryKind = iota
+
+	// entrySource publishes a tree hash.
+	entrySource
+
+	// entryAddKey adds a signer.
+	entryAddKey
+
+	// entryRemKey removes a signer.
+	entryRemKey
+
+	// entrySigCtl sets the signature threshold.
+	entrySigCtl
+)
+
+const (
+	// minSignerWeight is the minimum weight of a signer, which is the
+	// minimum weight the Codechain addkey command accepts.
+	minSignerWeight = 1
+
+	// maxSignerWeight is the maximum weight of a signer.  It keeps the
+	// total weight of the signers n from overflowing.
+	maxSignerWeight = math.MaxInt32
+)
+
+// pendingEntry is a hashchain entry along with the total weight of the
+// signatures it received so far.
+type pendingEntry struct {
+	kind     entryKind
+	treeHash string
+	pubKey   [32]byte
+	weight   int
+	m        int
+	sigs     int
+}
+
+// signerState tracks the signers of a hashchain and the signature threshold
+// while its entries are applied one by one, following the signature rules of
+// Codechain:
+//
+//   - The signer which starts the hashchain is the only signer with weight 1
+//     and the signature threshold m is 1.
+//   - source entries must be signed by a signer.  signtr entries sign all
+//     entries up to and including the given one on behalf of a signer.
+//   - An entry, and thus the tree hash it publishes or the change of the
+//     signers or of m it makes, is confirmed once the total weight of its
+//     signatures reaches m and all preceding entries are confirmed.
+//   - addkey entries must be signed with the added key, must add a signer
+//     with a weight in [minSignerWeight, maxSignerWeight], and must not add
+//     a signer twice, remkey entries must remove a signer and must not lower
+//     the total weight of the signers n below m, and sigctl entries must set
+//     m to a positive value not larger than n.
+//
+// These are the rules hashchain.Read of the Codechain library verifies, which
+// the chain state runs on the received entries as well, except that the library
+// accepts any weight.  Violations are reported as a RuleError, so peers which
+// send such entries can be penalized.
+type signerState struct {
+	numLines   int
+	head       [32]byte
+	lastTime   int64
+	m          int
+	n          int
+	weights    map[[32]byte]int
+	barriers   map[[32]byte]int
+	linkHashes map[[32]byte]int
+	published  map[string]struct{}
+	entries    []pendingEntry
+	signedLine int
+	signed     []string
+}
+
+// newSignerState returns a signer state for an empty hashchain.
+func newSignerState() *signerState {
+	return &signerState{
+		weights:    make(map[[32]byte]int),
+		barriers:   make(map[[32]byte]int),
+		linkHashes: make(map[[32]byte]int),
+		published:  map[string]struct{}{tree.EmptyHash: {}},
+		signed:     []string{tree.EmptyHash},
+	}
+}
+
+// clone returns a deep copy of the signer state, so entries can be applied
+// to it without changing the original one.
+func (s *signerState) clone() *signerState {
+	c := *s
+	c.weights = make(map[[32]byte]int, len(s.weights))
+	for k, v := range s.weights {
+		c.weights[k] = v
+	}
+	c.barriers = make(map[[32]byte]int, len(s.barriers))
+	for k, v := range s.barriers {
+		c.barriers[k] = v
+	}
+	c.linkHashes = make(map[[32]byte]int, len(s.linkHashes))
+	for k, v := range s.linkHashes {
+		c.linkHashes[k] = v
+	}
+	c.published = make(map[string]struct{}, len(s.published))
+	for k := range s.published {
+		c.published[k] = struct{}{}
+	}
+	c.entries = append([]pendingEntry(nil), s.entries...)
+	c.signed = append([]string(nil), s.signed...)
+	return &c
+}
+
+// signedTreeHashes returns the confirmed tree hashes, starting with the empty
+// tree hash.
+func (s *signerState) signedTreeHashes() []string {
+	return append([]string(nil), s.signed...)
+}
+
+// decodeKey decodes a base64 encoded public key of a hashchain entry.
+func decodeKey(field string) ([32]byte, error) {
+	var pubKey [32]byte
+	b, err := base64.RawURLEncoding.DecodeString(field)
+	if err != nil || len(b) != len(pubKey) {
+		return pubKey, ruleError(ErrMalformedEntry,
+			fmt.Sprintf("invalid public key %q", field))
+	}
+	copy(pubKey[:], b)
+	return pubKey, nil
+}
+
+// decodeSignature decodes a base64 encoded signature of a hashchain entry.
+func decodeSignature(field string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(field)
+	if err != nil || len(b) != ed25519.SignatureSize {
+		return nil, ruleError(ErrMalformedEntry,
+			fmt.Sprintf("invalid signature %q", field))
+	}
+	return b, nil
+}
+
+// decodeHash decodes a hex encoded hash of a hashchain entry.
+func decodeHash(field string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.Decode(field, 32)
+	if err != nil {
+		return hash, ruleError(ErrMalformedEntry,
+			fmt.Sprintf("invalid hash %q", field))
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// parseEntry splits the given hashchain entry into the hash of the previous
+// entry, the time, the type, and the type fields.
+func parseEntry(line string) ([32]byte, int64, string, []string, error) {
+	var prev [32]byte
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) != 4 {
+		str := fmt.Sprintf("hashchain entry %q does not have four "+
+			"space separated parts", line)
+		return prev, 0, "", nil, ruleError(ErrMalformedEntry, str)
+	}
+	prev, err := decodeHash(parts[0])
+	if err != nil {
+		return prev, 0, "", nil, err
+	}
+	datum, err := time.Parse(parts[1])
+	if err != nil {
+		str := fmt.Sprintf("hashchain entry %q has an invalid time: %v",
+			line, err)
+		return prev, 0, "", nil, ruleError(ErrMalformedEntry, str)
+	}
+	fields := strings.SplitN(parts[3], " ", 4)
+
+	// Make sure the entry is in canonical form, so its hash is well
+	// defined.
+	canonical := fmt.Sprintf("%x %s %s %s", prev, time.Format(datum),
+		parts[2], strings.Join(fields, " "))
+	if canonical != line {
+		str := fmt.Sprintf("hashchain entry %q is not in canonical form",
+			line)
+		return prev, 0, "", nil, ruleError(ErrMalformedEntry, str)
+	}
+	return prev, datum, parts[2], fields, nil
+}
+
+// checkFields makes sure an entry of the given type has the given number of
+// type fields, or one more if comment is true.
+func checkFields(linkType string, fields []string, num int, comment bool) error {
+	if len(fields) == num || (comment && len(fields) == num+1) {
+		return nil
+	}
+	str := fmt.Sprintf("%s entry has %d type fields", linkType, len(fields))
+	return ruleError(ErrMalformedEntry, str)
+}
+
+// comment returns the optional comment of an entry with the given number of
+// mandatory type fields.
+func comment(fields []string, num int) string {
+	if len(fields) > num {
+		return fields[num]
+	}
+	return ""
+}
+
+// apply verifies the given hashchain entry against the signer state and
+// applies it.  The signer state is left in an undefined state if an error is
+// returned, so entries which might be invalid must be applied to a clone.
+func (s *signerState) apply(line string) error {
+	prev, datum, linkType, fields, err := parseEntry(line)
+	if err != nil {
+		return err
+	}
+
+	// The first entry refers to the empty tree hash and every other entry
+	// to the hash of the preceding one.
+	wantPrev := s.head
+	if s.numLines == 0 {
+		wantPrev, _ = decodeHash(tree.EmptyHash)
+	}
+	if prev != wantPrev {
+		str := fmt.Sprintf("hashchain entry %q does not extend head %x",
+			line, wantPrev[:])
+		return ruleError(ErrBrokenLink, str)
+	}
+	if datum < s.lastTime {
+		str := fmt.Sprintf("hashchain entry %q predates the preceding "+
+			"entry", line)
+		return ruleError(ErrMalformedEntry, str)
+	}
+	if s.numLines == 0 && linkType != linktype.ChainStart {
+		str := fmt.Sprintf("hashchain starts with %s entry", linkType)
+		return ruleError(ErrMalformedEntry, str)
+	}
+
+	idx := s.numLines
+	linkHash := sha256.Sum256([]byte(line))
+	switch linkType {
+	case linktype.ChainStart:
+		err = s.applyChainStart(idx, fields)
+	case linktype.Source:
+		err = s.applySource(fields)
+	case linktype.Signature:
+		err = s.applySignature(fields)
+	case linktype.AddKey:
+		err = s.applyAddKey(fields)
+	case linktype.RemoveKey:
+		err = s.applyRemKey(fields)
+	case linktype.SignatureControl:
+		err = s.applySigCtl(fields)
+	default:
+		str := fmt.Sprintf("hashchain entry has unknown type %q",
+			linkType)
+		err = ruleError(ErrMalformedEntry, str)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.linkHashes[linkHash] = idx
+	s.numLines++
+	s.head = linkHash
+	s.lastTime = datum
+	return nil
+}
+
+// applyChainStart applies a cstart entry with the given type fields:
+//
+//	pubkey nonce signature [comment]
+func (s *signerState) applyChainStart(idx int, fields []string) error {
+	if idx != 0 {
+		return ruleError(ErrMalformedEntry, "cstart entry does not "+
+			"start the hashchain")
+	}
+	if err := checkFields(linktype.ChainStart, fields, 3, true); err != nil {
+		return err
+	}
+	pubKey, err := decodeKey(fields[0])
+	if err != nil {
+		return err
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil || len(nonce) != 24 {
+		return ruleError(ErrMalformedEntry, fmt.Sprintf("invalid "+
+			"nonce %q", fields[1]))
+	}
+	sig, err := decodeSignature(fields[2])
+	if err != nil {
+		return err
+	}
+	msg := append(append(pubKey[:], nonce...), comment(fields, 3)...)
+	if !ed25519.Verify(pubKey[:], msg, sig) {
+		return ruleError(ErrBadSignature, "cstart signature does not "+
+			"verify")
+	}
+
+	s.m = 1
+	s.n = 1
+	s.weights[pubKey] = 1
+	s.barriers[pubKey] = 0
+	s.entries = append(s.entries, pendingEntry{})
+	return nil
+}
+
+// applySource applies a source entry with the given type fields:
+//
+//	tree-hash pubkey signature [comment]
+func (s *signerState) applySource(fields []string) error {
+	if err := checkFields(linktype.Source, fields, 3, true); err != nil {
+		return err
+	}
+	treeHash, err := decodeHash(fields[0])
+	if err != nil {
+		return err
+	}
+	pubKey, err := decodeKey(fields[1])
+	if err != nil {
+		return err
+	}
+	sig, err := decodeSignature(fields[2])
+	if err != nil {
+		return err
+	}
+	msg := append(treeHash[:], comment(fields, 3)...)
+	if !ed25519.Verify(pubKey[:], msg, sig) {
+		str := fmt.Sprintf("source signature for tree hash %s does "+
+			"not verify", fields[0])
+		return ruleError(ErrBadSignature, str)
+	}
+	if _, ok := s.weights[pubKey]; !ok {
+		str := fmt.Sprintf("tree hash %s published by %s which is "+
+			"not a signer", fields[0], fields[1])
+		return ruleError(ErrUnknownSigner, str)
+	}
+	if _, ok := s.published[fields[0]]; ok {
+		str := fmt.Sprintf("tree hash %s published twice", fields[0])
+		return ruleError(ErrDuplicateTreeHash, str)
+	}
+
+	s.published[fields[0]] = struct{}{}
+	s.entries = append(s.entries, pendingEntry{
+		kind:     entrySource,
+		treeHash: fields[0],
+		pubKey:   pubKey,
+	})
+	return nil
+}
+
+// applySignature applies a signtr entry with the given type fields:
+//
+//	link-hash pubkey signature
+//
+// The signer signs all entries up to and including the one with the given link
+// hash it has not signed yet, and all entries which received enough
+// signatures are confirmed in order.
+func (s *signerState) applySignature(fields []string) error {
+	if err := checkFields(linktype.Signature, fields, 3, false); err != nil {
+		return err
+	}
+	linkHash, err := decodeHash(fields[0])
+	if err != nil {
+		return err
+	}
+	pubKey, err := decodeKey(fields[1])
+	if err != nil {
+		return err
+	}
+	sig, err := decodeSignature(fields[2])
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pubKey[:], linkHash[:], sig) {
+		str := fmt.Sprintf("signature of entry %s does not verify",
+			fields[0])
+		return ruleError(ErrBadSignature, str)
+	}
+	line, ok := s.linkHashes[linkHash]
+	if !ok {
+		str := fmt.Sprintf("signature of unknown entry %s", fields[0])
+		return ruleError(ErrUnknownLinkHash, str)
+	}
+	weight, ok := s.weights[pubKey]
+	if !ok {
+		str := fmt.Sprintf("entry %s signed by %s which is not a "+
+			"signer", fields[0], fields[1])
+		return ruleError(ErrUnknownSigner, str)
+	}
+
+	for i := s.barriers[pubKey] + 1; i <= line; i++ {
+		s.entries[i].sigs += weight
+	}
+	s.barriers[pubKey] = line
+
+	// Confirm all entries which received enough signatures in order.  The
+	// threshold may change while doing so.  Entries which have nothing to
+	// confirm never hold up the ones following them.
+	i := s.signedLine + 1
+	for ; i <= line; i++ {
+		if s.entries[i].kind != entryNone && s.entries[i].sigs < s.m {
+			break
+		}
+		if err := s.confirm(i); err != nil {
+			return err
+		}
+	}
+	s.signedLine = i - 1
+	s.entries = append(s.entries, pendingEntry{})
+	return nil
+}
+
+// confirm applies the operation of the entry at the given index, which
+// received enough signatures.
+func (s *signerState) confirm(i int) error {
+	e := &s.entries[i]
+	switch e.kind {
+	case entrySource:
+		// The signer which published the tree hash might have been
+		// removed in the meantime.
+		if _, ok := s.weights[e.pubKey]; !ok {
+			str := fmt.Sprintf("tree hash %s published by a removed "+
+				"signer", e.treeHash)
+			return ruleError(ErrUnknownSigner, str)
+		}
+		s.signed = append(s.signed, e.treeHash)
+	case entryAddKey:
+		s.n += e.weight
+		s.weights[e.pubKey] = e.weight
+		s.barriers[e.pubKey] = i
+	case entryRemKey:
+		s.n -= e.weight
+		delete(s.weights, e.pubKey)
+		delete(s.barriers, e.pubKey)
+	case entrySigCtl:
+		s.m = e.m
+	}
+	s.entries[i] = pendingEntry{}
+	return nil
+}
+
+// pendingThreshold returns the signature threshold m and the total weight of
+// the signers n which result once all unconfirmed entries are confirmed.
+func (s *signerState) pendingThreshold() (int, int) {
+	m, n := s.m, s.n
+	for _, e := range s.entries[s.signedLine+1:] {
+		switch e.kind {
+		case entryAddKey:
+			n += e.weight
+		case entryRemKey:
+			n -= e.weight
+		case entrySigCtl:
+			m = e.m
+		}
+	}
+	return m, n
+}
+
+// pendingWeight returns the weight the given key has once all unconfirmed
+// entries are confirmed and whether it is a signer then.
+func (s *signerState) pendingWeight(pubKey [32]byte) (int, bool) {
+	for i := len(s.entries) - 1; i > s.signedLine; i-- {
+		e := &s.entries[i]
+		if e.pubKey != pubKey {
+			continue
+		}
+		switch e.kind {
+		case entryAddKey:
+			return e.weight, true
+		case entryRemKey:
+			return 0, false
+		}
+	}
+	weight, ok := s.weights[pubKey]
+	return weight, ok
+}
+
+// applyAddKey applies an addkey entry with the given type fields:
+//
+//	weight pubkey signature [comment]
+func (s *signerState) applyAddKey(fields []string) error {
+	if err := checkFields(linktype.AddKey, fields, 3, true); err != nil {
+		return err
+	}
+	weight, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return ruleError(ErrMalformedEntry, fmt.Sprintf("invalid "+
+			"weight %q", fields[0]))
+	}
+	if weight < minSignerWeight || weight > maxSignerWeight {
+		str := fmt.Sprintf("addkey weight %d not in range [%d, %d]",
+			weight, minSignerWeight, maxSignerWeight)
+		return ruleError(ErrInvalidKeyChange, str)
+	}
+	pubKey, err := decodeKey(fields[1])
+	if err != nil {
+		return err
+	}
+	sig, err := decodeSignature(fields[2])
+	if err != nil {
+		return err
+	}
+	msg := append(pubKey[:], comment(fields, 3)...)
+	if !ed25519.Verify(pubKey[:], msg, sig) {
+		str := fmt.Sprintf("addkey signature of %s does not verify",
+			fields[1])
+		return ruleError(ErrBadSignature, str)
+	}
+	if _, ok := s.pendingWeight(pubKey); ok {
+		str := fmt.Sprintf("addkey of %s which is already a signer",
+			fields[1])
+		return ruleError(ErrInvalidKeyChange, str)
+	}
+
+	s.entries = append(s.entries, pendingEntry{
+		kind:   entryAddKey,
+		pubKey: pubKey,
+		weight: weight,
+	})
+	return nil
+}
+
+// applyRemKey applies a remkey entry with the given type fields:
+//
+//	pubkey
+func (s *signerState) applyRemKey(fields []string) error {
+	if err := checkFields(linktype.RemoveKey, fields, 1, false); err != nil {
+		return err
+	}
+	pubKey, err := decodeKey(fields[0])
+	if err != nil {
+		return err
+	}
+	weight, ok := s.pendingWeight(pubKey)
+	if !ok {
+		str := fmt.Sprintf("remkey of %s which is not a signer",
+			fields[0])
+		return ruleError(ErrInvalidKeyChange, str)
+	}
+	m, n := s.pendingThreshold()
+	if n-weight < m {
+		str := fmt.Sprintf("remkey of %s would lower the total weight "+
+			"of the signers to %d below the signature threshold %d",
+			fields[0], n-weight, m)
+		return ruleError(ErrInvalidThreshold, str)
+	}
+
+	s.entries = append(s.entries, pendingEntry{
+		kind:   entryRemKey,
+		pubKey: pubKey,
+		weight: weight,
+	})
+	return nil
+}
+
+// applySigCtl applies a sigctl entry with the given type fields:
+//
+//	m
+func (s *signerState) applySigCtl(fields []string) error {
+	if err := checkFields(linktype.SignatureControl, fields, 1, false); err != nil {
+		return err
+	}
+	m, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return ruleError(ErrMalformedEntry, fmt.Sprintf("invalid "+
+			"signature threshold %q", fields[0]))
+	}
+	_, n := s.pendingThreshold()
+	if m <= 0 || m > n {
+		str := fmt.Sprintf("signature threshold %d not in range "+
+			"[1, %d]", m, n)
+		return ruleError(ErrInvalidThreshold, str)
+	}
+
+	s.entries = append(s.entries, pendingEntry{
+		kind: entrySigCtl,
+		m:    m,
+	})
+	return nil
+}
+
+// verifyEntries verifies the given hashchain entries, which extend the
+// hashchain described by the given signer state, and returns the resulting
+// signer state.  The given signer state is not changed.
+func verifyEntries(s *signerState, lines []string) (*signerState, error) {
+	next := s.clone()
+	for _, line := range lines {
+		if err := next.apply(line); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+// loadSignerState returns the signer state of the hashchain consisting of the
+// given entries.  Unlike verifyEntries, errors are not RuleErrors, since the
+// entries are expected to have been verified before.
+func loadSignerState(lines []string) (*signerState, error) {
+	s := newSignerState()
+	for i, line := range lines {
+		if err := s.apply(line); err != nil {
+			return nil, fmt.Errorf("hashchain entry %d: %v", i, err)
+		}
+	}
+	return s, nil
+}
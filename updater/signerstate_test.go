@@ -0,0 +1,442 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/frankbraun/codechain/hashchain"
+	"github.com/frankbraun/codechain/util/time"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testEntry returns a hashchain entry with the given hash of the previous
+// entry, time, type, and type fields.
+func testEntry(prev [32]byte, datum int64, linkType string, fields ...string) string {
+	return fmt.Sprintf("%x %s %s %s", prev, time.Format(datum), linkType,
+		strings.Join(fields, " "))
+}
+
+// encode returns the base64 encoding used by hashchain entries.
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// TestSignerState ensures the signer state of a hashchain created with the
+// Codechain library matches the one of the library and that invalid hashchain
+// entries are rejected with the expected error code.
+func TestSignerState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Generate the key of the signer which starts the hashchain, the key
+	// of a second signer, and the key of somebody who is not a signer.
+	var keys [3]ed25519.PrivateKey
+	for i := range keys {
+		_, keys[i], err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pubKey := func(i int) []byte { return keys[i][32:] }
+	secKey := func(i int) [64]byte {
+		var sec [64]byte
+		copy(sec[:], keys[i])
+		return sec
+	}
+	treeHashes := [][32]byte{
+		sha256.Sum256([]byte("tree 1")),
+		sha256.Sum256([]byte("tree 2")),
+		sha256.Sum256([]byte("tree 3")),
+	}
+
+	// Create a hashchain in which the first signer adds the second one,
+	// publishes and confirms the first tree hash, and raises the signature
+	// threshold to 2.  The second signer publishes the second tree hash,
+	// which is only signed by the first signer.
+	hc, _ := startHashchain(t, dir, keys[0])
+	defer hc.Close()
+	var pub [32]byte
+	var sig [64]byte
+	copy(pub[:], pubKey(1))
+	copy(sig[:], ed25519.Sign(keys[1], pubKey(1)))
+	if _, err := hc.AddKey(1, pub, sig, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.Signature(hc.Head(), secKey(0), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.Source(treeHashes[0], secKey(0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.Signature(hc.Head(), secKey(0), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.SignatureControl(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.Signature(hc.Head(), secKey(0), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.Source(treeHashes[1], secKey(1), nil); err != nil {
+		t.Fatal(err)
+	}
+	sourceHash := hc.Head()
+	if _, err := hc.Signature(sourceHash, secKey(0), false); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := hc.Fprint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	// Ensure the signer state matches the one of the library.
+	s, err := loadSignerState(lines)
+	if err != nil {
+		t.Fatalf("loadSignerState: unexpected error: %v", err)
+	}
+	if s.head != hc.Head() {
+		t.Fatalf("head: got %x, want %x", s.head, hc.Head())
+	}
+	if s.m != hc.M() || s.n != hc.N() {
+		t.Fatalf("m, n: got %d, %d, want %d, %d", s.m, s.n, hc.M(),
+			hc.N())
+	}
+	lastSigned, idx := hc.LastSignedTreeHash()
+	signed := s.signedTreeHashes()
+	if len(signed) != idx+1 || signed[idx] != lastSigned {
+		t.Fatalf("signed tree hashes: got %v, want %d ending in %s",
+			signed, idx+1, lastSigned)
+	}
+
+	// Ensure the signature of the second signer confirms the second tree
+	// hash without changing the original signer state.
+	signtr := testEntry(s.head, s.lastTime, "signtr",
+		fmt.Sprintf("%x", sourceHash), encode(pubKey(1)),
+		encode(ed25519.Sign(keys[1], sourceHash[:])))
+	next, err := verifyEntries(s, []string{signtr})
+	if err != nil {
+		t.Fatalf("verifyEntries: unexpected error: %v", err)
+	}
+	if got := len(next.signedTreeHashes()); got != 3 {
+		t.Fatalf("verifyEntries: got %d signed tree hashes, want 3", got)
+	}
+	if got := len(s.signedTreeHashes()); got != 2 {
+		t.Fatalf("verifyEntries: original signer state changed to %d "+
+			"signed tree hashes", got)
+	}
+
+	source := func(signer, sigKey int, treeHash [32]byte) string {
+		return testEntry(s.head, s.lastTime, "source",
+			fmt.Sprintf("%x", treeHash), encode(pubKey(signer)),
+			encode(ed25519.Sign(keys[sigKey], treeHash[:])))
+	}
+	signature := func(signer int, linkHash [32]byte) string {
+		return testEntry(s.head, s.lastTime, "signtr",
+			fmt.Sprintf("%x", linkHash), encode(pubKey(signer)),
+			encode(ed25519.Sign(keys[signer], linkHash[:])))
+	}
+	addKey := func(signer, sigKey int) string {
+		return testEntry(s.head, s.lastTime, "addkey", "1",
+			encode(pubKey(signer)),
+			encode(ed25519.Sign(keys[sigKey], pubKey(signer))))
+	}
+	tests := []struct {
+		name string
+		line string
+		code ErrorCode
+	}{{
+		name: "source with bad signature",
+		line: source(0, 1, treeHashes[2]),
+		code: ErrBadSignature,
+	}, {
+		name: "source by unknown signer",
+		line: source(2, 2, treeHashes[2]),
+		code: ErrUnknownSigner,
+	}, {
+		name: "source of signed tree hash",
+		line: source(0, 0, treeHashes[0]),
+		code: ErrDuplicateTreeHash,
+	}, {
+		name: "source of unsigned tree hash",
+		line: source(0, 0, treeHashes[1]),
+		code: ErrDuplicateTreeHash,
+	}, {
+		name: "signtr of unknown entry",
+		line: signature(0, treeHashes[2]),
+		code: ErrUnknownLinkHash,
+	}, {
+		name: "signtr by unknown signer",
+		line: signature(2, sourceHash),
+		code: ErrUnknownSigner,
+	}, {
+		name: "addkey with bad signature",
+		line: addKey(2, 0),
+		code: ErrBadSignature,
+	}, {
+		name: "addkey of signer",
+		line: addKey(1, 1),
+		code: ErrInvalidKeyChange,
+	}, {
+		name: "remkey of unknown signer",
+		line: testEntry(s.head, s.lastTime, "remkey", encode(pubKey(2))),
+		code: ErrInvalidKeyChange,
+	}, {
+		name: "remkey lowering n below m",
+		line: testEntry(s.head, s.lastTime, "remkey", encode(pubKey(1))),
+		code: ErrInvalidThreshold,
+	}, {
+		name: "sigctl with m larger than n",
+		line: testEntry(s.head, s.lastTime, "sigctl", "3"),
+		code: ErrInvalidThreshold,
+	}, {
+		name: "sigctl with m zero",
+		line: testEntry(s.head, s.lastTime, "sigctl", "0"),
+		code: ErrInvalidThreshold,
+	}, {
+		name: "broken link",
+		line: testEntry(treeHashes[2], s.lastTime, "sigctl", "1"),
+		code: ErrBrokenLink,
+	}, {
+		name: "time going backwards",
+		line: testEntry(s.head, s.lastTime-1, "sigctl", "1"),
+		code: ErrMalformedEntry,
+	}, {
+		name: "second cstart",
+		line: strings.Replace(lines[0], lines[0][:64],
+			fmt.Sprintf("%x", s.head), 1),
+		code: ErrMalformedEntry,
+	}, {
+		name: "unknown type",
+		line: testEntry(s.head, s.lastTime, "unknown", "1"),
+		code: ErrMalformedEntry,
+	}, {
+		name: "non-canonical entry",
+		line: strings.Replace(testEntry(s.head, s.lastTime, "sigctl",
+			"1"), "Z ", "+00:00 ", 1),
+		code: ErrMalformedEntry,
+	}}
+
+	for _, test := range tests {
+		_, err := verifyEntries(s, []string{test.line})
+		rerr, ok := err.(RuleError)
+		if !ok {
+			t.Errorf("%s: got error %v, want RuleError", test.name, err)
+			continue
+		}
+		if rerr.ErrorCode != test.code {
+			t.Errorf("%s: got error code %v, want %v", test.name,
+				rerr.ErrorCode, test.code)
+			continue
+		}
+
+		// Ensure the Codechain library rejects the entry as well.
+		all := strings.Join(append(lines, test.line), "\n") + "\n"
+		if _, err := hashchain.Read(strings.NewReader(all)); err == nil {
+			t.Errorf("%s: hashchain accepted by Codechain", test.name)
+		}
+	}
+}
+
+// TestSignerStateAgreement ensures the signer state agrees with the Codechain
+// library on the signers, their weights, the signature threshold, and the
+// signed tree hashes after every entry of a hashchain created with the library.
+func TestSignerStateAgreement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var keys [4]ed25519.PrivateKey
+	for i := range keys {
+		_, keys[i], err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	secKey := func(i int) [64]byte {
+		var sec [64]byte
+		copy(sec[:], keys[i])
+		return sec
+	}
+
+	hc, _ := startHashchain(t, dir, keys[0])
+	defer hc.Close()
+	addKey := func(i, weight int) func() (string, error) {
+		return func() (string, error) {
+			var pub [32]byte
+			var sig [64]byte
+			copy(pub[:], keys[i][32:])
+			copy(sig[:], ed25519.Sign(keys[i], pub[:]))
+			return hc.AddKey(weight, pub, sig, nil)
+		}
+	}
+	remKey := func(i int) func() (string, error) {
+		return func() (string, error) {
+			var pub [32]byte
+			copy(pub[:], keys[i][32:])
+			return hc.RemoveKey(pub)
+		}
+	}
+	source := func(i int, tree string) func() (string, error) {
+		return func() (string, error) {
+			return hc.Source(sha256.Sum256([]byte(tree)), secKey(i), nil)
+		}
+	}
+	signtr := func(i int) func() (string, error) {
+		return func() (string, error) {
+			return hc.Signature(hc.Head(), secKey(i), false)
+		}
+	}
+	sigCtl := func(m int) func() (string, error) {
+		return func() (string, error) {
+			return hc.SignatureControl(m)
+		}
+	}
+
+	tests := []struct {
+		name string
+		op   func() (string, error)
+	}{
+		{"addkey of signer 1 with weight 2", addKey(1, 2)},
+		{"signtr by signer 0", signtr(0)},
+		{"source by signer 1", source(1, "tree 1")},
+		{"signtr by signer 1", signtr(1)},
+		{"addkey of signer 2 with weight 3", addKey(2, 3)},
+		{"sigctl raising m to 4", sigCtl(4)},
+		{"signtr by signer 0 with m 1", signtr(0)},
+		{"source by signer 0", source(0, "tree 2")},
+		{"signtr by signer 0 below m", signtr(0)},
+		{"signtr by signer 2 reaching m", signtr(2)},
+		{"remkey of signer 1", remKey(1)},
+		{"source by signer 2", source(2, "tree 3")},
+		{"signtr by signer 2 below m", signtr(2)},
+		{"signtr by signer 0 reaching m", signtr(0)},
+		{"sigctl lowering m to 2", sigCtl(2)},
+		{"signtr by signer 2 below m", signtr(2)},
+		{"signtr by signer 0 reaching m", signtr(0)},
+		{"addkey of signer 3 with maximum weight", addKey(3, maxSignerWeight)},
+		{"signtr by signer 2 confirming addkey", signtr(2)},
+		{"source by signer 3", source(3, "tree 4")},
+		{"signtr by signer 3", signtr(3)},
+	}
+
+	for _, test := range tests {
+		if _, err := test.op(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var buf bytes.Buffer
+		if err := hc.Fprint(&buf); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+		s, err := loadSignerState(lines)
+		if err != nil {
+			t.Fatalf("%s: loadSignerState: unexpected error: %v",
+				test.name, err)
+		}
+		lib, err := hashchain.Read(strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatalf("%s: hashchain.Read: unexpected error: %v",
+				test.name, err)
+		}
+		if s.head != lib.Head() {
+			t.Fatalf("%s: head: got %x, want %x", test.name, s.head,
+				lib.Head())
+		}
+		if s.m != lib.M() || s.n != lib.N() {
+			t.Fatalf("%s: m, n: got %d, %d, want %d, %d", test.name,
+				s.m, s.n, lib.M(), lib.N())
+		}
+		if len(s.weights) != len(lib.Signer()) {
+			t.Fatalf("%s: got %d signers, want %d", test.name,
+				len(s.weights), len(lib.Signer()))
+		}
+		for pubKey, weight := range s.weights {
+			want := lib.SignerWeight(encode(pubKey[:]))
+			if weight != want {
+				t.Fatalf("%s: weight of %x: got %d, want %d",
+					test.name, pubKey[:], weight, want)
+			}
+		}
+		lastSigned, idx := lib.LastSignedTreeHash()
+		signed := s.signedTreeHashes()
+		if len(signed) != idx+1 || signed[idx] != lastSigned {
+			t.Fatalf("%s: signed tree hashes: got %v, want %d ending "+
+				"in %s", test.name, signed, idx+1, lastSigned)
+		}
+		lib.Close()
+	}
+}
+
+// TestSignerStateWeights ensures addkey entries are only accepted for weights
+// in the range the Codechain addkey command allows.
+func TestSignerStateWeights(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var keys [2]ed25519.PrivateKey
+	for i := range keys {
+		_, keys[i], err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hc, startLine := startHashchain(t, dir, keys[0])
+	defer hc.Close()
+	s, err := loadSignerState([]string{startLine})
+	if err != nil {
+		t.Fatalf("loadSignerState: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		weight string
+		valid  bool
+	}{
+		{"1", true},
+		{"3", true},
+		{fmt.Sprint(maxSignerWeight), true},
+		{"0", false},
+		{"-1", false},
+		{fmt.Sprint(int64(maxSignerWeight) + 1), false},
+		{"9223372036854775807", false},
+	}
+
+	pubKey := keys[1][32:]
+	for _, test := range tests {
+		line := testEntry(s.head, s.lastTime, "addkey", test.weight,
+			encode(pubKey), encode(ed25519.Sign(keys[1], pubKey)))
+		_, err := verifyEntries(s, []string{line})
+		if test.valid {
+			if err != nil {
+				t.Errorf("weight %s: unexpected error: %v",
+					test.weight, err)
+			}
+			continue
+		}
+		rerr, ok := err.(RuleError)
+		if !ok || rerr.ErrorCode != ErrInvalidKeyChange {
+			t.Errorf("weight %s: got error %v, want %v", test.weight,
+				err, ErrInvalidKeyChange)
+		}
+	}
+}
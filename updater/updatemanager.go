@@ -7,79 +7,266 @@
 package updater
 
 import (
-	"os"
-	"path/filepath"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/frankbraun/codechain/hashchain"
-	"github.com/frankbraun/codechain/util/file"
 	"github.com/frankbraun/codechain/util/hex"
+	"github.com/bitum-project/bitumd/chaincfg"
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/database"
 	"github.com/bitum-project/bitumd/peer"
 	"github.com/bitum-project/bitumd/updater/internal/chainstate"
 	"github.com/bitum-project/bitumd/wire"
 )
 
+// invMsg packages a Bitum inv message, the peer it came from, and a channel to
+// signal the result of processing it together so the update handler has access
+// to that information.
+type invMsg struct {
+	inv   *wire.MsgInv
+	peer  *peer.Peer
+	reply chan error
+}
+
 const (
-	mainnetHeadStr = "4fc99b1f42c57c3e0918162006ec85b8fbe2e0e25cf9f36ad287a9f56d2463e9"
-	testnetHeadStr = "246d7e6ab314d694fd602b04d5aa46bd6713e667e0e1ad6675533787b2b3fe00"
+	// maxPendingPatches is the maximum number of announced patch files
+	// for tree hashes which are not signed yet that are remembered.
+	maxPendingPatches = 100
+
+	// maxPendingPatchesPerPeer is the maximum number of announced patch
+	// files for tree hashes which are not signed yet that are remembered
+	// per peer, so a single peer can not occupy all of them.
+	maxPendingPatchesPerPeer = 10
+
+	// pendingPatchTimeout is the duration after which an announced patch
+	// file for a tree hash which is still not signed is forgotten.
+	pendingPatchTimeout = 24 * time.Hour
+
+	// maxRequestedEntries is the maximum number of announced hashchain
+	// heads whose entries are requested at once.
+	maxRequestedEntries = 100
+
+	// maxRequestedEntriesPerPeer is the maximum number of announced
+	// hashchain heads whose entries are requested from a single peer at
+	// once, so a single peer can not occupy all of them.
+	maxRequestedEntriesPerPeer = 10
+
+	// entryRequestTimeout is the duration a peer may take to send the
+	// hashchain entries up to a head it announced before the announcement
+	// is considered unsatisfied.
+	entryRequestTimeout = 2 * time.Minute
 )
 
-// invMsg packages a Bitum inv message and the peer it came from together
-// so the update handler has access to that information.
-type invMsg struct {
-	inv  *wire.MsgInv
+// entryRequest describes an announced hashchain head whose entries are
+// requested from the peer which announced it.  Only the entries up to one head
+// are requested from a peer at a time, which sent indicates, and requested is
+// the time they were last requested or the head was announced otherwise.
+type entryRequest struct {
+	peer      *peer.Peer
+	sent      bool
+	requested time.Time
+}
+
+// pendingPatch describes an announced patch file for a tree hash which is not
+// signed yet along with the peer which announced it and when.
+type pendingPatch struct {
+	peer      *peer.Peer
+	announced time.Time
+}
+
+// getCChainMsg packages a Bitum getcchain message and the peer it came from
+// together so the update handler has access to that information.
+type getCChainMsg struct {
+	msg  *wire.MsgGetCChain
+	peer *peer.Peer
+}
+
+// cchainMsg packages a Bitum cchain message, the peer it came from, and a
+// channel to signal the result of processing it together so the update
+// handler has access to that information.
+type cchainMsg struct {
+	msg   *wire.MsgCChain
+	peer  *peer.Peer
+	reply chan error
+}
+
+// getPatchMsg packages a Bitum getpatch message and the peer it came from
+// together so the update handler has access to that information.
+type getPatchMsg struct {
+	msg  *wire.MsgGetPatch
+	peer *peer.Peer
+}
+
+// patchMsg packages a Bitum patch message, the peer it came from, and a
+// channel to signal the result of processing it together so the update
+// handler has access to that information.
+type patchMsg struct {
+	msg   *wire.MsgPatch
+	peer  *peer.Peer
+	reply chan error
+}
+
+// donePeerMsg signifies a peer has disconnected.
+type donePeerMsg struct {
 	peer *peer.Peer
 }
 
+// Config is a descriptor containing the update manager configuration.
+type Config struct {
+	// MaxPeers is the maximum number of peers the server connects to.  It
+	// is used to size the message queue of the update manager.
+	MaxPeers int
+
+	// ChainParams identifies which chain parameters the update manager is
+	// associated with.  They define the Codechain hashchain of the network.
+	ChainParams *chaincfg.Params
+
+	// TreeHash is the Codechain source tree hash the running binary was
+	// built from.
+	TreeHash string
+
+	// DB is the database which houses the updater chain state.
+	DB database.DB
+
+	// DataDir is the data directory which contains the source tree and
+	// its hashchain.
+	DataDir string
+
+	// RelayInventory defines the function to use to announce new
+	// hashchain entries and patch files to all connected peers.
+	RelayInventory func(invVect *wire.InvVect)
+
+	// AutoUpdate enables automatic updates of the source tree in the data
+	// directory to the last signed tree hash.  Once a day, the source tree
+	// is updated with probability AutoUpdateProbability, or regardless of
+	// it once it is AutoUpdateWindow signed tree hashes behind.
+	AutoUpdate            bool
+	AutoUpdateProbability float64
+	AutoUpdateWindow      uint32
+}
+
 // UpdateManager provides a concurrency safe update manager for handling all
 // incoming Codechain entries and patch files.
 type UpdateManager struct {
 	started    int32
 	shutdown   int32
+	cfg        Config
 	msgChan    chan interface{}
 	wg         sync.WaitGroup
 	quit       chan struct{}
 	chainState *chainstate.ChainState
+
+	// signers tracks the signers and the signature threshold of the local
+	// hashchain, which received hashchain entries are verified against.
+	signers *signerState
+
+	// requestedEntries tracks the announced hashchain heads whose entries
+	// are requested and the peer they are requested from.
+	requestedEntries map[chainhash.Hash]*entryRequest
+
+	// requestedPatches tracks the announced patch files which have been
+	// requested and the peer they have been requested from.
+	requestedPatches map[chainhash.Hash]*peer.Peer
+
+	// pendingPatches tracks the announced patch files for tree hashes
+	// which are not signed by the local hashchain yet and the peer which
+	// announced them.  Peers announce patch files as soon as they have
+	// them, which can be before the hashchain entries signing them have
+	// been received from another peer, so they are requested once the
+	// tree hashes are signed.  They expire after pendingPatchTimeout.
+	pendingPatches map[chainhash.Hash]*pendingPatch
+
+	// head, lastSignedTreeHash, and signedTreeHashes cache the head and
+	// the signed tree hashes of the local hashchain for concurrent access
+	// outside of the update handler.
+	stateMtx           sync.RWMutex
+	head               [32]byte
+	lastSignedTreeHash [32]byte
+	signedTreeHashes   [][32]byte
+
+	// autoUpdate holds the outcome of the last automatic update check and
+	// is protected by stateMtx.  rand is the source of the random numbers
+	// which decide whether the source tree is updated.
+	autoUpdate AutoUpdateStatus
+	rand       *rand.Rand
 }
 
-func containsStringAtPos(sa []string, s string) (bool, int) {
-	for i, v := range sa {
-		if v == s {
-			return true, i
-		}
-	}
-	return false, -1
+// headChecker is implemented by hashchains which can check whether they
+// contain a given head.
+type headChecker interface {
+	CheckHead(head [32]byte) error
 }
 
-func checkHashchain(filename, treeHash string) error {
-	hc, err := hashchain.ReadFile(filename)
+// startSigner returns the public key of the signer which started a hashchain
+// with the given first line.
+func startSigner(line string) ([32]byte, error) {
+	var pubKey [32]byte
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) < 4 || fields[2] != "cstart" {
+		return pubKey, errors.New("first hashchain entry is not a " +
+			"cstart entry")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(fields[3])
 	if err != nil {
-		return err
+		return pubKey, err
+	}
+	if len(key) != len(pubKey) {
+		return pubKey, fmt.Errorf("invalid signer public key length %d",
+			len(key))
 	}
-	defer hc.Close()
+	copy(pubKey[:], key)
+	return pubKey, nil
+}
 
-	// Make sure hash chain contains known head.
-	var (
-		mainnetHead [32]byte
-		testnetHead [32]byte
-	)
-	head, err := hex.Decode(mainnetHeadStr, 32)
-	if err != nil {
-		return err
+// checkGenesis makes sure the given hashchain, which starts with the given
+// line, is the Codechain hashchain of the network described by the given
+// parameters.  It must contain the genesis head of the network and must be
+// started by one of the signers of the network, if they are defined.
+func checkGenesis(hc headChecker, startLine string, params *chaincfg.Params) error {
+	var zero [32]byte
+	if params.CodechainGenesisHead != zero {
+		if err := hc.CheckHead(params.CodechainGenesisHead); err != nil {
+			return fmt.Errorf("genesis head %x of %s not found: %v",
+				params.CodechainGenesisHead[:], params.Name, err)
+		}
+	}
+	if params.CodechainSigners == nil {
+		return nil
 	}
-	copy(mainnetHead[:], head)
-	head, err = hex.Decode(testnetHeadStr, 32)
+	pubKey, err := startSigner(startLine)
 	if err != nil {
 		return err
 	}
-	copy(testnetHead[:], head)
-	if err := hc.CheckHead(mainnetHead); err != nil {
-		log.Warnf("Head for mainnet not found, checking testnet: %v", err)
-		if err := hc.CheckHead(testnetHead); err != nil {
-			return err
+	for _, signer := range params.CodechainSigners {
+		if pubKey == signer {
+			return nil
 		}
-		log.Info("Head for testnet found.")
+	}
+	return fmt.Errorf("hashchain started by unknown signer %x for %s",
+		pubKey[:], params.Name)
+}
+
+// checkTreeHash makes sure the chain state holds the hashchain of the network
+// described by the given parameters and logs whether the given tree hash is a
+// signed or unsigned tree hash of it.
+func checkTreeHash(treeHash string, cs *chainstate.ChainState, params *chaincfg.Params) error {
+	log.Infof("Checking treehash %s", treeHash)
+	if cs.IsEmpty() {
+		log.Warn("hashchain is empty, abort check.")
+		return nil
+	}
+
+	// Make sure hash chain belongs to the network.
+	if err := checkGenesis(cs, cs.StartLine(), params); err != nil {
+		return err
 	}
 
 	// Try to find treeHash in hash chain (signed or unsigned).
@@ -88,84 +275,548 @@ func checkHashchain(filename, treeHash string) error {
 		return nil
 	}
 	// Make sure the treehash is parsable.
-	_, err = hex.Decode(treeHash, 32)
+	_, err := hex.Decode(treeHash, 32)
 	if err != nil {
 		return err
 	}
-	treeHashes := hc.TreeHashes()
-	contains, pos := containsStringAtPos(treeHashes, treeHash)
-	if !contains {
-		log.Warnf("Treehash %s not found.", treeHash)
-		return nil
-	}
-	_, lastPos := hc.LastSignedTreeHash()
-	if pos <= lastPos {
+	switch {
+	case cs.IsSignedTreeHash(treeHash):
 		log.Infof("Signed treehash %s found.", treeHash)
-	} else {
+	case cs.IsTreeHash(treeHash):
 		log.Infof("Unsigned treehash %s found.", treeHash)
+	default:
+		log.Warnf("Treehash %s not found.", treeHash)
 	}
 	return nil
 }
 
-func checkTreeHash(treeHash, dataDir string) error {
-	codechainDir := filepath.Join(dataDir, "src", ".codechain")
-	log.Infof("Checking treehash %s in %s", treeHash, codechainDir)
-	if err := os.MkdirAll(codechainDir, 0755); err != nil {
-		return err
-	}
-	hashchainFile := filepath.Join(codechainDir, "hashchain")
-	exists, err := file.Exists(hashchainFile)
-	if err != nil {
-		return err
-	}
-	if !exists {
-		log.Warnf("%s: file does not exist, abort check.", hashchainFile)
-		return nil
-	}
-	return checkHashchain(hashchainFile, treeHash)
-}
-
 // NewUpdateManager returns a new Bitum update manager.
 // Use Start to begin processing asynchronous update inv updates.
-func NewUpdateManager(maxPeers int, treeHash, dataDir string) (*UpdateManager, error) {
+func NewUpdateManager(cfg *Config) (*UpdateManager, error) {
 	um := UpdateManager{
-		msgChan: make(chan interface{}, maxPeers*3),
-		quit:    make(chan struct{}),
+		cfg:              *cfg,
+		msgChan:          make(chan interface{}, cfg.MaxPeers*3),
+		quit:             make(chan struct{}),
+		requestedEntries: make(map[chainhash.Hash]*entryRequest),
+		requestedPatches: make(map[chainhash.Hash]*peer.Peer),
+		pendingPatches:   make(map[chainhash.Hash]*pendingPatch),
+		autoUpdate: AutoUpdateStatus{
+			Enabled:     cfg.AutoUpdate,
+			Probability: cfg.AutoUpdateProbability,
+			Window:      cfg.AutoUpdateWindow,
+		},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 
-	// Check source code version at startup.
-	err := checkTreeHash(treeHash, dataDir)
+	var err error
+	um.chainState, err = chainstate.New(cfg.DB, cfg.DataDir)
 	if err != nil {
 		return nil, err
 	}
-
-	um.chainState, err = chainstate.New(dataDir)
+	var zero [32]byte
+	lines := um.chainState.Lines(zero, zero, math.MaxInt32)
+	um.signers, err = loadSignerState(lines)
 	if err != nil {
+		um.chainState.Close()
 		return nil, err
 	}
 
+	// Check source code version at startup.
+	if err := checkTreeHash(cfg.TreeHash, um.chainState, cfg.ChainParams); err != nil {
+		return nil, err
+	}
+	if err := um.updateCachedState(); err != nil {
+		return nil, err
+	}
+	if cfg.AutoUpdate {
+		log.Infof("Automatic updates enabled (probability %v per day, "+
+			"window %d)", cfg.AutoUpdateProbability, cfg.AutoUpdateWindow)
+	}
+
 	return &um, nil
 }
 
+// updateCachedState updates the cached head and signed tree hashes from the
+// chain state.
+func (u *UpdateManager) updateCachedState() error {
+	var lastSigned [32]byte
+	treeHash, err := hex.Decode(u.chainState.LastSignedTreeHash(), 32)
+	if err != nil {
+		return err
+	}
+	copy(lastSigned[:], treeHash)
+
+	signedHex := u.chainState.SignedTreeHashes()
+	signed := make([][32]byte, len(signedHex))
+	for i, h := range signedHex {
+		treeHash, err := hex.Decode(h, 32)
+		if err != nil {
+			return err
+		}
+		copy(signed[i][:], treeHash)
+	}
+
+	u.stateMtx.Lock()
+	u.head = u.chainState.Head()
+	u.lastSignedTreeHash = lastSigned
+	u.signedTreeHashes = signed
+	u.stateMtx.Unlock()
+	return nil
+}
+
+// Head returns the head of the local hashchain.
+//
+// This function is safe for concurrent access.
+func (u *UpdateManager) Head() [32]byte {
+	u.stateMtx.RLock()
+	defer u.stateMtx.RUnlock()
+	return u.head
+}
+
+// LastSignedTreeHash returns the last signed tree hash of the local hashchain.
+//
+// This function is safe for concurrent access.
+func (u *UpdateManager) LastSignedTreeHash() [32]byte {
+	u.stateMtx.RLock()
+	defer u.stateMtx.RUnlock()
+	return u.lastSignedTreeHash
+}
+
+// SignedTreeHashes returns the signed tree hashes of the local hashchain
+// ordered from oldest to newest.
+//
+// This function is safe for concurrent access.
+func (u *UpdateManager) SignedTreeHashes() [][32]byte {
+	u.stateMtx.RLock()
+	defer u.stateMtx.RUnlock()
+	signed := make([][32]byte, len(u.signedTreeHashes))
+	copy(signed, u.signedTreeHashes)
+	return signed
+}
+
 // handleInvMsg handles inv messages from all peers.
 // We examine the inventory advertised by the remote peer and act accordingly.
-func (u *UpdateManager) handleInvMsg(imsg *invMsg) {
+// A RuleError is returned if the peer did not send the hashchain entries of a
+// head it announced earlier in time.
+func (u *UpdateManager) handleInvMsg(imsg *invMsg) error {
 	log.Info("Handling inv message...")
+	unsatisfied := u.expireEntryRequests(imsg.peer, time.Now())
 	for _, invVect := range imsg.inv.InvList {
 		switch invVect.Type {
 		case wire.InvTypeCodechainEntry:
 			log.Infof("Codechain entry: %x", invVect.Hash[:])
+			imsg.peer.AddKnownInventory(invVect)
 			if u.chainState.EntryIsKnown(hex.Encode(invVect.Hash[:])) {
 				log.Info("Entry is known.")
-			} else {
-				log.Info("Entry is unknown.")
+				continue
+			}
+			log.Info("Entry is unknown.")
+
+			// Request the missing entries unless they have already
+			// been requested from a peer which is still connected.
+			if r, ok := u.requestedEntries[invVect.Hash]; ok && r.peer.Connected() {
+				continue
+			}
+			if !u.requestEntries(imsg.peer, &invVect.Hash, time.Now()) {
+				log.Debugf("Ignoring announced hashchain head %x "+
+					"from %s: too many requested entries",
+					invVect.Hash[:], imsg.peer)
 			}
 		case wire.InvTypePatch:
 			log.Infof("Patch file: %x", invVect.Hash[:])
+			imsg.peer.AddKnownInventory(invVect)
+			treeHash := hex.Encode(invVect.Hash[:])
+			if u.chainState.PatchIsKnown(treeHash) {
+				log.Info("Patch file is known.")
+				continue
+			}
+
+			// Patch files can only be verified against signed tree
+			// hashes of the local hashchain.  Remember them in case
+			// the hashchain entries signing them are still to come.
+			if !u.chainState.IsSignedTreeHash(treeHash) {
+				log.Infof("Tree hash %s is not signed.", treeHash)
+				u.addPendingPatch(invVect.Hash, imsg.peer, time.Now())
+				continue
+			}
+			u.requestPatches(imsg.peer, treeHash)
 		default:
 			log.Warnf("Type not handled here: %s", invVect.Type)
 		}
 	}
+
+	if unsatisfied {
+		str := fmt.Sprintf("peer %s did not send the hashchain entries "+
+			"of an announced head within %v", imsg.peer,
+			entryRequestTimeout)
+		return ruleError(ErrUnsatisfiedEntries, str)
+	}
+	return nil
+}
+
+// requestEntries requests all hashchain entries following the current head up
+// to the given stop hash, which the given peer announced at the passed time,
+// from the peer.  The request is sent once the entries up to the heads the peer
+// announced before arrived.  The announcement is ignored when the maximum
+// number of requested heads in total or for the peer is reached.  It returns
+// whether the head was requested.
+func (u *UpdateManager) requestEntries(p *peer.Peer, stop *chainhash.Hash, now time.Time) bool {
+	if _, ok := u.requestedEntries[*stop]; !ok &&
+		len(u.requestedEntries) >= maxRequestedEntries {
+
+		return false
+	}
+	var numPeerEntries int
+	inFlight := false
+	for _, r := range u.requestedEntries {
+		if r.peer == p {
+			numPeerEntries++
+			inFlight = inFlight || r.sent
+		}
+	}
+	if numPeerEntries >= maxRequestedEntriesPerPeer {
+		return false
+	}
+
+	r := &entryRequest{peer: p, requested: now}
+	u.requestedEntries[*stop] = r
+	if !inFlight {
+		u.sendEntryRequest(stop, r, now)
+	}
+	return true
+}
+
+// sendEntryRequest sends the request for all hashchain entries following the
+// current head up to the given stop hash to the peer of the passed request at
+// the passed time.
+func (u *UpdateManager) sendEntryRequest(stop *chainhash.Hash, r *entryRequest, now time.Time) {
+	var start chainhash.Hash
+	if !u.chainState.IsEmpty() {
+		start = u.chainState.Head()
+	}
+	log.Debugf("Requesting hashchain entries %x to %x from %s", start[:],
+		stop[:], r.peer)
+	r.sent = true
+	r.requested = now
+	r.peer.QueueMessage(wire.NewMsgGetCChain(&start, stop), nil)
+}
+
+// sendNextEntryRequest sends the request for the entries up to the next head
+// the given peer announced, if any, at the passed time.
+func (u *UpdateManager) sendNextEntryRequest(p *peer.Peer, now time.Time) {
+	var next *chainhash.Hash
+	var nextRequest *entryRequest
+	for stop, r := range u.requestedEntries {
+		if r.peer != p {
+			continue
+		}
+		if r.sent {
+			return
+		}
+		if nextRequest == nil || r.requested.Before(nextRequest.requested) {
+			stop := stop
+			next, nextRequest = &stop, r
+		}
+	}
+	if nextRequest != nil {
+		u.sendEntryRequest(next, nextRequest, now)
+	}
+}
+
+// expireEntryRequests forgets the heads announced by peers which did not send
+// the requested hashchain entries within entryRequestTimeout of the passed
+// time, along with the other heads they announced.  It returns whether the
+// passed peer is one of them.
+func (u *UpdateManager) expireEntryRequests(p *peer.Peer, now time.Time) bool {
+	expired := make(map[*peer.Peer]struct{})
+	for _, r := range u.requestedEntries {
+		if r.sent && now.Sub(r.requested) > entryRequestTimeout {
+			expired[r.peer] = struct{}{}
+		}
+	}
+	for stop, r := range u.requestedEntries {
+		if _, ok := expired[r.peer]; ok {
+			delete(u.requestedEntries, stop)
+		}
+	}
+	_, ok := expired[p]
+	return ok
+}
+
+// handleGetCChainMsg handles getcchain messages from all peers by sending
+// the requested hashchain entries, if known.
+func (u *UpdateManager) handleGetCChainMsg(gmsg *getCChainMsg) {
+	lines := u.chainState.Lines(gmsg.msg.HeadStart, gmsg.msg.HeadStop,
+		wire.MaxCChainLinesPerMsg)
+	if len(lines) == 0 {
+		log.Debugf("No hashchain entries to send to %s", gmsg.peer)
+		return
+	}
+
+	msg := wire.NewMsgCChain(&gmsg.msg.HeadStart)
+	for _, line := range lines {
+		if err := msg.AddLine(line); err != nil {
+			log.Warnf("Failed to add hashchain entry: %v", err)
+			return
+		}
+	}
+	gmsg.peer.QueueMessage(msg, nil)
+}
+
+// handleCChainMsg handles cchain messages from all peers.  The received
+// hashchain entries are verified against the signers of the local hashchain,
+// appended to it and the new head is announced to all connected peers.  A
+// RuleError is returned if the hashchain entries are invalid or the peer sent
+// all of the entries it has without reaching the head it announced.
+func (u *UpdateManager) handleCChainMsg(cmsg *cchainMsg) error {
+	// Ignore unsolicited hashchain entries.
+	requested := false
+	for _, r := range u.requestedEntries {
+		if r.peer == cmsg.peer && r.sent {
+			requested = true
+			break
+		}
+	}
+	if !requested {
+		log.Debugf("Ignoring unsolicited hashchain entries from %s",
+			cmsg.peer)
+		return nil
+	}
+
+	// Skip entries which became known since the request was sent.
+	lines := cmsg.msg.Lines
+	for len(lines) > 0 {
+		hash := sha256.Sum256([]byte(lines[0]))
+		if !u.chainState.EntryIsKnown(hex.Encode(hash[:])) {
+			break
+		}
+		lines = lines[1:]
+	}
+
+	if len(lines) > 0 {
+		signers, err := verifyEntries(u.signers, lines)
+		if err != nil {
+			u.removeRequests(cmsg.peer)
+			return err
+		}
+		hc, err := u.chainState.Verify(lines)
+		if err != nil {
+			log.Warnf("Received invalid hashchain entries from %s: %v",
+				cmsg.peer, err)
+			u.removeRequests(cmsg.peer)
+			return nil
+		}
+		startLine := u.chainState.StartLine()
+		if startLine == "" {
+			startLine = lines[0]
+		}
+		if err := checkGenesis(hc, startLine, u.cfg.ChainParams); err != nil {
+			log.Warnf("Received hashchain from %s of another "+
+				"network: %v", cmsg.peer, err)
+			u.removeRequests(cmsg.peer)
+			return nil
+		}
+		if err := u.chainState.Append(lines); err != nil {
+			log.Errorf("Failed to append hashchain entries: %v", err)
+			return nil
+		}
+		u.signers = signers
+		if err := u.updateCachedState(); err != nil {
+			log.Errorf("Failed to update cached chain state: %v", err)
+		}
+		head := chainhash.Hash(u.chainState.Head())
+		log.Infof("Added %d hashchain entries from %s, new head %x",
+			len(lines), cmsg.peer, head[:])
+		if u.cfg.RelayInventory != nil {
+			u.cfg.RelayInventory(wire.NewInvVect(
+				wire.InvTypeCodechainEntry, &head))
+		}
+		u.requestPendingPatches()
+	}
+
+	// Remove satisfied requests.  The peer is asked for more entries up to
+	// the head whose entries it is sending if it sent the maximum number of
+	// entries per message, while the head is unsatisfied otherwise.  The
+	// entries up to the next head it announced are requested once the ones
+	// up to the current head arrived.
+	now := time.Now()
+	full := len(cmsg.msg.Lines) == wire.MaxCChainLinesPerMsg
+	var unsatisfied *chainhash.Hash
+	for stop, r := range u.requestedEntries {
+		if u.chainState.EntryIsKnown(hex.Encode(stop[:])) {
+			delete(u.requestedEntries, stop)
+			continue
+		}
+		if r.peer != cmsg.peer || !r.sent {
+			continue
+		}
+		stop := stop
+		if full {
+			u.sendEntryRequest(&stop, r, now)
+			continue
+		}
+		delete(u.requestedEntries, stop)
+		unsatisfied = &stop
+	}
+	u.sendNextEntryRequest(cmsg.peer, now)
+	if unsatisfied != nil {
+		str := fmt.Sprintf("peer %s sent its hashchain entries without "+
+			"reaching the announced head %x", cmsg.peer, unsatisfied[:])
+		return ruleError(ErrUnsatisfiedEntries, str)
+	}
+	return nil
+}
+
+// addPendingPatch remembers the patch file for the passed tree hash, which is
+// not signed yet, as announced by the passed peer at the passed time.  Expired
+// announcements are forgotten first.  The announcement is ignored when the
+// patch file is already pending or the maximum number of pending patch files
+// in total or for the peer is reached.  It returns whether it was remembered.
+func (u *UpdateManager) addPendingPatch(hash chainhash.Hash, p *peer.Peer, now time.Time) bool {
+	for h, pp := range u.pendingPatches {
+		if now.Sub(pp.announced) > pendingPatchTimeout {
+			delete(u.pendingPatches, h)
+		}
+	}
+	if _, ok := u.pendingPatches[hash]; ok {
+		return false
+	}
+	if len(u.pendingPatches) >= maxPendingPatches {
+		return false
+	}
+	var numPeerPatches int
+	for _, pp := range u.pendingPatches {
+		if pp.peer == p {
+			numPeerPatches++
+		}
+	}
+	if numPeerPatches >= maxPendingPatchesPerPeer {
+		return false
+	}
+	u.pendingPatches[hash] = &pendingPatch{peer: p, announced: now}
+	return true
+}
+
+// requestPendingPatches requests the announced patch files whose tree hashes
+// became signed from the peers which announced them.  Announcements of peers
+// which disconnected in the meantime are dropped.
+func (u *UpdateManager) requestPendingPatches() {
+	for hash, pp := range u.pendingPatches {
+		treeHash := hex.Encode(hash[:])
+		switch {
+		case !pp.peer.Connected():
+			delete(u.pendingPatches, hash)
+		case u.chainState.IsSignedTreeHash(treeHash):
+			delete(u.pendingPatches, hash)
+			if !u.chainState.PatchIsKnown(treeHash) {
+				u.requestPatches(pp.peer, treeHash)
+			}
+		}
+	}
+}
+
+// removeRequests removes all outstanding hashchain entry requests to the
+// given peer and the patch files it announced which are pending.
+func (u *UpdateManager) removeRequests(p *peer.Peer) {
+	for stop, r := range u.requestedEntries {
+		if r.peer == p {
+			delete(u.requestedEntries, stop)
+		}
+	}
+	for hash, pp := range u.pendingPatches {
+		if pp.peer == p {
+			delete(u.pendingPatches, hash)
+		}
+	}
+}
+
+// requestPatches requests the patch files for all signed tree hashes up to and
+// including the given one which are not known yet from the given peer.  The
+// patch files are requested in hashchain order, because each of them can only
+// be verified if the preceding ones are known.
+func (u *UpdateManager) requestPatches(p *peer.Peer, treeHash string) {
+	gmsgs := make([]*wire.MsgGetPatch, 0)
+	for _, missing := range u.chainState.MissingPatches(treeHash) {
+		var hash chainhash.Hash
+		b, err := hex.Decode(missing, 32)
+		if err != nil {
+			log.Errorf("Failed to decode tree hash %s: %v", missing, err)
+			return
+		}
+		copy(hash[:], b)
+		if rp, ok := u.requestedPatches[hash]; ok && rp.Connected() {
+			continue
+		}
+		u.requestedPatches[hash] = p
+		gmsgs = append(gmsgs, wire.NewMsgGetPatch(&hash))
+	}
+	for _, gmsg := range gmsgs {
+		log.Debugf("Requesting patch file %x from %s", gmsg.TreeHash[:], p)
+		p.QueueMessage(gmsg, nil)
+	}
+}
+
+// handleGetPatchMsg handles getpatch messages from all peers by sending the
+// requested patch file, if known.
+func (u *UpdateManager) handleGetPatchMsg(gmsg *getPatchMsg) {
+	treeHash := hex.Encode(gmsg.msg.TreeHash[:])
+	if !u.chainState.PatchIsKnown(treeHash) {
+		log.Debugf("No patch file %s to send to %s", treeHash, gmsg.peer)
+		return
+	}
+	patch, err := u.chainState.Patch(treeHash)
+	if err != nil {
+		log.Errorf("Failed to read patch file %s: %v", treeHash, err)
+		return
+	}
+	if len(patch) > wire.MaxPatchSize {
+		log.Warnf("Patch file %s too large to send", treeHash)
+		return
+	}
+	gmsg.peer.QueueMessage(wire.NewMsgPatch(&gmsg.msg.TreeHash, patch), nil)
+}
+
+// handlePatchMsg handles patch messages from all peers.  The received patch
+// file is verified against the signed tree hashes of the local hashchain,
+// stored, and announced to all connected peers.  A RuleError is returned if
+// the patch file is invalid.
+func (u *UpdateManager) handlePatchMsg(pmsg *patchMsg) error {
+	treeHash := hex.Encode(pmsg.msg.TreeHash[:])
+
+	// Ignore unsolicited patch files.  Patch files for tree hashes which
+	// are not signed cannot be valid.
+	if p, ok := u.requestedPatches[pmsg.msg.TreeHash]; !ok || p != pmsg.peer {
+		if !u.chainState.IsSignedTreeHash(treeHash) {
+			str := fmt.Sprintf("patch file for tree hash %s "+
+				"which is not signed", treeHash)
+			return ruleError(ErrUnsignedPatch, str)
+		}
+		log.Debugf("Ignoring unsolicited patch file %s from %s",
+			treeHash, pmsg.peer)
+		return nil
+	}
+	delete(u.requestedPatches, pmsg.msg.TreeHash)
+
+	if u.chainState.PatchIsKnown(treeHash) {
+		return nil
+	}
+	err := u.chainState.AddPatch(treeHash, pmsg.msg.Patch)
+	switch err.(type) {
+	case nil:
+	case chainstate.PatchError:
+		return ruleError(ErrInvalidPatch, err.Error())
+	default:
+		log.Warnf("Cannot add patch file %s from %s: %v", treeHash,
+			pmsg.peer, err)
+		return nil
+	}
+
+	log.Infof("Added patch file %s from %s", treeHash, pmsg.peer)
+	if u.cfg.RelayInventory != nil {
+		u.cfg.RelayInventory(wire.NewInvVect(wire.InvTypePatch,
+			&pmsg.msg.TreeHash))
+	}
+	return nil
 }
 
 // blockHandler is the main handler for the block manager.  It must be run
@@ -175,37 +826,124 @@ func (u *UpdateManager) handleInvMsg(imsg *invMsg) {
 // important because the block manager controls which blocks are needed and how
 // the fetching should proceed.
 func (u *UpdateManager) updateHandler() {
+	// Decide whether to update the source tree once per interval when
+	// automatic updates are enabled.
+	var autoUpdateTicker *time.Ticker
+	var autoUpdateChan <-chan time.Time
+	if u.cfg.AutoUpdate {
+		autoUpdateTicker = time.NewTicker(autoUpdateInterval)
+		autoUpdateChan = autoUpdateTicker.C
+	}
+
 out:
 	for {
 		select {
 		case m := <-u.msgChan:
 			switch msg := m.(type) {
 			case *invMsg:
-				u.handleInvMsg(msg)
+				msg.reply <- u.handleInvMsg(msg)
+
+			case *getCChainMsg:
+				u.handleGetCChainMsg(msg)
+
+			case *cchainMsg:
+				msg.reply <- u.handleCChainMsg(msg)
+
+			case *getPatchMsg:
+				u.handleGetPatchMsg(msg)
+
+			case *patchMsg:
+				msg.reply <- u.handlePatchMsg(msg)
+
+			case *donePeerMsg:
+				u.removeRequests(msg.peer)
+
 			default:
 				log.Warnf("Invalid message type in update "+
 					"handler: %T", msg)
 			}
 
+		case <-autoUpdateChan:
+			u.checkAutoUpdate()
+
 		case <-u.quit:
 			break out
 		}
 	}
 
+	if autoUpdateTicker != nil {
+		autoUpdateTicker.Stop()
+	}
 	u.chainState.Close()
 	u.wg.Done()
 	log.Trace("Update handler done")
 }
 
 // QueueInv adds the passed inv message and peer to the update handling queue.
-func (u *UpdateManager) QueueInv(inv *wire.MsgInv, p *peer.Peer) {
-	// No channel handling here because peers do not need to block on inv
-	// messages.
+// The result of processing the inventory is sent on the passed channel, which
+// is a RuleError if the peer did not satisfy its earlier announcements.
+func (u *UpdateManager) QueueInv(inv *wire.MsgInv, p *peer.Peer, done chan error) {
+	if atomic.LoadInt32(&u.shutdown) != 0 {
+		done <- nil
+		return
+	}
+
+	u.msgChan <- &invMsg{inv: inv, peer: p, reply: done}
+}
+
+// DonePeer informs the update manager that a peer has disconnected.
+func (u *UpdateManager) DonePeer(p *peer.Peer) {
+	if atomic.LoadInt32(&u.shutdown) != 0 {
+		return
+	}
+
+	u.msgChan <- &donePeerMsg{peer: p}
+}
+
+// QueueGetCChain adds the passed getcchain message and peer to the update
+// handling queue.
+func (u *UpdateManager) QueueGetCChain(msg *wire.MsgGetCChain, p *peer.Peer) {
+	if atomic.LoadInt32(&u.shutdown) != 0 {
+		return
+	}
+
+	u.msgChan <- &getCChainMsg{msg: msg, peer: p}
+}
+
+// QueueCChain adds the passed cchain message and peer to the update handling
+// queue.  The result of processing the hashchain entries is sent on the passed
+// channel, which is a RuleError if they are invalid.
+func (u *UpdateManager) QueueCChain(msg *wire.MsgCChain, p *peer.Peer, done chan error) {
+	// Don't accept more hashchain entries if we're shutting down.
+	if atomic.LoadInt32(&u.shutdown) != 0 {
+		done <- nil
+		return
+	}
+
+	u.msgChan <- &cchainMsg{msg: msg, peer: p, reply: done}
+}
+
+// QueueGetPatch adds the passed getpatch message and peer to the update
+// handling queue.
+func (u *UpdateManager) QueueGetPatch(msg *wire.MsgGetPatch, p *peer.Peer) {
+	if atomic.LoadInt32(&u.shutdown) != 0 {
+		return
+	}
+
+	u.msgChan <- &getPatchMsg{msg: msg, peer: p}
+}
+
+// QueuePatch adds the passed patch message and peer to the update handling
+// queue.  The result of processing the patch file is sent on the passed
+// channel, which is a RuleError if the patch file is invalid.
+func (u *UpdateManager) QueuePatch(msg *wire.MsgPatch, p *peer.Peer, done chan error) {
+	// Don't accept more patch files if we're shutting down.
 	if atomic.LoadInt32(&u.shutdown) != 0 {
+		done <- nil
 		return
 	}
 
-	u.msgChan <- &invMsg{inv: inv, peer: p}
+	u.msgChan <- &patchMsg{msg: msg, peer: p, reply: done}
 }
 
 // Start begins the core update handler which processes Codechain entry and
@@ -0,0 +1,271 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package updater
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitum-project/bitumd/chaincfg"
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/bitum-project/bitumd/database"
+	_ "github.com/bitum-project/bitumd/database/ffldb"
+	"github.com/bitum-project/bitumd/peer"
+	"github.com/bitum-project/bitumd/updater/internal/chainstate"
+	"github.com/bitum-project/bitumd/wire"
+	"github.com/frankbraun/codechain/hashchain"
+	"golang.org/x/crypto/ed25519"
+)
+
+// startHashchain starts a hashchain in the given directory with the given
+// secret key and returns it along with its first line.
+func startHashchain(t *testing.T, dir string, secKey ed25519.PrivateKey) (*hashchain.HashChain, string) {
+	t.Helper()
+
+	var sec [64]byte
+	copy(sec[:], secKey)
+	hc, _, err := hashchain.Start(filepath.Join(dir, "hashchain"), sec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := hc.Fprint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return hc, strings.SplitN(buf.String(), "\n", 2)[0]
+}
+
+// TestCheckGenesis ensures hashchains are only accepted for the network whose
+// genesis head they contain or whose signer started them.
+func TestCheckGenesis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Start a hashchain with the documented throwaway signer of regnet and
+	// another one with a random signer.
+	seed := sha256.Sum256([]byte("bitum regnet codechain signer"))
+	regnetDir := filepath.Join(dir, "regnet")
+	otherDir := filepath.Join(dir, "other")
+	for _, d := range []string{regnetDir, otherDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	regnetHC, regnetStart := startHashchain(t, regnetDir,
+		ed25519.NewKeyFromSeed(seed[:]))
+	defer regnetHC.Close()
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHC, otherStart := startHashchain(t, otherDir, otherKey)
+	defer otherHC.Close()
+
+	// Pin a copy of the regnet parameters by the head of the other
+	// hashchain instead of the signers.
+	pinned := chaincfg.RegNetParams
+	pinned.CodechainSigners = nil
+	pinned.CodechainGenesisHead = otherHC.Head()
+
+	tests := []struct {
+		name      string
+		hc        headChecker
+		startLine string
+		params    *chaincfg.Params
+		valid     bool
+	}{
+		{"regnet signer", regnetHC, regnetStart, &chaincfg.RegNetParams, true},
+		{"unknown signer", otherHC, otherStart, &chaincfg.RegNetParams, false},
+		{"simnet signer", regnetHC, regnetStart, &chaincfg.SimNetParams, false},
+		{"mainnet genesis", regnetHC, regnetStart, &chaincfg.MainNetParams, false},
+		{"pinned genesis", otherHC, otherStart, &pinned, true},
+		{"not pinned genesis", regnetHC, regnetStart, &pinned, false},
+		{"no cstart", regnetHC, "foo", &chaincfg.RegNetParams, false},
+	}
+
+	for _, test := range tests {
+		err := checkGenesis(test.hc, test.startLine, test.params)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+// TestPendingPatches ensures announced patch files for tree hashes which are
+// not signed yet are limited per peer, expire, and are removed along with the
+// requests of the peer which announced them.
+func TestPendingPatches(t *testing.T) {
+	u := &UpdateManager{
+		requestedEntries: make(map[chainhash.Hash]*entryRequest),
+		pendingPatches:   make(map[chainhash.Hash]*pendingPatch),
+	}
+	p1 := peer.NewInboundPeer(&peer.Config{})
+	p2 := peer.NewInboundPeer(&peer.Config{})
+	now := time.Now()
+
+	// The announcements of a single peer are limited.
+	for i := 0; i < maxPendingPatchesPerPeer+1; i++ {
+		hash := chainhash.HashH([]byte{1, byte(i)})
+		added := u.addPendingPatch(hash, p1, now)
+		if want := i < maxPendingPatchesPerPeer; added != want {
+			t.Fatalf("announcement %d of peer 1: got added %v, want %v",
+				i, added, want)
+		}
+	}
+
+	// Duplicate announcements are ignored while other peers may still
+	// announce patch files.
+	if u.addPendingPatch(chainhash.HashH([]byte{1, 0}), p2, now) {
+		t.Fatal("duplicate announcement was added")
+	}
+	if !u.addPendingPatch(chainhash.HashH([]byte{2, 0}), p2, now) {
+		t.Fatal("announcement of peer 2 was not added")
+	}
+
+	// The announcements of peer 1 expire and make room for new ones.
+	later := now.Add(pendingPatchTimeout + time.Second)
+	if !u.addPendingPatch(chainhash.HashH([]byte{1, 0xff}), p1, later) {
+		t.Fatal("announcement of peer 1 after expiry was not added")
+	}
+	if got := len(u.pendingPatches); got != 1 {
+		t.Fatalf("unexpected number of pending patches after expiry - "+
+			"got %d, want 1", got)
+	}
+
+	// The announcements of a disconnected peer are removed.
+	u.addPendingPatch(chainhash.HashH([]byte{2, 1}), p2, later)
+	u.removeRequests(p1)
+	for hash, pp := range u.pendingPatches {
+		if pp.peer == p1 {
+			t.Fatalf("pending patch %v of removed peer remains", hash)
+		}
+	}
+	if got := len(u.pendingPatches); got != 1 {
+		t.Fatalf("unexpected number of pending patches after removal - "+
+			"got %d, want 1", got)
+	}
+}
+
+// TestEntryRequests ensures the requests for the hashchain entries of announced
+// heads are limited per peer and in total, are sent one at a time per peer, and
+// that announcements which are not satisfied are reported and forgotten.
+func TestEntryRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := database.Create("ffldb", filepath.Join(dir, "db"),
+		wire.RegNet)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	cs, err := chainstate.New(db, dir)
+	if err != nil {
+		t.Fatalf("failed to create chain state: %v", err)
+	}
+
+	u := &UpdateManager{
+		chainState:       cs,
+		requestedEntries: make(map[chainhash.Hash]*entryRequest),
+		pendingPatches:   make(map[chainhash.Hash]*pendingPatch),
+	}
+	numSent := func(p *peer.Peer) int {
+		var n int
+		for _, r := range u.requestedEntries {
+			if r.peer == p && r.sent {
+				n++
+			}
+		}
+		return n
+	}
+	now := time.Now()
+
+	// The announcements of a single peer are limited and only the entries
+	// up to one of them are requested at a time.
+	p1 := peer.NewInboundPeer(&peer.Config{})
+	for i := 0; i < maxRequestedEntriesPerPeer+1; i++ {
+		hash := chainhash.HashH([]byte{0, byte(i)})
+		added := u.requestEntries(p1, &hash, now.Add(time.Duration(i)))
+		if want := i < maxRequestedEntriesPerPeer; added != want {
+			t.Fatalf("announcement %d of peer 1: got added %v, want %v",
+				i, added, want)
+		}
+	}
+	if got := numSent(p1); got != 1 {
+		t.Fatalf("unexpected number of sent requests %d, want 1", got)
+	}
+
+	// The announcements of all peers are limited.
+	for i := 1; len(u.requestedEntries) < maxRequestedEntries; i++ {
+		p := peer.NewInboundPeer(&peer.Config{})
+		for j := 0; j < maxRequestedEntriesPerPeer; j++ {
+			hash := chainhash.HashH([]byte{byte(i), byte(j)})
+			if !u.requestEntries(p, &hash, now) {
+				t.Fatalf("announcement %d of peer %d was not added",
+					j, i)
+			}
+		}
+	}
+	p2 := peer.NewInboundPeer(&peer.Config{})
+	hash := chainhash.HashH([]byte{0xff})
+	if u.requestEntries(p2, &hash, now) {
+		t.Fatal("announcement beyond the total limit was added")
+	}
+
+	// A peer which sends all of the entries it has without reaching the
+	// announced head did not satisfy the announcement, and the entries up
+	// to the next head it announced are requested.
+	first := chainhash.HashH([]byte{0, 0})
+	err = u.handleCChainMsg(&cchainMsg{
+		msg:  wire.NewMsgCChain(&chainhash.Hash{}),
+		peer: p1,
+	})
+	if rerr, ok := err.(RuleError); !ok ||
+		rerr.ErrorCode != ErrUnsatisfiedEntries {
+
+		t.Fatalf("unexpected error for unsatisfied announcement: %v",
+			err)
+	}
+	if _, ok := u.requestedEntries[first]; ok {
+		t.Fatal("unsatisfied announcement was not forgotten")
+	}
+	next := chainhash.HashH([]byte{0, 1})
+	if r := u.requestedEntries[next]; r == nil || !r.sent {
+		t.Fatal("entries up to the next announced head were not " +
+			"requested")
+	}
+
+	// The announcements of peers which do not send the requested entries
+	// in time expire and are reported for the announcing peer.
+	later := now.Add(entryRequestTimeout + time.Second)
+	if !u.expireEntryRequests(p1, later) {
+		t.Fatal("expired announcement of peer 1 was not reported")
+	}
+	if len(u.requestedEntries) != 0 {
+		t.Fatalf("unexpected number of requested heads %d after "+
+			"expiry, want 0", len(u.requestedEntries))
+	}
+	if !u.requestEntries(p2, &hash, later) {
+		t.Fatal("announcement after expiry was not added")
+	}
+	if u.expireEntryRequests(p2, later) {
+		t.Fatal("announcement of peer 2 expired early")
+	}
+}
@@ -5,25 +5,16 @@
 package updater
 
 import (
-	"fmt"
 	"path/filepath"
 
 	"github.com/frankbraun/codechain/hashchain"
-	"github.com/bitum-project/bitumd/wire"
+	"github.com/bitum-project/bitumd/chaincfg"
 )
 
-// Head returns the current hashchain head for the given net.
-func Head(net wire.CurrencyNet) (*[32]byte, error) {
-	var dir string
-	switch net {
-	case wire.MainNet:
-		dir = ".codechain_mainnet"
-	case wire.TestNet:
-		dir = ".codechain_testnet"
-	default:
-		return nil, fmt.Errorf("wire.CurrencyNet %v not supported", net)
-	}
-	filename := filepath.Join(dir, "hashchain")
+// Head returns the current hashchain head of the Codechain repository of the
+// network described by the given parameters in the current directory.
+func Head(params *chaincfg.Params) (*[32]byte, error) {
+	filename := filepath.Join(params.CodechainDir, "hashchain")
 	hc, err := hashchain.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,147 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// uploadTargetTimeframe is the timeframe of the rolling window the
+	// upload target applies to.
+	uploadTargetTimeframe = 24 * time.Hour
+
+	// uploadTargetBuckets is the number of buckets the bytes sent within
+	// the rolling window are tallied in.  Bytes leave the window once their
+	// bucket is older than the timeframe.
+	uploadTargetBuckets = 24
+
+	// uploadTargetBucketDuration is the duration covered by each bucket.
+	uploadTargetBucketDuration = uploadTargetTimeframe / uploadTargetBuckets
+
+	// historicalBlockAge is the age of blocks which are considered
+	// historical.  Historical blocks are no longer served to peers without
+	// the download permission once the upload target is reached.
+	historicalBlockAge = 7 * 24 * time.Hour
+
+	// uploadTargetPeerShare is the inverse of the fraction of the upload
+	// target block data sent to a single peer may use within the
+	// timeframe.  Historical blocks are no longer served to peers without
+	// the download permission once they used their share, so a single peer
+	// can not use up the whole target.
+	uploadTargetPeerShare = 8
+)
+
+// errUploadTargetReached is returned when a historical block is not served
+// because the upload target is reached.
+var errUploadTargetReached = errors.New("upload target reached")
+
+// uploadTarget tracks the number of bytes sent to peers within a rolling
+// window of uploadTargetTimeframe against a target.  It is safe for concurrent
+// access.
+type uploadTarget struct {
+	target uint64 // Max bytes per timeframe, 0 for no limit.
+
+	mtx         sync.Mutex
+	buckets     [uploadTargetBuckets]uint64
+	current     int       // Index of the bucket bytes are added to.
+	bucketStart time.Time // Start time of the current bucket.
+}
+
+// newUploadTarget returns a new upload target which allows sending the passed
+// number of bytes within the timeframe.  A target of zero disables the limit.
+func newUploadTarget(target uint64) *uploadTarget {
+	return &uploadTarget{
+		target:      target,
+		bucketStart: time.Now().Truncate(uploadTargetBucketDuration),
+	}
+}
+
+// advance moves the current bucket forward to the passed time, clearing the
+// buckets which leave the window.
+//
+// This function MUST be called with the mutex held (for writes).
+func (u *uploadTarget) advance(now time.Time) {
+	elapsed := int(now.Sub(u.bucketStart) / uploadTargetBucketDuration)
+	if elapsed <= 0 {
+		return
+	}
+	if elapsed >= uploadTargetBuckets {
+		u.buckets = [uploadTargetBuckets]uint64{}
+	} else {
+		for i := 0; i < elapsed; i++ {
+			u.current = (u.current + 1) % uploadTargetBuckets
+			u.buckets[u.current] = 0
+		}
+	}
+	u.bucketStart = now.Truncate(uploadTargetBucketDuration)
+}
+
+// peerTarget returns a new upload target for the block data sent to a single
+// peer, which allows sending its share of the target.  It has no limit when the
+// target has none.
+func (u *uploadTarget) peerTarget() *uploadTarget {
+	if u.target == 0 {
+		return newUploadTarget(0)
+	}
+	target := u.target / uploadTargetPeerShare
+	if target == 0 {
+		target = 1
+	}
+	return newUploadTarget(target)
+}
+
+// addBytesAt adds the passed number of bytes sent at the passed time.
+func (u *uploadTarget) addBytesAt(now time.Time, n uint64) {
+	u.mtx.Lock()
+	u.advance(now)
+	u.buckets[u.current] += n
+	u.mtx.Unlock()
+}
+
+// addBytes adds the passed number of sent bytes.
+func (u *uploadTarget) addBytes(n uint64) {
+	u.addBytesAt(time.Now(), n)
+}
+
+// sentAt returns the number of bytes sent within the window which ends at the
+// passed time.
+func (u *uploadTarget) sentAt(now time.Time) uint64 {
+	u.mtx.Lock()
+	u.advance(now)
+	var sent uint64
+	for _, n := range u.buckets {
+		sent += n
+	}
+	u.mtx.Unlock()
+	return sent
+}
+
+// remainingAt returns the number of bytes which may still be sent within the
+// window which ends at the passed time.  It is zero when there is no target.
+func (u *uploadTarget) remainingAt(now time.Time) uint64 {
+	if u.target == 0 {
+		return 0
+	}
+	sent := u.sentAt(now)
+	if sent >= u.target {
+		return 0
+	}
+	return u.target - sent
+}
+
+// remaining returns the number of bytes which may still be sent within the
+// current window.  It is zero when there is no target.
+func (u *uploadTarget) remaining() uint64 {
+	return u.remainingAt(time.Now())
+}
+
+// reached returns whether the target is set and the bytes sent within the
+// current window reached it.
+func (u *uploadTarget) reached() bool {
+	return u.target != 0 && u.remainingAt(time.Now()) == 0
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUploadTarget ensures bytes sent are tracked within the rolling window and
+// leave it once they are older than the timeframe.
+func TestUploadTarget(t *testing.T) {
+	start := time.Unix(1560000000, 0).Truncate(uploadTargetBucketDuration)
+	u := newUploadTarget(1000)
+	u.bucketStart = start
+
+	// Send bytes spread over the first half of the timeframe.
+	u.addBytesAt(start, 300)
+	u.addBytesAt(start.Add(time.Hour+time.Minute), 200)
+	u.addBytesAt(start.Add(12*time.Hour), 400)
+	now := start.Add(12*time.Hour + time.Minute)
+	if sent := u.sentAt(now); sent != 900 {
+		t.Fatalf("sentAt: got %d, want 900", sent)
+	}
+	if left := u.remainingAt(now); left != 100 {
+		t.Fatalf("remainingAt: got %d, want 100", left)
+	}
+
+	// Reach the target.
+	u.addBytesAt(now, 200)
+	if left := u.remainingAt(now); left != 0 {
+		t.Fatalf("remainingAt: got %d after exceeding target, want 0",
+			left)
+	}
+
+	// The first bucket leaves the window once the timeframe elapsed.
+	now = start.Add(uploadTargetTimeframe)
+	if sent := u.sentAt(now); sent != 800 {
+		t.Fatalf("sentAt: got %d after first bucket expired, want 800",
+			sent)
+	}
+	if left := u.remainingAt(now); left != 200 {
+		t.Fatalf("remainingAt: got %d, want 200", left)
+	}
+
+	// The second bucket leaves the window an hour later.
+	now = now.Add(time.Hour)
+	if sent := u.sentAt(now); sent != 600 {
+		t.Fatalf("sentAt: got %d after second bucket expired, want 600",
+			sent)
+	}
+
+	// All bytes leave the window after being idle for the timeframe.
+	now = now.Add(uploadTargetTimeframe)
+	if sent := u.sentAt(now); sent != 0 {
+		t.Fatalf("sentAt: got %d after idle timeframe, want 0", sent)
+	}
+	u.addBytesAt(now, 50)
+	if sent := u.sentAt(now.Add(time.Minute)); sent != 50 {
+		t.Fatalf("sentAt: got %d after idle timeframe, want 50", sent)
+	}
+
+	// No bytes are left without a target.
+	if left := newUploadTarget(0).remaining(); left != 0 {
+		t.Fatalf("remaining: got %d without target, want 0", left)
+	}
+}
+
+// TestPeerUploadTarget ensures the upload target of a single peer allows its
+// share of the target.
+func TestPeerUploadTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target uint64
+		want   uint64
+	}{
+		{name: "no limit", target: 0, want: 0},
+		{name: "share", target: 8000, want: 1000},
+		{name: "minimum share", target: 1, want: 1},
+	}
+	for _, test := range tests {
+		u := newUploadTarget(test.target).peerTarget()
+		if u.target != test.want {
+			t.Errorf("%s: unexpected peer target - got %d, want %d",
+				test.name, u.target, test.want)
+		}
+	}
+
+	u := newUploadTarget(8000).peerTarget()
+	u.addBytes(999)
+	if u.reached() {
+		t.Fatal("peer target reached below its share")
+	}
+	u.addBytes(1)
+	if !u.reached() {
+		t.Fatal("peer target not reached at its share")
+	}
+}
@@ -31,6 +31,7 @@ const (
 	InvTypeTx             InvType = 1
 	InvTypeBlock          InvType = 2
 	InvTypeFilteredBlock  InvType = 3
+	InvTypeCmpctBlock     InvType = 4
 	InvTypeCodechainEntry InvType = 256 // Bitum updater
 	InvTypePatch          InvType = 257 // Bitum updater
 )
@@ -41,6 +42,7 @@ var ivStrings = map[InvType]string{
 	InvTypeTx:             "MSG_TX",
 	InvTypeBlock:          "MSG_BLOCK",
 	InvTypeFilteredBlock:  "MSG_FILTERED_BLOCK",
+	InvTypeCmpctBlock:     "MSG_CMPCT_BLOCK",
 	InvTypeCodechainEntry: "MSG_CODECHAIN_ENTRY",
 	InvTypePatch:          "MSG_PATCH",
 }
@@ -25,6 +25,7 @@ func TestInvTypeStringer(t *testing.T) {
 		{InvTypeTx, "MSG_TX"},
 		{InvTypeBlock, "MSG_BLOCK"},
 		{InvTypeFilteredBlock, "MSG_FILTERED_BLOCK"},
+		{InvTypeCmpctBlock, "MSG_CMPCT_BLOCK"},
 		{InvTypeCodechainEntry, "MSG_CODECHAIN_ENTRY"},
 		{InvTypePatch, "MSG_PATCH"},
 		{0xffffffff, "Unknown InvType (4294967295)"},
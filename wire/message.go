@@ -55,6 +55,16 @@ const (
 	CmdCFilter        = "cfilter"
 	CmdCFHeaders      = "cfheaders"
 	CmdCFTypes        = "cftypes"
+	CmdGetCChain      = "getcchain"
+	CmdCChain         = "cchain"
+	CmdGetPatch       = "getpatch"
+	CmdPatch          = "patch"
+	CmdSendCmpct      = "sendcmpct"
+	CmdCmpctBlock     = "cmpctblock"
+	CmdGetBlockTxn    = "getblocktxn"
+	CmdBlockTxn       = "blocktxn"
+	CmdSendAddrV2     = "sendaddrv2"
+	CmdAddrV2         = "addrv2"
 )
 
 // Message is an interface that describes a Bitum message.  A type that
@@ -151,6 +161,36 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdCFTypes:
 		msg = &MsgCFTypes{}
 
+	case CmdGetCChain:
+		msg = &MsgGetCChain{}
+
+	case CmdCChain:
+		msg = &MsgCChain{}
+
+	case CmdGetPatch:
+		msg = &MsgGetPatch{}
+
+	case CmdPatch:
+		msg = &MsgPatch{}
+
+	case CmdSendCmpct:
+		msg = &MsgSendCmpct{}
+
+	case CmdCmpctBlock:
+		msg = &MsgCmpctBlock{}
+
+	case CmdGetBlockTxn:
+		msg = &MsgGetBlockTxn{}
+
+	case CmdBlockTxn:
+		msg = &MsgBlockTxn{}
+
+	case CmdSendAddrV2:
+		msg = &MsgSendAddrV2{}
+
+	case CmdAddrV2:
+		msg = &MsgAddrV2{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
@@ -77,6 +77,20 @@ func TestMessage(t *testing.T) {
 	msgCFHeaders := NewMsgCFHeaders()
 	msgCFTypes := NewMsgCFTypes([]FilterType{GCSFilterExtended})
 	msgReject := NewMsgReject("block", RejectDuplicate, "duplicate block")
+	msgGetCChain := NewMsgGetCChain(&chainhash.Hash{}, &chainhash.Hash{})
+	msgCChain := NewMsgCChain(&chainhash.Hash{})
+	msgCChain.AddLine("line")
+	msgGetPatch := NewMsgGetPatch(&chainhash.Hash{})
+	msgPatch := NewMsgPatch(&chainhash.Hash{}, []byte("abc"))
+	msgSendCmpct := NewMsgSendCmpct(true, CmpctBlockVersion)
+	msgCmpctBlock := NewMsgCmpctBlockFromBlock(&testBlock, 123123)
+	msgGetBlockTxn := NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{1},
+		[]uint32{0, 2})
+	msgBlockTxn := NewMsgBlockTxn(&chainhash.Hash{})
+	msgSendAddrV2 := NewMsgSendAddrV2()
+	msgAddrV2 := NewMsgAddrV2()
+	msgAddrV2.AddAddress(NewNetAddressV2(time.Unix(0x495fab29, 0),
+		SFNodeNetwork, NetIDTorV3, make([]byte, 32), 9108))
 
 	tests := []struct {
 		in     Message     // Value to encode
@@ -85,7 +99,7 @@ func TestMessage(t *testing.T) {
 		bitumnet CurrencyNet // Network to use for wire encoding
 		bytes  int         // Expected num bytes read/written
 	}{
-		{msgVersion, msgVersion, pver, MainNet, 125},          // [0]
+		{msgVersion, msgVersion, pver, MainNet, 157},          // [0]
 		{msgVerack, msgVerack, pver, MainNet, 24},             // [1]
 		{msgGetAddr, msgGetAddr, pver, MainNet, 24},           // [2]
 		{msgAddr, msgAddr, pver, MainNet, 25},                 // [3]
@@ -107,6 +121,16 @@ func TestMessage(t *testing.T) {
 		{msgCFilter, msgCFilter, pver, MainNet, 65},           // [24]
 		{msgCFHeaders, msgCFHeaders, pver, MainNet, 58},       // [25]
 		{msgCFTypes, msgCFTypes, pver, MainNet, 26},           // [26]
+		{msgGetCChain, msgGetCChain, pver, MainNet, 88},       // [27]
+		{msgCChain, msgCChain, pver, MainNet, 62},             // [28]
+		{msgGetPatch, msgGetPatch, pver, MainNet, 56},         // [29]
+		{msgPatch, msgPatch, pver, MainNet, 60},               // [30]
+		{msgSendCmpct, msgSendCmpct, pver, MainNet, 33},       // [31]
+		{msgCmpctBlock, msgCmpctBlock, pver, MainNet, 381},    // [32]
+		{msgGetBlockTxn, msgGetBlockTxn, pver, MainNet, 61},   // [33]
+		{msgBlockTxn, msgBlockTxn, pver, MainNet, 58},         // [34]
+		{msgSendAddrV2, msgSendAddrV2, pver, MainNet, 24},     // [35]
+		{msgAddrV2, msgAddrV2, pver, MainNet, 66},             // [36]
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -0,0 +1,260 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestAddrV2 tests the MsgAddrV2 API.
+func TestAddrV2(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "addrv2"
+	msg := NewMsgAddrV2()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgAddrV2: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Num addresses (varInt) + max allowed addresses.
+	wantPayload := uint32(531009)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure addresses are added properly.
+	na := NewNetAddressV2(time.Now(), SFNodeNetwork, NetIDTorV3,
+		make([]byte, 32), 9108)
+	err := msg.AddAddress(na)
+	if err != nil {
+		t.Errorf("AddAddress: %v", err)
+	}
+	if msg.AddrList[0] != na {
+		t.Errorf("AddAddress: wrong address added - got %v, want %v",
+			spew.Sprint(msg.AddrList[0]), spew.Sprint(na))
+	}
+
+	// Ensure the address list is cleared properly.
+	msg.ClearAddresses()
+	if len(msg.AddrList) != 0 {
+		t.Errorf("ClearAddresses: address list is not empty - "+
+			"got %v [%v], want %v", len(msg.AddrList),
+			spew.Sprint(msg.AddrList[0]), 0)
+	}
+
+	// Ensure adding more than the max allowed addresses per message returns
+	// error.
+	for i := 0; i < MaxAddrPerMsg+1; i++ {
+		err = msg.AddAddress(na)
+	}
+	if err == nil {
+		t.Errorf("AddAddress: expected error on too many addresses " +
+			"not received")
+	}
+	err = msg.AddAddresses(na)
+	if err == nil {
+		t.Errorf("AddAddresses: expected error on too many addresses " +
+			"not received")
+	}
+}
+
+// TestAddrV2Wire tests the MsgAddrV2 wire encode and decode for various numbers
+// of addresses and protocol versions.
+func TestAddrV2Wire(t *testing.T) {
+	timestamp := time.Unix(0x495fab29, 0)
+
+	// A couple of addresses of different networks.
+	na := NewNetAddressV2(timestamp, SFNodeNetwork, NetIDIPv4,
+		[]byte{0x7f, 0x00, 0x00, 0x01}, 9108)
+	na2 := NewNetAddressV2(timestamp, SFNodeNetwork, NetIDTorV3,
+		bytes.Repeat([]byte{0xab}, 32), 9108)
+
+	// Empty address message.
+	noAddr := NewMsgAddrV2()
+	noAddrEncoded := []byte{
+		0x00, // Varint for number of addresses
+	}
+
+	// Address message with multiple addresses.
+	multiAddr := NewMsgAddrV2()
+	multiAddr.AddAddresses(na, na2)
+	multiAddrEncoded := []byte{
+		0x02,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,                   // Varint for services
+		0x01,                   // Network ID
+		0x04,                   // Varint for address size
+		0x7f, 0x00, 0x00, 0x01, // Address
+		0x23, 0x94, // Port 9108 in big-endian
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01, // Varint for services
+		0x04, // Network ID
+		0x20, // Varint for address size
+	}
+	multiAddrEncoded = append(multiAddrEncoded, na2.Addr...)
+	multiAddrEncoded = append(multiAddrEncoded, 0x23, 0x94)
+
+	tests := []struct {
+		in   *MsgAddrV2 // Message to encode
+		out  *MsgAddrV2 // Expected decoded message
+		buf  []byte     // Wire encoding
+		pver uint32     // Protocol version for wire encoding
+	}{
+		// Latest protocol version with no addresses.
+		{
+			noAddr,
+			noAddr,
+			noAddrEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version AddrV2Version with multiple addresses.
+		{
+			multiAddr,
+			multiAddr,
+			multiAddrEncoded,
+			AddrV2Version,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgAddrV2
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestAddrV2WireErrors performs negative tests against wire encode and decode
+// of MsgAddrV2 to confirm error paths work correctly.
+func TestAddrV2WireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoAddrV2 := AddrV2Version - 1
+	wireErr := &MessageError{}
+
+	// A valid address used to force errors.
+	na := NewNetAddressV2(time.Unix(0x495fab29, 0), SFNodeNetwork,
+		NetIDIPv4, []byte{0x7f, 0x00, 0x00, 0x01}, 9108)
+
+	// Address message with a single address.
+	baseAddr := NewMsgAddrV2()
+	baseAddr.AddAddress(na)
+	baseAddrEncoded := []byte{
+		0x01,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,                   // Varint for services
+		0x01,                   // Network ID
+		0x04,                   // Varint for address size
+		0x7f, 0x00, 0x00, 0x01, // Address
+		0x23, 0x94, // Port 9108 in big-endian
+	}
+
+	// Message that forces an error by having more than the max allowed
+	// addresses.
+	maxAddr := NewMsgAddrV2()
+	for i := 0; i < MaxAddrPerMsg; i++ {
+		maxAddr.AddAddress(na)
+	}
+	maxAddr.AddrList = append(maxAddr.AddrList, na)
+	maxAddrEncoded := []byte{
+		0xfd, 0xe9, 0x03, // Varint for number of addresses (1001)
+	}
+
+	tests := []struct {
+		in       *MsgAddrV2 // Value to encode
+		buf      []byte     // Wire encoding
+		pver     uint32     // Protocol version for wire encoding
+		max      int        // Max size of fixed buffer to induce errors
+		writeErr error      // Expected write error
+		readErr  error      // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in addresses count.
+		{baseAddr, baseAddrEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in address list.
+		{baseAddr, baseAddrEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max addresses.
+		{maxAddr, maxAddrEncoded, pver, 3, wireErr, wireErr},
+		// Force error due to unsupported protocol version.
+		{baseAddr, baseAddrEncoded, pverNoAddrV2, len(baseAddrEncoded), wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgAddrV2
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
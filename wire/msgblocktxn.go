@@ -0,0 +1,149 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+// MsgBlockTxn implements the Message interface and represents a bitum blocktxn
+// message.  It is used to deliver the transactions of a block requested via a
+// getblocktxn message (MsgGetBlockTxn).
+//
+// Transactions and STransactions hold the requested transactions of the
+// regular and stake transaction trees in the order they were requested.
+//
+// This message was not added until protocol versions starting with
+// CmpctBlocksVersion.
+type MsgBlockTxn struct {
+	BlockHash     chainhash.Hash
+	Transactions  []*MsgTx
+	STransactions []*MsgTx
+}
+
+// AddTransaction adds a transaction to the message.
+func (msg *MsgBlockTxn) AddTransaction(tx *MsgTx) {
+	msg.Transactions = append(msg.Transactions, tx)
+}
+
+// AddSTransaction adds a stake transaction to the message.
+func (msg *MsgBlockTxn) AddSTransaction(tx *MsgTx) {
+	msg.STransactions = append(msg.STransactions, tx)
+}
+
+// readBlockTxns reads the transactions of a transaction tree from r.
+func readBlockTxns(r io.Reader, pver uint32, tree string) ([]*MsgTx, error) {
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many %s transactions to fit into a "+
+			"block [count %d, max %d]", tree, count, maxTxPerTree)
+		return nil, messageError("MsgBlockTxn.BtcDecode", str)
+	}
+
+	txns := make([]*MsgTx, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var tx MsgTx
+		if err := tx.BtcDecode(r, pver); err != nil {
+			return nil, err
+		}
+		txns = append(txns, &tx)
+	}
+	return txns, nil
+}
+
+// writeBlockTxns writes the transactions of a transaction tree to w.
+func writeBlockTxns(w io.Writer, pver uint32, txns []*MsgTx, tree string) error {
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	count := uint64(len(txns))
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many %s transactions to fit into a "+
+			"block [count %d, max %d]", tree, count, maxTxPerTree)
+		return messageError("MsgBlockTxn.BtcEncode", str)
+	}
+
+	err := WriteVarInt(w, pver, count)
+	if err != nil {
+		return err
+	}
+	for _, tx := range txns {
+		if err := tx.BtcEncode(w, pver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("blocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgBlockTxn.BtcDecode", str)
+	}
+
+	err := readElement(r, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	msg.Transactions, err = readBlockTxns(r, pver, "regular")
+	if err != nil {
+		return err
+	}
+	msg.STransactions, err = readBlockTxns(r, pver, "stake")
+	return err
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("blocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgBlockTxn.BtcEncode", str)
+	}
+
+	err := writeElement(w, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeBlockTxns(w, pver, msg.Transactions, "regular")
+	if err != nil {
+		return err
+	}
+	return writeBlockTxns(w, pver, msg.STransactions, "stake")
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgBlockTxn) Command() string {
+	return CmdBlockTxn
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// The transactions of a block can not be larger than the block.
+	return MaxBlockPayload
+}
+
+// NewMsgBlockTxn returns a new bitum blocktxn message that conforms to the
+// Message interface using the passed parameters.  See MsgBlockTxn for details.
+func NewMsgBlockTxn(blockHash *chainhash.Hash) *MsgBlockTxn {
+	return &MsgBlockTxn{
+		BlockHash:     *blockHash,
+		Transactions:  make([]*MsgTx, 0),
+		STransactions: make([]*MsgTx, 0),
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// testBlockTxn returns a blocktxn message with the transactions of the test
+// block (testBlock) along with its wire encoding.
+func testBlockTxn() (*MsgBlockTxn, []byte) {
+	blockHash := testBlock.BlockHash()
+	msg := NewMsgBlockTxn(&blockHash)
+	msg.AddTransaction(testBlock.Transactions[0])
+	msg.AddSTransaction(testBlock.STransactions[0])
+
+	// The transaction trees are encoded the same way as in the block.
+	encoded := make([]byte, 0, 512)
+	encoded = append(encoded, blockHash[:]...)
+	encoded = append(encoded, testBlockBytes[MaxBlockHeaderPayload:]...)
+	return msg, encoded
+}
+
+// TestBlockTxn tests the MsgBlockTxn API.
+func TestBlockTxn(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "blocktxn"
+	msg := NewMsgBlockTxn(&chainhash.Hash{})
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	wantPayload := uint32(MaxBlockPayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure transactions are added properly.
+	tx := testBlock.Transactions[0]
+	msg.AddTransaction(tx)
+	if !reflect.DeepEqual(msg.Transactions, []*MsgTx{tx}) {
+		t.Errorf("AddTransaction: wrong transactions - got %v, want %v",
+			spew.Sdump(msg.Transactions), spew.Sdump(tx))
+	}
+	stx := testBlock.STransactions[0]
+	msg.AddSTransaction(stx)
+	if !reflect.DeepEqual(msg.STransactions, []*MsgTx{stx}) {
+		t.Errorf("AddSTransaction: wrong transactions - got %v, want %v",
+			spew.Sdump(msg.STransactions), spew.Sdump(stx))
+	}
+}
+
+// TestBlockTxnWire tests the MsgBlockTxn wire encode and decode for various
+// protocol versions.
+func TestBlockTxnWire(t *testing.T) {
+	blockTxn, blockTxnEncoded := testBlockTxn()
+
+	tests := []struct {
+		in   *MsgBlockTxn // Message to encode
+		out  *MsgBlockTxn // Expected decoded message
+		buf  []byte       // Wire encoding
+		pver uint32       // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			blockTxn,
+			blockTxn,
+			blockTxnEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CmpctBlocksVersion.
+		{
+			blockTxn,
+			blockTxn,
+			blockTxnEncoded,
+			CmpctBlocksVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgBlockTxn
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestBlockTxnWireErrors performs negative tests against wire encode and
+// decode of MsgBlockTxn to confirm error paths work correctly.
+func TestBlockTxnWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCmpct := CmpctBlocksVersion - 1
+	wireErr := &MessageError{}
+
+	baseBlockTxn, baseBlockTxnEncoded := testBlockTxn()
+	stakeOffset := 32 + testBlockSTxLocs[0].TxStart - 1 - MaxBlockHeaderPayload
+
+	// Encoded message with more transactions than fit into a block.
+	tooManyEncoded := make([]byte, 32, 41)
+	tooManyEncoded = append(tooManyEncoded, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff)
+
+	// Message with more transactions than fit into a block.
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	tooManyBlockTxn := NewMsgBlockTxn(&chainhash.Hash{})
+	tooManyBlockTxn.STransactions = make([]*MsgTx, maxTxPerTree+1)
+
+	tests := []struct {
+		in       *MsgBlockTxn // Value to encode
+		buf      []byte       // Wire encoding
+		pver     uint32       // Protocol version for wire encoding
+		max      int          // Max size of fixed buffer to induce errors
+		writeErr error        // Expected write error
+		readErr  error        // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in block hash.
+		{baseBlockTxn, baseBlockTxnEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in transaction count.
+		{baseBlockTxn, baseBlockTxnEncoded, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in transaction.
+		{baseBlockTxn, baseBlockTxnEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error in stake transaction count.
+		{baseBlockTxn, baseBlockTxnEncoded, pver, stakeOffset, io.ErrShortWrite, io.EOF},
+		// Force error in stake transaction.
+		{baseBlockTxn, baseBlockTxnEncoded, pver, stakeOffset + 1, io.ErrShortWrite, io.EOF},
+		// Force error due to unsupported protocol version.
+		{baseBlockTxn, baseBlockTxnEncoded, pverNoCmpct, len(baseBlockTxnEncoded), wireErr, wireErr},
+		// Force error with too many transactions.
+		{baseBlockTxn, tooManyEncoded, pver, len(baseBlockTxnEncoded), nil, wireErr},
+		// Force error with too many stake transactions.
+		{tooManyBlockTxn, baseBlockTxnEncoded, pver, 1 << 20, wireErr, nil},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgBlockTxn
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+const (
+	// MaxCChainLinesPerMsg is the maximum number of hashchain lines that
+	// can be in a single cchain message.
+	MaxCChainLinesPerMsg = 1000
+
+	// MaxCChainLineSize is the maximum size in bytes of a single hashchain
+	// line (without the trailing newline).
+	MaxCChainLineSize = 4096
+)
+
+// MsgCChain implements the Message interface and represents a bitum cchain
+// message.  It is used to deliver Codechain hashchain entries in response to
+// a getcchain message (MsgGetCChain).
+//
+// HeadStart is the hash of the hashchain entry the delivered lines follow and
+// is the zero hash when the lines start at the beginning of the hashchain.
+// Each line is a complete hashchain entry without the trailing newline.
+//
+// Use the AddLine function to build up the list of lines when sending a cchain
+// message to another peer.
+type MsgCChain struct {
+	HeadStart chainhash.Hash
+	Lines     []string
+}
+
+// AddLine adds a new hashchain line to the message.
+func (msg *MsgCChain) AddLine(line string) error {
+	if len(msg.Lines)+1 > MaxCChainLinesPerMsg {
+		str := fmt.Sprintf("too many lines in message [max %v]",
+			MaxCChainLinesPerMsg)
+		return messageError("MsgCChain.AddLine", str)
+	}
+	if len(line) > MaxCChainLineSize {
+		str := fmt.Sprintf("line too large for message [size %v, "+
+			"max %v]", len(line), MaxCChainLineSize)
+		return messageError("MsgCChain.AddLine", str)
+	}
+
+	msg.Lines = append(msg.Lines, line)
+	return nil
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCChain) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("cchain message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCChain.BtcDecode", str)
+	}
+
+	err := readElement(r, &msg.HeadStart)
+	if err != nil {
+		return err
+	}
+
+	// Read num lines and limit to max.
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > MaxCChainLinesPerMsg {
+		str := fmt.Sprintf("too many lines for message "+
+			"[count %v, max %v]", count, MaxCChainLinesPerMsg)
+		return messageError("MsgCChain.BtcDecode", str)
+	}
+
+	msg.Lines = make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		line, err := ReadVarBytes(r, pver, MaxCChainLineSize,
+			"cchain line")
+		if err != nil {
+			return err
+		}
+		msg.Lines = append(msg.Lines, string(line))
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCChain) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("cchain message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCChain.BtcEncode", str)
+	}
+
+	// Limit to max lines per message.
+	count := len(msg.Lines)
+	if count > MaxCChainLinesPerMsg {
+		str := fmt.Sprintf("too many lines for message "+
+			"[count %v, max %v]", count, MaxCChainLinesPerMsg)
+		return messageError("MsgCChain.BtcEncode", str)
+	}
+
+	err := writeElement(w, &msg.HeadStart)
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range msg.Lines {
+		if len(line) > MaxCChainLineSize {
+			str := fmt.Sprintf("line too large for message "+
+				"[size %v, max %v]", len(line), MaxCChainLineSize)
+			return messageError("MsgCChain.BtcEncode", str)
+		}
+		err := WriteVarBytes(w, pver, []byte(line))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCChain) Command() string {
+	return CmdCChain
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCChain) MaxPayloadLength(pver uint32) uint32 {
+	// Head start + num lines (varInt) + max allowed lines (varInt line
+	// length + line).
+	return chainhash.HashSize + MaxVarIntPayload + (MaxCChainLinesPerMsg *
+		(uint32(VarIntSerializeSize(MaxCChainLineSize)) +
+			MaxCChainLineSize))
+}
+
+// NewMsgCChain returns a new bitum cchain message that conforms to the
+// Message interface.  See MsgCChain for details.
+func NewMsgCChain(headStart *chainhash.Hash) *MsgCChain {
+	return &MsgCChain{
+		HeadStart: *headStart,
+		Lines:     make([]string, 0, MaxCChainLinesPerMsg),
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestCChain tests the MsgCChain API.
+func TestCChain(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "cchain"
+	msg := NewMsgCChain(&chainhash.Hash{})
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCChain: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Head start + num lines (varInt) + max allowed lines (varInt line
+	// length + line).
+	wantPayload := uint32(4099041)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure lines are added properly.
+	line := "line"
+	err := msg.AddLine(line)
+	if err != nil {
+		t.Errorf("AddLine: %v", err)
+	}
+	if msg.Lines[0] != line {
+		t.Errorf("AddLine: wrong line added - got %v, want %v",
+			msg.Lines[0], line)
+	}
+
+	// Ensure adding a line which exceeds the max allowed line size returns
+	// error.
+	err = msg.AddLine(strings.Repeat("a", MaxCChainLineSize+1))
+	if reflect.TypeOf(err) != reflect.TypeOf(&MessageError{}) {
+		t.Errorf("AddLine: expected error on too large line not " +
+			"received")
+	}
+
+	// Ensure adding more than the max allowed lines per message returns
+	// error.
+	for i := 0; i < MaxCChainLinesPerMsg; i++ {
+		err = msg.AddLine(line)
+	}
+	if reflect.TypeOf(err) != reflect.TypeOf(&MessageError{}) {
+		t.Errorf("AddLine: expected error on too many lines not " +
+			"received")
+	}
+}
+
+// TestCChainWire tests the MsgCChain wire encode and decode for various
+// numbers of lines and protocol versions.
+func TestCChainWire(t *testing.T) {
+	headStart := chainhash.Hash{
+		0x4f, 0xc9, 0x9b, 0x1f, 0x42, 0xc5, 0x7c, 0x3e,
+		0x09, 0x18, 0x16, 0x20, 0x06, 0xec, 0x85, 0xb8,
+		0xfb, 0xe2, 0xe0, 0xe2, 0x5c, 0xf9, 0xf3, 0x6a,
+		0xd2, 0x87, 0xa9, 0xf5, 0x6d, 0x24, 0x63, 0xe9,
+	}
+
+	// Empty cchain message.
+	noLines := NewMsgCChain(&headStart)
+	noLinesEncoded := append(headStart[:], []byte{
+		0x00, // Varint for number of lines
+	}...)
+
+	// Message with multiple lines.
+	multiLines := NewMsgCChain(&headStart)
+	multiLines.AddLine("abc")
+	multiLines.AddLine("de f")
+	multiLinesEncoded := append(headStart[:], []byte{
+		0x02,                   // Varint for number of lines
+		0x03, 0x61, 0x62, 0x63, // Line "abc"
+		0x04, 0x64, 0x65, 0x20, 0x66, // Line "de f"
+	}...)
+
+	tests := []struct {
+		in   *MsgCChain // Message to encode
+		out  *MsgCChain // Expected decoded message
+		buf  []byte     // Wire encoding
+		pver uint32     // Protocol version for wire encoding
+	}{
+		// Latest protocol version with no lines.
+		{
+			noLines,
+			noLines,
+			noLinesEncoded,
+			ProtocolVersion,
+		},
+
+		// Latest protocol version with multiple lines.
+		{
+			multiLines,
+			multiLines,
+			multiLinesEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CodechainVersion with multiple lines.
+		{
+			multiLines,
+			multiLines,
+			multiLinesEncoded,
+			CodechainVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgCChain
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg.HeadStart, test.out.HeadStart) ||
+			!reflect.DeepEqual(msg.Lines, test.out.Lines) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestCChainWireErrors performs negative tests against wire encode and decode
+// of MsgCChain to confirm error paths work correctly.
+func TestCChainWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCodechain := CodechainVersion - 1
+	wireErr := &MessageError{}
+
+	// Message with one line.
+	baseCChain := NewMsgCChain(&chainhash.Hash{})
+	baseCChain.AddLine("abc")
+	baseCChainEncoded := append(make([]byte, 32), []byte{
+		0x01,                   // Varint for number of lines
+		0x03, 0x61, 0x62, 0x63, // Line "abc"
+	}...)
+
+	// Message that forces an error by having more than the max allowed
+	// lines.
+	maxLines := NewMsgCChain(&chainhash.Hash{})
+	for i := 0; i < MaxCChainLinesPerMsg; i++ {
+		maxLines.AddLine("abc")
+	}
+	maxLines.Lines = append(maxLines.Lines, "abc")
+	maxLinesEncoded := append(make([]byte, 32), []byte{
+		0xfd, 0xe9, 0x03, // Varint for number of lines (1001)
+	}...)
+
+	// Message that forces an error by having a line which is larger than
+	// the max allowed line size.
+	largeLine := NewMsgCChain(&chainhash.Hash{})
+	largeLine.Lines = append(largeLine.Lines,
+		strings.Repeat("a", MaxCChainLineSize+1))
+	largeLineEncoded := append(make([]byte, 32), []byte{
+		0x01,             // Varint for number of lines
+		0xfd, 0x01, 0x10, // Varint for line size (4097)
+	}...)
+
+	tests := []struct {
+		in       *MsgCChain // Value to encode
+		buf      []byte     // Wire encoding
+		pver     uint32     // Protocol version for wire encoding
+		max      int        // Max size of fixed buffer to induce errors
+		writeErr error      // Expected write error
+		readErr  error      // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in head start.
+		{baseCChain, baseCChainEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in line count.
+		{baseCChain, baseCChainEncoded, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in line.
+		{baseCChain, baseCChainEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max lines.
+		{maxLines, maxLinesEncoded, pver, 35, wireErr, wireErr},
+		// Force error with greater than max line size.
+		{largeLine, largeLineEncoded, pver, 36, wireErr, wireErr},
+		// Force error due to unsupported protocol version.
+		{baseCChain, baseCChainEncoded, pverNoCodechain, 37, wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgCChain
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/dchest/siphash"
+)
+
+// ShortTxIDSize is the size in bytes of a short transaction ID in a compact
+// block.
+const ShortTxIDSize = 6
+
+// shortTxIDMask masks the bits of a SipHash-2-4 output which make up a short
+// transaction ID.
+const shortTxIDMask = 1<<(ShortTxIDSize*8) - 1
+
+// ShortTxID returns the short transaction ID of the transaction with the given
+// full hash (see MsgTx.TxHashFull) under the SipHash keys of a compact block.
+//
+// The full hash is used rather than the transaction hash since the latter does
+// not commit to the signature scripts of the transaction, which would allow a
+// transaction with the same prefix but different signature scripts to match.
+func ShortTxID(k0, k1 uint64, fullHash *chainhash.Hash) uint64 {
+	return siphash.Hash(k0, k1, fullHash[:]) & shortTxIDMask
+}
+
+// PrefilledTx is a transaction which is sent in full in a compact block along
+// with its index in the transaction tree of the block.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a bitum
+// cmpctblock message.  It is used to relay a block to peers which have most of
+// its transactions in their memory pool already.
+//
+// Instead of the transactions of the block, the message holds the short
+// transaction IDs (see ShortTxID) of both transaction trees under SipHash keys
+// which are derived from the block header and Nonce.  Transactions the
+// receiver is not expected to have, such as the coinbase, are prefilled along
+// with their index in the transaction tree.  The short IDs fill the remaining
+// positions of the tree in order.  Transactions the receiver is unable to
+// find are requested with a getblocktxn message (MsgGetBlockTxn).
+//
+// This message was not added until protocol versions starting with
+// CmpctBlocksVersion.
+type MsgCmpctBlock struct {
+	Header         BlockHeader
+	Nonce          uint64
+	ShortIDs       []uint64
+	PrefilledTxns  []PrefilledTx
+	StakeShortIDs  []uint64
+	PrefilledSTxns []PrefilledTx
+}
+
+// SipHashKeys returns the SipHash keys of the short transaction IDs of the
+// compact block, which are the first 16 bytes of the hash of the serialized
+// block header followed by the nonce.
+func (msg *MsgCmpctBlock) SipHashKeys() (uint64, uint64) {
+	var buf bytes.Buffer
+	buf.Grow(MaxBlockHeaderPayload + 8)
+	_ = writeBlockHeader(&buf, 0, &msg.Header)
+	_ = binarySerializer.PutUint64(&buf, littleEndian, msg.Nonce)
+	hash := chainhash.HashB(buf.Bytes())
+	return littleEndian.Uint64(hash[0:8]), littleEndian.Uint64(hash[8:16])
+}
+
+// readCmpctTxTree reads the short transaction IDs and prefilled transactions of
+// a transaction tree of a compact block from r.
+func readCmpctTxTree(r io.Reader, pver uint32, tree string) ([]uint64, []PrefilledTx, error) {
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many %s short IDs to fit into a block "+
+			"[count %d, max %d]", tree, count, maxTxPerTree)
+		return nil, nil, messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	shortIDs := make([]uint64, 0, count)
+	var b [8]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, b[:ShortTxIDSize]); err != nil {
+			return nil, nil, err
+		}
+		shortIDs = append(shortIDs, littleEndian.Uint64(b[:]))
+	}
+
+	prefilledCount, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, nil, err
+	}
+	numTxns := count + prefilledCount
+	if prefilledCount > maxTxPerTree || numTxns > maxTxPerTree {
+		str := fmt.Sprintf("too many %s transactions to fit into a "+
+			"block [count %d, max %d]", tree, numTxns, maxTxPerTree)
+		return nil, nil, messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	prefilled := make([]PrefilledTx, 0, prefilledCount)
+	for i := uint64(0); i < prefilledCount; i++ {
+		index, err := ReadVarInt(r, pver)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Prefilled transactions must be in order and within the
+		// transaction tree.
+		if index >= numTxns || (i > 0 &&
+			index <= uint64(prefilled[i-1].Index)) {
+			str := fmt.Sprintf("invalid %s prefilled transaction "+
+				"index %d", tree, index)
+			return nil, nil, messageError("MsgCmpctBlock.BtcDecode",
+				str)
+		}
+
+		var tx MsgTx
+		if err := tx.BtcDecode(r, pver); err != nil {
+			return nil, nil, err
+		}
+		prefilled = append(prefilled, PrefilledTx{
+			Index: uint32(index),
+			Tx:    &tx,
+		})
+	}
+
+	return shortIDs, prefilled, nil
+}
+
+// writeCmpctTxTree writes the short transaction IDs and prefilled transactions
+// of a transaction tree of a compact block to w.
+func writeCmpctTxTree(w io.Writer, pver uint32, shortIDs []uint64, prefilled []PrefilledTx, tree string) error {
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	numTxns := uint64(len(shortIDs) + len(prefilled))
+	if numTxns > maxTxPerTree {
+		str := fmt.Sprintf("too many %s transactions to fit into a "+
+			"block [count %d, max %d]", tree, numTxns, maxTxPerTree)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	err := WriteVarInt(w, pver, uint64(len(shortIDs)))
+	if err != nil {
+		return err
+	}
+	var b [8]byte
+	for _, shortID := range shortIDs {
+		littleEndian.PutUint64(b[:], shortID)
+		if _, err := w.Write(b[:ShortTxIDSize]); err != nil {
+			return err
+		}
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(prefilled)))
+	if err != nil {
+		return err
+	}
+	for _, ptx := range prefilled {
+		err := WriteVarInt(w, pver, uint64(ptx.Index))
+		if err != nil {
+			return err
+		}
+		if err := ptx.Tx.BtcEncode(w, pver); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+
+	err := readBlockHeader(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	msg.ShortIDs, msg.PrefilledTxns, err = readCmpctTxTree(r, pver,
+		"regular")
+	if err != nil {
+		return err
+	}
+	msg.StakeShortIDs, msg.PrefilledSTxns, err = readCmpctTxTree(r, pver,
+		"stake")
+	return err
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	err := writeBlockHeader(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = writeCmpctTxTree(w, pver, msg.ShortIDs, msg.PrefilledTxns,
+		"regular")
+	if err != nil {
+		return err
+	}
+	return writeCmpctTxTree(w, pver, msg.StakeShortIDs, msg.PrefilledSTxns,
+		"stake")
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// A compact block can not be larger than the block it represents
+	// except for the nonce and the prefilled transaction indexes, which are
+	// more than made up for by the short IDs replacing the transactions.
+	return MaxBlockPayload
+}
+
+// NewMsgCmpctBlock returns a new bitum cmpctblock message that conforms to the
+// Message interface using the passed parameters.  See MsgCmpctBlock for
+// details.
+func NewMsgCmpctBlock(header *BlockHeader, nonce uint64) *MsgCmpctBlock {
+	return &MsgCmpctBlock{
+		Header:         *header,
+		Nonce:          nonce,
+		ShortIDs:       make([]uint64, 0),
+		PrefilledTxns:  make([]PrefilledTx, 0),
+		StakeShortIDs:  make([]uint64, 0),
+		PrefilledSTxns: make([]PrefilledTx, 0),
+	}
+}
+
+// NewMsgCmpctBlockFromBlock returns a new bitum cmpctblock message for the
+// given block using the given nonce.  The coinbase is prefilled, all other
+// transactions are represented by their short transaction IDs.
+func NewMsgCmpctBlockFromBlock(block *MsgBlock, nonce uint64) *MsgCmpctBlock {
+	msg := NewMsgCmpctBlock(&block.Header, nonce)
+	k0, k1 := msg.SipHashKeys()
+	shortIDs := func(txns []*MsgTx) []uint64 {
+		ids := make([]uint64, 0, len(txns))
+		for _, tx := range txns {
+			hash := tx.TxHashFull()
+			ids = append(ids, ShortTxID(k0, k1, &hash))
+		}
+		return ids
+	}
+	if len(block.Transactions) > 0 {
+		msg.PrefilledTxns = []PrefilledTx{{
+			Index: 0,
+			Tx:    block.Transactions[0],
+		}}
+		msg.ShortIDs = shortIDs(block.Transactions[1:])
+	}
+	msg.StakeShortIDs = shortIDs(block.STransactions)
+	return msg
+}
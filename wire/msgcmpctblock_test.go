@@ -0,0 +1,263 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// testCmpctBlock returns a compact block for the test block (testBlock) with
+// fixed short IDs along with its wire encoding.
+func testCmpctBlock() (*MsgCmpctBlock, []byte) {
+	msg := NewMsgCmpctBlock(&testBlock.Header, 0x0807060504030201)
+	msg.ShortIDs = []uint64{0x0f0e0d0c0b0a}
+	msg.PrefilledTxns = []PrefilledTx{{Index: 1, Tx: testBlock.Transactions[0]}}
+	msg.StakeShortIDs = []uint64{0x151413121110, 0x1b1a19181716}
+
+	txLoc := testBlockTxLocs[0]
+	encoded := make([]byte, 0, 256)
+	encoded = append(encoded, testBlockBytes[:MaxBlockHeaderPayload]...)
+	encoded = append(encoded,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // Nonce
+		0x01,                               // Varint for number of short IDs
+		0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, // Short ID
+		0x01, // Varint for number of prefilled transactions
+		0x01, // Varint for prefilled transaction index
+	)
+	encoded = append(encoded,
+		testBlockBytes[txLoc.TxStart:txLoc.TxStart+txLoc.TxLen]...)
+	encoded = append(encoded,
+		0x02,                               // Varint for number of stake short IDs
+		0x10, 0x11, 0x12, 0x13, 0x14, 0x15, // Stake short ID
+		0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, // Stake short ID
+		0x00, // Varint for number of prefilled stake transactions
+	)
+	return msg, encoded
+}
+
+// TestCmpctBlock tests the MsgCmpctBlock API.
+func TestCmpctBlock(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "cmpctblock"
+	msg := NewMsgCmpctBlock(&testBlock.Header, 1)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCmpctBlock: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	wantPayload := uint32(MaxBlockPayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure the SipHash keys depend on the nonce.
+	k0, k1 := msg.SipHashKeys()
+	k2, k3 := NewMsgCmpctBlock(&testBlock.Header, 2).SipHashKeys()
+	if k0 == k2 && k1 == k3 {
+		t.Errorf("SipHashKeys: same keys for different nonces")
+	}
+
+	// Ensure a compact block created from a block prefills the coinbase
+	// and contains the short IDs of all other transactions.
+	msg = NewMsgCmpctBlockFromBlock(&testBlock, 1)
+	if !reflect.DeepEqual(msg.PrefilledTxns, []PrefilledTx{{Index: 0,
+		Tx: testBlock.Transactions[0]}}) {
+		t.Errorf("NewMsgCmpctBlockFromBlock: wrong prefilled "+
+			"transactions - got %v", spew.Sdump(msg.PrefilledTxns))
+	}
+	if len(msg.ShortIDs) != 0 || len(msg.PrefilledSTxns) != 0 {
+		t.Errorf("NewMsgCmpctBlockFromBlock: unexpected transactions")
+	}
+	stxHash := testBlock.STransactions[0].TxHashFull()
+	wantShortID := ShortTxID(k0, k1, &stxHash)
+	if !reflect.DeepEqual(msg.StakeShortIDs, []uint64{wantShortID}) {
+		t.Errorf("NewMsgCmpctBlockFromBlock: wrong stake short IDs - "+
+			"got %x, want [%x]", msg.StakeShortIDs, wantShortID)
+	}
+	if wantShortID > 1<<(ShortTxIDSize*8)-1 {
+		t.Errorf("ShortTxID: short ID %x exceeds %d bytes", wantShortID,
+			ShortTxIDSize)
+	}
+}
+
+// TestCmpctBlockWire tests the MsgCmpctBlock wire encode and decode for
+// various protocol versions.
+func TestCmpctBlockWire(t *testing.T) {
+	cmpctBlock, cmpctBlockEncoded := testCmpctBlock()
+
+	tests := []struct {
+		in   *MsgCmpctBlock // Message to encode
+		out  *MsgCmpctBlock // Expected decoded message
+		buf  []byte         // Wire encoding
+		pver uint32         // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			cmpctBlock,
+			cmpctBlock,
+			cmpctBlockEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CmpctBlocksVersion.
+		{
+			cmpctBlock,
+			cmpctBlock,
+			cmpctBlockEncoded,
+			CmpctBlocksVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgCmpctBlock
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestCmpctBlockWireErrors performs negative tests against wire encode and
+// decode of MsgCmpctBlock to confirm error paths work correctly.
+func TestCmpctBlockWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCmpct := CmpctBlocksVersion - 1
+	wireErr := &MessageError{}
+
+	baseCmpctBlock, baseCmpctBlockEncoded := testCmpctBlock()
+
+	// Offsets of the fields in the encoded compact block.
+	nonceOffset := MaxBlockHeaderPayload
+	shortIDsOffset := nonceOffset + 8
+	prefilledOffset := shortIDsOffset + 1 + ShortTxIDSize
+	stakeOffset := prefilledOffset + 2 + testBlockTxLocs[0].TxLen
+
+	// Encoded compact block with a prefilled transaction index outside of
+	// the transaction tree.
+	badIndexEncoded := make([]byte, len(baseCmpctBlockEncoded))
+	copy(badIndexEncoded, baseCmpctBlockEncoded)
+	badIndexEncoded[prefilledOffset+1] = 0x02
+
+	// Encoded compact block with more short IDs than fit into a block.
+	tooManyEncoded := make([]byte, shortIDsOffset, shortIDsOffset+9)
+	copy(tooManyEncoded, baseCmpctBlockEncoded)
+	tooManyEncoded = append(tooManyEncoded, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff)
+
+	// Compact block with more transactions than fit into a block.
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	tooManyCmpctBlock := NewMsgCmpctBlock(&testBlock.Header, 0)
+	tooManyCmpctBlock.ShortIDs = make([]uint64, maxTxPerTree+1)
+
+	tests := []struct {
+		in       *MsgCmpctBlock // Value to encode
+		buf      []byte         // Wire encoding
+		pver     uint32         // Protocol version for wire encoding
+		max      int            // Max size of fixed buffer to induce errors
+		writeErr error          // Expected write error
+		readErr  error          // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in header.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in nonce.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, nonceOffset, io.ErrShortWrite, io.EOF},
+		// Force error in short ID count.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, shortIDsOffset, io.ErrShortWrite, io.EOF},
+		// Force error in short ID.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, shortIDsOffset + 1, io.ErrShortWrite, io.EOF},
+		// Force error in prefilled transaction count.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, prefilledOffset, io.ErrShortWrite, io.EOF},
+		// Force error in prefilled transaction index.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, prefilledOffset + 1, io.ErrShortWrite, io.EOF},
+		// Force error in prefilled transaction.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, prefilledOffset + 2, io.ErrShortWrite, io.EOF},
+		// Force error in stake short ID count.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pver, stakeOffset, io.ErrShortWrite, io.EOF},
+		// Force error due to unsupported protocol version.
+		{baseCmpctBlock, baseCmpctBlockEncoded, pverNoCmpct, len(baseCmpctBlockEncoded), wireErr, wireErr},
+		// Force error with prefilled transaction index outside of tree.
+		{baseCmpctBlock, badIndexEncoded, pver, len(badIndexEncoded), nil, wireErr},
+		// Force error with too many short IDs.
+		{baseCmpctBlock, tooManyEncoded, pver, len(baseCmpctBlockEncoded), nil, wireErr},
+		// Force error with too many transactions.
+		{tooManyCmpctBlock, baseCmpctBlockEncoded, pver, 1 << 20, wireErr, nil},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgCmpctBlock
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+// MsgGetBlockTxn implements the Message interface and represents a bitum
+// getblocktxn message.  It is used to request the transactions of a block
+// announced via a compact block (MsgCmpctBlock) which could not be found in
+// the memory pool.  The transactions are returned via a blocktxn message
+// (MsgBlockTxn).
+//
+// Indexes and StakeIndexes are the indexes of the requested transactions in
+// the regular and stake transaction trees of the block, in ascending order.
+//
+// This message was not added until protocol versions starting with
+// CmpctBlocksVersion.
+type MsgGetBlockTxn struct {
+	BlockHash    chainhash.Hash
+	Indexes      []uint32
+	StakeIndexes []uint32
+}
+
+// readTxIndexes reads the transaction indexes of a transaction tree from r.
+func readTxIndexes(r io.Reader, pver uint32, tree string) ([]uint32, error) {
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many %s transaction indexes for message "+
+			"[count %d, max %d]", tree, count, maxTxPerTree)
+		return nil, messageError("MsgGetBlockTxn.BtcDecode", str)
+	}
+
+	indexes := make([]uint32, 0, count)
+	for i := uint64(0); i < count; i++ {
+		index, err := ReadVarInt(r, pver)
+		if err != nil {
+			return nil, err
+		}
+
+		// The indexes must be in ascending order and within the
+		// transaction tree.
+		if index >= maxTxPerTree || (i > 0 &&
+			index <= uint64(indexes[i-1])) {
+			str := fmt.Sprintf("invalid %s transaction index %d",
+				tree, index)
+			return nil, messageError("MsgGetBlockTxn.BtcDecode", str)
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// writeTxIndexes writes the transaction indexes of a transaction tree to w.
+func writeTxIndexes(w io.Writer, pver uint32, indexes []uint32, tree string) error {
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	count := uint64(len(indexes))
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many %s transaction indexes for message "+
+			"[count %d, max %d]", tree, count, maxTxPerTree)
+		return messageError("MsgGetBlockTxn.BtcEncode", str)
+	}
+
+	err := WriteVarInt(w, pver, count)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		err := WriteVarInt(w, pver, uint64(index))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcDecode", str)
+	}
+
+	err := readElement(r, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	msg.Indexes, err = readTxIndexes(r, pver, "regular")
+	if err != nil {
+		return err
+	}
+	msg.StakeIndexes, err = readTxIndexes(r, pver, "stake")
+	return err
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcEncode", str)
+	}
+
+	err := writeElement(w, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeTxIndexes(w, pver, msg.Indexes, "regular")
+	if err != nil {
+		return err
+	}
+	return writeTxIndexes(w, pver, msg.StakeIndexes, "stake")
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetBlockTxn) Command() string {
+	return CmdGetBlockTxn
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + 2 * (num indexes (varInt) + max indexes (varInt each)).
+	maxTxPerTree := uint32(MaxTxPerTxTree(pver))
+	return chainhash.HashSize + 2*(MaxVarIntPayload+
+		maxTxPerTree*MaxVarIntPayload)
+}
+
+// NewMsgGetBlockTxn returns a new bitum getblocktxn message that conforms to
+// the Message interface using the passed parameters.  See MsgGetBlockTxn for
+// details.
+func NewMsgGetBlockTxn(blockHash *chainhash.Hash, indexes, stakeIndexes []uint32) *MsgGetBlockTxn {
+	return &MsgGetBlockTxn{
+		BlockHash:    *blockHash,
+		Indexes:      indexes,
+		StakeIndexes: stakeIndexes,
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetBlockTxn tests the MsgGetBlockTxn API.
+func TestGetBlockTxn(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getblocktxn"
+	msg := NewMsgGetBlockTxn(&chainhash.Hash{}, nil, nil)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Block hash + 2 * (num indexes (varInt) + max indexes (varInt each)).
+	wantPayload := uint32(786488)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+}
+
+// TestGetBlockTxnWire tests the MsgGetBlockTxn wire encode and decode for
+// various protocol versions.
+func TestGetBlockTxnWire(t *testing.T) {
+	blockHash := chainhash.Hash{
+		0x59, 0x98, 0xc6, 0x3a, 0xca, 0x42, 0xe4, 0x71,
+		0x29, 0x7c, 0x0f, 0xa3, 0x53, 0x53, 0x8a, 0x93,
+		0xd4, 0xd4, 0xcf, 0xaf, 0xe9, 0xa6, 0x72, 0xdf,
+		0x69, 0x89, 0xe6, 0x94, 0x18, 0x8b, 0x4a, 0x92,
+	}
+	getBlockTxn := NewMsgGetBlockTxn(&blockHash, []uint32{1, 300},
+		[]uint32{})
+	getBlockTxnEncoded := append(blockHash[:],
+		0x02,             // Varint for number of indexes
+		0x01,             // Index 1
+		0xfd, 0x2c, 0x01, // Index 300
+		0x00, // Varint for number of stake indexes
+	)
+
+	tests := []struct {
+		in   *MsgGetBlockTxn // Message to encode
+		out  *MsgGetBlockTxn // Expected decoded message
+		buf  []byte          // Wire encoding
+		pver uint32          // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			getBlockTxn,
+			getBlockTxn,
+			getBlockTxnEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CmpctBlocksVersion.
+		{
+			getBlockTxn,
+			getBlockTxn,
+			getBlockTxnEncoded,
+			CmpctBlocksVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgGetBlockTxn
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestGetBlockTxnWireErrors performs negative tests against wire encode and
+// decode of MsgGetBlockTxn to confirm error paths work correctly.
+func TestGetBlockTxnWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCmpct := CmpctBlocksVersion - 1
+	wireErr := &MessageError{}
+
+	baseGetBlockTxn := NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{1},
+		[]uint32{2})
+	baseGetBlockTxnEncoded := make([]byte, 32, 36)
+	baseGetBlockTxnEncoded = append(baseGetBlockTxnEncoded,
+		0x01, 0x01, // Indexes
+		0x01, 0x02, // Stake indexes
+	)
+
+	// Encoded message with indexes which are not in ascending order.
+	unorderedEncoded := make([]byte, 32, 37)
+	unorderedEncoded = append(unorderedEncoded,
+		0x02, 0x02, 0x01, // Indexes
+		0x00, // Stake indexes
+	)
+
+	// Encoded message with more indexes than fit into a block.
+	tooManyEncoded := make([]byte, 32, 41)
+	tooManyEncoded = append(tooManyEncoded, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff)
+
+	// Message with more indexes than fit into a block.
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	tooManyGetBlockTxn := NewMsgGetBlockTxn(&chainhash.Hash{},
+		make([]uint32, maxTxPerTree+1), nil)
+
+	tests := []struct {
+		in       *MsgGetBlockTxn // Value to encode
+		buf      []byte          // Wire encoding
+		pver     uint32          // Protocol version for wire encoding
+		max      int             // Max size of fixed buffer to induce errors
+		writeErr error           // Expected write error
+		readErr  error           // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in block hash.
+		{baseGetBlockTxn, baseGetBlockTxnEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in index count.
+		{baseGetBlockTxn, baseGetBlockTxnEncoded, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in index.
+		{baseGetBlockTxn, baseGetBlockTxnEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error in stake index count.
+		{baseGetBlockTxn, baseGetBlockTxnEncoded, pver, 34, io.ErrShortWrite, io.EOF},
+		// Force error in stake index.
+		{baseGetBlockTxn, baseGetBlockTxnEncoded, pver, 35, io.ErrShortWrite, io.EOF},
+		// Force error due to unsupported protocol version.
+		{baseGetBlockTxn, baseGetBlockTxnEncoded, pverNoCmpct, 36, wireErr, wireErr},
+		// Force error with indexes not in ascending order.
+		{baseGetBlockTxn, unorderedEncoded, pver, 36, nil, wireErr},
+		// Force error with too many indexes.
+		{baseGetBlockTxn, tooManyEncoded, pver, 41, nil, wireErr},
+		// Force error with too many indexes.
+		{tooManyGetBlockTxn, baseGetBlockTxnEncoded, pver, 1 << 20, wireErr, nil},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgGetBlockTxn
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+// MsgGetCChain implements the Message interface and represents a bitum
+// getcchain message.  It is used to request the Codechain hashchain entries
+// which follow the entry identified by HeadStart up to and including the entry
+// identified by HeadStop.  The entries are returned via a cchain message
+// (MsgCChain) and are limited by the maximum number of lines per message,
+// which is currently 1000.
+//
+// Both hashes are the raw SHA256 hashes of the respective hashchain lines.  A
+// zero HeadStart requests the entire hashchain and a zero HeadStop requests
+// all entries up to the current head of the remote hashchain.
+type MsgGetCChain struct {
+	HeadStart chainhash.Hash
+	HeadStop  chainhash.Hash
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCChain) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("getcchain message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCChain.BtcDecode", str)
+	}
+
+	return readElements(r, &msg.HeadStart, &msg.HeadStop)
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCChain) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("getcchain message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCChain.BtcEncode", str)
+	}
+
+	return writeElements(w, &msg.HeadStart, &msg.HeadStop)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCChain) Command() string {
+	return CmdGetCChain
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCChain) MaxPayloadLength(pver uint32) uint32 {
+	// Head start + head stop.
+	return chainhash.HashSize * 2
+}
+
+// NewMsgGetCChain returns a new bitum getcchain message that conforms to the
+// Message interface using the passed parameters.  See MsgGetCChain for
+// details.
+func NewMsgGetCChain(headStart, headStop *chainhash.Hash) *MsgGetCChain {
+	return &MsgGetCChain{
+		HeadStart: *headStart,
+		HeadStop:  *headStop,
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetCChain tests the MsgGetCChain API.
+func TestGetCChain(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getcchain"
+	msg := NewMsgGetCChain(&chainhash.Hash{}, &chainhash.Hash{})
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetCChain: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Head start + head stop.
+	wantPayload := uint32(64)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+}
+
+// TestGetCChainWire tests the MsgGetCChain wire encode and decode for various
+// protocol versions.
+func TestGetCChainWire(t *testing.T) {
+	headStart := chainhash.Hash{
+		0x4f, 0xc9, 0x9b, 0x1f, 0x42, 0xc5, 0x7c, 0x3e,
+		0x09, 0x18, 0x16, 0x20, 0x06, 0xec, 0x85, 0xb8,
+		0xfb, 0xe2, 0xe0, 0xe2, 0x5c, 0xf9, 0xf3, 0x6a,
+		0xd2, 0x87, 0xa9, 0xf5, 0x6d, 0x24, 0x63, 0xe9,
+	}
+	headStop := chainhash.Hash{
+		0x24, 0x6d, 0x7e, 0x6a, 0xb3, 0x14, 0xd6, 0x94,
+		0xfd, 0x60, 0x2b, 0x04, 0xd5, 0xaa, 0x46, 0xbd,
+		0x67, 0x13, 0xe6, 0x67, 0xe0, 0xe1, 0xad, 0x66,
+		0x75, 0x53, 0x37, 0x87, 0xb2, 0xb3, 0xfe, 0x00,
+	}
+
+	// Request for the entire hashchain.
+	entireChain := NewMsgGetCChain(&chainhash.Hash{}, &chainhash.Hash{})
+	entireChainEncoded := make([]byte, 64)
+
+	// Request for a range of hashchain entries.
+	chainRange := NewMsgGetCChain(&headStart, &headStop)
+	chainRangeEncoded := append(headStart[:], headStop[:]...)
+
+	tests := []struct {
+		in   *MsgGetCChain // Message to encode
+		out  *MsgGetCChain // Expected decoded message
+		buf  []byte        // Wire encoding
+		pver uint32        // Protocol version for wire encoding
+	}{
+		// Latest protocol version requesting the entire hashchain.
+		{
+			entireChain,
+			entireChain,
+			entireChainEncoded,
+			ProtocolVersion,
+		},
+
+		// Latest protocol version requesting a range of entries.
+		{
+			chainRange,
+			chainRange,
+			chainRangeEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CodechainVersion requesting a range of entries.
+		{
+			chainRange,
+			chainRange,
+			chainRangeEncoded,
+			CodechainVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgGetCChain
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestGetCChainWireErrors performs negative tests against wire encode and
+// decode of MsgGetCChain to confirm error paths work correctly.
+func TestGetCChainWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCodechain := CodechainVersion - 1
+	wireErr := &MessageError{}
+
+	baseGetCChain := NewMsgGetCChain(&chainhash.Hash{0x01}, &chainhash.Hash{0x02})
+	baseGetCChainEncoded := make([]byte, 64)
+	baseGetCChainEncoded[0] = 0x01
+	baseGetCChainEncoded[32] = 0x02
+
+	tests := []struct {
+		in       *MsgGetCChain // Value to encode
+		buf      []byte        // Wire encoding
+		pver     uint32        // Protocol version for wire encoding
+		max      int           // Max size of fixed buffer to induce errors
+		writeErr error         // Expected write error
+		readErr  error         // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in head start.
+		{baseGetCChain, baseGetCChainEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in head stop.
+		{baseGetCChain, baseGetCChainEncoded, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error due to unsupported protocol version.
+		{baseGetCChain, baseGetCChainEncoded, pverNoCodechain, 64, wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgGetCChain
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
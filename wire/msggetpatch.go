@@ -0,0 +1,66 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+// MsgGetPatch implements the Message interface and represents a bitum
+// getpatch message.  It is used to request the Codechain patch file which
+// results in the source tree identified by TreeHash.  The patch file is
+// returned via a patch message (MsgPatch).
+type MsgGetPatch struct {
+	TreeHash chainhash.Hash
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetPatch) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("getpatch message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetPatch.BtcDecode", str)
+	}
+
+	return readElement(r, &msg.TreeHash)
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetPatch) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("getpatch message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetPatch.BtcEncode", str)
+	}
+
+	return writeElement(w, &msg.TreeHash)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetPatch) Command() string {
+	return CmdGetPatch
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetPatch) MaxPayloadLength(pver uint32) uint32 {
+	// Tree hash.
+	return chainhash.HashSize
+}
+
+// NewMsgGetPatch returns a new bitum getpatch message that conforms to the
+// Message interface using the passed parameters.  See MsgGetPatch for
+// details.
+func NewMsgGetPatch(treeHash *chainhash.Hash) *MsgGetPatch {
+	return &MsgGetPatch{
+		TreeHash: *treeHash,
+	}
+}
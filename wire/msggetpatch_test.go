@@ -0,0 +1,173 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetPatch tests the MsgGetPatch API.
+func TestGetPatch(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getpatch"
+	msg := NewMsgGetPatch(&chainhash.Hash{})
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetPatch: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Tree hash.
+	wantPayload := uint32(32)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+}
+
+// TestGetPatchWire tests the MsgGetPatch wire encode and decode for various
+// protocol versions.
+func TestGetPatchWire(t *testing.T) {
+	treeHash := chainhash.Hash{
+		0x59, 0x98, 0xc6, 0x3a, 0xca, 0x42, 0xe4, 0x71,
+		0x29, 0x7c, 0x0f, 0xa3, 0x53, 0x53, 0x8a, 0x93,
+		0xd4, 0xd4, 0xcf, 0xaf, 0xe9, 0xa6, 0x72, 0xdf,
+		0x69, 0x89, 0xe6, 0x94, 0x18, 0x8b, 0x4a, 0x92,
+	}
+	getPatch := NewMsgGetPatch(&treeHash)
+	getPatchEncoded := treeHash[:]
+
+	tests := []struct {
+		in   *MsgGetPatch // Message to encode
+		out  *MsgGetPatch // Expected decoded message
+		buf  []byte       // Wire encoding
+		pver uint32       // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			getPatch,
+			getPatch,
+			getPatchEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CodechainVersion.
+		{
+			getPatch,
+			getPatch,
+			getPatchEncoded,
+			CodechainVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgGetPatch
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestGetPatchWireErrors performs negative tests against wire encode and
+// decode of MsgGetPatch to confirm error paths work correctly.
+func TestGetPatchWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCodechain := CodechainVersion - 1
+	wireErr := &MessageError{}
+
+	baseGetPatch := NewMsgGetPatch(&chainhash.Hash{0x01})
+	baseGetPatchEncoded := make([]byte, 32)
+	baseGetPatchEncoded[0] = 0x01
+
+	tests := []struct {
+		in       *MsgGetPatch // Value to encode
+		buf      []byte       // Wire encoding
+		pver     uint32       // Protocol version for wire encoding
+		max      int          // Max size of fixed buffer to induce errors
+		writeErr error        // Expected write error
+		readErr  error        // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in tree hash.
+		{baseGetPatch, baseGetPatchEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error due to unsupported protocol version.
+		{baseGetPatch, baseGetPatchEncoded, pverNoCodechain, 32, wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgGetPatch
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
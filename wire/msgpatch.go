@@ -0,0 +1,91 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+)
+
+// MaxPatchSize is the maximum size in bytes of a patch file that can be in a
+// single patch message.
+const MaxPatchSize = MaxMessagePayload - (chainhash.HashSize + MaxVarIntPayload)
+
+// MsgPatch implements the Message interface and represents a bitum patch
+// message.  It is used to deliver a Codechain patch file in response to a
+// getpatch message (MsgGetPatch).
+//
+// TreeHash is the hash of the source tree which results from applying the
+// patch file and Patch is the raw content of the patch file.
+type MsgPatch struct {
+	TreeHash chainhash.Hash
+	Patch    []byte
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgPatch) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("patch message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgPatch.BtcDecode", str)
+	}
+
+	err := readElement(r, &msg.TreeHash)
+	if err != nil {
+		return err
+	}
+
+	msg.Patch, err = ReadVarBytes(r, pver, MaxPatchSize, "patch")
+	return err
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgPatch) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CodechainVersion {
+		str := fmt.Sprintf("patch message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgPatch.BtcEncode", str)
+	}
+
+	size := len(msg.Patch)
+	if size > MaxPatchSize {
+		str := fmt.Sprintf("patch size too large for message "+
+			"[size %v, max %v]", size, MaxPatchSize)
+		return messageError("MsgPatch.BtcEncode", str)
+	}
+
+	err := writeElement(w, &msg.TreeHash)
+	if err != nil {
+		return err
+	}
+
+	return WriteVarBytes(w, pver, msg.Patch)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgPatch) Command() string {
+	return CmdPatch
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgPatch) MaxPayloadLength(pver uint32) uint32 {
+	// Tree hash + num patch bytes (varInt) + patch.
+	return chainhash.HashSize + MaxVarIntPayload + MaxPatchSize
+}
+
+// NewMsgPatch returns a new bitum patch message that conforms to the Message
+// interface using the passed parameters.  See MsgPatch for details.
+func NewMsgPatch(treeHash *chainhash.Hash, patch []byte) *MsgPatch {
+	return &MsgPatch{
+		TreeHash: *treeHash,
+		Patch:    patch,
+	}
+}
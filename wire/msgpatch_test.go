@@ -0,0 +1,207 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestPatch tests the MsgPatch API.
+func TestPatch(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "patch"
+	msg := NewMsgPatch(&chainhash.Hash{}, nil)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgPatch: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Tree hash + num patch bytes (varInt) + patch.
+	wantPayload := uint32(MaxMessagePayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+}
+
+// TestPatchWire tests the MsgPatch wire encode and decode for various
+// protocol versions.
+func TestPatchWire(t *testing.T) {
+	treeHash := chainhash.Hash{
+		0x59, 0x98, 0xc6, 0x3a, 0xca, 0x42, 0xe4, 0x71,
+		0x29, 0x7c, 0x0f, 0xa3, 0x53, 0x53, 0x8a, 0x93,
+		0xd4, 0xd4, 0xcf, 0xaf, 0xe9, 0xa6, 0x72, 0xdf,
+		0x69, 0x89, 0xe6, 0x94, 0x18, 0x8b, 0x4a, 0x92,
+	}
+
+	// Patch without content.
+	noPatch := NewMsgPatch(&treeHash, []byte{})
+	noPatchEncoded := append(treeHash[:], 0x00) // Varint for patch size
+
+	// Patch with content.
+	patch := NewMsgPatch(&treeHash,
+		[]byte("codechain patchfile version 1\n"))
+	patchEncoded := append(treeHash[:], 0x1e) // Varint for patch size
+	patchEncoded = append(patchEncoded,
+		[]byte("codechain patchfile version 1\n")...)
+
+	tests := []struct {
+		in   *MsgPatch // Message to encode
+		out  *MsgPatch // Expected decoded message
+		buf  []byte    // Wire encoding
+		pver uint32    // Protocol version for wire encoding
+	}{
+		// Latest protocol version with no patch content.
+		{
+			noPatch,
+			noPatch,
+			noPatchEncoded,
+			ProtocolVersion,
+		},
+
+		// Latest protocol version with patch content.
+		{
+			patch,
+			patch,
+			patchEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version CodechainVersion with patch content.
+		{
+			patch,
+			patch,
+			patchEncoded,
+			CodechainVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgPatch
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestPatchWireErrors performs negative tests against wire encode and decode
+// of MsgPatch to confirm error paths work correctly.
+func TestPatchWireErrors(t *testing.T) {
+	pver := ProtocolVersion
+	pverNoCodechain := CodechainVersion - 1
+	wireErr := &MessageError{}
+
+	// Message with a short patch.
+	basePatch := NewMsgPatch(&chainhash.Hash{}, []byte("abc"))
+	basePatchEncoded := append(make([]byte, 32), []byte{
+		0x03,             // Varint for patch size
+		0x61, 0x62, 0x63, // Patch "abc"
+	}...)
+
+	// Message that forces an error by having a patch which is larger than
+	// the max allowed patch size.
+	largePatch := NewMsgPatch(&chainhash.Hash{},
+		make([]byte, MaxPatchSize+1))
+	largePatchEncoded := append(make([]byte, 32), []byte{
+		0xfe, 0xd8, 0xff, 0xff, 0x01, // Varint for patch size
+	}...)
+
+	tests := []struct {
+		in       *MsgPatch // Value to encode
+		buf      []byte    // Wire encoding
+		pver     uint32    // Protocol version for wire encoding
+		max      int       // Max size of fixed buffer to induce errors
+		writeErr error     // Expected write error
+		readErr  error     // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in tree hash.
+		{basePatch, basePatchEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in patch size.
+		{basePatch, basePatchEncoded, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in patch.
+		{basePatch, basePatchEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max patch size.
+		{largePatch, largePatchEncoded, pver, 37, wireErr, wireErr},
+		// Force error due to unsupported protocol version.
+		{basePatch, basePatchEncoded, pverNoCodechain, 36, wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg MsgPatch
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type MessageError, check them for
+		// equality.
+		if _, ok := err.(*MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
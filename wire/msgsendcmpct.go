@@ -0,0 +1,79 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// CmpctBlockVersion is the version of the compact block encoding implemented by
+// this package.  It is advertised in sendcmpct messages.
+const CmpctBlockVersion = 1
+
+// MsgSendCmpct implements the Message interface and represents a bitum
+// sendcmpct message.  It is used to request the receiving peer to relay blocks
+// as compact blocks (MsgCmpctBlock) in the given compact block encoding
+// version.
+//
+// When Announce is set, the receiving peer is asked to announce new blocks by
+// sending compact blocks directly instead of inv or headers messages, which is
+// referred to as high-bandwidth mode.  Otherwise, new blocks are announced as
+// usual and compact blocks are only sent in response to getdata messages with
+// compact block inventory vectors (InvTypeCmpctBlock).
+//
+// This message was not added until protocol versions starting with
+// CmpctBlocksVersion.
+type MsgSendCmpct struct {
+	Announce bool
+	Version  uint64
+}
+
+// BtcDecode decodes r using the Bitum protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("sendcmpct message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendCmpct.BtcDecode", str)
+	}
+
+	return readElements(r, &msg.Announce, &msg.Version)
+}
+
+// BtcEncode encodes the receiver to w using the Bitum protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CmpctBlocksVersion {
+		str := fmt.Sprintf("sendcmpct message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendCmpct.BtcEncode", str)
+	}
+
+	return writeElements(w, msg.Announce, msg.Version)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgSendCmpct) Command() string {
+	return CmdSendCmpct
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) MaxPayloadLength(pver uint32) uint32 {
+	// Announce flag 1 byte + version 8 bytes.
+	return 9
+}
+
+// NewMsgSendCmpct returns a new bitum sendcmpct message that conforms to the
+// Message interface using the passed parameters.  See MsgSendCmpct for
+// details.
+func NewMsgSendCmpct(announce bool, version uint64) *MsgSendCmpct {
+	return &MsgSendCmpct{
+		Announce: announce,
+		Version:  version,
+	}
+}
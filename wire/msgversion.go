@@ -12,6 +12,8 @@ import (
 	"net"
 	"strings"
 	"time"
+
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
 )
 
 // MaxUserAgentLen is the maximum allowed length for the user agent field in a
@@ -57,6 +59,11 @@ type MsgVersion struct {
 
 	// Don't announce transactions to peer.
 	DisableRelayTx bool
+
+	// Codechain tree hash of the source tree the generator of the version
+	// message is running.  It is zero if unknown and only encoded for
+	// protocol versions >= TreeHashVersion.
+	TreeHash chainhash.Hash
 }
 
 // HasService returns whether the specified service is supported by the peer
@@ -146,6 +153,16 @@ func (msg *MsgVersion) BtcDecode(r io.Reader, pver uint32) error {
 		msg.DisableRelayTx = !relayTx
 	}
 
+	// Protocol versions >= TreeHashVersion added the tree hash of the
+	// running source tree.  It is only considered present if there are
+	// bytes remaining in the message.
+	if buf.Len() > 0 {
+		err = readElement(buf, &msg.TreeHash)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -188,7 +205,15 @@ func (msg *MsgVersion) BtcEncode(w io.Writer, pver uint32) error {
 		return err
 	}
 
-	return writeElement(w, !msg.DisableRelayTx)
+	err = writeElement(w, !msg.DisableRelayTx)
+	if err != nil {
+		return err
+	}
+
+	if pver >= TreeHashVersion {
+		return writeElement(w, &msg.TreeHash)
+	}
+	return nil
 }
 
 // Command returns the protocol command string for the message.  This is part
@@ -206,8 +231,14 @@ func (msg *MsgVersion) MaxPayloadLength(pver uint32) uint32 {
 	// remote and local net addresses + nonce 8 bytes + length of user
 	// agent (varInt) + max allowed useragent length + last block 4 bytes +
 	// relay transactions flag 1 byte.
-	return 33 + (maxNetAddressPayload(pver) * 2) + MaxVarIntPayload +
+	plen := 33 + (maxNetAddressPayload(pver) * 2) + MaxVarIntPayload +
 		MaxUserAgentLen
+
+	// Tree hash of the running source tree.
+	if pver >= TreeHashVersion {
+		plen += chainhash.HashSize
+	}
+	return plen
 }
 
 // NewMsgVersion returns a new Bitum version message that conforms to the
@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bitum-project/bitumd/chaincfg/chainhash"
 	"github.com/davecgh/go-spew/spew"
 )
 
@@ -113,8 +114,8 @@ func TestVersion(t *testing.T) {
 	// Protocol version 4 bytes + services 8 bytes + timestamp 8 bytes +
 	// remote and local net addresses + nonce 8 bytes + length of user agent
 	// (varInt) + max allowed user agent length + last block 4 bytes +
-	// relay transactions flag 1 byte.
-	wantPayload := uint32(358)
+	// relay transactions flag 1 byte + tree hash 32 bytes.
+	wantPayload := uint32(390)
 	maxPayload := msg.MaxPayloadLength(pver)
 	if maxPayload != wantPayload {
 		t.Errorf("MaxPayloadLength: wrong max payload length for "+
@@ -184,6 +185,24 @@ func TestVersionWire(t *testing.T) {
 	copy(verRelayTxFalseEncoded, baseVersionBIP0037Encoded)
 	verRelayTxFalseEncoded[len(verRelayTxFalseEncoded)-1] = 0
 
+	// verTreeHash and verTreeHashEncoded is a version message as of
+	// TreeHashVersion with the tree hash of the running source tree.
+	baseVersionTreeHashCopy := *baseVersionBIP0037
+	verTreeHash := &baseVersionTreeHashCopy
+	verTreeHash.TreeHash = chainhash.Hash{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+		0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18,
+		0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20,
+	}
+	verTreeHashEncoded := make([]byte, 0, len(baseVersionBIP0037Encoded)+
+		chainhash.HashSize)
+	verTreeHashEncoded = append(verTreeHashEncoded,
+		baseVersionBIP0037Encoded...)
+	verTreeHashEncoded = append(verTreeHashEncoded, verTreeHash.TreeHash[:]...)
+	verZeroTreeHashEncoded := make([]byte, len(verTreeHashEncoded))
+	copy(verZeroTreeHashEncoded, baseVersionBIP0037Encoded)
+
 	tests := []struct {
 		in   *MsgVersion // Message to encode
 		out  *MsgVersion // Expected decoded message
@@ -191,11 +210,37 @@ func TestVersionWire(t *testing.T) {
 		pver uint32      // Protocol version for wire encoding
 	}{
 		// Latest protocol version.
+		{
+			verTreeHash,
+			verTreeHash,
+			verTreeHashEncoded,
+			ProtocolVersion,
+		},
+
+		// Protocol version TreeHashVersion without a tree hash.
 		{
 			baseVersionBIP0037,
 			baseVersionBIP0037,
+			verZeroTreeHashEncoded,
+			TreeHashVersion,
+		},
+
+		// Protocol version CodechainVersion.  The tree hash is not
+		// encoded.
+		{
+			verTreeHash,
+			baseVersionBIP0037,
 			baseVersionBIP0037Encoded,
-			ProtocolVersion,
+			CodechainVersion,
+		},
+
+		// Protocol version CodechainVersion with the transaction relay
+		// disabled.
+		{
+			verRelayTxFalse,
+			verRelayTxFalse,
+			verRelayTxFalseEncoded,
+			CodechainVersion,
 		},
 	}
 
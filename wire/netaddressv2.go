@@ -0,0 +1,284 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// NetworkID identifies the network an address of a NetAddressV2 belongs to and
+// therefore how its address bytes are interpreted.
+type NetworkID uint8
+
+const (
+	// NetIDIPv4 identifies an IPv4 address of 4 bytes.
+	NetIDIPv4 NetworkID = 1
+
+	// NetIDIPv6 identifies an IPv6 address of 16 bytes.
+	NetIDIPv6 NetworkID = 2
+
+	// NetIDTorV2 identifies a Tor v2 onion service address of 10 bytes,
+	// which is the truncated hash of the public key of the service.
+	NetIDTorV2 NetworkID = 3
+
+	// NetIDTorV3 identifies a Tor v3 onion service address of 32 bytes,
+	// which is the ed25519 public key of the service.
+	NetIDTorV3 NetworkID = 4
+
+	// NetIDI2P identifies an I2P address of 32 bytes, which is the SHA-256
+	// hash of the destination.
+	NetIDI2P NetworkID = 5
+
+	// NetIDCJDNS identifies a CJDNS address of 16 bytes.
+	NetIDCJDNS NetworkID = 6
+)
+
+// MaxNetAddressV2Size is the maximum number of address bytes of a NetAddressV2.
+// Addresses of unknown networks are accepted up to this size so that nodes can
+// relay them without understanding them.
+const MaxNetAddressV2Size = 512
+
+// netIDAddrSizes houses the address sizes of the known networks.
+var netIDAddrSizes = map[NetworkID]int{
+	NetIDIPv4:  4,
+	NetIDIPv6:  16,
+	NetIDTorV2: 10,
+	NetIDTorV3: 32,
+	NetIDI2P:   32,
+	NetIDCJDNS: 16,
+}
+
+// Map of network IDs back to their constant names for pretty printing.
+var netIDStrings = map[NetworkID]string{
+	NetIDIPv4:  "NetIDIPv4",
+	NetIDIPv6:  "NetIDIPv6",
+	NetIDTorV2: "NetIDTorV2",
+	NetIDTorV3: "NetIDTorV3",
+	NetIDI2P:   "NetIDI2P",
+	NetIDCJDNS: "NetIDCJDNS",
+}
+
+// String returns the NetworkID in human-readable form.
+func (id NetworkID) String() string {
+	if s, ok := netIDStrings[id]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown NetworkID (%d)", uint8(id))
+}
+
+// AddrSize returns the size in bytes of the addresses of the network and
+// whether or not the network is known.
+func (id NetworkID) AddrSize() (int, bool) {
+	size, ok := netIDAddrSizes[id]
+	return size, ok
+}
+
+// onionCatPrefix is the IPv6 prefix (fd87:d87e:eb43::/48) used by OnionCat to
+// map Tor v2 onion service addresses into the IPv6 address space.
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// maxNetAddressV2Payload returns the max payload size for a Bitum NetAddressV2
+// based on the protocol version.
+func maxNetAddressV2Payload(pver uint32) uint32 {
+	// Timestamp 4 bytes + services up to 9 bytes + network ID 1 byte +
+	// address length up to 3 bytes + address + port 2 bytes.
+	return 4 + MaxVarIntPayload + 1 + 3 + MaxNetAddressV2Size + 2
+}
+
+// NetAddressV2 defines information about a peer on the network including the
+// time it was last seen, the services it supports, its address, and port.
+// Unlike NetAddress, the address is of variable length and its meaning depends
+// on the network it belongs to, which allows addresses that do not fit into an
+// IPv6 address, such as Tor v3 onion service addresses.
+type NetAddressV2 struct {
+	// Last time the address was seen.  This is encoded as a uint32 on the
+	// wire and therefore is limited to 2106.
+	Timestamp time.Time
+
+	// Bitfield which identifies the services supported by the address.
+	Services ServiceFlag
+
+	// NetID identifies the network the address belongs to.
+	NetID NetworkID
+
+	// Addr is the address of the peer within its network.
+	Addr []byte
+
+	// Port the peer is using.  This is encoded in big endian on the wire
+	// which differs from most everything else.
+	Port uint16
+}
+
+// HasService returns whether the specified service is supported by the address.
+func (na *NetAddressV2) HasService(service ServiceFlag) bool {
+	return na.Services&service == service
+}
+
+// AddService adds service as a supported service by the peer generating the
+// message.
+func (na *NetAddressV2) AddService(service ServiceFlag) {
+	na.Services |= service
+}
+
+// ToLegacy returns the address as a NetAddress along with whether or not the
+// conversion was possible.  IPv4, IPv6, and CJDNS addresses are converted
+// directly, while Tor v2 addresses are mapped into the OnionCat range.  The
+// addresses of all other networks can not be represented by a NetAddress.
+func (na *NetAddressV2) ToLegacy() (*NetAddress, bool) {
+	if size, ok := na.NetID.AddrSize(); !ok || size != len(na.Addr) {
+		return nil, false
+	}
+
+	var ip net.IP
+	switch na.NetID {
+	case NetIDIPv4:
+		ip = net.IPv4(na.Addr[0], na.Addr[1], na.Addr[2], na.Addr[3])
+
+	case NetIDIPv6, NetIDCJDNS:
+		ip = make(net.IP, net.IPv6len)
+		copy(ip, na.Addr)
+
+	case NetIDTorV2:
+		ip = make(net.IP, net.IPv6len)
+		copy(ip, onionCatPrefix)
+		copy(ip[len(onionCatPrefix):], na.Addr)
+
+	default:
+		return nil, false
+	}
+
+	return &NetAddress{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		IP:        ip,
+		Port:      na.Port,
+	}, true
+}
+
+// NewNetAddressV2 returns a new NetAddressV2 using the provided timestamp,
+// supported services, network ID, address, and port.  The timestamp is rounded
+// to single second precision.
+func NewNetAddressV2(timestamp time.Time, services ServiceFlag, netID NetworkID, addr []byte, port uint16) *NetAddressV2 {
+	// Limit the timestamp to one second precision since the protocol
+	// doesn't support better.
+	return &NetAddressV2{
+		Timestamp: time.Unix(timestamp.Unix(), 0),
+		Services:  services,
+		NetID:     netID,
+		Addr:      addr,
+		Port:      port,
+	}
+}
+
+// NewNetAddressV2FromLegacy returns a new NetAddressV2 for the passed
+// NetAddress.  IPv4 addresses and IPv6 addresses in the OnionCat range are
+// converted to NetIDIPv4 and NetIDTorV2 addresses respectively, all other
+// addresses are NetIDIPv6 addresses.
+func NewNetAddressV2FromLegacy(na *NetAddress) *NetAddressV2 {
+	netID := NetIDIPv6
+	addr := make([]byte, net.IPv6len)
+	if na.IP != nil {
+		copy(addr, na.IP.To16())
+	}
+	switch {
+	case na.IP.To4() != nil:
+		netID = NetIDIPv4
+		addr = addr[12:]
+
+	case bytes.HasPrefix(addr, onionCatPrefix):
+		netID = NetIDTorV2
+		addr = addr[len(onionCatPrefix):]
+	}
+
+	return &NetAddressV2{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		NetID:     netID,
+		Addr:      addr,
+		Port:      na.Port,
+	}
+}
+
+// readNetAddressV2 reads an encoded NetAddressV2 from r depending on the
+// protocol version.
+func readNetAddressV2(r io.Reader, pver uint32, na *NetAddressV2) error {
+	// NOTE: The Bitum protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.
+	err := readElement(r, (*uint32Time)(&na.Timestamp))
+	if err != nil {
+		return err
+	}
+
+	services, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	na.Services = ServiceFlag(services)
+
+	err = readElement(r, (*uint8)(&na.NetID))
+	if err != nil {
+		return err
+	}
+
+	na.Addr, err = ReadVarBytes(r, pver, MaxNetAddressV2Size, "address")
+	if err != nil {
+		return err
+	}
+	if size, ok := na.NetID.AddrSize(); ok && size != len(na.Addr) {
+		str := fmt.Sprintf("invalid address size for network %v "+
+			"[size %d, want %d]", na.NetID, len(na.Addr), size)
+		return messageError("readNetAddressV2", str)
+	}
+
+	// Sigh.  Bitum protocol mixes little and big endian.
+	na.Port, err = binarySerializer.Uint16(r, bigEndian)
+	return err
+}
+
+// writeNetAddressV2 serializes a NetAddressV2 to w depending on the protocol
+// version.
+func writeNetAddressV2(w io.Writer, pver uint32, na *NetAddressV2) error {
+	if len(na.Addr) > MaxNetAddressV2Size {
+		str := fmt.Sprintf("address is larger than the max allowed "+
+			"size [size %d, max %d]", len(na.Addr), MaxNetAddressV2Size)
+		return messageError("writeNetAddressV2", str)
+	}
+	if size, ok := na.NetID.AddrSize(); ok && size != len(na.Addr) {
+		str := fmt.Sprintf("invalid address size for network %v "+
+			"[size %d, want %d]", na.NetID, len(na.Addr), size)
+		return messageError("writeNetAddressV2", str)
+	}
+
+	// NOTE: The Bitum protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.
+	err := writeElement(w, uint32(na.Timestamp.Unix()))
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarInt(w, pver, uint64(na.Services))
+	if err != nil {
+		return err
+	}
+
+	err = writeElement(w, uint8(na.NetID))
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarBytes(w, pver, na.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Sigh.  Bitum protocol mixes little and big endian.
+	return binary.Write(w, bigEndian, na.Port)
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2019 The Bitum developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestNetAddressV2Legacy ensures addresses are converted from and to legacy
+// addresses (NetAddress) as expected.
+func TestNetAddressV2Legacy(t *testing.T) {
+	timestamp := time.Unix(0x495fab29, 0)
+	onionCat := net.ParseIP("fd87:d87e:eb43:0102:0304:0506:0708:090a")
+
+	tests := []struct {
+		name   string
+		legacy *NetAddress // Legacy address
+		netID  NetworkID   // Expected network ID
+		addr   []byte      // Expected address bytes
+	}{
+		{
+			name: "IPv4",
+			legacy: NewNetAddressTimestamp(timestamp, SFNodeNetwork,
+				net.ParseIP("127.0.0.1"), 9108),
+			netID: NetIDIPv4,
+			addr:  []byte{0x7f, 0x00, 0x00, 0x01},
+		},
+		{
+			name: "IPv6",
+			legacy: NewNetAddressTimestamp(timestamp, SFNodeNetwork,
+				net.ParseIP("2001:db8::1"), 9108),
+			netID: NetIDIPv6,
+			addr: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0x01},
+		},
+		{
+			name: "Tor v2",
+			legacy: NewNetAddressTimestamp(timestamp, SFNodeNetwork,
+				onionCat, 9108),
+			netID: NetIDTorV2,
+			addr: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+				0x08, 0x09, 0x0a},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for _, test := range tests {
+		na := NewNetAddressV2FromLegacy(test.legacy)
+		if na.NetID != test.netID || !bytes.Equal(na.Addr, test.addr) {
+			t.Errorf("NewNetAddressV2FromLegacy (%s): wrong address - "+
+				"got %v %x, want %v %x", test.name, na.NetID,
+				na.Addr, test.netID, test.addr)
+			continue
+		}
+		if na.Timestamp != timestamp || na.Services != SFNodeNetwork ||
+			na.Port != 9108 {
+			t.Errorf("NewNetAddressV2FromLegacy (%s): wrong fields - "+
+				"got %v", test.name, spew.Sdump(na))
+			continue
+		}
+
+		legacy, ok := na.ToLegacy()
+		if !ok {
+			t.Errorf("ToLegacy (%s): unable to convert", test.name)
+			continue
+		}
+		if !legacy.IP.Equal(test.legacy.IP) ||
+			legacy.Port != test.legacy.Port ||
+			legacy.Services != test.legacy.Services ||
+			legacy.Timestamp != test.legacy.Timestamp {
+			t.Errorf("ToLegacy (%s): wrong address - got %v, want %v",
+				test.name, spew.Sdump(legacy),
+				spew.Sdump(test.legacy))
+			continue
+		}
+	}
+
+	// Ensure addresses without a legacy representation are not converted.
+	noLegacy := []*NetAddressV2{
+		NewNetAddressV2(timestamp, 0, NetIDTorV3, make([]byte, 32), 9108),
+		NewNetAddressV2(timestamp, 0, NetIDI2P, make([]byte, 32), 9108),
+		NewNetAddressV2(timestamp, 0, NetworkID(0xff), []byte{1}, 9108),
+		NewNetAddressV2(timestamp, 0, NetIDIPv4, make([]byte, 16), 9108),
+	}
+	for i, na := range noLegacy {
+		if _, ok := na.ToLegacy(); ok {
+			t.Errorf("ToLegacy #%d: converted address %v", i,
+				spew.Sdump(na))
+		}
+	}
+}
+
+// TestNetworkIDStringer tests the stringized output for network IDs.
+func TestNetworkIDStringer(t *testing.T) {
+	tests := []struct {
+		in   NetworkID
+		want string
+	}{
+		{NetIDIPv4, "NetIDIPv4"},
+		{NetIDTorV3, "NetIDTorV3"},
+		{0xff, "Unknown NetworkID (255)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestNetAddressV2Wire tests the NetAddressV2 wire encode and decode for
+// various network IDs.
+func TestNetAddressV2Wire(t *testing.T) {
+	timestamp := time.Unix(0x495fab29, 0)
+	pver := ProtocolVersion
+
+	// Tor v3 address.
+	torV3Addr := bytes.Repeat([]byte{0xab}, 32)
+	torV3 := NewNetAddressV2(timestamp, SFNodeNetwork|SFNodeCF, NetIDTorV3,
+		torV3Addr, 9108)
+	torV3Encoded := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x05, // Varint for services
+		0x04, // Network ID
+		0x20, // Varint for address size
+	}
+	torV3Encoded = append(torV3Encoded, torV3Addr...)
+	torV3Encoded = append(torV3Encoded, 0x23, 0x94) // Port 9108 in big-endian
+
+	// Address of an unknown network.
+	unknown := NewNetAddressV2(timestamp, 0, NetworkID(0xff),
+		[]byte{0x01, 0x02, 0x03}, 9108)
+	unknownEncoded := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x00,             // Varint for services
+		0xff,             // Network ID
+		0x03,             // Varint for address size
+		0x01, 0x02, 0x03, // Address
+		0x23, 0x94, // Port 9108 in big-endian
+	}
+
+	tests := []struct {
+		in  *NetAddressV2 // NetAddressV2 to encode
+		out *NetAddressV2 // Expected decoded NetAddressV2
+		buf []byte        // Wire encoding
+	}{
+		{torV3, torV3, torV3Encoded},
+		{unknown, unknown, unknownEncoded},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		var buf bytes.Buffer
+		err := writeNetAddressV2(&buf, pver, test.in)
+		if err != nil {
+			t.Errorf("writeNetAddressV2 #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("writeNetAddressV2 #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var na NetAddressV2
+		rbuf := bytes.NewReader(test.buf)
+		err = readNetAddressV2(rbuf, pver, &na)
+		if err != nil {
+			t.Errorf("readNetAddressV2 #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&na, test.out) {
+			t.Errorf("readNetAddressV2 #%d\n got: %s want: %s", i,
+				spew.Sdump(na), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestNetAddressV2WireErrors performs negative tests against wire encode and
+// decode NetAddressV2 to confirm error paths work correctly.
+func TestNetAddressV2WireErrors(t *testing.T) {
+	timestamp := time.Unix(0x495fab29, 0)
+	pver := ProtocolVersion
+	wireErr := &MessageError{}
+
+	ipv4 := NewNetAddressV2(timestamp, SFNodeNetwork, NetIDIPv4,
+		[]byte{0x7f, 0x00, 0x00, 0x01}, 9108)
+	ipv4Encoded := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,                   // Varint for services
+		0x01,                   // Network ID
+		0x04,                   // Varint for address size
+		0x7f, 0x00, 0x00, 0x01, // Address
+		0x23, 0x94, // Port 9108 in big-endian
+	}
+
+	// Address with a size that does not match its network.
+	badSize := NewNetAddressV2(timestamp, SFNodeNetwork, NetIDIPv4,
+		make([]byte, 16), 9108)
+	badSizeEncoded := make([]byte, len(ipv4Encoded))
+	copy(badSizeEncoded, ipv4Encoded)
+	badSizeEncoded[6] = 0x03
+
+	// Address which exceeds the max address size.
+	tooLarge := NewNetAddressV2(timestamp, SFNodeNetwork, NetworkID(0xff),
+		make([]byte, MaxNetAddressV2Size+1), 9108)
+	tooLargeEncoded := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,             // Varint for services
+		0xff,             // Network ID
+		0xfd, 0x01, 0x02, // Varint for address size
+	}
+
+	tests := []struct {
+		in       *NetAddressV2 // Value to encode
+		buf      []byte        // Wire encoding
+		max      int           // Max size of fixed buffer to induce errors
+		writeErr error         // Expected write error
+		readErr  error         // Expected read error
+	}{
+		// Force errors on timestamp.
+		{ipv4, ipv4Encoded, 0, io.ErrShortWrite, io.EOF},
+		// Force errors on services.
+		{ipv4, ipv4Encoded, 4, io.ErrShortWrite, io.EOF},
+		// Force errors on network ID.
+		{ipv4, ipv4Encoded, 5, io.ErrShortWrite, io.EOF},
+		// Force errors on address.
+		{ipv4, ipv4Encoded, 6, io.ErrShortWrite, io.EOF},
+		// Force errors on port.
+		{ipv4, ipv4Encoded, 11, io.ErrShortWrite, io.EOF},
+		// Force errors on address size that does not match the network.
+		{badSize, badSizeEncoded, len(badSizeEncoded), wireErr, wireErr},
+		// Force errors on address that exceeds the max size.
+		{tooLarge, tooLargeEncoded, len(tooLargeEncoded), wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := writeNetAddressV2(w, pver, test.in)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("writeNetAddressV2 #%d wrong error got: %v, "+
+				"want: %v", i, err, test.writeErr)
+			continue
+		}
+		if _, ok := err.(*MessageError); !ok && err != test.writeErr {
+			t.Errorf("writeNetAddressV2 #%d wrong error got: %v, "+
+				"want: %v", i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var na NetAddressV2
+		r := newFixedReader(test.max, test.buf)
+		err = readNetAddressV2(r, pver, &na)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("readNetAddressV2 #%d wrong error got: %v, "+
+				"want: %v", i, err, test.readErr)
+			continue
+		}
+		if _, ok := err.(*MessageError); !ok && err != test.readErr {
+			t.Errorf("readNetAddressV2 #%d wrong error got: %v, "+
+				"want: %v", i, err, test.readErr)
+			continue
+		}
+	}
+}
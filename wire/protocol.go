@@ -17,7 +17,7 @@ const (
 	InitialProcotolVersion uint32 = 1
 
 	// ProtocolVersion is the latest protocol version this package supports.
-	ProtocolVersion uint32 = 6
+	ProtocolVersion uint32 = 10
 
 	// NodeBloomVersion is the protocol version which added the SFNodeBloom
 	// service flag (unused).
@@ -39,6 +39,26 @@ const (
 	// flag and the cfheaders, cfilter, cftypes, getcfheaders, getcfilter and
 	// getcftypes messages.
 	NodeCFVersion uint32 = 6
+
+	// CodechainVersion is the protocol version which adds the getcchain,
+	// cchain, getpatch, and patch messages used to distribute Codechain
+	// hashchain entries and patch files.
+	CodechainVersion uint32 = 7
+
+	// TreeHashVersion is the protocol version which adds the Codechain
+	// tree hash of the source tree a node is running to the version
+	// message.
+	TreeHashVersion uint32 = 8
+
+	// CmpctBlocksVersion is the protocol version which adds the sendcmpct,
+	// cmpctblock, getblocktxn, and blocktxn messages used to relay blocks
+	// as compact blocks.
+	CmpctBlocksVersion uint32 = 9
+
+	// AddrV2Version is the protocol version which adds the sendaddrv2 and
+	// addrv2 messages used to relay addresses of variable length, such as
+	// Tor v3 onion service addresses.
+	AddrV2Version uint32 = 10
 )
 
 // ServiceFlag identifies services supported by a Bitum peer.
@@ -55,6 +75,10 @@ const (
 	// SFNodeCF is a flag used to indicate a peer supports committed
 	// filters (CFs).
 	SFNodeCF
+
+	// SFNodeP2PV2 is a flag used to indicate a peer supports the encrypted
+	// and authenticated v2 P2P transport.
+	SFNodeP2PV2
 )
 
 // Map of service flags back to their constant names for pretty printing.
@@ -62,6 +86,7 @@ var sfStrings = map[ServiceFlag]string{
 	SFNodeNetwork: "SFNodeNetwork",
 	SFNodeBloom:   "SFNodeBloom",
 	SFNodeCF:      "SFNodeCF",
+	SFNodeP2PV2:   "SFNodeP2PV2",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -70,6 +95,7 @@ var orderedSFStrings = []ServiceFlag{
 	SFNodeNetwork,
 	SFNodeBloom,
 	SFNodeCF,
+	SFNodeP2PV2,
 }
 
 // String returns the ServiceFlag in human-readable form.
@@ -17,7 +17,8 @@ func TestServiceFlagStringer(t *testing.T) {
 		{SFNodeNetwork, "SFNodeNetwork"},
 		{SFNodeBloom, "SFNodeBloom"},
 		{SFNodeCF, "SFNodeCF"},
-		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeCF|0xfffffff8"},
+		{SFNodeP2PV2, "SFNodeP2PV2"},
+		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeCF|SFNodeP2PV2|0xfffffff0"},
 	}
 
 	t.Logf("Running %d tests", len(tests))